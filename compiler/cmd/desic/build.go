@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/desilang/desi/compiler/internal/cc"
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/pkg/desi"
+)
+
+// buildOptions holds the flags shared by `build` and `run`.
+type buildOptions struct {
+	cc      string // --cc
+	out     string // --out
+	target  string // --target
+	runtime string // --runtime
+	file    string // positional entry file
+	werr    bool   // --Werror
+	watch   bool   // --watch
+	report  bool   // --report
+	emit    string // --emit (build only): comma-separated outputs, see emitKinds
+
+	denyDeprecated bool // --deny-deprecated
+
+	cache *desi.CheckCache // reused across --watch rebuilds
+}
+
+// defaultRuntimeDir is where desi_std.c lives relative to the working
+// directory unless the config file or --runtime says otherwise.
+var defaultRuntimeDir = filepath.Join("runtime", "c")
+
+func (o *buildOptions) register(fs *flag.FlagSet) {
+	rt := userConfig.RuntimeDir
+	if rt == "" {
+		rt = defaultRuntimeDir
+	}
+	fs.StringVar(&o.cc, "cc", userConfig.CC, "C compiler used to build a binary (e.g. clang); emit C only if empty")
+	fs.StringVar(&o.out, "out", "", "binary name under gen/out (default: entry file basename)")
+	fs.StringVar(&o.target, "target", "", "cross-compile for this target triple, e.g. aarch64-linux-musl (needs -cc zig or clang)")
+	fs.StringVar(&o.runtime, "runtime", rt, "directory containing the C runtime (desi_std.c/.h)")
+	fs.BoolVar(&o.werr, "Werror", userConfig.Werror, "treat warnings as errors")
+	fs.BoolVar(&o.werr, "werror", userConfig.Werror, "alias for -Werror")
+	fs.BoolVar(&o.watch, "watch", false, "rebuild whenever a source file changes")
+	fs.BoolVar(&o.denyDeprecated, "deny-deprecated", false, "reject deprecated syntax instead of warning")
+	fs.BoolVar(&o.report, "report", false, "write a JSON build report (errors, warnings, timings, outputs) to "+reportPath())
+}
+
+// emitKinds are the outputs --emit can name. C is always written; deps adds
+// gen/out/<name>.d, a Make/Ninja depfile listing the .desi files read, and
+// amalgam gen/out/<name>.amalgam.c, the C with the runtime pasted in.
+var emitKinds = []string{"c", "deps", "amalgam"}
+
+// emits reports whether --emit names kind.
+func (o *buildOptions) emits(kind string) bool {
+	return slices.Contains(strings.Split(o.emit, ","), kind)
+}
+
+/* ---------- build ---------- */
+
+func runBuild(c *command, o *buildOptions, args []string) int {
+	if len(args) != 1 {
+		return c.usageErr("want exactly one entry file, got %d", len(args))
+	}
+	for _, k := range strings.Split(o.emit, ",") {
+		if k != "" && !slices.Contains(emitKinds, k) {
+			return c.usageErr("--emit: unknown output %q (want %s)", k, strings.Join(emitKinds, ", "))
+		}
+	}
+	o.file = args[0]
+	if o.watch && o.file == "-" {
+		return c.usageErr("--watch cannot be used with stdin input")
+	}
+	if o.watch {
+		o.cache = desi.NewCheckCache()
+		return watchLoop(func() []string {
+			r := buildEntry(*o)
+			return r.deps
+		})
+	}
+	return buildEntry(*o).code
+}
+
+// buildResult reports what buildEntry produced.
+type buildResult struct {
+	bin  string   // binary path; empty unless --cc was given
+	deps []string // every source file the build looked at (for --watch)
+	code int      // process exit code
+}
+
+// buildEntry runs the full pipeline for a.file through desi.Build and
+// reports its diagnostics.
+func buildEntry(a buildOptions) buildResult {
+	opts := desi.BuildOptions{
+		Entry:          a.file,
+		Werror:         a.werr,
+		DenyDeprecated: a.denyDeprecated,
+		CC:             a.cc,
+		RuntimeDir:     a.runtime,
+		StdDir:         userConfig.StdDir,
+		Out:            a.out,
+		Target:         a.target,
+		CheckCache:     a.cache,
+		DepFile:        a.emits("deps"),
+		Amalgam:        a.emits("amalgam"),
+	}
+	fs, entry, err := entryFS(a.file)
+	if err != nil {
+		reportError(err)
+		return buildResult{code: exitDiag}
+	}
+	opts.Entry, opts.FS = entry, fs
+	if a.file == "-" {
+		opts.Name = "stdin"
+	}
+
+	if a.cache != nil {
+		a.cache.Checked, a.cache.Reused = 0, 0
+	}
+	start := time.Now()
+	res, err := desi.Build(opts)
+	total := time.Since(start)
+	if a.cache != nil && a.cache.Checked+a.cache.Reused > 0 {
+		infof("[watch] re-checked %d of %d function(s)\n", a.cache.Checked, a.cache.Checked+a.cache.Reused)
+	}
+	for _, w := range res.Warnings {
+		reportWarning(w)
+	}
+	res.Errors = diag.Tidy(res.Errors)
+	for _, e := range res.Errors {
+		reportErrorIn(e, entrySource(a.file, fs))
+	}
+	if res.CFile != "" {
+		infof("wrote %s\n", res.CFile)
+	}
+	if res.Amalgam != "" {
+		infof("wrote %s\n", res.Amalgam)
+	}
+	if res.DepFile != "" {
+		infof("wrote %s\n", res.DepFile)
+	}
+	if a.report {
+		if rerr := writeReport(displayName(a.file), res, err == nil, total); rerr != nil {
+			term.Eprintf("write %s: %v\n", reportPath(), rerr)
+			return buildResult{deps: res.Files, code: exitInternal}
+		}
+		infof("wrote %s\n", reportPath())
+	}
+	r := buildResult{bin: res.Binary, deps: res.Files}
+	switch {
+	case err == nil:
+		if res.Binary != "" {
+			infof("built %s\n", res.Binary)
+		}
+	case errors.Is(err, desi.ErrDiagnostics):
+		r.code = exitDiag
+	case errors.Is(err, desi.ErrCompile):
+		term.Eprintf("%v\n", err)
+		return buildResult{deps: res.Files, code: exitDiag}
+	default:
+		term.Eprintf("%v\n", err)
+		return buildResult{deps: res.Files, code: exitInternal}
+	}
+	if len(res.Warnings) > 0 {
+		infof("%s", warningTable(res.Warnings))
+	}
+	term.Eprintf("summary: %d error(s), %d warning(s)\n", len(res.Errors), len(res.Warnings))
+	return r
+}
+
+/* ---------- run ---------- */
+
+// runRun builds the script and executes it. Flag parsing stops at the
+// script path (command.stopAtArg), so everything after it — optionally
+// behind a "--" — is passed to the program untouched. This is what a
+// "#!/usr/bin/env -S desic run" shebang line produces.
+func runRun(c *command, o *buildOptions, args []string) int {
+	if len(args) == 0 {
+		return c.usageErr("missing script file")
+	}
+	o.file, args = args[0], args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if o.watch && o.file == "-" {
+		return c.usageErr("--watch cannot be used with stdin input")
+	}
+	if o.cc == "" {
+		path, err := cc.Find("")
+		if err != nil {
+			term.Eprintf("run: %v (pass -cc or see 'desic doctor')\n", err)
+			return exitDiag
+		}
+		o.cc = path
+	}
+	if o.watch {
+		o.cache = desi.NewCheckCache()
+		// The program runs alongside the watch loop: a change stops it,
+		// rebuilds and starts the new binary, so long-running programs
+		// (servers, loops) pick up edits too.
+		var sc *script
+		code := watchLoop(func() []string {
+			sc.stop() // before the build, which rewrites the binary
+			sc = nil
+			r := buildEntry(*o)
+			if r.code == 0 {
+				sc = startScript(r.bin, args)
+			}
+			return r.deps
+		})
+		sc.stop()
+		return code
+	}
+	r := buildEntry(*o)
+	if r.code != 0 {
+		return r.code
+	}
+	return execScript(r.bin, args)
+}
+
+// scriptCmd is the command that runs a built binary with the terminal's
+// stdio.
+func scriptCmd(binPath string, scriptArgs []string) *exec.Cmd {
+	abs, err := filepath.Abs(binPath)
+	if err != nil {
+		abs = binPath
+	}
+	cmd := exec.Command(abs, scriptArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// execScript runs a freshly built binary and returns its exit code.
+func execScript(binPath string, scriptArgs []string) int {
+	if err := scriptCmd(binPath, scriptArgs).Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return ee.ExitCode()
+		}
+		term.Eprintf("run %s: %v\n", binPath, err)
+		return exitInternal
+	}
+	return exitOK
+}
+
+// script is a program `run --watch` started and has not waited for.
+type script struct {
+	cmd     *exec.Cmd
+	done    chan struct{} // closed once the program has exited
+	stopped atomic.Bool   // set by stop, so its kill is not reported
+}
+
+// startScript starts a built binary without waiting for it. When the
+// program ends by itself its status is reported, since the watch loop keeps
+// going. It returns nil if the program could not be started.
+func startScript(binPath string, scriptArgs []string) *script {
+	s := &script{cmd: scriptCmd(binPath, scriptArgs), done: make(chan struct{})}
+	if err := s.cmd.Start(); err != nil {
+		term.Eprintf("run %s: %v\n", binPath, err)
+		return nil
+	}
+	go func() {
+		defer close(s.done)
+		err := s.cmd.Wait()
+		if s.stopped.Load() {
+			return
+		}
+		code := 0
+		if ee, ok := err.(*exec.ExitError); ok {
+			code = ee.ExitCode()
+		}
+		term.Eprintf("[watch] program exited with status %d\n", code)
+	}()
+	return s
+}
+
+// stop kills the program if it is still running and waits for it to exit.
+// A nil script is a no-op.
+func (s *script) stop() {
+	if s == nil {
+		return
+	}
+	s.stopped.Store(true)
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	_ = s.cmd.Process.Kill()
+	<-s.done
+}
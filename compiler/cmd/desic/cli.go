@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/config"
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/highlight"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// Exit codes shared by every subcommand. `run` is the exception once the
+// program has started: it exits with the program's own status.
+const (
+	exitOK       = 0 // success
+	exitDiag     = 1 // the input has diagnostics (read/parse/check/cc errors)
+	exitUsage    = 2 // bad command line
+	exitInternal = 3 // the compiler itself failed (e.g. could not write outputs)
+)
+
+// command is one desic subcommand. Each command owns a flag.FlagSet built
+// fresh per invocation; flag values live in option structs captured by the
+// flags/run closures (see commands()).
+type command struct {
+	name    string
+	args    string // positional synopsis for help, e.g. "<file.desi>..."
+	summary string
+	help    string   // optional longer description printed by `desic help <cmd>`
+	words   []string // fixed positional values offered by shell completion
+
+	// flags registers command-specific flags; may be nil.
+	flags func(fs *flag.FlagSet)
+
+	// run executes the command with the positional arguments left after
+	// flag parsing and returns the exit code.
+	run func(args []string) int
+
+	// stopAtArg ends flag parsing at the first positional argument, so
+	// anything after it is passed through untouched (used by `run`).
+	stopAtArg bool
+}
+
+// globalFlags are accepted by every command, before or after its name.
+type globalFlags struct {
+	quiet   bool   // suppress informational lines such as "wrote ..." / "built ..."
+	color   string // auto | always | never
+	context int    // source lines shown before and after a diagnostic's line
+}
+
+var global globalFlags
+
+// userConfig holds defaults from the user config file; flags override it.
+// main loads it before building the command table.
+var userConfig config.Config
+
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&global.quiet, "quiet", global.quiet, "suppress informational output (wrote/built lines)")
+	fs.BoolVar(&global.quiet, "q", global.quiet, "shorthand for -quiet")
+	fs.StringVar(&global.color, "color", global.color, "colorize diagnostics: auto, always or never")
+	fs.IntVar(&global.context, "context", global.context, "source lines shown before and after a diagnostic's line")
+}
+
+// applyGlobalFlags makes parsed global flags take effect.
+func applyGlobalFlags() error {
+	if global.context < 0 {
+		return fmt.Errorf("context must be at least 0, got %d", global.context)
+	}
+	return term.SetColorMode(global.color)
+}
+
+// infof prints informational progress unless --quiet is set; diagnostics
+// and summaries always go through term.Eprintf directly.
+func infof(format string, a ...any) {
+	if !global.quiet {
+		term.Eprintf(format, a...)
+	}
+}
+
+// reportError prints one error diagnostic with a (possibly colored) label.
+func reportError(e error) {
+	term.Eprintf("%s %v\n", term.Paint(term.Red, "error:"), e)
+}
+
+// reportErrorIn is reportError followed, for a diagnostic that points into
+// a file, by the source lines around it. read returns the contents of the
+// diagnostic's file (d.File, or "" for the input itself), nil if unavailable.
+func reportErrorIn(e error, read func(file string) []byte) {
+	reportError(e)
+	var d diag.Diagnostic
+	if !errors.As(e, &d) || d.Span.Start.Line == 0 {
+		return
+	}
+	if src := read(d.File); src != nil {
+		term.Eprintf("%s", diag.Snippet(string(src), d.Span, diag.SnippetOptions{Context: global.context, Line: highlight.Line}))
+	}
+}
+
+// reportWarning prints one warning diagnostic with a (possibly colored) label.
+func reportWarning(w fmt.Stringer) {
+	term.Eprintf("%s %s\n", term.Paint(term.Yellow, "warning:"), w.String())
+}
+
+// flagSet builds the command's flag set, writing parse errors to w.
+func (c *command) flagSet(w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() { c.printHelp(w) }
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	addGlobalFlags(fs)
+	return fs
+}
+
+// parse parses argv, allowing flags before and after positional arguments
+// (`desic build x.desi --cc=clang`). A literal "--" ends flag parsing; with
+// stopAtArg so does the first positional. "-" is a positional (stdin).
+func (c *command) parse(argv []string, w io.Writer) ([]string, error) {
+	fs := c.flagSet(w)
+	var pos []string
+	for {
+		if err := fs.Parse(argv); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		if consumed := len(argv) - len(rest); consumed > 0 && argv[consumed-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		if c.stopAtArg {
+			return append(pos, rest...), nil
+		}
+		pos = append(pos, rest[0])
+		argv = rest[1:]
+	}
+}
+
+// execute parses flags and runs the command.
+func (c *command) execute(argv []string) int {
+	args, err := c.parse(argv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if err := applyGlobalFlags(); err != nil {
+		term.Eprintf("%s: %v\n", c.name, err)
+		return exitUsage
+	}
+	return c.runGuarded(args)
+}
+
+// runGuarded runs the command. A panic is a bug in desic, never the input's
+// fault, so it is reported as an internal compiler error (ICE) with the stack
+// for a bug report, and exits with exitInternal rather than Go's crash.
+func (c *command) runGuarded(args []string) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			term.Eprintf("%s internal compiler error in desic %s: %v\n", term.Paint(term.Red, "error:"), c.name, r)
+			term.Eprintf("This is a bug in desic. Please report it with the input that caused it and this trace:\n%s", debug.Stack())
+			code = exitInternal
+		}
+	}()
+	return c.run(args)
+}
+
+// usageLine is the one-line synopsis, e.g. "desic build [flags] <entry.desi>".
+func (c *command) usageLine() string {
+	parts := []string{"desic", c.name, "[flags]"}
+	if c.args != "" {
+		parts = append(parts, c.args)
+	}
+	return strings.Join(parts, " ")
+}
+
+// usageErr reports a positional-argument problem and returns exitUsage.
+func (c *command) usageErr(format string, a ...any) int {
+	if format != "" {
+		term.Eprintf(c.name+": "+format+"\n", a...)
+	}
+	term.Eprintf("usage: %s\n", c.usageLine())
+	return exitUsage
+}
+
+func (c *command) printHelp(w io.Writer) {
+	term.Wprintf(w, "usage: %s\n\n%s\n", c.usageLine(), c.summary)
+	if c.help != "" {
+		term.Wprintf(w, "\n%s\n", strings.TrimRight(c.help, "\n"))
+	}
+	term.Wprintf(w, "\nFlags:\n")
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	addGlobalFlags(fs)
+	fs.PrintDefaults()
+}
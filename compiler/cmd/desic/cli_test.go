@@ -0,0 +1,276 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/pkg/desi"
+)
+
+func parseWith(t *testing.T, name string, argv ...string) ([]string, error) {
+	t.Helper()
+	c := lookup(commands(), name)
+	if c == nil {
+		t.Fatalf("no command %q", name)
+	}
+	return c.parse(argv, io.Discard)
+}
+
+func TestBuildFlagsAnywhere(t *testing.T) {
+	var o buildOptions
+	c := &command{name: "build", flags: o.register}
+	args, err := c.parse([]string{"--cc", "clang", "x.desi", "--out=app", "--Werror"}, io.Discard)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(args, []string{"x.desi"}) {
+		t.Fatalf("args = %v, want [x.desi]", args)
+	}
+	if o.cc != "clang" || o.out != "app" || !o.werr {
+		t.Fatalf("options not applied: %+v", o)
+	}
+}
+
+func TestDoubleDashEndsFlags(t *testing.T) {
+	args, err := parseWith(t, "build", "--watch", "--", "-odd-name.desi")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(args, []string{"-odd-name.desi"}) {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestStdinIsPositional(t *testing.T) {
+	args, err := parseWith(t, "parse", "-", "-q")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(args, []string{"-"}) {
+		t.Fatalf("args = %v, want [-]", args)
+	}
+}
+
+func TestRunPassesScriptArgsThrough(t *testing.T) {
+	var o buildOptions
+	c := &command{name: "run", flags: o.register, stopAtArg: true}
+	args, err := c.parse([]string{"--cc=gcc", "s.desi", "--cc=ignored", "-v", "a"}, io.Discard)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []string{"s.desi", "--cc=ignored", "-v", "a"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+	if o.cc != "gcc" {
+		t.Fatalf("cc = %q, want gcc", o.cc)
+	}
+}
+
+func TestUnknownFlagIsUsageError(t *testing.T) {
+	if _, err := parseWith(t, "check", "--nope", "x.desi"); err == nil {
+		t.Fatalf("expected error for unknown flag")
+	}
+	c := lookup(commands(), "check")
+	c.run = func([]string) int { t.Fatalf("run must not be called"); return 0 }
+	if code := c.execute([]string{"--nope"}); code != exitUsage {
+		t.Fatalf("exit = %d, want %d", code, exitUsage)
+	}
+}
+
+func TestPanicIsInternalError(t *testing.T) {
+	stderr := os.Stderr
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+
+	c := lookup(commands(), "check")
+	c.run = func([]string) int {
+		var let *ast.LetStmt
+		return len(let.Name)
+	}
+	if code := c.execute([]string{"x.desi"}); code != exitInternal {
+		t.Fatalf("exit = %d, want %d", code, exitInternal)
+	}
+	out, _ := os.ReadFile(f.Name())
+	for _, want := range []string{"internal compiler error in desic check: runtime error: invalid memory address", "This is a bug in desic", "TestPanicIsInternalError"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("stderr lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestEveryCommandHasHelp(t *testing.T) {
+	for _, c := range commands() {
+		if c.summary == "" || c.run == nil {
+			t.Errorf("command %q lacks summary or run func", c.name)
+		}
+		if _, err := c.parse([]string{"-h"}, io.Discard); err == nil {
+			t.Errorf("command %q: -h should report flag.ErrHelp", c.name)
+		}
+	}
+}
+
+func TestEntrySourceReadsThroughProvider(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.desi")
+	if err := os.WriteFile(main, []byte("on disk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := desi.NewOverlay(nil)
+	fs.Set(main, []byte("unsaved\n"))
+	fs.Set(filepath.Join(dir, "lib", "util.desi"), []byte("util\n"))
+	fs.Set(stdinName, []byte("piped\n"))
+
+	read := entrySource(main, fs)
+	for file, want := range map[string]string{"": "unsaved\n", "main.desi": "unsaved\n", "lib/util.desi": "util\n"} {
+		if got := string(read(file)); got != want {
+			t.Errorf("read(%q) = %q, want %q", file, got, want)
+		}
+	}
+	if got := read("missing.desi"); got != nil {
+		t.Errorf("read(missing) = %q, want nil", got)
+	}
+	if got := string(entrySource("-", fs)(stdinName)); got != "piped\n" {
+		t.Errorf("stdin = %q, want piped", got)
+	}
+}
+
+func TestExplainPrecedence(t *testing.T) {
+	var b strings.Builder
+	writePrecedence(&b)
+	out := b.String()
+	for _, want := range []string{"8      *  /  %", "6      ??               right (reserved)", "3      and", "1      |>", "Ranges .. and ..= bind looser"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	var b strings.Builder
+	if err := writeTrace(&b, "t.desi", []byte("def f() -> void:\n  x := 1 + 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{`1:1     "def"            func_decl`, `2:10    "+"                      prec7`, "2:13    NEWLINE              assign_stmt"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if err := writeTrace(io.Discard, "bad.desi", []byte("def f(\n")); err == nil || !strings.HasPrefix(err.Error(), "parse bad.desi: ") {
+		t.Errorf("err = %v, want a parse error naming bad.desi", err)
+	}
+}
+
+func TestGrammarEBNF(t *testing.T) {
+	var b strings.Builder
+	writeEBNF(&b)
+	out := b.String()
+	for _, want := range []string{"file         := NEWLINE* package_decl?", `prec9        := unary ( "**" prec9 )? ;`, "Terminals from the lexer: IDENT"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTreeSitterRules(t *testing.T) {
+	cases := map[string]string{
+		`"pub"? "import" dotted_ident NEWLINE`:  `seq(optional('pub'), 'import', $.dotted_ident, $._newline)`,
+		`NEWLINE* package_decl? EOF`:            `seq(repeat($._newline), optional($.package_decl))`,
+		`( "-" | "!" | "not" ) unary | postfix`: `choice(seq(choice('-', '!', 'not'), $.unary), $.postfix)`,
+		`NEWLINE INDENT stmt+ DEDENT`:           `seq($._newline, $._indent, repeat1($.stmt), $._dedent)`,
+		`IDENT | "(" expr ")"`:                  `choice($.identifier, seq('(', $.expr, ')'))`,
+	}
+	for ebnf, want := range cases {
+		if got, err := tsRule(ebnf); err != nil || got != want {
+			t.Errorf("tsRule(%s) = %s, %v; want %s", ebnf, got, err, want)
+		}
+	}
+
+	var b strings.Builder
+	if err := writeTreeSitter(&b); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); strings.Count(out, "(") != strings.Count(out, ")") || !strings.Contains(out, "    prec9: $ => seq($.unary, optional(seq('**', $.prec9))),\n") {
+		t.Errorf("grammar.js is unbalanced or lacks prec9:\n%s", out)
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	var b strings.Builder
+	writeHighlights(&b)
+	out := b.String()
+	for _, want := range []string{`"def"`, `"pub"`, `["or" "and" "not"] @keyword.operator`, `"**"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("highlights lack %s:\n%s", want, out)
+		}
+	}
+	// reserved but not yet in the grammar: a query naming them would not compile
+	if strings.Contains(out, `"match"`) || strings.Contains(out, `"struct"`) {
+		t.Errorf("highlights name words the grammar does not use:\n%s", out)
+	}
+}
+
+func TestWarningTable(t *testing.T) {
+	ws := []desi.Warning{{Code: "W0002", Msg: "a"}, {Code: "W0001", Msg: "b"}, {Code: "W0001", Msg: "c"}, {Msg: "d"}}
+	want := "warnings by code:\n  W0001  2\n  -      1\n  W0002  1\n"
+	if got := warningTable(ws); got != want {
+		t.Errorf("warningTable =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGenBuild(t *testing.T) {
+	c := lookup(commands(), "gen-build")
+	o := genBuildOptions{format: "ninja", cc: "cc", runtime: "runtime/c", desic: "desic"}
+	var b strings.Builder
+	if code := runGenBuild(c, o, []string{"app.desi", "tools/fmt.desi"}, &b); code != exitOK {
+		t.Fatalf("exit = %d", code)
+	}
+	for _, want := range []string{
+		"build gen/out/app.c: desic app.desi\n  dep = gen/out/app.d\n",
+		"build gen/out/obj/fmt.o: cc gen/out/fmt.c\n",
+		"build gen/out/obj/desi_std.o: cc runtime/c/desi_std.c\n",
+		"default gen/out/app gen/out/fmt\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("build.ninja lacks %q:\n%s", want, b.String())
+		}
+	}
+
+	o.format = "make"
+	b.Reset()
+	if code := runGenBuild(c, o, []string{"app.desi"}, &b); code != exitOK {
+		t.Fatalf("exit = %d", code)
+	}
+	if want := "gen/out/app: gen/out/obj/app.o gen/out/obj/desi_std.o\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Makefile lacks %q:\n%s", want, b.String())
+	}
+
+	o.cc, o.target = "/opt/zig/zig", "x86_64-windows-gnu"
+	b.Reset()
+	if code := runGenBuild(c, o, []string{"app.desi"}, &b); code != exitOK {
+		t.Fatalf("exit = %d", code)
+	}
+	if want := "CC = /opt/zig/zig cc -target x86_64-windows-gnu\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Makefile lacks %q:\n%s", want, b.String())
+	}
+	if want := "all: gen/out/app.exe\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("Makefile lacks %q:\n%s", want, b.String())
+	}
+
+	o.cc = "gcc"
+	for _, args := range [][]string{{"app.desi"}, {"a/x.desi", "b/x.desi"}, {"-"}} {
+		if code := runGenBuild(c, o, args, io.Discard); code != exitUsage {
+			t.Errorf("gen-build %v: exit = %d, want %d", args, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// Completion scripts are generated from the command table, so new commands
+// and flags show up in shells without touching this file.
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+type flagSpec struct {
+	name  string
+	usage string
+	value bool // takes an argument
+}
+
+// flagSpecs lists a command's flags (including globals) sorted by name.
+func flagSpecs(c *command) []flagSpec {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	addGlobalFlags(fs)
+	var out []flagSpec
+	fs.VisitAll(func(f *flag.Flag) {
+		bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+		out = append(out, flagSpec{name: f.Name, usage: f.Usage, value: !ok || !bf.IsBoolFlag()})
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// dashed renders a flag name the way users type it: -q, --quiet.
+func dashed(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+// takesFiles reports whether a command's positionals are .desi files.
+func takesFiles(c *command) bool { return strings.Contains(c.args, ".desi") }
+
+func runCompletion(c *command, cmds []*command, args []string) int {
+	if len(args) != 1 {
+		return c.usageErr("want one shell name")
+	}
+	var b strings.Builder
+	switch args[0] {
+	case "bash":
+		completionBash(&b, cmds)
+	case "zsh":
+		completionZsh(&b, cmds)
+	case "fish":
+		completionFish(&b, cmds)
+	default:
+		return c.usageErr("unsupported shell %q (want %s)", args[0], strings.Join(completionShells, ", "))
+	}
+	term.Printf("%s", b.String())
+	return exitOK
+}
+
+func commandNames(cmds []*command) []string {
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func completionBash(b *strings.Builder, cmds []*command) {
+	term.Bprintf(b, "# bash completion for desic; load with: source <(desic completion bash)\n")
+	term.Bprintf(b, "_desic() {\n")
+	term.Bprintf(b, "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" cmd=\"${COMP_WORDS[1]}\" flags=\"\" words=\"\" files=0\n")
+	term.Bprintf(b, "  if [[ $COMP_CWORD -eq 1 ]]; then\n")
+	term.Bprintf(b, "    COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(commandNames(cmds), " "))
+	term.Bprintf(b, "    return\n")
+	term.Bprintf(b, "  fi\n")
+	term.Bprintf(b, "  case \"$cmd\" in\n")
+	for _, c := range cmds {
+		var fl []string
+		for _, f := range flagSpecs(c) {
+			fl = append(fl, dashed(f.name))
+		}
+		term.Bprintf(b, "    %s) flags=\"%s\"", c.name, strings.Join(fl, " "))
+		if len(c.words) > 0 {
+			term.Bprintf(b, "; words=\"%s\"", strings.Join(c.words, " "))
+		}
+		if takesFiles(c) {
+			term.Bprintf(b, "; files=1")
+		}
+		term.Bprintf(b, " ;;\n")
+	}
+	term.Bprintf(b, "  esac\n")
+	term.Bprintf(b, "  if [[ \"$cur\" == -* ]]; then\n")
+	term.Bprintf(b, "    COMPREPLY=( $(compgen -W \"$flags\" -- \"$cur\") )\n")
+	term.Bprintf(b, "  elif [[ $files -eq 1 ]]; then\n")
+	term.Bprintf(b, "    COMPREPLY=( $(compgen -f -X '!*.desi' -- \"$cur\") $(compgen -d -- \"$cur\") )\n")
+	term.Bprintf(b, "  else\n")
+	term.Bprintf(b, "    COMPREPLY=( $(compgen -W \"$words\" -- \"$cur\") )\n")
+	term.Bprintf(b, "  fi\n")
+	term.Bprintf(b, "}\n")
+	term.Bprintf(b, "complete -o filenames -F _desic desic\n")
+}
+
+// zshEscape escapes characters that are special inside _arguments/_describe specs.
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func completionZsh(b *strings.Builder, cmds []*command) {
+	term.Bprintf(b, "#compdef desic\n")
+	term.Bprintf(b, "# zsh completion for desic; save as _desic somewhere on $fpath\n")
+	term.Bprintf(b, "_desic() {\n")
+	term.Bprintf(b, "  local -a commands\n")
+	term.Bprintf(b, "  commands=(\n")
+	for _, c := range cmds {
+		term.Bprintf(b, "    '%s:%s'\n", c.name, zshEscape(c.summary))
+	}
+	term.Bprintf(b, "  )\n")
+	term.Bprintf(b, "  if (( CURRENT == 2 )); then\n")
+	term.Bprintf(b, "    _describe 'command' commands\n")
+	term.Bprintf(b, "    return\n")
+	term.Bprintf(b, "  fi\n")
+	term.Bprintf(b, "  shift words; (( CURRENT-- ))\n")
+	term.Bprintf(b, "  case $words[1] in\n")
+	for _, c := range cmds {
+		var specs []string
+		for _, f := range flagSpecs(c) {
+			if f.value {
+				specs = append(specs, fmt.Sprintf("'%s=[%s]:%s: '", dashed(f.name), zshEscape(f.usage), f.name))
+			} else {
+				specs = append(specs, fmt.Sprintf("'%s[%s]'", dashed(f.name), zshEscape(f.usage)))
+			}
+		}
+		switch {
+		case takesFiles(c):
+			specs = append(specs, `'*:file:_files -g "*.desi"'`)
+		case len(c.words) > 0:
+			specs = append(specs, fmt.Sprintf("'1:arg:(%s)'", strings.Join(c.words, " ")))
+		}
+		term.Bprintf(b, "    %s)\n      _arguments %s\n      ;;\n", c.name, strings.Join(specs, " \\\n        "))
+	}
+	term.Bprintf(b, "  esac\n")
+	term.Bprintf(b, "}\n")
+	term.Bprintf(b, "_desic \"$@\"\n")
+}
+
+func fishEscape(s string) string { return strings.ReplaceAll(s, "'", "\\'") }
+
+func completionFish(b *strings.Builder, cmds []*command) {
+	term.Bprintf(b, "# fish completion for desic; save as ~/.config/fish/completions/desic.fish\n")
+	term.Bprintf(b, "complete -c desic -f\n")
+	for _, c := range cmds {
+		term.Bprintf(b, "complete -c desic -n __fish_use_subcommand -a %s -d '%s'\n", c.name, fishEscape(c.summary))
+	}
+	for _, c := range cmds {
+		cond := "__fish_seen_subcommand_from " + c.name
+		for _, f := range flagSpecs(c) {
+			opt := "-l " + f.name
+			if len(f.name) == 1 {
+				opt = "-s " + f.name
+			}
+			if f.value {
+				opt += " -r"
+			}
+			term.Bprintf(b, "complete -c desic -n '%s' %s -d '%s'\n", cond, opt, fishEscape(f.usage))
+		}
+		if takesFiles(c) {
+			term.Bprintf(b, "complete -c desic -n '%s' -a '(__fish_complete_suffix .desi)'\n", cond)
+		}
+		if len(c.words) > 0 {
+			term.Bprintf(b, "complete -c desic -n '%s' -a '%s'\n", cond, strings.Join(c.words, " "))
+		}
+	}
+}
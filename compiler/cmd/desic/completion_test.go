@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompletionCoversCommandsAndFlags(t *testing.T) {
+	cmds := commands()
+	gens := map[string]func(*strings.Builder, []*command){
+		"bash": completionBash,
+		"zsh":  completionZsh,
+		"fish": completionFish,
+	}
+	for shell, gen := range gens {
+		var b strings.Builder
+		gen(&b, cmds)
+		out := b.String()
+		for _, c := range cmds {
+			if !strings.Contains(out, c.name) {
+				t.Errorf("%s: missing command %q", shell, c.name)
+			}
+		}
+		for _, want := range []string{"Werror", "watch", "quiet", ".desi"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: missing %q", shell, want)
+			}
+		}
+	}
+}
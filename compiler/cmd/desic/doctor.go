@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/cc"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// doctorCheck is one environment probe. fix is shown only on failure.
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+	fix    string
+}
+
+type doctorOptions struct {
+	cc      string
+	runtime string
+}
+
+func runDoctor(o doctorOptions) int {
+	var checks []doctorCheck
+	checks = append(checks, doctorConfig())
+	compiler, ccCheck := doctorCompiler(o.cc)
+	checks = append(checks, ccCheck)
+	rtCheck := doctorRuntime(o.runtime)
+	checks = append(checks, rtCheck)
+	checks = append(checks, doctorGenDir())
+	if ccCheck.ok && rtCheck.ok {
+		checks = append(checks, doctorSmokeBuild(compiler, o.runtime))
+	}
+
+	failed := 0
+	for _, c := range checks {
+		label := term.Paint(term.Green, "[ok]  ")
+		if !c.ok {
+			label = term.Paint(term.Red, "[FAIL]")
+			failed++
+		}
+		term.Printf("%s %s: %s\n", label, c.name, c.detail)
+		if !c.ok && c.fix != "" {
+			term.Printf("       fix: %s\n", c.fix)
+		}
+	}
+	if failed > 0 {
+		term.Printf("\n%d problem(s) found\n", failed)
+		return exitDiag
+	}
+	term.Printf("\nall checks passed\n")
+	return exitOK
+}
+
+func doctorConfig() doctorCheck {
+	c := doctorCheck{name: "config", ok: true}
+	if userConfig.Path == "" {
+		c.detail = "no config file (optional; see 'desic help')"
+	} else {
+		c.detail = userConfig.Path
+	}
+	return c
+}
+
+func doctorCompiler(explicit string) (string, doctorCheck) {
+	c := doctorCheck{name: "C compiler"}
+	path, err := cc.Find(explicit)
+	if err != nil {
+		c.detail = err.Error()
+		c.fix = "run 'desic toolchain install', or install clang or gcc (Windows: LLVM for Windows) and make sure it is on PATH, or pass -cc / set cc in the config"
+		return "", c
+	}
+	c.ok = true
+	c.detail = path
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err == nil {
+		if first, _, _ := strings.Cut(string(out), "\n"); first != "" {
+			c.detail += " (" + strings.TrimSpace(first) + ")"
+		}
+	}
+	return path, c
+}
+
+func doctorRuntime(dir string) doctorCheck {
+	c := doctorCheck{name: "runtime", detail: dir}
+	for _, f := range []string{"desi_std.c", "desi_std.h"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			c.detail = filepath.Join(dir, f) + " not found"
+			c.fix = "run desic from the repository root, or pass -runtime / set runtime_dir in the config"
+			return c
+		}
+	}
+	c.ok = true
+	return c
+}
+
+func doctorGenDir() doctorCheck {
+	dir := filepath.Join("gen", "out")
+	c := doctorCheck{name: "output dir", detail: dir + " is writable"}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.detail = err.Error()
+		c.fix = "run desic from a writable directory (build output goes to ./gen/out)"
+		return c
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		c.detail = err.Error()
+		c.fix = "check permissions on " + dir
+		return c
+	}
+	name := f.Name()
+	_ = f.Close()
+	_ = os.Remove(name)
+	c.ok = true
+	return c
+}
+
+// doctorSmokeBuild compiles and runs a trivial program against the runtime,
+// which catches broken toolchains (missing headers, no linker) that a
+// --version probe does not.
+func doctorSmokeBuild(compiler, runtimeDir string) doctorCheck {
+	c := doctorCheck{name: "smoke build"}
+	tmp, err := os.MkdirTemp("", "desi-doctor-")
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "smoke.c")
+	prog := "#include \"desi_std.h\"\nint main(void) { return 0; }\n"
+	if err := os.WriteFile(src, []byte(prog), 0o644); err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	var out bytes.Buffer
+	co := cc.Options{CC: compiler, RuntimeDir: runtimeDir, Sources: []string{src}, Out: filepath.Join(tmp, "smoke"), Stdout: &out, Stderr: &out}
+	err = cc.Compile(co)
+	if err == nil {
+		err = exec.Command(co.Binary()).Run()
+	}
+	if err != nil {
+		c.detail = err.Error()
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			c.detail += "\n       " + strings.ReplaceAll(msg, "\n", "\n       ")
+		}
+		c.fix = "the compiler could not build a program with the runtime; check that the C standard library headers are installed"
+		return c
+	}
+	c.ok = true
+	c.detail = "compiled and ran a test program"
+	return c
+}
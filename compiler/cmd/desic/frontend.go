@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/highlight"
+	"github.com/desilang/desi/compiler/internal/lexer"
+	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/internal/vfs"
+	"github.com/desilang/desi/compiler/pkg/desi"
+)
+
+/* ---------- input ---------- */
+
+// stdinName is the synthetic file name diagnostics use for "-" input.
+const stdinName = "<stdin>"
+
+// readSource reads path, or all of stdin when path is "-".
+func readSource(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// displayName is how a file argument is shown in messages.
+func displayName(path string) string {
+	if path == "-" {
+		return stdinName
+	}
+	return path
+}
+
+// entryFS returns the files an entry is loaded from and the path to load it
+// by. For "-" the source comes from stdin and is served from an overlay at
+// ./<stdin>, so relative imports resolve against the working directory.
+func entryFS(path string) (desi.FileProvider, string, error) {
+	if path != "-" {
+		return vfs.OS{}, path, nil
+	}
+	data, err := readSource(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read %s: %v", stdinName, err)
+	}
+	fs := desi.NewOverlay(nil)
+	fs.Set(stdinName, data)
+	return fs, stdinName, nil
+}
+
+// loadEntry resolves and parses the entry file plus its imports. It also
+// returns the files they were read from, for entrySource.
+func loadEntry(path string, denyDeprecated bool) (*desi.LoadResult, desi.FileProvider, []error) {
+	fs, entry, err := entryFS(path)
+	if err != nil {
+		return &desi.LoadResult{}, nil, []error{err}
+	}
+	res, errs := desi.Load(entry, desi.LoadOptions{FS: fs, StdDir: userConfig.StdDir, DenyDeprecated: denyDeprecated})
+	return res, fs, errs
+}
+
+/* ---------- batch ---------- */
+
+// expandInputs expands glob patterns in args. Plain paths (and "-") are kept
+// as given so a missing file still produces a normal read error; a glob
+// that matches nothing is reported, since silently doing nothing hides typos.
+func expandInputs(args []string) ([]string, error) {
+	var out []string
+	stdin := false
+	for _, a := range args {
+		if a == "-" {
+			if stdin {
+				return nil, fmt.Errorf("stdin (-) given more than once")
+			}
+			stdin = true
+			out = append(out, a)
+			continue
+		}
+		if !strings.ContainsAny(a, "*?[") {
+			out = append(out, a)
+			continue
+		}
+		matches, err := filepath.Glob(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", a, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", a)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// runBatch applies one to every file. With more than one file each result
+// gets a "==> file <==" header and a final tally is printed; the exit code
+// is the worst per-file code.
+func runBatch(files []string, one func(path string) int) int {
+	if len(files) == 1 {
+		return one(files[0])
+	}
+	worst, failed := 0, 0
+	for i, f := range files {
+		if i > 0 {
+			term.Println()
+		}
+		term.Printf("==> %s <==\n", displayName(f))
+		code := one(f)
+		if code != 0 {
+			failed++
+		}
+		if code > worst {
+			worst = code
+		}
+	}
+	term.Eprintf("batch: %d file(s) OK, %d with errors\n", len(files)-failed, failed)
+	return worst
+}
+
+// batchCommand adapts a per-file function into a command run func that
+// expands globs and aggregates results.
+func batchCommand(c *command, one func(path string) int) func(args []string) int {
+	return func(args []string) int {
+		files, err := expandInputs(args)
+		if err != nil {
+			return c.usageErr("%v", err)
+		}
+		if len(files) == 0 {
+			return c.usageErr("")
+		}
+		return runBatch(files, one)
+	}
+}
+
+// entrySource reads the files named by the loader's diagnostics, which are
+// relative to the entry file's directory ("" is the entry itself; "-" is
+// read at stdinName, as entryFS serves it). It reads
+// through fs, the provider the build used, so snippets show the overlaid
+// contents of stdin or unsaved buffers rather than what is on disk.
+func entrySource(entry string, fs desi.FileProvider) func(file string) []byte {
+	return func(file string) []byte {
+		if fs == nil {
+			return nil
+		}
+		if entry == "-" {
+			entry = stdinName
+		}
+		path := entry
+		if file != "" {
+			path = filepath.Join(filepath.Dir(entry), file)
+		}
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+}
+
+/* ---------- lex ---------- */
+
+// lexOne streams the tokens of path, so large generated files and piped
+// input are lexed without being read whole first. Diagnostics re-read a
+// file for their snippets; stdin cannot be re-read, so its have none.
+func lexOne(path string) int {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			term.Eprintf("read %s: %v\n", displayName(path), err)
+			return exitDiag
+		}
+		defer f.Close()
+		r = f
+	}
+	lx := lexer.NewFromReader(r)
+	for {
+		t := lx.Next()
+		if t.Kind == lexer.TokEOF {
+			term.Printf("%d:%d  %s\n", t.Line, t.Col, t.Kind)
+			break
+		}
+		lex := t.Lex
+		if len(lex) > 40 {
+			lex = lex[:37] + "..."
+		}
+		if lex == "" {
+			term.Printf("%d:%d  %-8s\n", t.Line, t.Col, t.Kind)
+		} else {
+			term.Printf("%d:%d  %-8s  %q\n", t.Line, t.Col, t.Kind, lex)
+		}
+	}
+	if err := lx.ReadError(); err != nil {
+		term.Eprintf("read %s: %v\n", displayName(path), err)
+		return exitDiag
+	}
+	if errs := lx.Errors(); len(errs) > 0 {
+		var data []byte
+		if path != "-" {
+			data, _ = os.ReadFile(path)
+		}
+		for _, e := range errs {
+			reportErrorIn(e, func(string) []byte { return data })
+		}
+		return exitDiag
+	}
+	return exitOK
+}
+
+/* ---------- highlight ---------- */
+
+func highlightOne(path string) int {
+	data, err := readSource(path)
+	if err != nil {
+		term.Eprintf("read %s: %v\n", displayName(path), err)
+		return exitDiag
+	}
+	term.Printf("%s", highlight.Source(string(data)))
+	return exitOK
+}
+
+/* ---------- parse ---------- */
+
+func parseOne(path string, trace bool) int {
+	data, err := readSource(path)
+	if err != nil {
+		term.Eprintf("read %s: %v\n", displayName(path), err)
+		return exitDiag
+	}
+	if trace {
+		if err := writeTrace(os.Stdout, displayName(path), data); err != nil {
+			term.Eprintf("%v\n", err)
+			return exitDiag
+		}
+		return exitOK
+	}
+	f, err := desi.Parse(displayName(path), data)
+	if err != nil {
+		term.Eprintf("%v\n", err)
+		return exitDiag
+	}
+	out := ast.DumpFile(f)
+	term.Printf("%s", out)
+	return exitOK
+}
+
+// writeTrace parses src, writing one line per token the parser consumes:
+// its position, its text (or kind, for layout tokens), and the innermost
+// production open, indented by how many enclose it.
+func writeTrace(w io.Writer, name string, src []byte) error {
+	p := parser.New(string(src))
+	p.SetTrace(func(t lexer.Token, prods []string) {
+		text := t.Kind.String()
+		if t.Lex != "" {
+			lex := t.Lex
+			if len(lex) > 40 {
+				lex = lex[:37] + "..."
+			}
+			text = strconv.Quote(lex)
+		}
+		prod, depth := "", 0
+		if n := len(prods); n > 0 {
+			prod, depth = prods[n-1], n-1
+		}
+		term.Wprintf(w, "%-7s %-14s %s%s\n", fmt.Sprintf("%d:%d", t.Line, t.Col), text, strings.Repeat("  ", depth), prod)
+	})
+	if _, err := p.ParseFile(); err != nil {
+		return fmt.Errorf("parse %s: %v", name, err)
+	}
+	return nil
+}
+
+/* ---------- check ---------- */
+
+type checkOptions struct {
+	werr           bool // --Werror
+	denyDeprecated bool // --deny-deprecated
+}
+
+func checkOne(path string, o checkOptions) int {
+	res, fs, perr := loadEntry(path, o.denyDeprecated)
+	if len(perr) > 0 {
+		perr = diag.Tidy(perr)
+		for _, e := range perr {
+			reportErrorIn(e, entrySource(path, fs))
+		}
+		term.Eprintf("summary: %d error(s), %d warning(s)\n", len(perr), 0)
+		return exitDiag
+	}
+	_, errs, warns := desi.Check(res.File)
+	errs = diag.Tidy(errs)
+	warns = append(res.Warnings, warns...)
+	for _, w := range warns {
+		reportWarning(w)
+	}
+	for _, e := range errs {
+		reportErrorIn(e, entrySource(path, fs))
+	}
+	term.Eprintf("summary: %d error(s), %d warning(s)\n", len(errs), len(warns))
+	if len(errs) > 0 || (o.werr && len(warns) > 0) {
+		return exitDiag
+	}
+	return exitOK
+}
+
+/* ---------- explain-codegen ---------- */
+
+// runExplainCodegen prints the C for an entry file with each function and
+// statement preceded by the Desi line it came from.
+func runExplainCodegen(c *command, args []string) int {
+	if len(args) != 1 {
+		return c.usageErr("want exactly one entry file, got %d", len(args))
+	}
+	path := args[0]
+	res, fs, errs := loadEntry(path, false)
+	var info *desi.Info
+	if len(errs) == 0 {
+		info, errs, _ = desi.Check(res.File)
+	}
+	if len(errs) > 0 {
+		errs = diag.Tidy(errs)
+		for _, e := range errs {
+			reportErrorIn(e, entrySource(path, fs))
+		}
+		term.Eprintf("summary: %d error(s), %d warning(s)\n", len(errs), 0)
+		return exitDiag
+	}
+	term.Printf("%s", desi.EmitCExplained(res.File, info, fs))
+	return exitOK
+}
+
+/* ---------- ast-diff ---------- */
+
+func runASTDiff(c *command, args []string) int {
+	if len(args) != 2 {
+		return c.usageErr("want two files, got %d", len(args))
+	}
+	if args[0] == "-" && args[1] == "-" {
+		return c.usageErr("stdin (-) given more than once")
+	}
+	var files [2]*ast.File
+	for i, path := range args {
+		data, err := readSource(path)
+		if err != nil {
+			term.Eprintf("read %s: %v\n", displayName(path), err)
+			return exitDiag
+		}
+		if files[i], err = desi.Parse(displayName(path), data); err != nil {
+			term.Eprintf("%v\n", err)
+			return exitDiag
+		}
+	}
+	for _, ch := range ast.Diff(files[0], files[1]) {
+		switch ch.Kind {
+		case ast.Added:
+			term.Printf("+ %s\n", ch.ID)
+		case ast.Removed:
+			term.Printf("- %s\n", ch.ID)
+		case ast.SigChanged:
+			term.Printf("~ %s: %s\n    was: %s\n    now: %s\n", ch.ID, ch.Kind, ch.Old, ch.New)
+		case ast.BodyChanged:
+			term.Printf("~ %s: %s\n", ch.ID, ch.Kind)
+		}
+		for _, id := range ch.Stmts {
+			term.Printf("    at %s\n", id)
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/cc"
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/pkg/desi"
+)
+
+// genBuildOptions holds the flags of `gen-build`.
+type genBuildOptions struct {
+	format  string // --format: ninja | make
+	cc      string // --cc
+	runtime string // --runtime
+	desic   string // --desic: how the generated file invokes the compiler
+	target  string // --target
+}
+
+// genFormats are the build file formats gen-build writes.
+var genFormats = []string{"ninja", "make"}
+
+// genEntry is one program in a generated build file. Stage-0 emits one C
+// translation unit per program (the loader merges its modules), so the
+// program is the unit of C emission; its modules reach the build tool
+// through the depfile `desic build --emit=deps` writes.
+type genEntry struct {
+	src  string // entry .desi file
+	c    string // gen/out/<name>.c
+	dep  string // gen/out/<name>.d
+	obj  string // gen/out/obj/<name>.o
+	bin  string // gen/out/<name>, plus .exe on Windows
+	name string
+}
+
+// genPlan is everything a generated build file describes. Paths use forward
+// slashes; both tools accept them on every platform.
+type genPlan struct {
+	desic, cc string
+	msvc      bool
+	linkFlags string // see cc.Options.LinkFlags
+	runtime   string // runtime directory
+	rtSrc     string // runtime/c/desi_std.c
+	rtObj     string // gen/out/obj/desi_std.o
+	entries   []genEntry
+}
+
+func runGenBuild(c *command, o genBuildOptions, args []string, w io.Writer) int {
+	switch o.format {
+	case "ninja", "make":
+	default:
+		return c.usageErr("--format: unknown format %q (want %s)", o.format, strings.Join(genFormats, ", "))
+	}
+	files, err := expandInputs(args)
+	if err != nil {
+		return c.usageErr("%v", err)
+	}
+	if len(files) == 0 {
+		return c.usageErr("want at least one entry file")
+	}
+	p, err := newGenPlan(o, files)
+	if err != nil {
+		return c.usageErr("%v", err)
+	}
+	if o.format == "make" {
+		writeMakefile(w, p)
+	} else {
+		writeNinja(w, p)
+	}
+	return exitOK
+}
+
+func newGenPlan(o genBuildOptions, files []string) (*genPlan, error) {
+	compiler := o.cc
+	if compiler == "" {
+		compiler = "cc"
+	}
+	if o.target != "" && !cc.CanTarget(compiler) {
+		return nil, fmt.Errorf("--target: %s cannot cross-compile; use zig or clang", compiler)
+	}
+	msvc := cc.MSVC(compiler)
+	linkFlags := strings.Join(cc.Options{CC: compiler, Target: o.target}.LinkFlags(), " ")
+	if cc.Zig(compiler) {
+		compiler += " cc"
+	}
+	if o.target != "" {
+		compiler += " -target " + o.target
+	}
+	objExt := ".o"
+	if msvc {
+		objExt = ".obj"
+	}
+	out := filepath.ToSlash(desi.DefaultOutDir)
+	rt := filepath.ToSlash(o.runtime)
+	p := &genPlan{
+		desic:     o.desic,
+		cc:        compiler,
+		msvc:      msvc,
+		linkFlags: linkFlags,
+		runtime:   rt,
+		rtSrc:     path.Join(rt, "desi_std.c"),
+		rtObj:     path.Join(out, "obj", "desi_std"+objExt),
+	}
+	seen := map[string]string{}
+	for _, f := range files {
+		if f == "-" {
+			return nil, fmt.Errorf("stdin (-) cannot be a build file entry")
+		}
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s and %s would both build %s/%s", prev, f, out, name)
+		}
+		seen[name] = f
+		p.entries = append(p.entries, genEntry{
+			src:  filepath.ToSlash(f),
+			c:    path.Join(out, name+".c"),
+			dep:  path.Join(out, name+".d"),
+			obj:  path.Join(out, "obj", name+objExt),
+			bin:  filepath.ToSlash(cc.Options{Out: filepath.Join(out, name), Target: o.target}.Binary()),
+			name: name,
+		})
+	}
+	return p, nil
+}
+
+/* ---------- ninja ---------- */
+
+// ninjaEscape escapes a path for a build line.
+func ninjaEscape(s string) string {
+	return strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:").Replace(s)
+}
+
+func writeNinja(w io.Writer, p *genPlan) {
+	term.Wprintf(w, "# Generated by 'desic gen-build --format=ninja'. Run ninja from the\n")
+	term.Wprintf(w, "# directory desic ran in; regenerate after adding or renaming entries.\n\n")
+	term.Wprintf(w, "desic = %s\ncc = %s\nruntime = %s\n\n", p.desic, p.cc, ninjaEscape(p.runtime))
+	term.Wprintf(w, "rule desic\n")
+	term.Wprintf(w, "  command = $desic -q build --emit=deps $in\n")
+	term.Wprintf(w, "  depfile = $dep\n  deps = gcc\n  description = DESIC $in\n\n")
+	term.Wprintf(w, "rule cc\n")
+	if p.msvc {
+		term.Wprintf(w, "  command = $cc /nologo /showIncludes /c $in /I $runtime /Fo$out\n")
+		term.Wprintf(w, "  deps = msvc\n")
+	} else {
+		term.Wprintf(w, "  command = $cc -MMD -MF $out.d -I $runtime -c $in -o $out\n")
+		term.Wprintf(w, "  depfile = $out.d\n  deps = gcc\n")
+	}
+	term.Wprintf(w, "  description = CC $out\n\n")
+	term.Wprintf(w, "rule link\n")
+	if p.msvc {
+		term.Wprintf(w, "  command = $cc /nologo $in /Fe$out\n")
+	} else {
+		term.Wprintf(w, "  command = $cc $in%s -o $out\n", flagSuffix(p.linkFlags))
+	}
+	term.Wprintf(w, "  description = LINK $out\n\n")
+	term.Wprintf(w, "build %s: cc %s\n", ninjaEscape(p.rtObj), ninjaEscape(p.rtSrc))
+	var bins []string
+	for _, e := range p.entries {
+		term.Wprintf(w, "\nbuild %s: desic %s\n  dep = %s\n", ninjaEscape(e.c), ninjaEscape(e.src), ninjaEscape(e.dep))
+		term.Wprintf(w, "build %s: cc %s\n", ninjaEscape(e.obj), ninjaEscape(e.c))
+		term.Wprintf(w, "build %s: link %s %s\n", ninjaEscape(e.bin), ninjaEscape(e.obj), ninjaEscape(p.rtObj))
+		term.Wprintf(w, "build %s: phony %s\n", ninjaEscape(e.name), ninjaEscape(e.bin))
+		bins = append(bins, ninjaEscape(e.bin))
+	}
+	term.Wprintf(w, "\ndefault %s\n", strings.Join(bins, " "))
+}
+
+// flagSuffix is flags with a separating space, or nothing when empty.
+func flagSuffix(flags string) string {
+	if flags == "" {
+		return ""
+	}
+	return " " + flags
+}
+
+/* ---------- make ---------- */
+
+// makeEscape escapes a path for a rule line. Make cannot quote spaces in
+// prerequisites portably, so they are left alone.
+func makeEscape(s string) string {
+	return strings.NewReplacer("$", "$$", "#", `\#`).Replace(s)
+}
+
+func writeMakefile(w io.Writer, p *genPlan) {
+	term.Wprintf(w, "# Generated by 'desic gen-build --format=make'. Run make from the\n")
+	term.Wprintf(w, "# directory desic ran in; regenerate after adding or renaming entries.\n\n")
+	term.Wprintf(w, "DESIC = %s\nCC = %s\nRUNTIME = %s\n\n", p.desic, p.cc, makeEscape(p.runtime))
+	var bins []string
+	for _, e := range p.entries {
+		bins = append(bins, makeEscape(e.bin))
+	}
+	term.Wprintf(w, ".PHONY: all\nall: %s\n\n", strings.Join(bins, " "))
+	compile := "$(CC) -MMD -MP -I $(RUNTIME) -c $< -o $@"
+	link := "$(CC) $^" + flagSuffix(p.linkFlags) + " -o $@"
+	if p.msvc {
+		compile = "$(CC) /nologo /c $< /I $(RUNTIME) /Fo$@"
+		link = "$(CC) /nologo $^ /Fe$@"
+	}
+	term.Wprintf(w, "%s: %s\n\t@mkdir -p $(@D)\n\t%s\n", makeEscape(p.rtObj), makeEscape(p.rtSrc), compile)
+	var deps []string
+	for _, e := range p.entries {
+		term.Wprintf(w, "\n%s: %s\n\t$(DESIC) -q build --emit=deps $<\n", makeEscape(e.c), makeEscape(e.src))
+		term.Wprintf(w, "%s: %s\n\t@mkdir -p $(@D)\n\t%s\n", makeEscape(e.obj), makeEscape(e.c), compile)
+		term.Wprintf(w, "%s: %s %s\n\t%s\n", makeEscape(e.bin), makeEscape(e.obj), makeEscape(p.rtObj), link)
+		deps = append(deps, makeEscape(e.dep))
+		if !p.msvc {
+			deps = append(deps, makeEscape(strings.TrimSuffix(e.obj, path.Ext(e.obj))+".d"))
+		}
+	}
+	if !p.msvc {
+		deps = append(deps, makeEscape(strings.TrimSuffix(p.rtObj, path.Ext(p.rtObj))+".d"))
+	}
+	term.Wprintf(w, "\n-include %s\n", strings.Join(deps, " "))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// writeEBNF prints the grammar the parser accepts, one production per line,
+// in the notation of docs/spec/grammar.ebnf.
+func writeEBNF(w io.Writer) {
+	rules := parser.Grammar()
+	width := 0
+	for _, r := range rules {
+		width = max(width, len(r.Name))
+	}
+	term.Wprintf(w, "(* Desi Stage-0 grammar, as accepted by the parser (desic grammar --ebnf) *)\n\n")
+	for _, r := range rules {
+		term.Wprintf(w, "%-*s := %s ;\n", width, r.Name, r.Body)
+	}
+	term.Wprintf(w, "\n(* Terminals from the lexer: %s *)\n", strings.Join(parser.Terminals, ", "))
+}
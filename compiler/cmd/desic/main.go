@@ -1,266 +1,382 @@
 package main
 
 import (
-  "flag"
-  "os"
-  "os/exec"
-  "path/filepath"
-  "strings"
+	"errors"
+	"flag"
+	"io"
+	"os"
 
-  "github.com/desilang/desi/compiler/internal/ast"
-  "github.com/desilang/desi/compiler/internal/build"
-  "github.com/desilang/desi/compiler/internal/check"
-  cgen "github.com/desilang/desi/compiler/internal/codegen/c"
-  "github.com/desilang/desi/compiler/internal/lexer"
-  "github.com/desilang/desi/compiler/internal/parser"
-  "github.com/desilang/desi/compiler/internal/term"
-  "github.com/desilang/desi/compiler/internal/version"
+	"github.com/desilang/desi/compiler/internal/config"
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/internal/toolchain"
+	"github.com/desilang/desi/compiler/internal/version"
 )
 
-func usage() {
-  term.Eprintln("desic — Desi compiler (Stage-0)")
-  term.Eprintln("")
-  term.Eprintln("Usage:")
-  term.Eprintln("  desic <command> [args]")
-  term.Eprintln("")
-  term.Eprintln("Commands:")
-  term.Eprintln("  version                    Print version")
-  term.Eprintln("  help                       Show this help")
-  term.Eprintln("  lex <file>                 Lex a .desi file and print tokens")
-  term.Eprintln("  parse <file>               Parse a .desi file and print AST outline")
-  term.Eprintln("  build [--cc=clang] [--out=name] [--Werror] <entry.desi>")
-  term.Eprintln("        (flags may appear before or after the file)")
-  term.Eprintln("")
-  term.Eprintln("Notes:")
-  term.Eprintln("  - Imports like 'foo.bar' resolve to 'foo/bar.desi' relative to the entry file’s dir.")
-  term.Eprintln("  - Imports starting with 'std.' are ignored in Stage-0 (provided by runtime).")
-  term.Eprintln("")
-  term.Eprintln("Outputs:")
-  term.Eprintln("  generated C:  gen/out/<basename>.c")
-  term.Eprintln("  binary (if --cc): gen/out/<out|basename>")
-}
+// commands returns the command table. Option structs are allocated here so
+// each table (and each test) gets fresh flag storage.
+func commands() []*command {
+	var cmds []*command
 
-/* ---------- lex ---------- */
+	versionCmd := &command{name: "version", summary: "Print version"}
+	versionCmd.run = func(args []string) int {
+		if len(args) != 0 {
+			return versionCmd.usageErr("unexpected arguments")
+		}
+		term.Printf("%s\n", version.String())
+		return exitOK
+	}
 
-func cmdLexDirect(path string) int {
-  data, err := os.ReadFile(path)
-  if err != nil {
-    term.Eprintf("read %s: %v\n", path, err)
-    return 1
-  }
-  lx := lexer.New(string(data))
-  for {
-    t := lx.Next()
-    if t.Kind == lexer.TokEOF {
-      term.Printf("%d:%d  %s\n", t.Line, t.Col, t.Kind)
-      break
-    }
-    lex := t.Lex
-    if len(lex) > 40 {
-      lex = lex[:37] + "..."
-    }
-    if lex == "" {
-      term.Printf("%d:%d  %-8s\n", t.Line, t.Col, t.Kind)
-    } else {
-      term.Printf("%d:%d  %-8s  %q\n", t.Line, t.Col, t.Kind, lex)
-    }
-  }
-  return 0
-}
+	helpCmd := &command{name: "help", args: "[command]", summary: "Show help for desic or a command"}
+	helpCmd.run = func(args []string) int {
+		switch len(args) {
+		case 0:
+			usage(os.Stdout, cmds)
+			return exitOK
+		case 1:
+			if c := lookup(cmds, args[0]); c != nil {
+				c.printHelp(os.Stdout)
+				return exitOK
+			}
+			term.Eprintf("help: unknown command %q\n", args[0])
+			return exitUsage
+		default:
+			return helpCmd.usageErr("want at most one command name")
+		}
+	}
 
-/* ---------- parse ---------- */
+	lexCmd := &command{name: "lex", args: "<file.desi|glob>...", summary: "Lex .desi files and print tokens"}
+	lexCmd.run = batchCommand(lexCmd, lexOne)
 
-func cmdParse(args []string) int {
-  if len(args) != 1 {
-    term.Eprintln("usage: desic parse <file.desi>")
-    return 2
-  }
-  data, err := os.ReadFile(args[0])
-  if err != nil {
-    term.Eprintf("read %s: %v\n", args[0], err)
-    return 1
-  }
-  p := parser.New(string(data))
-  f, err := p.ParseFile()
-  if err != nil {
-    term.Eprintf("parse: %v\n", err)
-    return 1
-  }
-  out := ast.DumpFile(f)
-  term.Printf("%s", out)
-  return 0
-}
+	highlightCmd := &command{
+		name:    "highlight",
+		args:    "<file.desi|glob>...",
+		summary: "Print .desi files with syntax colors",
+		help: "" +
+			"Colors follow the global -color flag; pass -color=always when piping\n" +
+			"into a pager such as 'less -R'.",
+	}
+	highlightCmd.run = batchCommand(highlightCmd, highlightOne)
 
-/* ---------- build (flags anywhere) ---------- */
+	var trace bool
+	parseCmd := &command{
+		name:    "parse",
+		args:    "<file.desi|glob>...",
+		summary: "Parse .desi files and print AST outlines",
+		help: "" +
+			"-trace prints, instead of the outline, each token as the parser consumes\n" +
+			"it, indented under the grammar production consuming it (the rule names\n" +
+			"of 'desic grammar'). It stops at the first syntax error.",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&trace, "trace", false, "print each token with the production consuming it")
+		},
+	}
+	parseCmd.run = batchCommand(parseCmd, func(path string) int { return parseOne(path, trace) })
 
-type buildArgs struct {
-  cc   string
-  out  string
-  file string
-  werr bool // --Werror
-}
+	astDiffCmd := &command{
+		name:    "ast-diff",
+		args:    "<old.desi> <new.desi>",
+		summary: "Report functions and imports added, removed or changed between two files",
+		help: "" +
+			"Functions are matched by name and compared structurally, so formatting\n" +
+			"and comments do not count. Changed functions list the IDs of the\n" +
+			"statements that differ (e.g. 'func main/2/then/0').",
+	}
+	astDiffCmd.run = func(args []string) int { return runASTDiff(astDiffCmd, args) }
 
-func parseBuildArgs(argv []string) (buildArgs, error) {
-  var a buildArgs
-  i := 0
-  for i < len(argv) {
-    s := argv[i]
-    if s == "--" {
-      i++
-      break
-    }
-    switch {
-    case strings.HasPrefix(s, "--cc="):
-      a.cc = s[len("--cc="):]
-      i++
-      continue
-    case s == "--cc":
-      if i+1 >= len(argv) {
-        return a, flag.ErrHelp
-      }
-      a.cc = argv[i+1]
-      i += 2
-      continue
-    case strings.HasPrefix(s, "--out="):
-      a.out = s[len("--out="):]
-      i++
-      continue
-    case s == "--out":
-      if i+1 >= len(argv) {
-        return a, flag.ErrHelp
-      }
-      a.out = argv[i+1]
-      i += 2
-      continue
-    case s == "--Werror" || s == "--werror":
-      a.werr = true
-      i++
-      continue
-    }
-    if !strings.HasPrefix(s, "-") && a.file == "" {
-      a.file = s
-      i++
-      continue
-    }
-    if strings.HasPrefix(s, "-") {
-      return a, flag.ErrHelp
-    }
-    i++
-  }
-  for i < len(argv) && a.file == "" {
-    if !strings.HasPrefix(argv[i], "-") {
-      a.file = argv[i]
-    }
-    i++
-  }
-  if a.file == "" {
-    return a, flag.ErrHelp
-  }
-  return a, nil
-}
+	precCmd := &command{
+		name:    "explain-precedence",
+		summary: "Print the binary operator precedence table the parser uses",
+	}
+	precCmd.run = func(args []string) int {
+		if len(args) != 0 {
+			return precCmd.usageErr("unexpected arguments")
+		}
+		writePrecedence(os.Stdout)
+		return exitOK
+	}
+
+	explainCmd := &command{
+		name:    "explain-codegen",
+		args:    "<entry.desi>",
+		summary: "Print the generated C with each statement's Desi line as a comment",
+		help: "" +
+			"Each C function and statement is preceded by a comment naming the\n" +
+			"Desi line it was lowered from, e.g. /* app.desi:4: n += 1 */. The C\n" +
+			"is otherwise what build writes; nothing is compiled.",
+	}
+	explainCmd.run = func(args []string) int { return runExplainCodegen(explainCmd, args) }
+
+	var ebnf, treeSitter, highlights bool
+	grammarCmd := &command{
+		name:    "grammar",
+		summary: "Print the grammar the parser accepts (EBNF or tree-sitter)",
+		help: "" +
+			"The expression rules are generated from the parser's operator tables,\n" +
+			"so the output always matches what 'desic parse' accepts.\n" +
+			"--tree-sitter prints a grammar.js skeleton (layout tokens need an external\n" +
+			"scanner); --highlights prints queries/highlights.scm to go with it.",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&ebnf, "ebnf", false, "print EBNF (the default)")
+			fs.BoolVar(&treeSitter, "tree-sitter", false, "print a tree-sitter grammar.js")
+			fs.BoolVar(&highlights, "highlights", false, "print tree-sitter highlight queries")
+		},
+	}
+	grammarCmd.run = func(args []string) int {
+		if len(args) != 0 {
+			return grammarCmd.usageErr("unexpected arguments")
+		}
+		n := 0
+		for _, set := range []bool{ebnf, treeSitter, highlights} {
+			if set {
+				n++
+			}
+		}
+		if n > 1 {
+			return grammarCmd.usageErr("choose one of --ebnf, --tree-sitter and --highlights")
+		}
+		switch {
+		case treeSitter:
+			if err := writeTreeSitter(os.Stdout); err != nil {
+				term.Eprintf("grammar: %v\n", err)
+				return exitInternal
+			}
+		case highlights:
+			writeHighlights(os.Stdout)
+		default:
+			writeEBNF(os.Stdout)
+		}
+		return exitOK
+	}
+
+	var co checkOptions
+	checkCmd := &command{
+		name:    "check",
+		args:    "<file.desi|glob>...",
+		summary: "Resolve imports and typecheck without emitting C",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&co.werr, "Werror", userConfig.Werror, "treat warnings as errors")
+			fs.BoolVar(&co.werr, "werror", userConfig.Werror, "alias for -Werror")
+			fs.BoolVar(&co.denyDeprecated, "deny-deprecated", false, "reject deprecated syntax instead of warning")
+		},
+	}
+	checkCmd.run = batchCommand(checkCmd, func(path string) int { return checkOne(path, co) })
 
-func cmdBuild(args []string) int {
-  a, err := parseBuildArgs(args)
-  if err != nil {
-    term.Eprintln("usage: desic build [--cc=clang] [--out=name] [--Werror] <entry.desi>")
-    return 2
-  }
+	var bo buildOptions
+	buildCmd := &command{
+		name:    "build",
+		args:    "<entry.desi>",
+		summary: "Emit C for an entry file and its imports; compile it with -cc",
+		help: "" +
+			"Flags may appear before or after the file.\n" +
+			"Outputs: gen/out/<basename>.c and, with -cc, gen/out/<out|basename>.\n" +
+			"-cc zig compiles with 'zig cc'; with -target it cross-compiles, e.g.\n" +
+			"'-cc zig -target x86_64-windows-gnu' (clang takes -target too).\n" +
+			"-emit=deps also writes gen/out/<basename>.d, a Make/Ninja depfile naming\n" +
+			"the .desi files the build read; -emit=amalgam writes\n" +
+			"gen/out/<basename>.amalgam.c, the C and the runtime in one file that\n" +
+			"compiles on its own.",
+		flags: func(fs *flag.FlagSet) {
+			bo.register(fs)
+			fs.StringVar(&bo.emit, "emit", "c", "outputs to write, comma-separated: c, deps, amalgam")
+		},
+	}
+	buildCmd.run = func(args []string) int { return runBuild(buildCmd, &bo, args) }
 
-  // Multi-file resolve + parse (entry + imports)
-  merged, perr := build.ResolveAndParse(a.file)
-  if len(perr) > 0 {
-    for _, e := range perr {
-      term.Eprintf("error: %v\n", e)
-    }
-    term.Eprintf("summary: %d error(s), %d warning(s)\n", len(perr), 0)
-    return 1
-  }
+	var gbo genBuildOptions
+	genBuildCmd := &command{
+		name:    "gen-build",
+		args:    "<entry.desi|glob>...",
+		summary: "Print a Ninja or Make file that builds the given entry files",
+		help: "" +
+			"Each entry becomes a program: 'desic build --emit=deps' emits its C,\n" +
+			"the C compiler turns that into an object, and the object is linked with\n" +
+			"a runtime object shared by all entries. Edits to imported modules\n" +
+			"rebuild only the programs that read them.\n" +
+			"ninja: desic gen-build app.desi tools/*.desi > build.ninja && ninja",
+		flags: func(fs *flag.FlagSet) {
+			rt := userConfig.RuntimeDir
+			if rt == "" {
+				rt = defaultRuntimeDir
+			}
+			compiler := userConfig.CC
+			if compiler == "" {
+				compiler = "cc"
+			}
+			fs.StringVar(&gbo.format, "format", "ninja", "build file format: ninja or make")
+			fs.StringVar(&gbo.cc, "cc", compiler, "C compiler the build file runs")
+			fs.StringVar(&gbo.runtime, "runtime", rt, "directory containing the C runtime (desi_std.c/.h)")
+			fs.StringVar(&gbo.desic, "desic", "desic", "desic command the build file runs")
+			fs.StringVar(&gbo.target, "target", "", "cross-compile for this target triple (needs -cc zig or clang)")
+		},
+	}
+	genBuildCmd.run = func(args []string) int { return runGenBuild(genBuildCmd, gbo, args, os.Stdout) }
 
-  // typecheck (errors block compile; warnings may block with --Werror)
-  info, errs, warns := cgenCheckFileShim(merged)
-  for _, w := range warns {
-    term.Eprintf("warning: %s\n", w.String())
-  }
-  for _, e := range errs {
-    term.Eprintf("error: %v\n", e)
-  }
-  if len(errs) > 0 || (a.werr && len(warns) > 0) {
-    term.Eprintf("summary: %d error(s), %d warning(s)\n", len(errs), len(warns))
-    return 1
-  }
+	var ro buildOptions
+	runCmd := &command{
+		name:    "run",
+		args:    "<file.desi> [args...]",
+		summary: "Build with a C compiler (-cc, $CC, or clang/gcc/cc) and execute the program",
+		help: "" +
+			"Flags must precede the file; everything after it is passed to the program.\n" +
+			"A leading '#!/usr/bin/env -S desic run' line makes a .desi file an executable script.",
+		flags:     ro.register,
+		stopAtArg: true,
+	}
+	runCmd.run = func(args []string) int { return runRun(runCmd, &ro, args) }
 
-  // Emit C to gen/out — name based on entry file basename
-  base := strings.TrimSuffix(filepath.Base(a.file), filepath.Ext(a.file))
-  outDir := filepath.Join("gen", "out")
-  if err := os.MkdirAll(outDir, 0o755); err != nil {
-    term.Eprintf("mkdir %s: %v\n", outDir, err)
-    return 1
-  }
-  cpath := filepath.Join(outDir, base+".c")
+	completionCmd := &command{
+		name:    "completion",
+		args:    "<bash|zsh|fish>",
+		summary: "Print a shell completion script",
+		help: "" +
+			"bash: source <(desic completion bash)\n" +
+			"zsh:  desic completion zsh > \"${fpath[1]}/_desic\"\n" +
+			"fish: desic completion fish > ~/.config/fish/completions/desic.fish",
+		words: completionShells,
+	}
+	completionCmd.run = func(args []string) int { return runCompletion(completionCmd, cmds, args) }
 
-  csrc := cgen.EmitFile(merged, info)
-  if err := os.WriteFile(cpath, []byte(csrc), 0o644); err != nil {
-    term.Eprintf("write %s: %v\n", cpath, err)
-    return 1
-  }
-  term.Eprintf("wrote %s\n", cpath)
+	var do doctorOptions
+	doctorCmd := &command{
+		name:    "doctor",
+		summary: "Check the build environment and suggest fixes",
+		flags: func(fs *flag.FlagSet) {
+			rt := userConfig.RuntimeDir
+			if rt == "" {
+				rt = defaultRuntimeDir
+			}
+			fs.StringVar(&do.cc, "cc", userConfig.CC, "C compiler to check (default: $CC, then clang/gcc/cc)")
+			fs.StringVar(&do.runtime, "runtime", rt, "runtime directory to check")
+		},
+	}
+	doctorCmd.run = func(args []string) int {
+		if len(args) != 0 {
+			return doctorCmd.usageErr("unexpected arguments")
+		}
+		return runDoctor(do)
+	}
 
-  // Optionally compile to gen/out/<out|basename>
-  if a.cc != "" {
-    outName := a.out
-    if outName == "" {
-      outName = base
-    }
-    binPath := filepath.Join(outDir, outName)
-    cmd := exec.Command(a.cc,
-      cpath,
-      filepath.Join("runtime", "c", "desi_std.c"),
-      "-I", filepath.Join("runtime", "c"),
-      "-o", binPath,
-    )
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
-    if err := cmd.Run(); err != nil {
-      term.Eprintf("cc failed: %v\n", err)
-      return 1
-    }
-    term.Eprintf("built %s\n", binPath)
-  }
-  term.Eprintf("summary: %d error(s), %d warning(s)\n", 0, len(warns))
-  return 0
+	toolchainCmd := &command{
+		name:    "toolchain",
+		args:    "install",
+		summary: "Download a portable C compiler for hosts without one",
+		help: "" +
+			"install fetches Zig " + toolchain.ZigVersion + " (clang and lld in one binary) for this\n" +
+			"platform, checks it against the SHA-256 pinned in desic, and\n" +
+			"unpacks it under " + toolchainHint() + ".\n" +
+			"run and doctor then use it when neither -cc nor $CC names a compiler.",
+		words: []string{"install"},
+	}
+	toolchainCmd.run = func(args []string) int {
+		if len(args) != 1 || args[0] != "install" {
+			return toolchainCmd.usageErr("want 'install'")
+		}
+		return runToolchainInstall()
+	}
+
+	cmds = []*command{versionCmd, helpCmd, lexCmd, highlightCmd, parseCmd, astDiffCmd, precCmd, explainCmd, grammarCmd, checkCmd, buildCmd, runCmd, genBuildCmd, doctorCmd, toolchainCmd, completionCmd}
+	helpCmd.words = commandNames(cmds)
+	return cmds
+}
+
+func lookup(cmds []*command, name string) *command {
+	for _, c := range cmds {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer, cmds []*command) {
+	term.Wprintf(w, "desic — Desi compiler (Stage-0)\n\n")
+	term.Wprintf(w, "Usage:\n  desic [global flags] <command> [flags] [args]\n\n")
+	term.Wprintf(w, "Commands:\n")
+	width := 10
+	for _, c := range cmds {
+		width = max(width, len(c.name))
+	}
+	for _, c := range cmds {
+		term.Wprintf(w, "  %-*s %s\n", width, c.name, c.summary)
+	}
+	term.Wprintf(w, "\nGlobal flags:\n")
+	term.Wprintf(w, "  -q, -quiet  Suppress informational output (wrote/built lines)\n")
+	term.Wprintf(w, "  -color      Colorize diagnostics: auto, always or never\n")
+	term.Wprintf(w, "  -context N  Source lines shown before and after a diagnostic's line\n")
+	term.Wprintf(w, "\nNotes:\n")
+	term.Wprintf(w, "  - Run 'desic help <command>' for a command's flags.\n")
+	term.Wprintf(w, "  - Defaults for -cc, -runtime, -color and -Werror can be set in %s\n", configHint())
+	term.Wprintf(w, "  - A file argument of '-' reads the source from stdin (shown as <stdin>).\n")
+	term.Wprintf(w, "  - lex/parse/check accept several files and glob patterns (e.g. 'examples/*.desi').\n")
+	term.Wprintf(w, "  - Imports like 'foo.bar' resolve to 'foo/bar.desi' relative to the entry file’s dir, then $DESI_PATH.\n")
+	term.Wprintf(w, "  - 'std.x' imports load std/x.desi if present, else must name a builtin module (io, fs, ...).\n")
+	term.Wprintf(w, "\nExit codes:\n")
+	term.Wprintf(w, "  0 ok, 1 diagnostics, 2 usage error, 3 internal error (run: the program's status)\n")
+}
+
+// dispatch parses global flags, picks the command, and runs it.
+func dispatch(argv []string, cmds []*command) int {
+	top := flag.NewFlagSet("desic", flag.ContinueOnError)
+	top.SetOutput(io.Discard)
+	addGlobalFlags(top)
+	showVersion := false
+	top.BoolVar(&showVersion, "version", false, "")
+	top.BoolVar(&showVersion, "v", false, "")
+	err := top.Parse(argv)
+	if errors.Is(err, flag.ErrHelp) {
+		usage(os.Stdout, cmds)
+		return exitOK
+	}
+	if err != nil {
+		term.Eprintf("desic: %v\n\n", err)
+		usage(os.Stderr, cmds)
+		return exitUsage
+	}
+	if err := applyGlobalFlags(); err != nil {
+		term.Eprintf("desic: %v\n", err)
+		return exitUsage
+	}
+	if showVersion {
+		term.Printf("%s\n", version.String())
+		return exitOK
+	}
+	rest := top.Args()
+	if len(rest) == 0 {
+		usage(os.Stderr, cmds)
+		return exitOK
+	}
+	c := lookup(cmds, rest[0])
+	if c == nil {
+		term.Eprintf("unknown command: %s\n\n", rest[0])
+		usage(os.Stderr, cmds)
+		return exitUsage
+	}
+	return c.execute(rest[1:])
 }
 
-// tiny local helper so main.go doesn't import check directly
-func cgenCheckFileShim(f *ast.File) (*check.Info, []error, []check.Warning) {
-  return check.CheckFile(f)
+// toolchainHint names the toolchain directory for help output.
+func toolchainHint() string {
+	if d := toolchain.Dir(); d != "" {
+		return d + " ($" + toolchain.EnvVar + ")"
+	}
+	return "$" + toolchain.EnvVar
 }
 
-/* ---------- main ---------- */
+// configHint names the config file for help output.
+func configHint() string {
+	if p := config.DefaultPath(); p != "" {
+		return p + " ($" + config.EnvVar + ")"
+	}
+	return "$" + config.EnvVar
+}
 
 func main() {
-  flag.Usage = usage
-  if len(os.Args) < 2 {
-    usage()
-    return
-  }
-  switch os.Args[1] {
-  case "version", "--version", "-v":
-    term.Printf("%s\n", version.String())
-  case "help", "--help", "-h":
-    usage()
-  case "lex":
-    if len(os.Args) != 3 {
-      term.Eprintln("usage: desic lex <file.desi>")
-      os.Exit(2)
-    }
-    os.Exit(cmdLexDirect(os.Args[2]))
-  case "parse":
-    os.Exit(cmdParse(os.Args[2:]))
-  case "build":
-    os.Exit(cmdBuild(os.Args[2:]))
-  default:
-    term.Eprintf("unknown command: %s\n\n", os.Args[1])
-    usage()
-    os.Exit(2)
-  }
+	cfg, err := config.Load()
+	if err != nil {
+		term.Eprintf("desic: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	userConfig = cfg
+	global.color = cfg.Color
+	os.Exit(dispatch(os.Args[1:], commands()))
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// writePrecedence prints the parser's binary operator table, tightest
+// binding first, one line per level.
+func writePrecedence(w io.Writer) {
+	ops := parser.Operators()
+	term.Wprintf(w, "%-6s %-16s %s\n", "level", "operators", "assoc")
+	for i := 0; i < len(ops); {
+		j := i
+		var names []string
+		for ; j < len(ops) && ops[j].Prec == ops[i].Prec; j++ {
+			names = append(names, ops[j].Op)
+		}
+		assoc := "left"
+		if ops[i].Right {
+			assoc = "right"
+		}
+		if ops[i].Reserved {
+			assoc += " (reserved)"
+		}
+		term.Wprintf(w, "%-6d %-16s %s\n", ops[i].Prec, strings.Join(names, "  "), assoc)
+		i = j
+	}
+	term.Wprintf(w, "\nRanges .. and ..= bind looser than every binary operator and do not chain.\n")
+	term.Wprintf(w, "Unary - ! not bind tighter than every binary operator;\n")
+	term.Wprintf(w, "calls, indexing and field access bind tighter still, as will\n")
+	term.Wprintf(w, "the reserved postfix ? and ?.; reserved operators are rejected for now.\n")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/pkg/desi"
+)
+
+// warningCounts tallies warnings by code; warnings without one count as "-".
+func warningCounts(ws []desi.Warning) map[string]int {
+	counts := map[string]int{}
+	for _, w := range ws {
+		code := w.Code
+		if code == "" {
+			code = "-"
+		}
+		counts[code]++
+	}
+	return counts
+}
+
+// warningTable renders warning counts by code, most frequent first.
+func warningTable(ws []desi.Warning) string {
+	counts := warningCounts(ws)
+	codes := make([]string, 0, len(counts))
+	for c := range counts {
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	var b strings.Builder
+	b.WriteString("warnings by code:\n")
+	for _, c := range codes {
+		term.Bprintf(&b, "  %-6s %d\n", c, counts[c])
+	}
+	return b.String()
+}
+
+// buildReport is the --report file, for CI dashboards. Field names are
+// part of the format; add fields rather than renaming them.
+type buildReport struct {
+	Entry         string             `json:"entry"`
+	OK            bool               `json:"ok"`
+	Errors        []string           `json:"errors"`
+	Warnings      []reportedWarning  `json:"warnings"`
+	WarningCounts map[string]int     `json:"warning_counts"`
+	DurationsMS   map[string]float64 `json:"durations_ms"` // per stage, plus "total"
+	Artifacts     map[string]string  `json:"artifacts"`    // "c" and "binary", when produced
+}
+
+type reportedWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// reportPath is where --report writes the build report.
+func reportPath() string { return filepath.Join(desi.DefaultOutDir, "build-report.json") }
+
+// writeReport writes the report for one build of entry that took total.
+func writeReport(entry string, res *desi.BuildResult, ok bool, total time.Duration) error {
+	r := buildReport{
+		Entry:         entry,
+		OK:            ok,
+		Errors:        []string{},
+		Warnings:      []reportedWarning{},
+		WarningCounts: warningCounts(res.Warnings),
+		DurationsMS:   map[string]float64{"total": ms(total)},
+		Artifacts:     map[string]string{},
+	}
+	for _, e := range res.Errors {
+		r.Errors = append(r.Errors, e.Error())
+	}
+	for _, w := range res.Warnings {
+		r.Warnings = append(r.Warnings, reportedWarning{Code: w.Code, Message: w.Msg})
+	}
+	for _, t := range res.Timings {
+		r.DurationsMS[t.Stage] = ms(t.Duration)
+	}
+	if res.CFile != "" {
+		r.Artifacts["c"] = res.CFile
+	}
+	if res.Amalgam != "" {
+		r.Artifacts["amalgam"] = res.Amalgam
+	}
+	if res.Binary != "" {
+		r.Artifacts["binary"] = res.Binary
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := reportPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
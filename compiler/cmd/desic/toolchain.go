@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/internal/toolchain"
+)
+
+// runToolchainInstall installs the pinned toolchain into toolchain.Dir.
+func runToolchainInstall() int {
+	log := io.Writer(os.Stderr)
+	if global.quiet {
+		log = io.Discard
+	}
+	path, err := toolchain.Install(toolchain.Options{Log: log})
+	if err != nil {
+		term.Eprintf("toolchain: %v\n", err)
+		return exitInternal
+	}
+	infof("installed %s\n", path)
+	return exitOK
+}
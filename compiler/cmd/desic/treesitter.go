@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/lexer"
+	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// tsTerminals maps the grammar's lexer terminals to tree-sitter rules.
+// The layout tokens come from an external scanner; EOF is implicit.
+var tsTerminals = map[string]string{
+	"IDENT":     "$.identifier",
+	"INT":       "$.integer",
+	"FLOAT":     "$.float",
+	"STR":       "$.string",
+	"FSTR_HEAD": "$.fstring_head",
+	"FSTR_MID":  "$.fstring_mid",
+	"FSTR_TAIL": "$.fstring_tail",
+	"CHAR":      "$.char",
+	"NEWLINE":   "$._newline",
+	"INDENT":    "$._indent",
+	"DEDENT":    "$._dedent",
+	"EOF":       "",
+}
+
+// tsTokens are the token rules, mirroring scanIdent, scanNumber, scanString,
+// scanChar and comment skipping in the lexer.
+var tsTokens = []struct{ name, body string }{
+	{"identifier", `/(r#)?[\p{L}_][\p{L}\p{Nd}_]*/`},
+	{"integer", `/0[xX][0-9a-fA-F_]+|0[bB][01_]+|[0-9][0-9_]*/`},
+	{"float", `/[0-9][0-9_]*(\.[0-9][0-9_]*([eE][+-]?[0-9][0-9_]*)?|[eE][+-]?[0-9][0-9_]*)/`},
+	{"string", `/f"([^"\\\n{]|\\.|\{\{)*"|r"[^"\n]*"|"""([^"\\]|\\(.|\n)|"[^"]|""[^"])*"""|"([^"\\\n]|\\.)*"/`},
+	{"fstring_head", `/f"([^"\\\n{]|\\.|\{\{)*\{/`},
+	{"fstring_mid", `/\}([^"\\\n{]|\\.|\{\{)*\{/`},
+	{"fstring_tail", `/\}([^"\\\n{]|\\.|\{\{)*"/`},
+	{"char", `/'([^'\\\n]|\\.)+'/`},
+	{"comment", `token(seq('#', /.*/))`},
+}
+
+// writeTreeSitter prints a tree-sitter grammar.js generated from the
+// parser's grammar. It is a skeleton: NEWLINE, INDENT and DEDENT need an
+// external scanner (src/scanner.c, as in tree-sitter-python).
+func writeTreeSitter(w io.Writer) error {
+	term.Wprintf(w, "// Generated by `desic grammar --tree-sitter` from the Desi Stage-0 parser.\n")
+	term.Wprintf(w, "// _newline, _indent and _dedent come from an external scanner (src/scanner.c).\n")
+	term.Wprintf(w, "module.exports = grammar({\n")
+	term.Wprintf(w, "  name: 'desi',\n")
+	term.Wprintf(w, "  word: $ => $.identifier,\n")
+	term.Wprintf(w, "  externals: $ => [$._newline, $._indent, $._dedent],\n")
+	term.Wprintf(w, "  extras: $ => [/[ \\t\\r]/, /\\\\\\r?\\n/, $.comment],\n") // \ joins lines
+	term.Wprintf(w, "  rules: {\n")
+	for _, r := range parser.Grammar() {
+		js, err := tsRule(r.Body)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		term.Wprintf(w, "    %s: $ => %s,\n", r.Name, js)
+	}
+	for _, t := range tsTokens {
+		term.Wprintf(w, "    %s: $ => %s,\n", t.name, t.body)
+	}
+	term.Wprintf(w, "  },\n});\n")
+	return nil
+}
+
+// writeHighlights prints tree-sitter highlight queries for every keyword and
+// symbol the grammar uses. Only those can appear in a query, so reserved
+// words no rule uses yet (match, struct, ...) wait until the parser does.
+func writeHighlights(w io.Writer) {
+	var lits []string
+	for _, r := range parser.Grammar() {
+		for _, lit := range tsLiterals(r.Body) {
+			if !slices.Contains(lits, lit) {
+				lits = append(lits, lit)
+			}
+		}
+	}
+	keywords := lexer.Keywords()
+	groups := map[string][]string{}
+	for _, lit := range lits {
+		group := "@operator"
+		switch {
+		case lit == "true" || lit == "false" || lit == "none":
+			group = "@constant.builtin"
+		case lit == "and" || lit == "or" || lit == "not":
+			group = "@keyword.operator"
+		case slices.Contains(keywords, lit):
+			group = "@keyword"
+		case slices.Contains([]string{"(", ")", "[", "]"}, lit):
+			group = "@punctuation.bracket"
+		case slices.Contains([]string{".", ",", ":"}, lit):
+			group = "@punctuation.delimiter"
+		}
+		groups[group] = append(groups[group], tsQuote(lit))
+	}
+	term.Wprintf(w, "; Generated by `desic grammar --highlights` from the Desi Stage-0 lexer and parser.\n")
+	for _, g := range []string{"@keyword", "@keyword.operator", "@constant.builtin", "@operator", "@punctuation.bracket", "@punctuation.delimiter"} {
+		if len(groups[g]) > 0 {
+			term.Wprintf(w, "[%s] %s\n", strings.Join(groups[g], " "), g)
+		}
+	}
+	term.Wprintf(w, "(func_decl (identifier) @function)\n")
+	term.Wprintf(w, "(integer) @number\n(float) @number\n(char) @character\n(string) @string\n(fstring_head) @string\n(fstring_mid) @string\n(fstring_tail) @string\n(comment) @comment\n")
+}
+
+// tsRule translates an EBNF rule body into a tree-sitter rule expression.
+func tsRule(body string) (string, error) {
+	toks, err := tsLex(body)
+	if err != nil {
+		return "", err
+	}
+	p := &tsParser{toks: toks}
+	js := p.alt()
+	if p.i < len(p.toks) {
+		return "", fmt.Errorf("unexpected %q", p.toks[p.i])
+	}
+	return js, nil
+}
+
+// tsLex splits an EBNF body into literals, names, parentheses and the
+// |, *, + and ? operators.
+func tsLex(body string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(body); {
+		switch c := body[i]; {
+		case c == ' ':
+			i++
+		case c == '"':
+			j := strings.IndexByte(body[i+1:], '"')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated literal in %q", body)
+			}
+			toks = append(toks, body[i:i+j+2])
+			i += j + 2
+		case strings.IndexByte("()|*+?", c) >= 0:
+			toks = append(toks, string(c))
+			i++
+		default:
+			j := i
+			for j < len(body) && strings.IndexByte(` "()|*+?`, body[j]) < 0 {
+				j++
+			}
+			toks = append(toks, body[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// tsLiterals returns the quoted literals of an EBNF body, unquoted.
+func tsLiterals(body string) []string {
+	toks, _ := tsLex(body)
+	var out []string
+	for _, t := range toks {
+		if strings.HasPrefix(t, `"`) {
+			out = append(out, t[1:len(t)-1])
+		}
+	}
+	return out
+}
+
+type tsParser struct {
+	toks []string
+	i    int
+}
+
+func (p *tsParser) peek() string {
+	if p.i < len(p.toks) {
+		return p.toks[p.i]
+	}
+	return ""
+}
+
+// alt := seq ( "|" seq )*
+func (p *tsParser) alt() string {
+	alts := []string{p.seq()}
+	for p.peek() == "|" {
+		p.i++
+		alts = append(alts, p.seq())
+	}
+	return tsCall("choice", alts)
+}
+
+// seq := item*, up to ")" or "|"
+func (p *tsParser) seq() string {
+	var items []string
+	for t := p.peek(); t != "" && t != ")" && t != "|"; t = p.peek() {
+		if it := p.item(); it != "" {
+			items = append(items, it)
+		}
+	}
+	return tsCall("seq", items)
+}
+
+// item := atom ( "*" | "+" | "?" )?
+func (p *tsParser) item() string {
+	var atom string
+	switch t := p.peek(); {
+	case t == "(":
+		p.i++
+		atom = p.alt()
+		if p.peek() == ")" {
+			p.i++
+		}
+	case strings.HasPrefix(t, `"`):
+		p.i++
+		atom = tsString(t[1 : len(t)-1])
+	default:
+		p.i++
+		js, ok := tsTerminals[t]
+		if !ok {
+			js = "$." + t
+		}
+		atom = js
+	}
+	wrap := map[string]string{"*": "repeat", "+": "repeat1", "?": "optional"}
+	if fn, ok := wrap[p.peek()]; ok {
+		p.i++
+		if atom != "" {
+			atom = fn + "(" + atom + ")"
+		}
+	}
+	return atom
+}
+
+// tsCall wraps several arguments in fn; a single one stands alone.
+func tsCall(fn string, args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+// tsString is a JavaScript string literal.
+func tsString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// tsQuote is a query string literal.
+func tsQuote(s string) string { return `"` + s + `"` }
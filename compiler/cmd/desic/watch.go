@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// Polling keeps --watch dependency-free and portable; the import sets we
+// watch are small enough that a stat per file per tick is negligible.
+const (
+	watchPoll     = 300 * time.Millisecond
+	watchDebounce = 150 * time.Millisecond
+)
+
+// fileStamp is what we compare between polls. A missing file has the zero
+// stamp, so creating an import that was not found also triggers a rebuild.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+func snapshot(paths []string) map[string]fileStamp {
+	m := make(map[string]fileStamp, len(paths))
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			m[p] = fileStamp{}
+			continue
+		}
+		m[p] = fileStamp{mod: st.ModTime(), size: st.Size()}
+	}
+	return m
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if t, ok := b[p]; !ok || !t.mod.Equal(s.mod) || t.size != s.size {
+			return false
+		}
+	}
+	return true
+}
+
+// watchLoop runs step, then polls the files it returned and runs it again
+// after each change. Bursts of writes (editors often save in several steps)
+// are coalesced by waiting until the snapshot is stable for watchDebounce.
+// Ctrl-C ends the loop; that is how a watch session is meant to stop, so it
+// returns exitOK.
+func watchLoop(step func() []string) int {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	sleep := func(d time.Duration) bool {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+
+	for {
+		paths := step()
+		snap := snapshot(paths)
+		term.Eprintf("[watch] watching %d file(s); Ctrl-C to stop\n", len(paths))
+
+		for {
+			if !sleep(watchPoll) {
+				return exitOK
+			}
+			cur := snapshot(paths)
+			if sameSnapshot(snap, cur) {
+				continue
+			}
+			for {
+				if !sleep(watchDebounce) {
+					return exitOK
+				}
+				next := snapshot(paths)
+				if sameSnapshot(cur, next) {
+					break
+				}
+				cur = next
+			}
+			break
+		}
+		term.Eprintf("\n[watch] %s: change detected, rebuilding\n", time.Now().Format("15:04:05"))
+	}
+}
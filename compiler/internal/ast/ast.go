@@ -17,13 +17,18 @@ type File struct {
 
 func (File) node() {}
 
-type PackageDecl struct{ Name string }
+type PackageDecl struct {
+	Name string
+	Pos  Pos // the `package` keyword
+}
 
 func (PackageDecl) node() {}
 
 type ImportDecl struct {
 	Path    string // e.g. "std.io"
 	Aliases []string
+	Pos     Pos  // the `import` (or `pub`) keyword
+	Pub     bool // `pub import`: the module's functions are re-exported
 }
 
 func (ImportDecl) node() {}
@@ -38,14 +43,27 @@ type FuncDecl struct {
 	Params []Param
 	Ret    string // textual type for now
 	Body   []Stmt
+	Module string // qualifier for calls from other files (set by the loader); "" for the entry file
+	// Package is the declaring file's package, e.g. "util.text" for
+	// util/text.desi (set by the loader); "" for the entry file.
+	Package string
+	// Reexports are further qualifiers for the function, one per module that
+	// re-exports it with `pub import`, directly or transitively (set by the loader).
+	Reexports []string
+	// Imports are the qualifiers the declaring file imports; module.f(...)
+	// calls in the body must use one of them or Module (set by the loader).
+	Imports []string
+	Pos     Pos    // the `def` keyword
+	File    string // path of the declaring file, as the loader read it (set by the loader)
 }
 
-func (FuncDecl) node() {}
-func (FuncDecl) decl() {}
+func (*FuncDecl) node() {}
+func (*FuncDecl) decl() {}
 
 type Param struct {
 	Name string
 	Type string
+	Mut  bool // `mut x: T`: passed by reference, assignments reach the caller
 }
 
 /*** EXPRESSIONS ***/
@@ -60,21 +78,56 @@ type IdentExpr struct{ Name string }
 func (*IdentExpr) node() {}
 func (*IdentExpr) expr() {}
 
-type IntLit struct{ Value string }
+type IntLit struct {
+	Value  string
+	Suffix string // type suffix ("u8" in "255u8"), or ""
+}
 
 func (*IntLit) node() {}
 func (*IntLit) expr() {}
 
+// FloatLit is a float literal ("2.5", "1e9"), separators removed.
+type FloatLit struct{ Value string }
+
+func (*FloatLit) node() {}
+func (*FloatLit) expr() {}
+
 type StrLit struct{ Value string }
 
 func (*StrLit) node() {}
 func (*StrLit) expr() {}
 
+// InterpExpr is an f-string, f"a{x}b". Parts are the literal pieces around
+// the holes, each lexed as a plain string literal (quotes included), so
+// len(Parts) == len(Holes)+1.
+type InterpExpr struct {
+	Parts []string
+	Holes []Expr
+}
+
+func (*InterpExpr) node() {}
+func (*InterpExpr) expr() {}
+
 type BoolLit struct{ Value bool }
 
 func (*BoolLit) node() {}
 func (*BoolLit) expr() {}
 
+// CharLit is a character literal ('a'); it has the value of its code point.
+type CharLit struct {
+	Value rune
+	Raw   string // source text, quotes included
+}
+
+func (*CharLit) node() {}
+func (*CharLit) expr() {}
+
+// NoneLit is `none`, the empty value of an optional type (`str?`).
+type NoneLit struct{}
+
+func (*NoneLit) node() {}
+func (*NoneLit) expr() {}
+
 type CallExpr struct {
 	Callee Expr
 	Args   []Expr
@@ -116,6 +169,42 @@ type BinaryExpr struct {
 func (*BinaryExpr) node() {}
 func (*BinaryExpr) expr() {}
 
+// RangeExpr is `Lo..Hi`, which stops before Hi, or `Lo..=Hi`, which
+// includes it.
+type RangeExpr struct {
+	Lo, Hi    Expr
+	Inclusive bool // ..=
+}
+
+func (*RangeExpr) node() {}
+func (*RangeExpr) expr() {}
+
+// Pos is a 1-based source position.
+type Pos struct{ Line, Col int }
+
+// ParenExpr is an expression written in parentheses. It has no meaning of
+// its own; it keeps the parens for diagnostics and for printing the source
+// back. Code that inspects the shape of an expression should Unparen it.
+type ParenExpr struct {
+	X      Expr
+	Lparen Pos // position of "("
+	Rparen Pos // position of ")"
+}
+
+func (*ParenExpr) node() {}
+func (*ParenExpr) expr() {}
+
+// Unparen returns e with any enclosing parentheses removed.
+func Unparen(e Expr) Expr {
+	for {
+		p, ok := e.(*ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
+
 /*** STATEMENTS ***/
 
 type Stmt interface {
@@ -123,46 +212,55 @@ type Stmt interface {
 	stmt()
 }
 
+// Every statement records Pos, the position of its first token. Statements
+// (like declarations) are pointers: only *LetStmt, not LetStmt, is a Stmt, so
+// a type switch over the pointer types sees every statement there is.
+
 type LetStmt struct {
 	Mutable bool
 	Name    string
 	Expr    Expr
+	Pos     Pos
 }
 
-func (LetStmt) node() {}
-func (LetStmt) stmt() {}
+func (*LetStmt) node() {}
+func (*LetStmt) stmt() {}
 
 type AssignStmt struct {
 	Name string
 	Expr Expr
+	Pos  Pos
 }
 
-func (AssignStmt) node() {}
-func (AssignStmt) stmt() {}
+func (*AssignStmt) node() {}
+func (*AssignStmt) stmt() {}
 
 type ReturnStmt struct {
 	Expr Expr // may be nil
+	Pos  Pos
 }
 
-func (ReturnStmt) node() {}
-func (ReturnStmt) stmt() {}
+func (*ReturnStmt) node() {}
+func (*ReturnStmt) stmt() {}
 
 type ExprStmt struct {
 	Expr Expr
+	Pos  Pos
 }
 
-func (ExprStmt) node() {}
-func (ExprStmt) stmt() {}
+func (*ExprStmt) node() {}
+func (*ExprStmt) stmt() {}
 
 type IfStmt struct {
 	Cond  Expr
 	Then  []Stmt
 	Elifs []ElseIf
 	Else  []Stmt // optional; nil if absent
+	Pos   Pos
 }
 
-func (IfStmt) node() {}
-func (IfStmt) stmt() {}
+func (*IfStmt) node() {}
+func (*IfStmt) stmt() {}
 
 type ElseIf struct {
 	Cond Expr
@@ -172,17 +270,66 @@ type ElseIf struct {
 type WhileStmt struct {
 	Cond Expr
 	Body []Stmt
+	Pos  Pos
 }
 
-func (WhileStmt) node() {}
-func (WhileStmt) stmt() {}
+func (*WhileStmt) node() {}
+func (*WhileStmt) stmt() {}
 
 type DeferStmt struct {
 	Call Expr // must be a call expression in Stage-0
+	Pos  Pos
+}
+
+func (*DeferStmt) node() {}
+func (*DeferStmt) stmt() {}
+
+// SpawnStmt is `spawn f(args)`: the arguments are evaluated here and the
+// call runs as a task on its own thread.
+type SpawnStmt struct {
+	Call Expr // a call of a user function
+	Pos  Pos
+}
+
+func (*SpawnStmt) node() {}
+func (*SpawnStmt) stmt() {}
+
+// WithStmt is `with Expr as Name:`. Expr is a resource (a file); Name is
+// bound to it in Body only, and the resource is closed whenever Body exits.
+type WithStmt struct {
+	Expr Expr
+	Name string
+	Body []Stmt
+	Pos  Pos
 }
 
-func (DeferStmt) node() {}
-func (DeferStmt) stmt() {}
+func (*WithStmt) node() {}
+func (*WithStmt) stmt() {}
+
+// StmtPos returns the position of the first token of s.
+func StmtPos(s Stmt) Pos {
+	switch st := s.(type) {
+	case *LetStmt:
+		return st.Pos
+	case *AssignStmt:
+		return st.Pos
+	case *ReturnStmt:
+		return st.Pos
+	case *ExprStmt:
+		return st.Pos
+	case *IfStmt:
+		return st.Pos
+	case *WhileStmt:
+		return st.Pos
+	case *DeferStmt:
+		return st.Pos
+	case *SpawnStmt:
+		return st.Pos
+	case *WithStmt:
+		return st.Pos
+	}
+	return Pos{}
+}
 
 /*** DUMP (pretty outline for CLI) ***/
 
@@ -192,6 +339,9 @@ func DumpFile(f *File) string {
 		fmt.Fprintf(&b, "package %s\n", f.Pkg.Name)
 	}
 	for _, im := range f.Imports {
+		if im.Pub {
+			b.WriteString("pub ")
+		}
 		fmt.Fprintf(&b, "import %s\n", im.Path)
 	}
 	for _, d := range f.Decls {
@@ -202,58 +352,43 @@ func DumpFile(f *File) string {
 				if i > 0 {
 					b.WriteString(", ")
 				}
+				if p.Mut {
+					b.WriteString("mut ")
+				}
 				fmt.Fprintf(&b, "%s: %s", p.Name, p.Type)
 			}
 			fmt.Fprintf(&b, ") -> %s:\n", orDefault(fn.Ret, "void"))
-			for _, s := range fn.Body {
-				switch st := s.(type) {
-				case *LetStmt:
-					if st.Mutable {
-						fmt.Fprintf(&b, "  let mut %s = %s\n", st.Name, exprString(st.Expr))
-					} else {
-						fmt.Fprintf(&b, "  let %s = %s\n", st.Name, exprString(st.Expr))
-					}
-				case *AssignStmt:
-					fmt.Fprintf(&b, "  %s := %s\n", st.Name, exprString(st.Expr))
-				case *ReturnStmt:
-					if st.Expr == nil {
-						fmt.Fprintf(&b, "  return\n")
-					} else {
-						fmt.Fprintf(&b, "  return %s\n", exprString(st.Expr))
-					}
-				case *ExprStmt:
-					fmt.Fprintf(&b, "  %s\n", exprString(st.Expr))
-				case *IfStmt:
-					fmt.Fprintf(&b, "  if %s:\n", exprString(st.Cond))
-					for _, s2 := range st.Then {
-						fmt.Fprintf(&b, "    %s\n", stmtString(s2))
-					}
-					for _, e := range st.Elifs {
-						fmt.Fprintf(&b, "  elif %s:\n", exprString(e.Cond))
-						for _, s2 := range e.Body {
-							fmt.Fprintf(&b, "    %s\n", stmtString(s2))
-						}
-					}
-					if st.Else != nil {
-						fmt.Fprintf(&b, "  else:\n")
-						for _, s2 := range st.Else {
-							fmt.Fprintf(&b, "    %s\n", stmtString(s2))
-						}
-					}
-				case *WhileStmt:
-					fmt.Fprintf(&b, "  while %s:\n", exprString(st.Cond))
-					for _, s2 := range st.Body {
-						fmt.Fprintf(&b, "    %s\n", stmtString(s2))
-					}
-				case *DeferStmt:
-					fmt.Fprintf(&b, "  defer %s\n", exprString(st.Call))
-				}
-			}
+			dumpStmts(&b, fn.Body, "  ")
 		}
 	}
 	return b.String()
 }
 
+// dumpStmts writes one line per statement, stmtString's, with the blocks of
+// if and while indented under it; every statement goes through here, at any
+// depth.
+func dumpStmts(b *strings.Builder, stmts []Stmt, indent string) {
+	for _, s := range stmts {
+		fmt.Fprintf(b, "%s%s\n", indent, stmtString(s))
+		switch st := s.(type) {
+		case *IfStmt:
+			dumpStmts(b, st.Then, indent+"  ")
+			for _, e := range st.Elifs {
+				fmt.Fprintf(b, "%selif %s:\n", indent, exprString(e.Cond))
+				dumpStmts(b, e.Body, indent+"  ")
+			}
+			if st.Else != nil {
+				fmt.Fprintf(b, "%selse:\n", indent)
+				dumpStmts(b, st.Else, indent+"  ")
+			}
+		case *WhileStmt:
+			dumpStmts(b, st.Body, indent+"  ")
+		case *WithStmt:
+			dumpStmts(b, st.Body, indent+"  ")
+		}
+	}
+}
+
 func orDefault(s, d string) string {
 	if strings.TrimSpace(s) == "" {
 		return d
@@ -266,14 +401,31 @@ func exprString(e Expr) string {
 	case *IdentExpr:
 		return v.Name
 	case *IntLit:
+		return v.Value + v.Suffix
+	case *FloatLit:
 		return v.Value
 	case *StrLit:
 		return v.Value
+	case *InterpExpr:
+		var b strings.Builder
+		b.WriteString(`f"`)
+		for i, part := range v.Parts {
+			b.WriteString(strings.TrimSuffix(strings.TrimPrefix(part, `"`), `"`))
+			if i < len(v.Holes) {
+				b.WriteString("{" + exprString(v.Holes[i]) + "}")
+			}
+		}
+		b.WriteString(`"`)
+		return b.String()
 	case *BoolLit:
 		if v.Value {
 			return "true"
 		}
 		return "false"
+	case *CharLit:
+		return v.Raw
+	case *NoneLit:
+		return "none"
 	case *CallExpr:
 		var parts []string
 		for _, a := range v.Args {
@@ -288,6 +440,14 @@ func exprString(e Expr) string {
 		return v.Op + " " + exprString(v.X)
 	case *BinaryExpr:
 		return "(" + exprString(v.Left) + " " + v.Op + " " + exprString(v.Right) + ")"
+	case *RangeExpr:
+		op := ".."
+		if v.Inclusive {
+			op = "..="
+		}
+		return "(" + exprString(v.Lo) + op + exprString(v.Hi) + ")"
+	case *ParenExpr:
+		return "(" + exprString(v.X) + ")"
 	default:
 		return "<expr>"
 	}
@@ -310,11 +470,15 @@ func stmtString(s Stmt) string {
 	case *ExprStmt:
 		return exprString(st.Expr)
 	case *IfStmt:
-		return "if …:"
+		return "if " + exprString(st.Cond) + ":"
 	case *WhileStmt:
-		return "while …:"
+		return "while " + exprString(st.Cond) + ":"
 	case *DeferStmt:
 		return "defer " + exprString(st.Call)
+	case *SpawnStmt:
+		return "spawn " + exprString(st.Call)
+	case *WithStmt:
+		return "with " + exprString(st.Expr) + " as " + st.Name + ":"
 	default:
 		return "<stmt>"
 	}
@@ -0,0 +1,77 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+)
+
+// stmtsSrc has every statement kind, at the top of a body and nested.
+const stmtsSrc = "" +
+	"def f(mut n: i32, s: str) -> void:\n" +
+	"  let a = 1\n" +
+	"  let mut b = a + 2\n" +
+	"  b := b * 3\n" +
+	"  defer io.println(b)\n" +
+	"  while b > 0:\n" +
+	"    if b == 1:\n" +
+	"      let c = -b\n" +
+	"      return\n" +
+	"    elif b == 2:\n" +
+	"      io.println(s)\n" +
+	"    else:\n" +
+	"      while false:\n" +
+	"        defer io.println(a)\n" +
+	"    b := b - 1\n" +
+	"  with fs.open(s) as h:\n" +
+	"    fs.close(h)\n" +
+	"  return\n"
+
+func TestDumpFileNests(t *testing.T) {
+	want := "" +
+		"\ndef f(mut n: i32, s: str) -> void:\n" +
+		"  let a = 1\n" +
+		"  let mut b = (a + 2)\n" +
+		"  b := (b * 3)\n" +
+		"  defer io.println(b)\n" +
+		"  while (b > 0):\n" +
+		"    if (b == 1):\n" +
+		"      let c = - b\n" +
+		"      return\n" +
+		"    elif (b == 2):\n" +
+		"      io.println(s)\n" +
+		"    else:\n" +
+		"      while false:\n" +
+		"        defer io.println(a)\n" +
+		"    b := (b - 1)\n" +
+		"  with fs.open(s) as h:\n" +
+		"    fs.close(h)\n" +
+		"  return\n"
+	if got := ast.DumpFile(mustParse(t, stmtsSrc)); got != want {
+		t.Errorf("DumpFile =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEveryStmtHasPos(t *testing.T) {
+	var walk func(ss []ast.Stmt)
+	walk = func(ss []ast.Stmt) {
+		for _, s := range ss {
+			if ast.StmtPos(s) == (ast.Pos{}) {
+				t.Errorf("%T has no position", s)
+			}
+			switch st := s.(type) {
+			case *ast.IfStmt:
+				walk(st.Then)
+				for _, e := range st.Elifs {
+					walk(e.Body)
+				}
+				walk(st.Else)
+			case *ast.WhileStmt:
+				walk(st.Body)
+			case *ast.WithStmt:
+				walk(st.Body)
+			}
+		}
+	}
+	walk(mustParse(t, stmtsSrc).Decls[0].(*ast.FuncDecl).Body)
+}
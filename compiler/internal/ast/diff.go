@@ -0,0 +1,121 @@
+package ast
+
+import "sort"
+
+// ChangeKind classifies one entry of a Diff.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	SigChanged  // function signature differs (body may too)
+	BodyChanged // same signature, different body
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case SigChanged:
+		return "signature changed"
+	case BodyChanged:
+		return "body changed"
+	}
+	return "?"
+}
+
+// Change is one structural difference between two files.
+type Change struct {
+	Kind ChangeKind
+	ID   ID
+	Old  string // old signature (functions) or path (imports); empty if Added
+	New  string // new signature or path; empty if Removed
+	// Stmts lists the IDs of statements that differ, for BodyChanged and
+	// SigChanged functions.
+	Stmts []ID
+}
+
+// Diff reports imports and functions added, removed or changed between old
+// and new, ordered by ID. Functions are matched by name, so a rename shows
+// up as one removal plus one addition.
+func Diff(old, new *File) []Change {
+	var out []Change
+
+	oldIm, newIm := map[ID]string{}, map[ID]string{}
+	for _, im := range old.Imports {
+		oldIm[ImportID(im)] = im.Path
+	}
+	for _, im := range new.Imports {
+		newIm[ImportID(im)] = im.Path
+	}
+	for id, p := range oldIm {
+		if _, ok := newIm[id]; !ok {
+			out = append(out, Change{Kind: Removed, ID: id, Old: p})
+		}
+	}
+	for id, p := range newIm {
+		if _, ok := oldIm[id]; !ok {
+			out = append(out, Change{Kind: Added, ID: id, New: p})
+		}
+	}
+
+	oldFn, newFn := funcsByID(old), funcsByID(new)
+	for id, fo := range oldFn {
+		fn, ok := newFn[id]
+		if !ok {
+			out = append(out, Change{Kind: Removed, ID: id, Old: Signature(fo)})
+			continue
+		}
+		if Fingerprint(fo) == Fingerprint(fn) {
+			continue
+		}
+		kind := BodyChanged
+		if Signature(fo) != Signature(fn) {
+			kind = SigChanged
+		}
+		out = append(out, Change{Kind: kind, ID: id, Old: Signature(fo), New: Signature(fn), Stmts: stmtChanges(fo, fn)})
+	}
+	for id, fn := range newFn {
+		if _, ok := oldFn[id]; !ok {
+			out = append(out, Change{Kind: Added, ID: id, New: Signature(fn)})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func funcsByID(f *File) map[ID]*FuncDecl {
+	m := map[ID]*FuncDecl{}
+	for _, d := range f.Decls {
+		if fn, ok := d.(*FuncDecl); ok {
+			m[FuncID(fn)] = fn
+		}
+	}
+	return m
+}
+
+// stmtChanges lists statement IDs present in only one version or whose
+// fingerprints differ, in the order they appear in new (then old).
+func stmtChanges(old, new *FuncDecl) []ID {
+	prints := map[ID]string{}
+	for _, r := range StmtIDs(old) {
+		prints[r.ID] = Fingerprint(r.Stmt)
+	}
+	var ids []ID
+	seen := map[ID]bool{}
+	for _, r := range StmtIDs(new) {
+		seen[r.ID] = true
+		if p, ok := prints[r.ID]; !ok || p != Fingerprint(r.Stmt) {
+			ids = append(ids, r.ID)
+		}
+	}
+	for _, r := range StmtIDs(old) {
+		if !seen[r.ID] {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
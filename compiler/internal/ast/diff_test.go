@@ -0,0 +1,78 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/parser"
+)
+
+func mustParse(t *testing.T, src string) *ast.File {
+	t.Helper()
+	f, err := parser.New(src).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return f
+}
+
+func TestFingerprintStableAcrossParses(t *testing.T) {
+	src := "def main() -> i32:\n  let x = 1\n  if x > 0:\n    return x\n  return 0\n"
+	a, b := mustParse(t, src), mustParse(t, src+"\n\n")
+	if ast.Fingerprint(a.Decls[0]) != ast.Fingerprint(b.Decls[0]) {
+		t.Fatal("fingerprint changed between identical parses")
+	}
+	refs := ast.StmtIDs(a.Decls[0].(*ast.FuncDecl))
+	var ids []ast.ID
+	for _, r := range refs {
+		ids = append(ids, r.ID)
+	}
+	want := []ast.ID{"func main/0", "func main/1", "func main/1/then/0", "func main/2"}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := mustParse(t, ""+
+		"import std.io\n"+
+		"def keep() -> i32:\n  return 1\n"+
+		"def body() -> i32:\n  let a = 1\n  return a\n"+
+		"def sig(a: i32) -> i32:\n  return a\n"+
+		"def gone() -> void:\n  return\n")
+	new := mustParse(t, ""+
+		"import std.fs\n"+
+		"def keep() -> i32:\n  return 1\n"+
+		"def body() -> i32:\n  let a = 2\n  return a\n"+
+		"def sig(a: i32, b: i32) -> i32:\n  return a\n"+
+		"def fresh() -> void:\n  return\n")
+
+	got := ast.Diff(old, new)
+	want := []struct {
+		kind ast.ChangeKind
+		id   ast.ID
+	}{
+		{ast.BodyChanged, "func body"},
+		{ast.Added, "func fresh"},
+		{ast.Removed, "func gone"},
+		{ast.SigChanged, "func sig"},
+		{ast.Added, "import std.fs"},
+		{ast.Removed, "import std.io"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d changes %+v, want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Kind != w.kind || got[i].ID != w.id {
+			t.Errorf("change %d = %v %s, want %v %s", i, got[i].Kind, got[i].ID, w.kind, w.id)
+		}
+	}
+	if s := got[0].Stmts; len(s) != 1 || s[0] != "func body/0" {
+		t.Errorf("body stmts = %v, want [func body/0]", s)
+	}
+}
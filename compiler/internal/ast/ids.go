@@ -0,0 +1,219 @@
+package ast
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ID identifies a node independently of its source position. Declarations
+// are keyed by name and statements by their path inside the function body,
+// so re-parsing unchanged code, or editing other functions, keeps IDs
+// stable. Inserting a statement does shift the IDs of its later siblings.
+type ID string
+
+// FuncID is the ID of a function declaration.
+func FuncID(fn *FuncDecl) ID { return ID("func " + fn.Name) }
+
+// ImportID is the ID of an import declaration. Re-exports are told apart
+// from plain imports, so making an import pub shows up in a diff.
+func ImportID(im ImportDecl) ID {
+	if im.Pub {
+		return ID("pub import " + im.Path)
+	}
+	return ID("import " + im.Path)
+}
+
+// StmtRef pairs a statement with its ID.
+type StmtRef struct {
+	ID   ID
+	Stmt Stmt
+}
+
+// StmtIDs lists every statement of fn, nested ones included, in source
+// order. IDs look like "func main/2", "func main/2/then/0",
+// "func main/2/elif1/0", "func main/2/else/0" and "func main/4/body/1".
+func StmtIDs(fn *FuncDecl) []StmtRef {
+	var out []StmtRef
+	var walk func(prefix ID, body []Stmt)
+	walk = func(prefix ID, body []Stmt) {
+		for i, s := range body {
+			id := ID(fmt.Sprintf("%s/%d", prefix, i))
+			out = append(out, StmtRef{ID: id, Stmt: s})
+			switch st := s.(type) {
+			case *IfStmt:
+				walk(id+"/then", st.Then)
+				for j, e := range st.Elifs {
+					walk(ID(fmt.Sprintf("%s/elif%d", id, j)), e.Body)
+				}
+				walk(id+"/else", st.Else)
+			case *WhileStmt:
+				walk(id+"/body", st.Body)
+			case *WithStmt:
+				walk(id+"/body", st.Body)
+			}
+		}
+	}
+	walk(FuncID(fn), fn.Body)
+	return out
+}
+
+// Signature renders fn's header, e.g. "def add(a: i32, b: i32) -> i32".
+func Signature(fn *FuncDecl) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "def %s(", fn.Name)
+	for i, p := range fn.Params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if p.Mut {
+			b.WriteString("mut ")
+		}
+		fmt.Fprintf(&b, "%s: %s", p.Name, p.Type)
+	}
+	fmt.Fprintf(&b, ") -> %s", orDefault(fn.Ret, "void"))
+	return b.String()
+}
+
+// Fingerprint is a content hash of n: equal fingerprints mean structurally
+// equal subtrees, regardless of formatting or comments.
+func Fingerprint(n Node) string {
+	var b strings.Builder
+	canon(&b, n)
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// canon writes an unambiguous rendering of n used for fingerprints.
+func canon(b *strings.Builder, n Node) {
+	switch v := n.(type) {
+	case *FuncDecl:
+		b.WriteString(Signature(v))
+		canonBody(b, v.Body)
+	case *IdentExpr:
+		fmt.Fprintf(b, "id(%s)", v.Name)
+	case *IntLit:
+		fmt.Fprintf(b, "int(%s%s)", v.Value, v.Suffix)
+	case *FloatLit:
+		fmt.Fprintf(b, "float(%s)", v.Value)
+	case *StrLit:
+		fmt.Fprintf(b, "str(%q)", v.Value)
+	case *InterpExpr:
+		b.WriteString("interp(")
+		for i, part := range v.Parts {
+			fmt.Fprintf(b, "%q", part)
+			if i < len(v.Holes) {
+				b.WriteString(",")
+				canon(b, v.Holes[i])
+				b.WriteString(",")
+			}
+		}
+		b.WriteString(")")
+	case *BoolLit:
+		fmt.Fprintf(b, "bool(%t)", v.Value)
+	case *CharLit:
+		fmt.Fprintf(b, "char(%d)", v.Value)
+	case *NoneLit:
+		b.WriteString("none")
+	case *CallExpr:
+		b.WriteString("call(")
+		canon(b, v.Callee)
+		for _, a := range v.Args {
+			b.WriteString(",")
+			canon(b, a)
+		}
+		b.WriteString(")")
+	case *IndexExpr:
+		b.WriteString("index(")
+		canon(b, v.Seq)
+		b.WriteString(",")
+		canon(b, v.Index)
+		b.WriteString(")")
+	case *FieldExpr:
+		b.WriteString("field(")
+		canon(b, v.X)
+		fmt.Fprintf(b, ",%s)", v.Name)
+	case *UnaryExpr:
+		fmt.Fprintf(b, "unary(%s,", v.Op)
+		canon(b, v.X)
+		b.WriteString(")")
+	case *ParenExpr:
+		canon(b, v.X) // redundant parens don't change what a statement is
+	case *BinaryExpr:
+		fmt.Fprintf(b, "bin(%s,", v.Op)
+		canon(b, v.Left)
+		b.WriteString(",")
+		canon(b, v.Right)
+		b.WriteString(")")
+	case *RangeExpr:
+		fmt.Fprintf(b, "range(%t,", v.Inclusive)
+		canon(b, v.Lo)
+		b.WriteString(",")
+		canon(b, v.Hi)
+		b.WriteString(")")
+	case *LetStmt:
+		fmt.Fprintf(b, "let(%t,%s,", v.Mutable, v.Name)
+		canon(b, v.Expr)
+		b.WriteString(")")
+	case *AssignStmt:
+		fmt.Fprintf(b, "assign(%s,", v.Name)
+		canon(b, v.Expr)
+		b.WriteString(")")
+	case *ReturnStmt:
+		b.WriteString("return(")
+		if v.Expr != nil {
+			canon(b, v.Expr)
+		}
+		b.WriteString(")")
+	case *ExprStmt:
+		b.WriteString("expr(")
+		canon(b, v.Expr)
+		b.WriteString(")")
+	case *IfStmt:
+		b.WriteString("if(")
+		canon(b, v.Cond)
+		canonBody(b, v.Then)
+		for _, e := range v.Elifs {
+			b.WriteString("elif(")
+			canon(b, e.Cond)
+			canonBody(b, e.Body)
+			b.WriteString(")")
+		}
+		if v.Else != nil {
+			b.WriteString("else")
+			canonBody(b, v.Else)
+		}
+		b.WriteString(")")
+	case *WhileStmt:
+		b.WriteString("while(")
+		canon(b, v.Cond)
+		canonBody(b, v.Body)
+		b.WriteString(")")
+	case *DeferStmt:
+		b.WriteString("defer(")
+		canon(b, v.Call)
+		b.WriteString(")")
+	case *SpawnStmt:
+		b.WriteString("spawn(")
+		canon(b, v.Call)
+		b.WriteString(")")
+	case *WithStmt:
+		b.WriteString("with(")
+		canon(b, v.Expr)
+		b.WriteString(" as " + v.Name)
+		canonBody(b, v.Body)
+		b.WriteString(")")
+	default:
+		fmt.Fprintf(b, "%T", n)
+	}
+}
+
+func canonBody(b *strings.Builder, body []Stmt) {
+	b.WriteString("{")
+	for _, s := range body {
+		canon(b, s)
+		b.WriteString(";")
+	}
+	b.WriteString("}")
+}
@@ -0,0 +1,175 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/vfs"
+)
+
+// PathEnv lists extra import roots, separated like $PATH. They are searched
+// after the entry file's directory.
+const PathEnv = "DESI_PATH"
+
+// SearchPathFromEnv returns the roots listed in $DESI_PATH, made absolute.
+func SearchPathFromEnv() []string {
+	var roots []string
+	for _, r := range filepath.SplitList(os.Getenv(PathEnv)) {
+		if r != "" {
+			roots = append(roots, mustAbs(r))
+		}
+	}
+	return roots
+}
+
+// validImportPath checks that every dot-separated segment of an import
+// path is an identifier, so it maps to exactly one relative file path.
+func validImportPath(path string) error {
+	for i, seg := range strings.Split(path, ".") {
+		if !isIdent(seg) {
+			return diag.Diagnostic{Code: diag.BadImportPath,
+				Msg: fmt.Sprintf("import %q: segment %d %q is not an identifier", path, i+1, seg)}
+		}
+	}
+	return nil
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// importFile is the file an import path names relative to a search root.
+func importFile(path string) string {
+	return strings.ReplaceAll(path, ".", string(filepath.Separator)) + ".desi"
+}
+
+// findImport returns the first root that has the file for path, and every
+// candidate file in search order.
+func findImport(fp vfs.FileProvider, roots []string, path string) (target string, tried []string) {
+	for _, root := range roots {
+		cand := filepath.Join(root, importFile(path))
+		tried = append(tried, cand)
+		if fileExists(fp, cand) {
+			return cand, tried
+		}
+	}
+	return "", tried
+}
+
+// importNotFound explains a failed import: where it looked and, when a
+// search root has something close, what was probably meant.
+func importNotFound(fp vfs.FileProvider, roots, tried []string, path, from, rootDir string) error {
+	var shown []string
+	for _, t := range tried {
+		shown = append(shown, display(rootDir, t))
+	}
+	msg := fmt.Sprintf("import %q not found (from %s); searched %s", path, display(rootDir, from), strings.Join(shown, ", "))
+	if dl, ok := fp.(vfs.DirLister); ok {
+		var missing string
+		for _, root := range roots {
+			fix, miss := nearMiss(fp, dl, root, path)
+			if fix != "" {
+				msg += fmt.Sprintf("; did you mean %q (%s)?", fix, display(rootDir, filepath.Join(root, importFile(fix))))
+				return diag.Diagnostic{Code: diag.ImportNotFound, Msg: msg}
+			}
+			if missing == "" && miss != "" {
+				missing = display(rootDir, miss)
+			}
+		}
+		if missing != "" {
+			msg += fmt.Sprintf("; no directory %s", missing)
+		}
+	}
+	return diag.Diagnostic{Code: diag.ImportNotFound, Msg: msg}
+}
+
+// nearMiss walks path's segments down from root, replacing each segment
+// that does not exist by a sibling differing only in case or by a typo or
+// two. It returns the corrected import path if that names an existing file,
+// otherwise the first directory that has no close match.
+func nearMiss(fp vfs.FileProvider, dl vfs.DirLister, root, path string) (fix, missingDir string) {
+	segs := strings.Split(path, ".")
+	dir := root
+	changed := false
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		name := seg
+		if last {
+			name += ".desi"
+		}
+		entries, err := dl.ListDir(dir)
+		if err != nil {
+			return "", dir
+		}
+		best, found := "", false
+		for _, e := range entries {
+			if e.Dir == last {
+				continue
+			}
+			if e.Name == name {
+				best, found = e.Name, true
+				break
+			}
+			if strings.EqualFold(e.Name, name) || (best == "" && editDistance(e.Name, name) <= 2) {
+				best = e.Name
+			}
+		}
+		if best == "" {
+			if last {
+				return "", ""
+			}
+			return "", filepath.Join(dir, seg)
+		}
+		if !found {
+			changed = true
+			segs[i] = strings.TrimSuffix(best, ".desi")
+		}
+		dir = filepath.Join(dir, best)
+	}
+	if !changed || !fileExists(fp, dir) {
+		return "", ""
+	}
+	return strings.Join(segs, "."), ""
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// display shows p relative to the entry directory when it is inside it,
+// and absolute otherwise (e.g. a $DESI_PATH root).
+func display(rootDir, p string) string {
+	if r := rel(rootDir, p); !strings.HasPrefix(r, "..") {
+		return r
+	}
+	return p
+}
@@ -0,0 +1,85 @@
+package build
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/vfs"
+)
+
+func TestImportFromSearchPath(t *testing.T) {
+	root, lib := t.TempDir(), t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import util.text\ndef main() -> i32:\n  return text.width()\n"))
+	fs.Set(filepath.Join(lib, "util", "text.desi"), []byte("def width() -> i32:\n  return 8\n"))
+
+	if _, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{Path: []string{}}); len(errs) != 1 {
+		t.Fatalf("without the lib root: errors = %v, want 1", errs)
+	}
+	res, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{Path: []string{lib}})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(res.File.Decls) != 2 {
+		t.Fatalf("want main + width, got %d decls", len(res.File.Decls))
+	}
+
+	t.Setenv(PathEnv, lib)
+	if _, errs := LoadFS(fs, filepath.Join(root, "main.desi")); len(errs) > 0 {
+		t.Fatalf("$%s not used: %v", PathEnv, errs)
+	}
+}
+
+func TestImportNotFoundDiagnostics(t *testing.T) {
+	root, lib := t.TempDir(), t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "util", "Text.desi"), []byte("def width() -> i32:\n  return 8\n"))
+	fs.Set(filepath.Join(root, "util", "strings.desi"), []byte("def pad() -> i32:\n  return 0\n"))
+	fs.Set(filepath.Join(lib, "net", "http.desi"), []byte("def get() -> i32:\n  return 0\n"))
+
+	cases := []struct {
+		imp  string
+		want []string
+	}{
+		{"util.text", []string{
+			`I0001: import "util.text" not found (from main.desi); searched ` + filepath.Join("util", "text.desi") + ", " + filepath.Join(lib, "util", "text.desi"),
+			`did you mean "util.Text"`,
+		}},
+		{"util.string", []string{`did you mean "util.strings"`}},
+		{"utils.strings", []string{`did you mean "util.strings"`}},
+		{"net.htp", []string{`did you mean "net.http" (` + filepath.Join(lib, "net", "http.desi") + ")"}},
+		{"gfx.draw", []string{"; no directory gfx"}},
+	}
+	for _, tc := range cases {
+		fs.Set(filepath.Join(root, "main.desi"), []byte("import "+tc.imp+"\n"))
+		_, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{Path: []string{lib}})
+		if len(errs) != 1 {
+			t.Fatalf("%s: errors = %v, want 1", tc.imp, errs)
+		}
+		var d diag.Diagnostic
+		if !errors.As(errs[0], &d) || d.Code != diag.ImportNotFound {
+			t.Errorf("%s: %v is not an %s diagnostic", tc.imp, errs[0], diag.ImportNotFound)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(errs[0].Error(), w) {
+				t.Errorf("%s: %v\nwant it to contain %q", tc.imp, errs[0], w)
+			}
+		}
+	}
+}
+
+func TestValidImportPath(t *testing.T) {
+	for _, ok := range []string{"a", "util.text", "_x.y2"} {
+		if err := validImportPath(ok); err != nil {
+			t.Errorf("%q: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "a..b", "a.", "1a", "a.b-c"} {
+		if err := validImportPath(bad); err == nil || !strings.HasPrefix(err.Error(), diag.BadImportPath) {
+			t.Errorf("%q: err = %v, want %s", bad, err, diag.BadImportPath)
+		}
+	}
+}
@@ -1,93 +1,259 @@
 package build
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/check"
+	"github.com/desilang/desi/compiler/internal/diag"
 	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/vfs"
 )
 
+// Result is the outcome of loading an entry file and its imports.
+type Result struct {
+	File     *ast.File       // merged declarations (entry first); nil on error
+	Paths    []string        // absolute paths of every file visited or looked up, in order
+	Warnings []check.Warning // uses of deprecated syntax, unless Options.DenyDeprecated
+}
+
+// DefaultStdDir is where Desi-source std modules live, relative to the
+// working directory like the C runtime, unless Options.StdDir says otherwise.
+var DefaultStdDir = "std"
+
+// Options configures LoadWith.
+type Options struct {
+	// StdDir holds Desi-source std modules: `import std.a.b` loads
+	// <StdDir>/a/b.desi. Empty means DefaultStdDir.
+	StdDir string
+
+	// Path lists import roots searched after the entry file's directory.
+	// nil means $DESI_PATH (see SearchPathFromEnv).
+	Path []string
+
+	// DenyDeprecated reports deprecated syntax as errors, not warnings.
+	DenyDeprecated bool
+
+	// Limits bound literal sizes and nesting in every file; the zero value
+	// means parser.DefaultLimits.
+	Limits parser.Limits
+}
+
 // ResolveAndParse loads the entry file, resolves imports recursively, and returns
 // a single merged *ast.File that concatenates all Decls (entry first, then deps).
 // Import rules (Stage-0):
-//   - import paths like "foo.bar" resolve to "<dir>/foo/bar.desi"
-//   - imports starting with "std." are ignored (runtime-provided)
-//   - cycles are detected and reported
+//   - import paths like "foo.bar" resolve to "<dir>/foo/bar.desi", where dir
+//     is the entry file's directory or, failing that, a $DESI_PATH root
+//   - "std.x" resolves to <std dir>/x.desi if present; otherwise x must be a
+//     builtin module (io, fs, ...) whose functions are compiler intrinsics
+//   - modules may import each other; only a cycle of `pub import`s is reported
 //   - duplicate loads are skipped
 func ResolveAndParse(entryPath string) (*ast.File, []error) {
+	res, errs := Load(entryPath)
+	return res.File, errs
+}
+
+// Load is ResolveAndParse but also reports the set of files it touched, even
+// when loading fails, so callers such as watch mode know what to poll.
+func Load(entryPath string) (*Result, []error) {
+	return LoadFS(vfs.OS{}, entryPath)
+}
+
+// LoadFS is Load reading every file through fp, so unsaved buffers can be
+// injected with a vfs.Overlay.
+func LoadFS(fp vfs.FileProvider, entryPath string) (*Result, []error) {
+	return LoadWith(fp, entryPath, Options{})
+}
+
+// LoadWith is LoadFS with explicit options.
+func LoadWith(fp vfs.FileProvider, entryPath string, opts Options) (*Result, []error) {
+	res := &Result{}
+	stdDir := opts.StdDir
+	if stdDir == "" {
+		stdDir = DefaultStdDir
+	}
+	stdAbs := mustAbs(stdDir)
 	entryAbs, err := filepath.Abs(entryPath)
 	if err != nil {
-		return nil, []error{fmt.Errorf("abs(%s): %v", entryPath, err)}
+		return res, []error{fmt.Errorf("abs(%s): %v", entryPath, err)}
 	}
 	rootDir := filepath.Dir(entryAbs)
+	searchPath := opts.Path
+	if searchPath == nil {
+		searchPath = SearchPathFromEnv()
+	}
+	roots := append([]string{rootDir}, searchPath...)
 
-	type unit struct {
-		path string // absolute file path
-		file *ast.File
+	// frame is a file being loaded; via is the import it is following, so
+	// the stack spells out the chain for cycle diagnostics.
+	type frame struct {
+		id, path string
+		via      ast.ImportDecl
 	}
 	var (
 		errs   []error
-		seen   = map[string]bool{} // absolute path → true
-		stack  = []string{}        // for cycle diagnostics
+		seen   = map[string]bool{} // pathKey → true
+		stack  = []*frame{}
 		result = []*unit{}
 	)
 
-	var load func(absPath string)
-	load = func(absPath string) {
-		if seen[absPath] {
+	// module is the file's import path without any "std." prefix ("" for
+	// the entry); std marks files under the std dir.
+	var load func(absPath, module string, std bool)
+	load = func(absPath, module string, std bool) {
+		id := pathKey(absPath)
+		if seen[id] {
 			return
 		}
-		// cycle check: if it's already on the stack, it's a cycle
-		for _, on := range stack {
-			if on == absPath {
-				errs = append(errs, fmt.Errorf("import cycle detected involving %s", absPath))
+		// A file already on the stack is being loaded: importing it again
+		// closes a cycle, which is fine, since signatures are collected
+		// program-wide before any body is checked. Only a ring of pub imports
+		// is an error, as every module in it would re-export itself.
+		for i, on := range stack {
+			if on.id != id {
+				continue
+			}
+			if slices.ContainsFunc(stack[i:], func(fr *frame) bool { return !fr.via.Pub }) {
 				return
 			}
+			var chain, edges []string
+			for _, fr := range stack[i:] {
+				chain = append(chain, rel(rootDir, fr.path))
+				edges = append(edges, fmt.Sprintf("\n  %s:%d:%d: import %s", rel(rootDir, fr.path), fr.via.Pos.Line, fr.via.Pos.Col, fr.via.Path))
+			}
+			chain = append(chain, rel(rootDir, absPath))
+			errs = append(errs, diag.Diagnostic{Code: diag.ImportCycle,
+				Msg: fmt.Sprintf("re-export cycle: %s%s\n  (make one of these a plain import)",
+					strings.Join(chain, " → "), strings.Join(edges, ""))})
+			return
 		}
-		stack = append(stack, absPath)
+		cur := &frame{id: id, path: absPath}
+		stack = append(stack, cur)
 		defer func() { stack = stack[:len(stack)-1] }()
+		res.Paths = append(res.Paths, absPath)
 
-		data, err := os.ReadFile(absPath)
+		data, err := fp.ReadFile(absPath)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("read %s: %v", rel(rootDir, absPath), err))
 			return
 		}
-		p := parser.New(string(data))
+		p := parser.NewWith(string(data), opts.Limits)
 		f, err := p.ParseFile()
 		if err != nil {
-			errs = append(errs, fmt.Errorf("parse %s: %v", rel(rootDir, absPath), err))
+			var d diag.Diagnostic
+			if errors.As(err, &d) {
+				d.File = rel(rootDir, absPath)
+				errs = append(errs, d)
+			} else {
+				errs = append(errs, fmt.Errorf("parse %s: %v", rel(rootDir, absPath), err))
+			}
 			return
 		}
+		for _, d := range p.Deprecations() {
+			d.File = rel(rootDir, absPath)
+			if opts.DenyDeprecated {
+				errs = append(errs, d)
+			} else {
+				at := fmt.Sprintf("%s:%d:%d", d.File, d.Span.Start.Line, d.Span.Start.Col)
+				res.Warnings = append(res.Warnings, check.Warning{Code: d.Code, Msg: at + ": " + d.Msg})
+			}
+		}
+
+		// An imported file's package is its import path; a package clause,
+		// optional, must agree with it. The entry file may name any package.
+		pkg := module
+		if std {
+			pkg = "std." + module
+		}
+		if f.Pkg != nil && pkg != "" && f.Pkg.Name != pkg {
+			errs = append(errs, diag.Diagnostic{File: rel(rootDir, absPath),
+				Span: diag.Span{Start: diag.Pos{Line: f.Pkg.Pos.Line, Col: f.Pkg.Pos.Col}},
+				Code: diag.PackageMismatch,
+				Msg:  fmt.Sprintf("package %s does not match the import path %s (declare package %s)", f.Pkg.Name, pkg, pkg)})
+		}
+
+		// Functions of imported modules can be called qualified by the last
+		// segment of their package: `import util.text` → text.f().
+		qual := pkg[strings.LastIndex(pkg, ".")+1:]
+		var imports []string
+		for _, imp := range f.Imports {
+			imports = append(imports, imp.Path[strings.LastIndex(imp.Path, ".")+1:])
+		}
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			fn.Module, fn.Package, fn.File, fn.Imports = qual, pkg, absPath, imports
+			if std && check.IsBuiltin(module, fn.Name) {
+				errs = append(errs, fmt.Errorf("%s: %s redefines the builtin std.%s.%s (builtins take precedence)",
+					rel(rootDir, absPath), fn.Name, module, fn.Name))
+			}
+		}
 
 		// resolve imports
+		var pubs []string
 		for _, imp := range f.Imports {
+			cur.via = imp
 			path := imp.Path
-			// ignore std.* for Stage-0
-			if strings.HasPrefix(path, "std.") {
+			if err := validImportPath(path); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", rel(rootDir, absPath), err))
+				continue
+			}
+			if name, ok := strings.CutPrefix(path, "std."); ok {
+				target := filepath.Join(stdAbs, strings.ReplaceAll(name, ".", string(filepath.Separator))+".desi")
+				switch {
+				case fileExists(fp, target):
+					load(target, name, true)
+					if imp.Pub {
+						pubs = append(pubs, pathKey(target))
+					}
+				case check.IsBuiltinModule(name):
+					// intrinsics only; nothing to load
+					if imp.Pub {
+						errs = append(errs, fmt.Errorf("%s:%d:%d: cannot re-export %q: builtin modules are intrinsics (call %s.f directly)",
+							rel(rootDir, absPath), imp.Pos.Line, imp.Pos.Col, path, name[strings.LastIndex(name, ".")+1:]))
+					}
+				default:
+					res.Paths = append(res.Paths, target)
+					errs = append(errs, fmt.Errorf("import %q: no such std module (no %s and not a builtin) (from %s)",
+						path, rel(rootDir, target), rel(rootDir, absPath)))
+				}
+				continue
+			}
+			if std {
+				errs = append(errs, fmt.Errorf("%s: std modules may only import std.*, not %q", rel(rootDir, absPath), path))
 				continue
 			}
-			relPath := strings.ReplaceAll(path, ".", string(filepath.Separator)) + ".desi"
-			target := filepath.Join(rootDir, relPath)
-			if !fileExists(target) {
-				errs = append(errs, fmt.Errorf("import %q → %s not found (from %s)",
-					path, rel(rootDir, target), rel(rootDir, absPath)))
+			target, tried := findImport(fp, roots, path)
+			if target == "" {
+				// watch every candidate: the import resolves once any appears
+				for _, t := range tried {
+					res.Paths = append(res.Paths, mustAbs(t))
+				}
+				errs = append(errs, importNotFound(fp, roots, tried, path, absPath, rootDir))
 				continue
 			}
-			load(mustAbs(target))
+			load(mustAbs(target), path, false)
+			if imp.Pub {
+				pubs = append(pubs, pathKey(mustAbs(target)))
+			}
 		}
 
-		result = append(result, &unit{path: absPath, file: f})
-		seen[absPath] = true
+		result = append(result, &unit{id: id, path: absPath, qual: qual, file: f, pubs: pubs})
+		seen[id] = true
 	}
 
-	load(entryAbs)
+	load(entryAbs, "", false)
 
 	if len(errs) > 0 {
-		return nil, errs
+		return res, errs
 	}
+	linkReexports(result)
 
 	// Merge: entry file first, then others in load order (which is DFS post-order).
 	// Ensure entry is first by stable partition.
@@ -109,28 +275,63 @@ func ResolveAndParse(entryPath string) (*ast.File, []error) {
 		}
 	}
 
-	return &merged, nil
+	res.File = &merged
+	return res, nil
 }
 
-func fileExists(p string) bool {
-	_, err := os.Stat(p)
+// unit is a loaded file.
+type unit struct {
+	id   string // pathKey of path
+	path string // absolute file path
+	qual string // call qualifier ("" for the entry)
+	file *ast.File
+	pubs []string // ids of the modules it re-exports with `pub import`
+}
+
+// linkReexports makes the functions of every module a unit re-exports,
+// directly or through further `pub import`s, callable with the unit's
+// qualifier too. A module reached twice (through a diamond or a cycle of
+// plain imports) is visited once.
+func linkReexports(units []*unit) {
+	byID := map[string]*unit{}
+	for _, u := range units {
+		byID[u.id] = u
+	}
+	for _, u := range units {
+		if u.qual == "" || len(u.pubs) == 0 {
+			continue
+		}
+		visited := map[string]bool{}
+		var visit func(id string)
+		visit = func(id string) {
+			dep := byID[id]
+			if dep == nil || visited[id] {
+				return
+			}
+			visited[id] = true
+			for _, d := range dep.file.Decls {
+				if fn, ok := d.(*ast.FuncDecl); ok && fn.Module != u.qual && !slices.Contains(fn.Reexports, u.qual) {
+					fn.Reexports = append(fn.Reexports, u.qual)
+				}
+			}
+			for _, next := range dep.pubs {
+				visit(next)
+			}
+		}
+		for _, id := range u.pubs {
+			visit(id)
+		}
+	}
+}
+
+func fileExists(fp vfs.FileProvider, p string) bool {
+	_, err := fp.ModTime(p)
 	return err == nil
 }
 func mustAbs(p string) string {
 	a, _ := filepath.Abs(p)
 	return a
 }
-func same(a, b string) bool {
-	aa, _ := filepath.EvalSymlinks(a)
-	bb, _ := filepath.EvalSymlinks(b)
-	if aa == "" {
-		aa = a
-	}
-	if bb == "" {
-		bb = b
-	}
-	return aa == bb
-}
 func rel(root, p string) string {
 	r, err := filepath.Rel(root, p)
 	if err != nil {
@@ -0,0 +1,277 @@
+package build
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/check"
+	"github.com/desilang/desi/compiler/internal/vfs"
+)
+
+func TestLoadFSFromOverlay(t *testing.T) {
+	root := t.TempDir() // exists on disk, but the sources below do not
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte(""+
+		"package main\n"+
+		"import util.math\n"+
+		"def main() -> i32:\n"+
+		"  return twice(21)\n"))
+	fs.Set(filepath.Join(root, "util", "math.desi"), []byte(""+
+		"def twice(x: i32) -> i32:\n"+
+		"  return x * 2\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(res.Paths) != 2 {
+		t.Fatalf("want 2 paths, got %v", res.Paths)
+	}
+	var names []string
+	for _, d := range res.File.Decls {
+		names = append(names, d.(*ast.FuncDecl).Name)
+	}
+	if len(names) != 2 || names[0] != "main" || names[1] != "twice" {
+		t.Fatalf("decl order = %v, want [main twice]", names)
+	}
+}
+
+func TestLoadFSMissingImportIsReported(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import nope\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) != 1 {
+		t.Fatalf("want 1 error, got %v", errs)
+	}
+	// the missing file is still reported so --watch can notice it appear
+	if len(res.Paths) != 2 {
+		t.Fatalf("want entry + missing import in paths, got %v", res.Paths)
+	}
+}
+
+func TestLoadStdSources(t *testing.T) {
+	root := t.TempDir()
+	std := filepath.Join(root, "stdlib")
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte(""+
+		"import std.io\n"+
+		"import std.text.pad\n"+
+		"def main() -> i32:\n"+
+		"  return width()\n"))
+	fs.Set(filepath.Join(std, "text", "pad.desi"), []byte(""+
+		"import std.io\n"+
+		"def width() -> i32:\n"+
+		"  return 8\n"))
+
+	res, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{StdDir: std})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if n := len(res.File.Decls); n != 2 {
+		t.Fatalf("want main + width, got %d decls", n)
+	}
+}
+
+func TestLoadStdErrors(t *testing.T) {
+	root := t.TempDir()
+	std := filepath.Join(root, "std")
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import std.nope\nimport std.time\n"))
+	fs.Set(filepath.Join(std, "time.desi"), []byte(""+
+		"import helpers\n"+
+		"def now_ms() -> i32:\n"+
+		"  return 0\n"))
+
+	_, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{StdDir: std})
+	want := []string{
+		`import "std.nope": no such std module`,
+		"now_ms redefines the builtin std.time.now_ms",
+		`std modules may only import std.*, not "helpers"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v", errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i].Error(), w) {
+			t.Errorf("error %d = %v, want it to contain %q", i, errs[i], w)
+		}
+	}
+}
+
+func TestLoadSetsModuleQualifiers(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import util.text\ndef main() -> i32:\n  return text.width()\n"))
+	fs.Set(filepath.Join(root, "util", "text.desi"), []byte("def width() -> i32:\n  return 8\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	mods := map[string]string{}
+	for _, d := range res.File.Decls {
+		fn := d.(*ast.FuncDecl)
+		mods[fn.Name] = fn.Module
+	}
+	if mods["main"] != "" || mods["width"] != "text" {
+		t.Fatalf("modules = %v, want main:\"\" width:text", mods)
+	}
+}
+
+func TestLoadMutualRecursionAcrossModules(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import even\ndef main() -> i32:\n  return even.is_even(7)\n"))
+	// even and odd import and call each other
+	fs.Set(filepath.Join(root, "even.desi"), []byte("import odd\ndef is_even(n: i32) -> i32:\n  if n == 0:\n    return 1\n  return odd.is_odd(n - 1)\n"))
+	fs.Set(filepath.Join(root, "odd.desi"), []byte("import even\ndef is_odd(n: i32) -> i32:\n  if n == 0:\n    return 0\n  return even.is_even(n - 1)\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected load errors: %v", errs)
+	}
+	if _, errs, _ := check.CheckFile(res.File); len(errs) > 0 {
+		t.Fatalf("unexpected check errors: %v", errs)
+	}
+}
+
+func TestLoadQualifierNeedsImport(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	// main loads both modules, but only odd imports the one it calls
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import even\nimport odd\ndef main() -> i32:\n  return even.is_even(7)\n"))
+	fs.Set(filepath.Join(root, "even.desi"), []byte("def is_even(n: i32) -> i32:\n  if n == 0:\n    return 1\n  return odd.is_odd(n - 1)\n"))
+	fs.Set(filepath.Join(root, "odd.desi"), []byte("import even\ndef is_odd(n: i32) -> i32:\n  if n == 0:\n    return 0\n  return even.is_even(n - 1)\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected load errors: %v", errs)
+	}
+	_, errs, _ = check.CheckFile(res.File)
+	want := `call to unknown function "odd.is_odd": module odd is loaded, but this file does not import it (missing import?)`
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Fatalf("errors = %v, want %q", errs, want)
+	}
+}
+
+func TestLoadReexportCycle(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import a\ndef main() -> void:\n  return\n"))
+	fs.Set(filepath.Join(root, "a.desi"), []byte("pub import b\ndef f() -> void:\n  return\n"))
+	fs.Set(filepath.Join(root, "b.desi"), []byte("pub import a\ndef g() -> void:\n  return\n"))
+
+	_, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "I0003: re-export cycle") {
+		t.Fatalf("errors = %v, want one re-export cycle", errs)
+	}
+	// the chain starts where the cycle closes, with the import of each edge
+	for _, want := range []string{"a.desi → b.desi → a.desi", "a.desi:1:1: import b", "b.desi:1:1: import a"} {
+		if !strings.Contains(errs[0].Error(), want) {
+			t.Errorf("%v\nwant it to contain %q", errs[0], want)
+		}
+	}
+
+	// one plain import in the ring breaks it
+	fs.Set(filepath.Join(root, "b.desi"), []byte("import a\ndef g() -> void:\n  return\n"))
+	if _, errs := LoadFS(fs, filepath.Join(root, "main.desi")); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestLoadReexports(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte(""+
+		"import lib\n"+
+		"def main() -> i32:\n"+
+		"  return lib.width() + lib.pad(1) + lib.version()\n"))
+	// lib is a facade over lib/text, which in turn re-exports lib/pad.
+	fs.Set(filepath.Join(root, "lib.desi"), []byte("pub import lib.text\ndef version() -> i32:\n  return 1\n"))
+	fs.Set(filepath.Join(root, "lib", "text.desi"), []byte("pub import lib.pad\ndef width() -> i32:\n  return 8\n"))
+	fs.Set(filepath.Join(root, "lib", "pad.desi"), []byte("def pad(n: i32) -> i32:\n  return n\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected load errors: %v", errs)
+	}
+	got := map[string]string{}
+	for _, d := range res.File.Decls {
+		fn := d.(*ast.FuncDecl)
+		got[fn.Name] = fn.Module + " " + strings.Join(fn.Reexports, ",")
+	}
+	want := map[string]string{"main": " ", "version": "lib ", "width": "text lib", "pad": "pad text,lib"}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("%s: module and re-exports = %q, want %q", name, got[name], w)
+		}
+	}
+	if _, errs, _ := check.CheckFile(res.File); len(errs) > 0 {
+		t.Fatalf("unexpected check errors: %v", errs)
+	}
+}
+
+func TestLoadReexportOfBuiltin(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import lib\n"))
+	fs.Set(filepath.Join(root, "lib.desi"), []byte("import std.fs\npub import std.io\n"))
+
+	_, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `lib.desi:2:1: cannot re-export "std.io": builtin modules are intrinsics`) {
+		t.Fatalf("errors = %v, want one builtin re-export error", errs)
+	}
+}
+
+func TestLoadPackageClauses(t *testing.T) {
+	root := t.TempDir()
+	std := filepath.Join(root, "std")
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("package main\nimport util.text\nimport std.pad\ndef main() -> i32:\n  return text.width() + pad.left()\n"))
+	fs.Set(filepath.Join(root, "util", "text.desi"), []byte("package util.text\ndef width() -> i32:\n  return 8\n"))
+	fs.Set(filepath.Join(std, "pad.desi"), []byte("def left() -> i32:\n  return 1\n")) // the clause is optional
+
+	res, errs := LoadWith(fs, filepath.Join(root, "main.desi"), Options{StdDir: std})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	pkgs := map[string]string{}
+	for _, d := range res.File.Decls {
+		fn := d.(*ast.FuncDecl)
+		pkgs[fn.Name] = fn.Package
+	}
+	if pkgs["main"] != "" || pkgs["width"] != "util.text" || pkgs["left"] != "std.pad" {
+		t.Fatalf("packages = %v", pkgs)
+	}
+
+	fs.Set(filepath.Join(root, "util", "text.desi"), []byte("\npackage main\ndef width() -> i32:\n  return 8\n"))
+	_, errs = LoadWith(fs, filepath.Join(root, "main.desi"), Options{StdDir: std})
+	want := filepath.Join("util", "text.desi") + ":2:1: I0004: package main does not match the import path util.text (declare package util.text)"
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Fatalf("errors = %v, want %q", errs, want)
+	}
+}
+
+func TestLoadDeprecatedSyntax(t *testing.T) {
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import util\ndef main() -> void:\n  return\n"))
+	fs.Set(filepath.Join(root, "util.desi"), []byte("def f() -> i32:\n  let mut n = 1\n  n = 2\n  return n\n"))
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(res.Warnings) != 1 || !strings.HasPrefix(res.Warnings[0].String(), "D0001: util.desi:3:5: n = ... reassigns with =") {
+		t.Fatalf("warnings = %v, want one D0001 in util.desi", res.Warnings)
+	}
+
+	res, errs = LoadWith(fs, filepath.Join(root, "main.desi"), Options{DenyDeprecated: true})
+	if len(res.Warnings) != 0 || len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "util.desi:3:5: D0001: n = ... reassigns with =") {
+		t.Fatalf("errors = %v, warnings = %v; want the deprecation as an error", errs, res.Warnings)
+	}
+}
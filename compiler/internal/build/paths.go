@@ -0,0 +1,43 @@
+package build
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// hostOS selects the path rules of pathKey; tests override it.
+var hostOS = runtime.GOOS
+
+// pathKey is the identity of a module file: two paths with the same key
+// are the same module and load once. Symlinks are resolved when the file
+// exists on disk (overlay-only files keep their path).
+func pathKey(p string) string {
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	}
+	return normPath(p, hostOS)
+}
+
+// normPath cleans p by the rules of goos. Windows file systems are
+// case-insensitive and accept both separators, so there p is case-folded
+// and uses "/", keeping the leading "//" of a UNC path (\\server\share),
+// which path.Clean would collapse into a root.
+func normPath(p, goos string) string {
+	if goos != "windows" {
+		return filepath.Clean(p)
+	}
+	p = strings.ReplaceAll(p, `\`, "/")
+	unc := strings.HasPrefix(p, "//")
+	p = path.Clean(p)
+	if unc {
+		p = "/" + p
+	}
+	return strings.ToLower(p)
+}
+
+// same reports whether a and b name the same module file.
+func same(a, b string) bool {
+	return pathKey(a) == pathKey(b)
+}
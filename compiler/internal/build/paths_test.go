@@ -0,0 +1,73 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/vfs"
+)
+
+func TestNormPath(t *testing.T) {
+	cases := []struct {
+		a, b string
+		goos string
+		same bool
+	}{
+		{"/src/a/../main.desi", "/src/main.desi", "linux", true},
+		{"/src/Main.desi", "/src/main.desi", "linux", false},
+		{`C:\Src\Main.desi`, `c:\src\main.desi`, "windows", true},
+		{`C:\src\util\..\main.desi`, "C:/src/main.desi", "windows", true},
+		{`\\Server\Share\lib\a.desi`, `\\server\share\lib\x\..\a.desi`, "windows", true},
+		// a UNC path is not the same as a rooted one on the current drive
+		{`\\server\share\a.desi`, `\server\share\a.desi`, "windows", false},
+	}
+	for _, tc := range cases {
+		ka, kb := normPath(tc.a, tc.goos), normPath(tc.b, tc.goos)
+		if (ka == kb) != tc.same {
+			t.Errorf("%s: %q → %q, %q → %q; same = %t, want %t", tc.goos, tc.a, ka, tc.b, kb, ka == kb, tc.same)
+		}
+	}
+}
+
+func TestLoadSymlinkedModuleOnce(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.desi", "import a\nimport b\ndef main() -> i32:\n  return f()\n")
+	write("a.desi", "def f() -> i32:\n  return 1\n")
+	if err := os.Symlink(filepath.Join(root, "a.desi"), filepath.Join(root, "b.desi")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+
+	res, errs := LoadFS(vfs.OS{}, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if n := len(res.File.Decls); n != 2 {
+		t.Fatalf("want main + one f, got %d decls", n)
+	}
+}
+
+func TestLoadCaseInsensitiveOnWindows(t *testing.T) {
+	defer func(prev string) { hostOS = prev }(hostOS)
+	hostOS = "windows"
+	root := t.TempDir()
+	fs := vfs.NewOverlay(nil)
+	// Two spellings of one file, as a case-insensitive file system sees it.
+	src := []byte("def f() -> i32:\n  return 1\n")
+	fs.Set(filepath.Join(root, "main.desi"), []byte("import util\nimport Util\ndef main() -> i32:\n  return f()\n"))
+	fs.Set(filepath.Join(root, "util.desi"), src)
+	fs.Set(filepath.Join(root, "Util.desi"), src)
+
+	res, errs := LoadFS(fs, filepath.Join(root, "main.desi"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if n := len(res.File.Decls); n != 2 {
+		t.Fatalf("want main + one f, got %d decls", n)
+	}
+}
@@ -0,0 +1,280 @@
+// Package cc drives the system C compiler: it picks one and compiles
+// generated C together with the Desi runtime.
+package cc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/term"
+	"github.com/desilang/desi/compiler/internal/toolchain"
+)
+
+// candidates are tried in order when neither an explicit compiler nor $CC
+// is given.
+var candidates = []string{"clang", "gcc", "cc"}
+
+// ErrNotFound is returned when no C compiler can be located.
+var ErrNotFound = errors.New("no C compiler found (tried $CC, an installed toolchain, clang, gcc, cc)")
+
+// lookPath resolves a command name on PATH; tests replace it.
+var lookPath = exec.LookPath
+
+// installed returns the compiler `desic toolchain install` set up, or "";
+// tests replace it.
+var installed = toolchain.Compiler
+
+// Find resolves the compiler to use and returns its path. An explicit name
+// wins, then $CC, then an installed toolchain, then the first candidate on
+// PATH.
+func Find(explicit string) (string, error) {
+	return pickCompiler(explicit)
+}
+
+func pickCompiler(explicit string) (string, error) {
+	if explicit != "" {
+		return lookPath(explicit)
+	}
+	if env := os.Getenv("CC"); env != "" {
+		return lookPath(env)
+	}
+	if p := installed(); p != "" {
+		return p, nil
+	}
+	for _, c := range candidates {
+		if p, err := lookPath(c); err == nil {
+			return p, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Runner runs one compiler command. The default executes it; tests pass a
+// fake so no toolchain is needed.
+type Runner func(name string, args []string, stdout, stderr io.Writer) error
+
+func execRunner(name string, args []string, stdout, stderr io.Writer) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return cmd.Run()
+}
+
+// Options describes one compile+link of generated C with the runtime.
+type Options struct {
+	CC         string   // compiler binary or path
+	RuntimeDir string   // directory containing desi_std.c / desi_std.h
+	Sources    []string // generated C files
+	Out        string   // output binary path; see Binary
+	GOOS       string   // OS the compiler runs on and targets; runtime.GOOS if ""
+	Target     string   // cross-compilation target triple (e.g. aarch64-linux-musl); zig or clang only
+	CacheDir   string   // if set, the runtime is compiled once per target under it; see RuntimeObject
+
+	Stdout, Stderr io.Writer // compiler output; os.Stdout/os.Stderr if nil
+
+	Run    Runner // executes the compiler; nil runs it for real
+	DryRun bool   // print the command line to Stdout instead of running it
+
+	runtimeObj string // RuntimeObject, once Compile has hashed its key
+}
+
+// MSVC reports whether cc takes MSVC-style flags (cl, clang-cl) rather
+// than gcc-style ones.
+func MSVC(cc string) bool {
+	base := strings.ToLower(filepath.Base(strings.ReplaceAll(cc, `\`, "/")))
+	base = strings.TrimSuffix(base, ".exe")
+	return base == "cl" || base == "clang-cl"
+}
+
+// Zig reports whether cc is the zig binary, which compiles C as `zig cc`.
+func Zig(cc string) bool {
+	base := strings.ToLower(filepath.Base(strings.ReplaceAll(cc, `\`, "/")))
+	return strings.TrimSuffix(base, ".exe") == "zig"
+}
+
+// CanTarget reports whether cc takes -target: zig and the clang driver
+// do, gcc and MSVC-style compilers are built for one target.
+func CanTarget(cc string) bool {
+	base := strings.ToLower(filepath.Base(strings.ReplaceAll(cc, `\`, "/")))
+	return Zig(cc) || strings.HasPrefix(base, "clang") && !MSVC(cc)
+}
+
+// goos is the OS the binary is for: the target's when cross-compiling.
+func (o Options) goos() string {
+	if o.Target != "" {
+		if strings.Contains(o.Target, "windows") {
+			return "windows"
+		}
+		return "other"
+	}
+	if o.GOOS != "" {
+		return o.GOOS
+	}
+	return runtime.GOOS
+}
+
+// Binary is the file the compiler writes: Out, with ".exe" added when
+// compiling on or for Windows (the toolchains add it anyway; callers need the
+// real name to run it).
+func (o Options) Binary() string {
+	if o.goos() == "windows" && !strings.EqualFold(filepath.Ext(o.Out), ".exe") {
+		return o.Out + ".exe"
+	}
+	return o.Out
+}
+
+// prefix is what every command line starts with: zig's "cc" subcommand,
+// MSVC's /nologo and the target.
+func (o Options) prefix() []string {
+	var args []string
+	if Zig(o.CC) {
+		args = append(args, "cc")
+	}
+	if MSVC(o.CC) {
+		args = append(args, "/nologo")
+	}
+	if o.Target != "" {
+		args = append(args, "-target", o.Target)
+	}
+	return args
+}
+
+// Args returns the compiler arguments (without the compiler itself). For
+// zig they start with the "cc" subcommand. With CacheDir set the runtime is
+// linked as RuntimeObject rather than compiled from source.
+func (o Options) Args() []string {
+	args := append(o.prefix(), o.Sources...)
+	if o.CacheDir != "" {
+		args = append(args, o.RuntimeObject())
+	} else {
+		args = append(args, filepath.Join(o.RuntimeDir, "desi_std.c"))
+	}
+	if MSVC(o.CC) {
+		// /Fe takes its value attached; a separate word would be a source.
+		return append(args, "/I", o.RuntimeDir, "/Fe"+o.Binary())
+	}
+	args = append(append(args, "-I", o.RuntimeDir), o.LinkFlags()...)
+	return append(args, "-o", o.Binary())
+}
+
+// LinkFlags are the flags linking a program needs beyond its objects:
+// -pthread for gcc-style compilers, since spawn and chan run on pthreads and
+// older glibc and the BSDs keep them out of libc. Windows uses its own
+// threads.
+func (o Options) LinkFlags() []string {
+	if MSVC(o.CC) || o.goos() == "windows" {
+		return nil
+	}
+	return []string{"-pthread"}
+}
+
+// RuntimeObject is where the runtime compiled for o's target lives:
+// CacheDir/<target>/<key>/desi_std.o, with "host" for native builds (.obj
+// for MSVC). Like compiler-rt, it is built once and linked into every
+// program for the target; key (see runtimeKey) changes with the compiler,
+// its flags or the runtime sources, so a stale object is never reused.
+func (o Options) RuntimeObject() string {
+	if o.runtimeObj != "" {
+		return o.runtimeObj
+	}
+	ext := ".o"
+	if MSVC(o.CC) {
+		ext = ".obj"
+	}
+	return filepath.Join(o.CacheDir, o.targetName(), o.runtimeKey(), "desi_std"+ext)
+}
+
+// targetName is o.Target, or "host" for native builds.
+func (o Options) targetName() string {
+	if o.Target == "" {
+		return "host"
+	}
+	return o.Target
+}
+
+// runtimeKey hashes what the runtime object depends on: the compiler (its
+// path, plus size and modification time when it is on disk, so an upgrade
+// misses), the flags it is compiled with, and desi_std.c and .h.
+func (o Options) runtimeKey() string {
+	h := sha256.New()
+	term.Wprintf(h, "%s\n", o.CC)
+	if p, err := exec.LookPath(o.CC); err == nil {
+		if st, err := os.Stat(p); err == nil {
+			term.Wprintf(h, "%d %d\n", st.Size(), st.ModTime().UnixNano())
+		}
+	}
+	term.Wprintf(h, "%q\n", o.prefix())
+	for _, f := range []string{"desi_std.c", "desi_std.h"} {
+		data, _ := os.ReadFile(filepath.Join(o.RuntimeDir, f))
+		term.Wprintf(h, "%s %d\n", f, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// RuntimeArgs returns the arguments that compile the runtime into
+// RuntimeObject.
+func (o Options) RuntimeArgs() []string {
+	args := append(o.prefix(), "-c", filepath.Join(o.RuntimeDir, "desi_std.c"))
+	if MSVC(o.CC) {
+		return append(args, "/I", o.RuntimeDir, "/Fo"+o.RuntimeObject())
+	}
+	return append(args, "-I", o.RuntimeDir, "-o", o.RuntimeObject())
+}
+
+// runtimeFresh reports whether RuntimeObject has been built.
+func (o Options) runtimeFresh() bool {
+	_, err := os.Stat(o.RuntimeObject())
+	return err == nil
+}
+
+// Compile runs the compiler described by o.
+func Compile(o Options) error {
+	stdout, stderr := o.Stdout, o.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if o.Target != "" && !CanTarget(o.CC) {
+		return fmt.Errorf("%s cannot cross-compile to %s; use zig or clang", o.CC, o.Target)
+	}
+	if o.CacheDir != "" {
+		o.runtimeObj = o.RuntimeObject() // hash the runtime's sources once
+	}
+	buildRuntime := o.CacheDir != "" && !o.runtimeFresh()
+	if o.DryRun {
+		if buildRuntime {
+			if _, err := fmt.Fprintln(stdout, strings.Join(append([]string{o.CC}, o.RuntimeArgs()...), " ")); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(stdout, strings.Join(append([]string{o.CC}, o.Args()...), " "))
+		return err
+	}
+	run := o.Run
+	if run == nil {
+		run = execRunner
+	}
+	if buildRuntime {
+		if err := os.MkdirAll(filepath.Dir(o.RuntimeObject()), 0o755); err != nil {
+			return err
+		}
+		if err := run(o.CC, o.RuntimeArgs(), stdout, stderr); err != nil {
+			os.Remove(o.RuntimeObject()) // never mistake a partial object for a fresh one
+			return fmt.Errorf("%s: runtime for %s: %v", o.CC, o.targetName(), err)
+		}
+	}
+	if err := run(o.CC, o.Args(), stdout, stderr); err != nil {
+		return fmt.Errorf("%s: %v", o.CC, err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package cc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	rt := filepath.Join("runtime", "c")
+	std := filepath.Join(rt, "desi_std.c")
+	cases := []struct {
+		name string
+		o    Options
+		want []string
+	}{
+		{
+			name: "gcc on linux",
+			o:    Options{CC: "/usr/bin/gcc", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "linux"},
+			want: []string{"a.c", std, "-I", rt, "-pthread", "-o", "app"},
+		},
+		{
+			name: "clang on darwin",
+			o:    Options{CC: "clang", RuntimeDir: rt, Sources: []string{"a.c", "b.c"}, Out: "app", GOOS: "darwin"},
+			want: []string{"a.c", "b.c", std, "-I", rt, "-pthread", "-o", "app"},
+		},
+		{
+			name: "gcc on windows adds .exe",
+			o:    Options{CC: "gcc.exe", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "windows"},
+			want: []string{"a.c", std, "-I", rt, "-o", "app.exe"},
+		},
+		{
+			name: "existing .exe is kept",
+			o:    Options{CC: "clang", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app.EXE", GOOS: "windows"},
+			want: []string{"a.c", std, "-I", rt, "-o", "app.EXE"},
+		},
+		{
+			name: "cl uses /Fe attached",
+			o:    Options{CC: `C:\VS\bin\cl.exe`, RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "windows"},
+			want: []string{"/nologo", "a.c", std, "/I", rt, "/Feapp.exe"},
+		},
+		{
+			name: "zig runs as zig cc",
+			o:    Options{CC: "/opt/zig/zig", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "linux"},
+			want: []string{"cc", "a.c", std, "-I", rt, "-pthread", "-o", "app"},
+		},
+		{
+			name: "cross target passes -target and names the binary for it",
+			o:    Options{CC: "zig", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "linux", Target: "x86_64-windows-gnu"},
+			want: []string{"cc", "-target", "x86_64-windows-gnu", "a.c", std, "-I", rt, "-o", "app.exe"},
+		},
+		{
+			name: "cross from windows to linux drops .exe",
+			o:    Options{CC: "clang.exe", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "windows", Target: "aarch64-linux-gnu"},
+			want: []string{"-target", "aarch64-linux-gnu", "a.c", std, "-I", rt, "-pthread", "-o", "app"},
+		},
+		{
+			name: "clang-cl is msvc-style",
+			o:    Options{CC: "clang-cl", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "windows"},
+			want: []string{"/nologo", "a.c", std, "/I", rt, "/Feapp.exe"},
+		},
+	}
+	for _, tc := range cases {
+		if got := tc.o.Args(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: args = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompileUsesRunner(t *testing.T) {
+	var gotName string
+	var gotArgs []string
+	o := Options{CC: "gcc", RuntimeDir: "rt", Sources: []string{"a.c"}, Out: "app", GOOS: "linux",
+		Run: func(name string, args []string, stdout, stderr io.Writer) error {
+			gotName, gotArgs = name, args
+			io.WriteString(stderr, "a.c:1: warning\n")
+			return nil
+		},
+	}
+	var stderr strings.Builder
+	o.Stderr = &stderr
+	if err := Compile(o); err != nil {
+		t.Fatal(err)
+	}
+	if gotName != "gcc" || !reflect.DeepEqual(gotArgs, o.Args()) {
+		t.Fatalf("ran %s %q, want gcc %q", gotName, gotArgs, o.Args())
+	}
+	if stderr.String() != "a.c:1: warning\n" {
+		t.Fatalf("stderr = %q, want the compiler's output", stderr.String())
+	}
+
+	o.Run = func(string, []string, io.Writer, io.Writer) error { return errors.New("exit status 1") }
+	if err := Compile(o); err == nil || err.Error() != "gcc: exit status 1" {
+		t.Fatalf("err = %v, want it prefixed with the compiler", err)
+	}
+
+	o.Target = "aarch64-linux-gnu"
+	o.Run = func(string, []string, io.Writer, io.Writer) error { t.Fatal("gcc cannot take -target"); return nil }
+	if err := Compile(o); err == nil || !strings.Contains(err.Error(), "cannot cross-compile") {
+		t.Fatalf("err = %v, want a cross-compile error", err)
+	}
+}
+
+func TestCompileDryRun(t *testing.T) {
+	var out strings.Builder
+	o := Options{CC: "cl", RuntimeDir: "rt", Sources: []string{"a.c"}, Out: "app", GOOS: "windows", Stdout: &out, DryRun: true,
+		Run: func(string, []string, io.Writer, io.Writer) error {
+			t.Fatal("DryRun must not run the compiler")
+			return nil
+		},
+	}
+	if err := Compile(o); err != nil {
+		t.Fatal(err)
+	}
+	want := "cl /nologo a.c " + filepath.Join("rt", "desi_std.c") + " /I rt /Feapp.exe\n"
+	if out.String() != want {
+		t.Fatalf("dry run printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestFind(t *testing.T) {
+	onPath := map[string]bool{"gcc": true, "cc": true}
+	defer func(prev func(string) (string, error)) { lookPath = prev }(lookPath)
+	defer func(prev func() string) { installed = prev }(installed)
+	installed = func() string { return "" }
+	lookPath = func(name string) (string, error) {
+		if onPath[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	t.Setenv("CC", "")
+	if p, err := Find(""); err != nil || p != "/usr/bin/gcc" {
+		t.Errorf("Find() = %q, %v; want the first candidate on PATH (gcc)", p, err)
+	}
+	if p, err := Find("cc"); err != nil || p != "/usr/bin/cc" {
+		t.Errorf("Find(cc) = %q, %v", p, err)
+	}
+	t.Setenv("CC", "clang")
+	if _, err := Find(""); err == nil {
+		t.Errorf("Find with CC=clang (not on PATH) succeeded; $CC must not fall back")
+	}
+	t.Setenv("CC", "")
+	installed = func() string { return "/cache/desi/toolchain/bin/clang" }
+	if p, err := Find(""); err != nil || p != "/cache/desi/toolchain/bin/clang" {
+		t.Errorf("Find() = %q, %v; want the installed toolchain ahead of PATH", p, err)
+	}
+	installed = func() string { return "" }
+	onPath = map[string]bool{}
+	if _, err := Find(""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRuntimeObjectPerTarget(t *testing.T) {
+	rt, cache := t.TempDir(), t.TempDir()
+	for _, f := range []string{"desi_std.c", "desi_std.h"} {
+		if err := os.WriteFile(filepath.Join(rt, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var runs [][]string
+	o := Options{CC: "zig", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", GOOS: "linux", CacheDir: cache,
+		Run: func(name string, args []string, stdout, stderr io.Writer) error {
+			runs = append(runs, args)
+			if slices.Contains(args, "-c") {
+				return os.WriteFile(args[len(args)-1], nil, 0o644)
+			}
+			return nil
+		},
+	}
+	compile := func(target string) {
+		t.Helper()
+		o.Target = target
+		if err := Compile(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compile("")
+	compile("")
+	compile("aarch64-linux-musl")
+	host := Options{CC: "zig", RuntimeDir: rt, CacheDir: cache}.RuntimeObject()
+	arm := Options{CC: "zig", RuntimeDir: rt, CacheDir: cache, Target: "aarch64-linux-musl"}.RuntimeObject()
+	if filepath.Dir(filepath.Dir(host)) != filepath.Join(cache, "host") {
+		t.Errorf("host runtime at %s, want it under %s", host, filepath.Join(cache, "host"))
+	}
+	want := [][]string{
+		{"cc", "-c", filepath.Join(rt, "desi_std.c"), "-I", rt, "-o", host},
+		{"cc", "a.c", host, "-I", rt, "-pthread", "-o", "app"},
+		{"cc", "a.c", host, "-I", rt, "-pthread", "-o", "app"}, // the host runtime is reused
+		{"cc", "-target", "aarch64-linux-musl", "-c", filepath.Join(rt, "desi_std.c"), "-I", rt, "-o", arm},
+		{"cc", "-target", "aarch64-linux-musl", "a.c", arm, "-I", rt, "-pthread", "-o", "app"},
+	}
+	if !reflect.DeepEqual(runs, want) {
+		t.Fatalf("ran\n%q\nwant\n%q", runs, want)
+	}
+
+	// an edited runtime, or another compiler, gets an object of its own
+	if err := os.WriteFile(filepath.Join(rt, "desi_std.h"), []byte("#define X 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runs = nil
+	compile("")
+	if len(runs) != 2 || !slices.Contains(runs[0], "-c") || slices.Contains(runs[0], host) {
+		t.Errorf("after editing desi_std.h ran %q, want the runtime rebuilt", runs)
+	}
+	o.CC = "clang"
+	runs = nil
+	compile("")
+	if len(runs) != 2 || !slices.Contains(runs[0], "-c") {
+		t.Errorf("with clang after zig ran %q, want the runtime rebuilt", runs)
+	}
+}
+
+func TestRuntimeErrorNamesTarget(t *testing.T) {
+	rt := t.TempDir()
+	fail := func(name string, args []string, stdout, stderr io.Writer) error { return errors.New("exit status 1") }
+	for target, want := range map[string]string{"": "runtime for host:", "aarch64-linux-musl": "runtime for aarch64-linux-musl:"} {
+		o := Options{CC: "zig", RuntimeDir: rt, Sources: []string{"a.c"}, Out: "app", CacheDir: t.TempDir(), Target: target, Run: fail}
+		if err := Compile(o); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("target %q: err = %v, want it to contain %q", target, err, want)
+		}
+	}
+}
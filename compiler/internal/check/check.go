@@ -2,9 +2,11 @@ package check
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/diag"
 )
 
 /* ---------- kinds ---------- */
@@ -17,9 +19,45 @@ const (
 	KindStr
 	KindBool
 	KindVoid
+	KindNone   // the `none` literal, before it meets an optional type
+	KindFloat  // f64, a C double
+	KindFile   // an open file (std.fs), a resource
+	KindBytes  // binary data (std.bytes); unlike str, never text
+	KindMutex  // a lock shared by tasks (std.sync)
+	KindAtomic // an integer shared by tasks (std.sync)
 )
 
+// kindOpt marks an optional kind: KindStr|kindOpt is `str?`.
+const kindOpt Kind = 1 << 4
+
+// Optional returns the optional form of k (`T?`).
+func (k Kind) Optional() Kind { return k | kindOpt }
+
+// IsOptional reports whether k is `T?` for some T.
+func (k Kind) IsOptional() bool { return k&kindOpt != 0 }
+
+// Elem returns T for `T?`, and k itself otherwise.
+func (k Kind) Elem() Kind { return k &^ kindOpt }
+
+// kindChan marks a channel kind: KindInt|kindChan is `chan[int]`.
+const kindChan Kind = 1 << 5
+
+// Chan returns the kind of a channel of k (`chan[T]`).
+func (k Kind) Chan() Kind { return k | kindChan }
+
+// IsChan reports whether k is `chan[T]` for some T.
+func (k Kind) IsChan() bool { return k&kindChan != 0 }
+
+// ChanElem returns T for `chan[T]`.
+func (k Kind) ChanElem() Kind { return k &^ kindChan }
+
 func (k Kind) String() string {
+	if k.IsOptional() {
+		return k.Elem().String() + "?"
+	}
+	if k.IsChan() {
+		return "chan[" + k.ChanElem().String() + "]"
+	}
 	switch k {
 	case KindInt:
 		return "int"
@@ -29,6 +67,18 @@ func (k Kind) String() string {
 		return "bool"
 	case KindVoid:
 		return "void"
+	case KindNone:
+		return "none"
+	case KindFloat:
+		return "f64"
+	case KindFile:
+		return "file"
+	case KindBytes:
+		return "bytes"
+	case KindMutex:
+		return "mutex"
+	case KindAtomic:
+		return "atomic"
 	default:
 		return "unknown"
 	}
@@ -37,13 +87,26 @@ func (k Kind) String() string {
 /* ---------- public info ---------- */
 
 type FuncSig struct {
-	Name   string
-	Params []Kind
-	Ret    Kind
+	Name    string
+	Params  []Kind
+	Mut     []bool // per param: by-reference `mut` parameter
+	Bits    []int  // per param: integer width in C (32 or 64)
+	Ret     Kind
+	RetBits int    // integer width of the result in C
+	Module  string // qualifier from ast.FuncDecl.Module; "" for the entry file
+	// Reexports are the qualifiers of facade modules that re-export it.
+	Reexports []string
+}
+
+// QualifiedBy reports whether module.f(...) names this function, either from
+// its own module or through a `pub import` re-export.
+func (s FuncSig) QualifiedBy(module string) bool {
+	return module != "" && (s.Module == module || slices.Contains(s.Reexports, module))
 }
 
 type Info struct {
-	Funcs map[string]FuncSig // function table for arity/type checks
+	Funcs   map[string]FuncSig // function table for arity/type checks
+	Modules map[string]bool    // qualifiers usable as module.f(...)
 }
 
 // Warning is a lightweight compiler warning.
@@ -59,39 +122,70 @@ func (w Warning) String() string {
 	return fmt.Sprintf("%s: %s", w.Code, w.Msg)
 }
 
+// Error is a checker error with a stable code, for mistakes common enough to
+// deserve a tailored message.
+type Error struct {
+	Code string // diag registry key, e.g. diag.DupParam
+	Msg  string
+}
+
+func (e Error) Error() string { return fmt.Sprintf("%s: %s", e.Code, e.Msg) }
+
 // CheckFile performs semantic checks and returns info, errors, and warnings.
 // NOTE: Stage-0 does not attach spans; that arrives in a later stage.
 func CheckFile(f *ast.File) (*Info, []error, []Warning) {
-	info := &Info{Funcs: map[string]FuncSig{}}
-	var errs []error
-	var warns []Warning
+	return CheckFileCached(f, nil)
+}
 
-	// collect function signatures
+// collectSigs builds the function table, reporting duplicates.
+func collectSigs(f *ast.File) (*Info, []error) {
+	info := &Info{Funcs: map[string]FuncSig{}, Modules: map[string]bool{}}
+	var errs []error
+	first := map[string]*ast.FuncDecl{}
 	for _, d := range f.Decls {
 		fn, ok := d.(*ast.FuncDecl)
 		if !ok {
 			continue
 		}
-		if _, exists := info.Funcs[fn.Name]; exists {
-			errs = append(errs, fmt.Errorf("duplicate function %q", fn.Name))
+		if prev, exists := first[fn.Name]; exists {
+			if prev.Package == fn.Package {
+				errs = append(errs, fmt.Errorf("duplicate function %q", fn.Name))
+			} else {
+				// Stage-0 shares one namespace across files (see docs/spec/syntax.md)
+				errs = append(errs, fmt.Errorf("duplicate function %q: declared in %s and in %s (function names must be unique program-wide)",
+					fn.Name, declaredIn(prev), declaredIn(fn)))
+			}
 			continue
 		}
+		first[fn.Name] = fn
 		var ps []Kind
+		var muts []bool
+		var bits []int
 		for _, p := range fn.Params {
 			ps = append(ps, mapTextType(p.Type))
+			muts = append(muts, p.Mut)
+			bits = append(bits, intBits(p.Type))
+		}
+		info.Funcs[fn.Name] = FuncSig{
+			Name: fn.Name, Params: ps, Mut: muts, Bits: bits,
+			Ret: mapTextType(fn.Ret), RetBits: intBits(fn.Ret), Module: fn.Module, Reexports: fn.Reexports,
+		}
+		if fn.Module != "" {
+			info.Modules[fn.Module] = true
+		}
+		for _, m := range fn.Reexports {
+			info.Modules[m] = true
 		}
-		info.Funcs[fn.Name] = FuncSig{Name: fn.Name, Params: ps, Ret: mapTextType(fn.Ret)}
 	}
+	return info, errs
+}
 
-	// check bodies
-	for _, d := range f.Decls {
-		if fn, ok := d.(*ast.FuncDecl); ok {
-			fnErrs, fnWarns := checkFunc(info, fn)
-			errs = append(errs, fnErrs...)
-			warns = append(warns, fnWarns...)
-		}
+// declaredIn names the module that declares fn, for messages.
+func declaredIn(fn *ast.FuncDecl) string {
+	if fn.Package == "" {
+		return "the entry file"
 	}
-	return info, errs, warns
+	return "module " + fn.Package
 }
 
 /* ---------- function + scopes ---------- */
@@ -99,16 +193,24 @@ func CheckFile(f *ast.File) (*Info, []error, []Warning) {
 type varInfo struct {
 	kind     Kind
 	mutable  bool
+	param    bool
 	declName string
 
 	// dataflow for Stage-0 warnings
 	read    bool
 	written bool
+
+	closedAt int // line of the with that closed this resource; 0 while open
+
+	// A file this function opened (owned) must be handed on (released):
+	// closed, scoped by with, returned or passed to a function.
+	owned, released bool
 }
 
 type scope struct {
 	parent *scope
 	vars   map[string]*varInfo // store pointers so we can mutate flags
+	narrow map[*varInfo]bool   // optionals known not to be none in this scope
 }
 
 func (s *scope) lookup(name string) (*varInfo, bool) {
@@ -119,6 +221,33 @@ func (s *scope) lookup(name string) (*varInfo, bool) {
 	}
 	return nil, false
 }
+
+// narrowed reports whether v is known not to be none here.
+func (s *scope) narrowed(v *varInfo) bool {
+	for cur := s; cur != nil; cur = cur.parent {
+		if cur.narrow[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// setNarrow records that v is not none for the rest of this scope.
+func (s *scope) setNarrow(v *varInfo) {
+	if s.narrow == nil {
+		s.narrow = map[*varInfo]bool{}
+	}
+	s.narrow[v] = true
+}
+
+// unnarrow forgets v's narrowing everywhere it is visible, after v may have
+// been assigned none.
+func (s *scope) unnarrow(v *varInfo) {
+	for cur := s; cur != nil; cur = cur.parent {
+		delete(cur.narrow, v)
+	}
+}
+
 func (s *scope) define(name string, v *varInfo) error {
 	if _, exists := s.vars[name]; exists {
 		return fmt.Errorf("redeclaration of %q", name)
@@ -129,6 +258,7 @@ func (s *scope) define(name string, v *varInfo) error {
 
 type checker struct {
 	info  *Info
+	fn    *ast.FuncDecl
 	fnSig FuncSig
 
 	scope *scope
@@ -141,6 +271,14 @@ type checker struct {
 
 	// Per-block "did we already return?" flags
 	blockReturned []bool
+
+	// Mutable variables read by a defer's arguments, with the defer's
+	// position (see noteDeferArgs)
+	deferred map[*varInfo]ast.Pos
+
+	// Names bound by with blocks that have ended, with the with's line, for
+	// a better message than "undeclared" when one is used afterwards
+	withNames map[string]int
 }
 
 func push[T any](s []T, v T) []T { return append(s, v) }
@@ -157,18 +295,58 @@ func top[T any](s []T) *T {
 	return &s[len(s)-1]
 }
 
+// paramIndex returns the index of the parameter called name, or -1.
+func paramIndex(params []ast.Param, name string) int {
+	for i, p := range params {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// freshParamName suggests a rename for a duplicate: name2, name3, ...
+func freshParamName(params []ast.Param, name string) string {
+	for n := 2; ; n++ {
+		cand := fmt.Sprintf("%s%d", name, n)
+		if paramIndex(params, cand) < 0 {
+			return cand
+		}
+	}
+}
+
+// freshLocalName suggests a local copy of name not visible in sc: name2, ...
+func freshLocalName(sc *scope, name string) string {
+	for n := 2; ; n++ {
+		cand := fmt.Sprintf("%s%d", name, n)
+		if _, ok := sc.lookup(cand); !ok {
+			return cand
+		}
+	}
+}
+
 func checkFunc(info *Info, fn *ast.FuncDecl) ([]error, []Warning) {
 	c := &checker{
 		info:   info,
+		fn:     fn,
 		fnSig:  info.Funcs[fn.Name],
 		scope:  &scope{vars: map[string]*varInfo{}},
 		locals: nil,
 	}
 	// params are immutable by default
 	for i, p := range fn.Params {
+		if j := paramIndex(fn.Params[:i], p.Name); j >= 0 {
+			c.errors = append(c.errors, Error{
+				Code: diag.DupParam,
+				Msg: fmt.Sprintf("duplicate parameter %q in %s: parameter %d repeats parameter %d (rename it, e.g. %q)",
+					p.Name, fn.Name, i+1, j+1, freshParamName(fn.Params, p.Name)),
+			})
+			continue
+		}
 		v := &varInfo{
 			kind:     mapTextType(p.Type),
-			mutable:  false,
+			mutable:  p.Mut,
+			param:    true,
 			declName: p.Name,
 			read:     false,
 			written:  true, // treat param as "written" (initialized by caller)
@@ -212,6 +390,17 @@ func checkFunc(info *Info, fn *ast.FuncDecl) ([]error, []Warning) {
 		}
 	}
 
+	// Files opened here and never handed on leak
+	for _, v := range c.locals {
+		if v.owned && !v.released {
+			c.warnings = append(c.warnings, Warning{
+				Code: "W0008",
+				Msg: fmt.Sprintf("file %q is never closed: call fs.close(%s), defer fs.close(%s) or scope it with with",
+					v.declName, v.declName, v.declName),
+			})
+		}
+	}
+
 	return c.errors, c.warnings
 }
 
@@ -230,7 +419,15 @@ func (c *checker) checkStmt(s ast.Stmt) {
 	switch st := s.(type) {
 	case *ast.LetStmt:
 		k := c.kindOfExpr(st.Expr)
+		if k == KindNone {
+			c.errors = append(c.errors, fmt.Errorf("let %s = none: cannot infer an optional type (use none where a T? is declared)", st.Name))
+			k = KindUnknown
+		}
+		c.checkLitRange(st.Expr, LitBits(st.Expr), "let "+st.Name) // i32 unless a suffix says i64
 		v := &varInfo{kind: k, mutable: st.Mutable, declName: st.Name, written: true}
+		if k.Elem() == KindFile {
+			v.owned = c.takeFile(st.Expr)
+		}
 		if err := c.scope.define(st.Name, v); err != nil {
 			c.errors = append(c.errors, err)
 		} else {
@@ -242,16 +439,40 @@ func (c *checker) checkStmt(s ast.Stmt) {
 			c.errors = append(c.errors, fmt.Errorf("assign to undeclared variable %q", st.Name))
 			return
 		}
-		if !v.mutable {
+		switch {
+		case v.param && !v.mutable:
+			c.errors = append(c.errors, Error{
+				Code: diag.AssignParam,
+				Msg: fmt.Sprintf("cannot assign to parameter %q: parameters are immutable; copy it into a local first (let mut %s = %s)",
+					st.Name, freshLocalName(c.scope, st.Name), st.Name),
+			})
+		case !v.mutable:
 			c.errors = append(c.errors, fmt.Errorf("cannot assign to immutable variable %q", st.Name))
 		}
 		rk := c.kindOfExpr(st.Expr)
-		if k, ok := unifyKinds(v.kind, rk); !ok {
-			c.errors = append(c.errors, fmt.Errorf("type mismatch: %q is %s but assigned %s", st.Name, v.kind, rk))
+		if k, ok := assignable(v.kind, rk); !ok {
+			c.errors = append(c.errors, fmt.Errorf("type mismatch: %q is %s but assigned %s%s", st.Name, v.kind, rk, noneHint(v.kind, rk)))
 		} else if v.kind == KindUnknown {
 			v.kind = k
 		}
+		if rk == KindNone || rk.IsOptional() {
+			c.scope.unnarrow(v)
+		}
+		if v.kind.Elem() == KindFile && rk.Elem() == KindFile {
+			if v.owned && !v.released {
+				c.warnings = append(c.warnings, Warning{
+					Code: "W0008",
+					Msg:  fmt.Sprintf("file %q is reassigned before it is closed; close it first", st.Name),
+				})
+			}
+			v.owned, v.released = c.takeFile(st.Expr), false
+		}
 		v.written = true
+		v.closedAt = 0 // a new value, e.g. a file opened again
+		if v.param {
+			v.read = true // the caller observes writes to a mut param
+		}
+		c.noteWrite(v)
 	case *ast.ReturnStmt:
 		exp := c.fnSig.Ret
 		if st.Expr == nil {
@@ -265,6 +486,7 @@ func (c *checker) checkStmt(s ast.Stmt) {
 			return
 		}
 		got := c.kindOfExpr(st.Expr)
+		c.release(st.Expr) // the caller owns a returned file
 		if exp == KindVoid {
 			c.errors = append(c.errors, fmt.Errorf("return value in function returning void"))
 			if br := top(c.blockReturned); br != nil {
@@ -272,20 +494,37 @@ func (c *checker) checkStmt(s ast.Stmt) {
 			}
 			return
 		}
-		if _, ok := unifyKinds(exp, got); !ok {
-			c.errors = append(c.errors, fmt.Errorf("return kind mismatch: have %s, got %s", exp, got))
+		if _, ok := assignable(exp, got); !ok {
+			c.errors = append(c.errors, fmt.Errorf("return kind mismatch: have %s, got %s%s", exp, got, noneHint(exp, got)))
+		} else {
+			c.checkLitRange(st.Expr, c.fnSig.RetBits, "return")
 		}
 		if br := top(c.blockReturned); br != nil {
 			*br = true
 		}
 	case *ast.ExprStmt:
-		c.kindOfExpr(st.Expr)
+		if k := c.kindOfExpr(st.Expr); k.Elem() == KindFile {
+			c.warnings = append(c.warnings, Warning{
+				Code: "W0008",
+				Msg:  "the file this call opens is discarded and never closed; bind it with let or with",
+			})
+		}
 	case *ast.IfStmt:
 		k := c.kindOfExpr(st.Cond)
 		if k != KindBool && k != KindInt && k != KindUnknown {
 			c.errors = append(c.errors, fmt.Errorf("if-condition must be bool/int, got %s", k))
 		}
+		// `if x != none:` narrows x to its element kind in the then-branch,
+		// `if x == none:` in the else-branch (see NoneTest).
+		var tested *varInfo
+		name, isNone, ok := NoneTest(st.Cond)
+		if v, found := c.scope.lookup(name); ok && found && v.kind.IsOptional() {
+			tested = v
+		}
 		c.withBlock(func() {
+			if tested != nil && !isNone {
+				c.scope.setNarrow(tested)
+			}
 			for _, s2 := range st.Then {
 				c.checkStmt(s2)
 			}
@@ -303,11 +542,17 @@ func (c *checker) checkStmt(s ast.Stmt) {
 		}
 		if st.Else != nil {
 			c.withBlock(func() {
+				if tested != nil && isNone && len(st.Elifs) == 0 {
+					c.scope.setNarrow(tested)
+				}
 				for _, s2 := range st.Else {
 					c.checkStmt(s2)
 				}
 			})
 		}
+		if tested != nil && NarrowsAfter(st) {
+			c.scope.setNarrow(tested)
+		}
 	case *ast.WhileStmt:
 		k := c.kindOfExpr(st.Cond)
 		if k != KindBool && k != KindInt && k != KindUnknown {
@@ -318,6 +563,10 @@ func (c *checker) checkStmt(s ast.Stmt) {
 				c.checkStmt(s2)
 			}
 		})
+	case *ast.WithStmt:
+		c.checkWith(st)
+	case *ast.SpawnStmt:
+		c.checkSpawn(st)
 	case *ast.DeferStmt:
 		// Stage-0: only at function top-level
 		if len(c.blockReturned) > 1 {
@@ -327,9 +576,339 @@ func (c *checker) checkStmt(s ast.Stmt) {
 			c.errors = append(c.errors, fmt.Errorf("defer expects a call expression"))
 		}
 		c.kindOfExpr(st.Call)
+		if call, ok := st.Call.(*ast.CallExpr); ok {
+			c.noteDeferArgs(call, st.Pos)
+		}
+	}
+}
+
+// checkWith checks `with e as name:`. e must be a resource, or an optional
+// one: the body then runs only if it is not none. name is the resource in the
+// body and nowhere else; if e is a variable, the variable is closed after the
+// block and may not be read again until it is reassigned.
+func (c *checker) checkWith(st *ast.WithStmt) {
+	k := c.kindOfExpr(st.Expr)
+	c.release(st.Expr)
+	if k.Elem() != KindFile && k != KindUnknown {
+		c.errors = append(c.errors, fmt.Errorf("with needs a resource (file or file?), got %s", k))
+		k = KindUnknown
+	}
+	c.withBlock(func() {
+		v := &varInfo{kind: k.Elem(), declName: st.Name, written: true}
+		if err := c.scope.define(st.Name, v); err != nil {
+			c.errors = append(c.errors, err)
+		}
+		c.locals = append(c.locals, v)
+		for _, s2 := range st.Body {
+			c.checkStmt(s2)
+		}
+	})
+	if c.withNames == nil {
+		c.withNames = map[string]int{}
+	}
+	c.withNames[st.Name] = st.Pos.Line
+	if id, ok := ast.Unparen(st.Expr).(*ast.IdentExpr); ok {
+		if v, ok := c.scope.lookup(id.Name); ok {
+			v.closedAt = st.Pos.Line
+		}
+	}
+}
+
+// checkSpawn checks `spawn f(args)`. f must be a function of the program
+// returning void, since nothing receives a task's result; results travel
+// over a chan instead. A task runs alongside its spawner, so it cannot share
+// a variable with it through a mut parameter.
+func (c *checker) checkSpawn(st *ast.SpawnStmt) {
+	call, ok := st.Call.(*ast.CallExpr)
+	if !ok {
+		c.errors = append(c.errors, fmt.Errorf("spawn expects a call expression"))
+		c.kindOfExpr(st.Call)
+		return
+	}
+	c.kindOfExpr(call)
+	sig, ok := c.userSig(call)
+	if !ok {
+		c.errors = append(c.errors, fmt.Errorf("spawn needs a call of a function of this program; std functions cannot be spawned (wrap the call in one)"))
+		return
+	}
+	if sig.Ret != KindVoid {
+		c.errors = append(c.errors, fmt.Errorf("spawn %s: it returns %s, which no one would receive; make it return void and send the result over a chan", sig.Name, sig.Ret))
+	}
+	for i, m := range sig.Mut {
+		if m {
+			c.errors = append(c.errors, fmt.Errorf("spawn %s: arg %d is passed to a mut parameter, but a task cannot share a variable with its spawner (send values over a chan)", sig.Name, i+1))
+		}
 	}
 }
 
+// checkHook checks the argument of a builtin that registers a function for
+// the runtime to call later: a function of the program, named rather than
+// called, taking nothing and returning void.
+func (c *checker) checkHook(name string, args []ast.Expr) {
+	if len(args) != 1 {
+		c.errors = append(c.errors, fmt.Errorf("%s: want 1 arg (a function), got %d", name, len(args)))
+		return
+	}
+	sig, ok := c.userSig(&ast.CallExpr{Callee: args[0]})
+	if id, isIdent := args[0].(*ast.IdentExpr); isIdent {
+		if _, isVar := c.scope.lookup(id.Name); isVar {
+			ok = false
+		}
+	}
+	if !ok {
+		if _, isIdent := args[0].(*ast.IdentExpr); !isIdent {
+			c.kindOfExpr(args[0])
+		}
+		c.errors = append(c.errors, fmt.Errorf("%s: the argument must name a function of this program, e.g. %s(cleanup)", name, name))
+		return
+	}
+	if len(sig.Params) != 0 || sig.Ret != KindVoid {
+		c.errors = append(c.errors, fmt.Errorf("%s: %s must take no arguments and return void", name, sig.Name))
+	}
+}
+
+// checkChanNew checks `chan[T].bounded(cap)`, the one way to make a channel.
+func (c *checker) checkChanNew(ix *ast.IndexExpr, method string, args []ast.Expr) Kind {
+	for _, a := range args {
+		c.kindOfExpr(a)
+	}
+	elem, text := KindUnknown, "?"
+	if id, ok := ix.Index.(*ast.IdentExpr); ok {
+		elem, text = mapTextType(id.Name), id.Name
+	}
+	switch elem {
+	case KindInt, KindStr, KindBool:
+	default:
+		c.errors = append(c.errors, fmt.Errorf("chan[%s]: a chan carries int, str or bool values", text))
+		return KindUnknown
+	}
+	if method != "bounded" {
+		c.errors = append(c.errors, fmt.Errorf("chan[%s] has no constructor %s (want chan[%s].bounded(cap))", elem, method, elem))
+		return KindUnknown
+	}
+	if len(args) != 1 {
+		c.errors = append(c.errors, fmt.Errorf("chan[%s].bounded: want 1 arg (cap: int), got %d", elem, len(args)))
+	} else if ak := c.kindOfExpr(args[0]); ak != KindInt && ak != KindUnknown {
+		c.errors = append(c.errors, fmt.Errorf("chan[%s].bounded: cap must be int, got %s", elem, ak))
+	}
+	return elem.Chan()
+}
+
+// chanFuncs are the std.chan functions; unlike stdBuiltins their
+// signatures depend on the element kind of the channel passed.
+var chanFuncs = map[string]bool{"send": true, "recv": true, "close": true}
+
+// checkChanCall checks chan.send(ch, v) -> bool, chan.recv(ch) -> T? and
+// chan.close(ch).
+func (c *checker) checkChanCall(fn string, args []ast.Expr) Kind {
+	name := "chan." + fn
+	want := 1
+	if fn == "send" {
+		want = 2
+	}
+	var kinds []Kind
+	for _, a := range args {
+		kinds = append(kinds, c.kindOfExpr(a))
+	}
+	if len(args) != want {
+		if want == 1 {
+			c.errors = append(c.errors, fmt.Errorf("%s: want 1 arg (ch: chan[T]), got %d", name, len(args)))
+		} else {
+			c.errors = append(c.errors, fmt.Errorf("%s: want 2 args (ch: chan[T], v: T), got %d", name, len(args)))
+		}
+		return KindUnknown
+	}
+	ch := kinds[0]
+	if !ch.IsChan() {
+		if ch != KindUnknown {
+			c.errors = append(c.errors, fmt.Errorf("%s: ch must be a chan, got %s", name, ch))
+		}
+		return KindUnknown
+	}
+	switch fn {
+	case "send":
+		if _, ok := unifyKinds(ch.ChanElem(), kinds[1]); !ok {
+			c.errors = append(c.errors, fmt.Errorf("%s: v must be %s for a %s, got %s", name, ch.ChanElem(), ch, kinds[1]))
+		}
+		return KindBool
+	case "recv":
+		return ch.ChanElem().Optional()
+	}
+	return KindVoid
+}
+
+// takeFile reports whether the variable a file-valued e initializes owns
+// the file: a new one (from a call) is owned, and an owned variable's file
+// moves to its copy. A copy of a borrowed file (a parameter's) is borrowed.
+func (c *checker) takeFile(e ast.Expr) bool {
+	id, ok := ast.Unparen(e).(*ast.IdentExpr)
+	if !ok {
+		return true
+	}
+	v, ok := c.scope.lookup(id.Name)
+	if !ok || !v.owned {
+		return false
+	}
+	v.released = true
+	return true
+}
+
+// release records that the file in variable e, if e is one, has been handed
+// on: closed, scoped, returned or passed to a function.
+func (c *checker) release(e ast.Expr) {
+	if id, ok := ast.Unparen(e).(*ast.IdentExpr); ok {
+		if v, ok := c.scope.lookup(id.Name); ok {
+			v.released = true
+		}
+	}
+}
+
+// noteDeferArgs records the mutable variables the arguments of a deferred
+// call read. Arguments are evaluated at the defer statement, so a later
+// write to one of them does not reach the call (noteWrite says so).
+// Arguments for mut parameters are passed by reference and see the value at
+// exit, so they are skipped.
+func (c *checker) noteDeferArgs(call *ast.CallExpr, pos ast.Pos) {
+	sig, user := c.userSig(call)
+	for i, a := range call.Args {
+		if user && i < len(sig.Mut) && sig.Mut[i] {
+			continue
+		}
+		identsIn(a, func(name string) {
+			v, ok := c.scope.lookup(name)
+			if !ok || !v.mutable {
+				return
+			}
+			if c.deferred == nil {
+				c.deferred = map[*varInfo]ast.Pos{}
+			}
+			if _, seen := c.deferred[v]; !seen {
+				c.deferred[v] = pos
+			}
+		})
+	}
+}
+
+// noteWrite warns, once, when v is written after a defer evaluated it.
+func (c *checker) noteWrite(v *varInfo) {
+	pos, ok := c.deferred[v]
+	if !ok {
+		return
+	}
+	delete(c.deferred, v)
+	c.warnings = append(c.warnings, Warning{
+		Code: "W0007",
+		Msg: fmt.Sprintf("%q changes after the defer at line %d reads it; the deferred call gets the value %s had at the defer",
+			v.declName, pos.Line, v.declName),
+	})
+}
+
+// userSig returns the signature of the user function call calls, if it
+// calls one.
+func (c *checker) userSig(call *ast.CallExpr) (FuncSig, bool) {
+	switch f := call.Callee.(type) {
+	case *ast.IdentExpr:
+		sig, ok := c.info.Funcs[f.Name]
+		return sig, ok
+	case *ast.FieldExpr:
+		if id, ok := f.X.(*ast.IdentExpr); ok && c.info.Modules[id.Name] {
+			if sig, ok := c.info.Funcs[f.Name]; ok && sig.QualifiedBy(id.Name) {
+				return sig, true
+			}
+		}
+	}
+	return FuncSig{}, false
+}
+
+// identsIn calls f with the name of every identifier e reads. Module
+// qualifiers (io in io.println) are not reads and are skipped.
+func identsIn(e ast.Expr, f func(name string)) {
+	switch v := e.(type) {
+	case *ast.IdentExpr:
+		f(v.Name)
+	case *ast.ParenExpr:
+		identsIn(v.X, f)
+	case *ast.UnaryExpr:
+		identsIn(v.X, f)
+	case *ast.BinaryExpr:
+		identsIn(v.Left, f)
+		identsIn(v.Right, f)
+	case *ast.RangeExpr:
+		identsIn(v.Lo, f)
+		identsIn(v.Hi, f)
+	case *ast.InterpExpr:
+		for _, h := range v.Holes {
+			identsIn(h, f)
+		}
+	case *ast.IndexExpr:
+		identsIn(v.Seq, f)
+		identsIn(v.Index, f)
+	case *ast.FieldExpr:
+		if _, ok := v.X.(*ast.IdentExpr); !ok {
+			identsIn(v.X, f)
+		}
+	case *ast.CallExpr:
+		identsIn(v.Callee, f)
+		for _, a := range v.Args {
+			identsIn(a, f)
+		}
+	}
+}
+
+// checkOptionalOperands types a binary expression with an optional or none
+// operand: the only operation on them is comparing an optional with none.
+func (c *checker) checkOptionalOperands(op string, lk, rk Kind) Kind {
+	if op == "==" || op == "!=" {
+		switch {
+		case lk == KindNone && rk == KindNone:
+			return KindBool
+		case lk == KindNone && (rk.IsOptional() || rk == KindUnknown),
+			rk == KindNone && (lk.IsOptional() || lk == KindUnknown):
+			return KindBool
+		case lk == KindNone || rk == KindNone:
+			other := lk
+			if other == KindNone {
+				other = rk
+			}
+			c.errors = append(c.errors, fmt.Errorf("comparing %s with none: only optional values can be none", other))
+			return KindUnknown
+		}
+	}
+	c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: optionals can only be compared with none (check against none first)", op, lk, rk))
+	return KindUnknown
+}
+
+// checkOrdering types an ordering comparison. Integers and strings (byte
+// order, see desi_str_cmp) can be ordered; bools cannot, since false < true
+// is almost always a mistake for a missing `not`.
+func (c *checker) checkOrdering(op string, lk, rk Kind) Kind {
+	if lk == KindBool || rk == KindBool {
+		c.errors = append(c.errors, fmt.Errorf("operator %s on bool: bools have no order (compare with == instead)", op))
+		return KindBool
+	}
+	if k, ok := unifyKinds(lk, rk); !ok || k == KindVoid {
+		c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: operands must both be integers or both str", op, lk, rk))
+	}
+	return KindBool
+}
+
+// checkFloatOperands types a binary operator with an f64 operand. Floats
+// mix with nothing else: Stage-0 has no implicit conversions.
+func (c *checker) checkFloatOperands(op string, lk, rk Kind) Kind {
+	if k, ok := unifyKinds(lk, rk); !ok || k != KindFloat {
+		c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: operands must have the same type", op, lk, rk))
+		return KindUnknown
+	}
+	switch op {
+	case "+", "-", "*", "/":
+		return KindFloat
+	case "==", "!=", "<", "<=", ">", ">=":
+		return KindBool
+	}
+	c.errors = append(c.errors, fmt.Errorf("operator %s is not defined on f64", op))
+	return KindUnknown
+}
+
 func (c *checker) withChildScope(body func()) {
 	prev := c.scope
 	c.scope = &scope{parent: prev, vars: map[string]*varInfo{}}
@@ -347,47 +926,126 @@ func (c *checker) withBlock(body func()) {
 func (c *checker) kindOfExpr(e ast.Expr) Kind {
 	switch v := e.(type) {
 	case *ast.IntLit:
+		c.checkLit64(v)
 		return KindInt
+	case *ast.FloatLit:
+		return KindFloat
 	case *ast.StrLit:
 		return KindStr
+	case *ast.InterpExpr:
+		for i, h := range v.Holes {
+			switch k := c.kindOfExpr(h); k {
+			case KindInt, KindStr, KindBool, KindFloat, KindUnknown:
+			default:
+				c.errors = append(c.errors, fmt.Errorf("f-string hole %d is %s (want str, an integer, bool or f64)", i+1, k))
+			}
+		}
+		return KindStr
 	case *ast.BoolLit:
 		return KindBool
+	case *ast.CharLit:
+		return KindInt // a code point
+	case *ast.NoneLit:
+		return KindNone
 	case *ast.IdentExpr:
 		if vi, ok := c.scope.lookup(v.Name); ok {
 			vi.read = true
+			if vi.closedAt != 0 {
+				c.errors = append(c.errors, fmt.Errorf("use of %q after the with at line %d closed it", v.Name, vi.closedAt))
+			}
+			if vi.kind.IsOptional() && c.scope.narrowed(vi) {
+				return vi.kind.Elem()
+			}
 			return vi.kind
 		}
 		if _, isFn := c.info.Funcs[v.Name]; isFn {
 			return KindUnknown
 		}
+		if line, ok := c.withNames[v.Name]; ok {
+			c.errors = append(c.errors, fmt.Errorf("use of %q after its with block (line %d) ended: the resource is closed and the name is only bound inside the block", v.Name, line))
+			return KindUnknown
+		}
 		c.errors = append(c.errors, fmt.Errorf("use of undeclared identifier %q", v.Name))
 		return KindUnknown
 	case *ast.UnaryExpr:
+		if _, _, ok := IntLiteral(v); ok {
+			c.checkLit64(v) // -9223372036854775808 is fine, its magnitude isn't
+			return KindInt
+		}
 		k := c.kindOfExpr(v.X)
-		if v.Op == "-" || v.Op == "!" || v.Op == "not" {
-			if k == KindInt || k == KindBool || k == KindUnknown {
-				return KindInt
+		switch {
+		case k == KindUnknown:
+			if v.Op == "-" {
+				return KindUnknown
 			}
+			return KindBool
+		case v.Op == "-" && (k == KindInt || k == KindFloat):
+			return k
+		case (v.Op == "!" || v.Op == "not") && (k == KindBool || k == KindInt):
+			return KindBool // an int operand is tested against 0, like a condition
+		case v.Op == "-":
+			c.errors = append(c.errors, fmt.Errorf("cannot negate %s (unary - needs an integer or f64)", k))
+		default:
+			c.errors = append(c.errors, fmt.Errorf("operator %s on %s (needs bool)", v.Op, k))
+		}
+		return KindUnknown
+	case *ast.ParenExpr:
+		return c.kindOfExpr(v.X)
+	case *ast.RangeExpr:
+		lk, hk := c.kindOfExpr(v.Lo), c.kindOfExpr(v.Hi)
+		if (lk != KindInt && lk != KindUnknown) || (hk != KindInt && hk != KindUnknown) {
+			c.errors = append(c.errors, fmt.Errorf("range bounds must be integers, got %s and %s", lk, hk))
+			return KindUnknown
 		}
+		c.errors = append(c.errors, fmt.Errorf("a range is not a value: ranges are for `for` loops, which Stage-0 does not have yet"))
 		return KindUnknown
 	case *ast.BinaryExpr:
 		lk := c.kindOfExpr(v.Left)
 		rk := c.kindOfExpr(v.Right)
+		if lk == KindNone || rk == KindNone || lk.IsOptional() || rk.IsOptional() {
+			return c.checkOptionalOperands(v.Op, lk, rk)
+		}
+		if lk == KindFloat || rk == KindFloat {
+			return c.checkFloatOperands(v.Op, lk, rk)
+		}
+		if lk == KindBytes || rk == KindBytes {
+			c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: bytes has no operators (use bytes.len, bytes.at and bytes.slice)", v.Op, lk, rk))
+			return KindUnknown
+		}
+		if lk == KindMutex || rk == KindMutex || lk == KindAtomic || rk == KindAtomic {
+			c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: mutex and atomic have no operators (use the std.sync functions, e.g. sync.load)", v.Op, lk, rk))
+			return KindUnknown
+		}
+		if lk.IsChan() || rk.IsChan() {
+			c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: a chan has no operators (use chan.send, chan.recv and chan.close)", v.Op, lk, rk))
+			return KindUnknown
+		}
+		if (lk == KindStr || rk == KindStr) && slices.Contains([]string{"+", "-", "*", "**", "/", "%"}, v.Op) {
+			// C has no string arithmetic to lower to; this also covers s += t
+			c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: str has no arithmetic (build strings with an f-string, e.g. f\"{a}{b}\")", v.Op, lk, rk))
+			return KindUnknown
+		}
 		switch v.Op {
 		case "+":
-			if lk == KindStr || rk == KindStr {
-				return KindStr
-			}
 			if lk == KindInt && rk == KindInt {
 				return KindInt
 			}
 			return KindUnknown
-		case "-", "*", "/", "%", "<", "<=", ">", ">=", "==", "!=":
+		case "-", "*", "**", "/", "%":
 			if _, ok := unifyKinds(lk, rk); ok {
 				return KindInt
 			}
 			return KindUnknown
-		case "and", "or", "|>":
+		case "==", "!=":
+			if _, ok := unifyKinds(lk, rk); !ok {
+				c.errors = append(c.errors, fmt.Errorf("operator %s on %s and %s: operands must have the same type", v.Op, lk, rk))
+			}
+			return KindBool
+		case "<", "<=", ">", ">=":
+			return c.checkOrdering(v.Op, lk, rk)
+		case "and", "or":
+			return KindBool
+		case "|>":
 			return KindInt
 		default:
 			return KindUnknown
@@ -397,13 +1055,21 @@ func (c *checker) kindOfExpr(e ast.Expr) Kind {
 	case *ast.IndexExpr:
 		return KindUnknown
 	case *ast.CallExpr:
+		// chan[T].bounded(cap) -> chan[T]
+		if fe, ok := v.Callee.(*ast.FieldExpr); ok {
+			if ix, ok := fe.X.(*ast.IndexExpr); ok {
+				if id, ok := ix.Seq.(*ast.IdentExpr); ok && id.Name == "chan" {
+					return c.checkChanNew(ix, fe.Name, v.Args)
+				}
+			}
+		}
 		// std.io.println
 		if fe, ok := v.Callee.(*ast.FieldExpr); ok {
 			if id, ok := fe.X.(*ast.IdentExpr); ok && id.Name == "io" && fe.Name == "println" {
 				for i, a := range v.Args {
 					ak := c.kindOfExpr(a)
 					switch ak {
-					case KindInt, KindStr, KindBool:
+					case KindInt, KindStr, KindBool, KindFloat:
 					case KindVoid:
 						c.errors = append(c.errors, fmt.Errorf("io.println arg %d is void (no value)", i+1))
 					default:
@@ -412,7 +1078,15 @@ func (c *checker) kindOfExpr(e ast.Expr) Kind {
 				}
 				return KindVoid
 			}
-			// std.fs.read_all(path: str) -> str
+			// std.io.printf(fmt, args...) -> void / std.fmt.sprintf(fmt, args...) -> str
+			if id, ok := fe.X.(*ast.IdentExpr); ok && ((id.Name == "io" && fe.Name == "printf") || (id.Name == "fmt" && fe.Name == "sprintf")) {
+				c.checkFormatCall(id.Name+"."+fe.Name, v.Args)
+				if fe.Name == "sprintf" {
+					return KindStr
+				}
+				return KindVoid
+			}
+			// std.fs.read_all(path: str) -> str? (none if the file can't be read)
 			if id, ok := fe.X.(*ast.IdentExpr); ok && id.Name == "fs" && fe.Name == "read_all" {
 				if len(v.Args) != 1 {
 					c.errors = append(c.errors, fmt.Errorf("fs.read_all: want 1 arg (path: str), got %d", len(v.Args)))
@@ -421,7 +1095,27 @@ func (c *checker) kindOfExpr(e ast.Expr) Kind {
 						c.errors = append(c.errors, fmt.Errorf("fs.read_all: path must be str, got %s", ak))
 					}
 				}
-				return KindStr
+				return KindStr.Optional()
+			}
+			// chan.send / chan.recv / chan.close, for any element kind
+			if id, ok := fe.X.(*ast.IdentExpr); ok && id.Name == "chan" {
+				if _, isVar := c.scope.lookup(id.Name); !isVar && chanFuncs[fe.Name] {
+					return c.checkChanCall(fe.Name, v.Args)
+				}
+			}
+			// fixed-signature std builtins (time, rand, proc, json)
+			if id, ok := fe.X.(*ast.IdentExpr); ok {
+				if b, ok := stdBuiltins[id.Name+"."+fe.Name]; ok {
+					if id.Name+"."+fe.Name == "fs.close" && len(v.Args) == 1 {
+						c.release(v.Args[0])
+					}
+					return c.checkBuiltinCall(id.Name+"."+fe.Name, b, v.Args)
+				}
+			}
+			// std.os.on_interrupt(handler), std.os.at_exit(hook) -> void
+			if id, ok := fe.X.(*ast.IdentExpr); ok && id.Name == "os" && (fe.Name == "on_interrupt" || fe.Name == "at_exit") {
+				c.checkHook("os."+fe.Name, v.Args)
+				return KindVoid
 			}
 			// std.os.exit(code: int) -> void
 			if id, ok := fe.X.(*ast.IdentExpr); ok && id.Name == "os" && fe.Name == "exit" {
@@ -435,21 +1129,41 @@ func (c *checker) kindOfExpr(e ast.Expr) Kind {
 				return KindVoid
 			}
 		}
+		// qualified user function call: module.f(...). Builtins were handled
+		// above; anything else with a module-like qualifier cannot resolve.
+		if fe, ok := v.Callee.(*ast.FieldExpr); ok {
+			if id, ok := fe.X.(*ast.IdentExpr); ok {
+				if _, isVar := c.scope.lookup(id.Name); !isVar {
+					qual := id.Name + "." + fe.Name
+					var why string
+					switch {
+					case c.info.Modules[id.Name]:
+						sig, ok := c.info.Funcs[fe.Name]
+						switch {
+						case !ok || !sig.QualifiedBy(id.Name):
+							why = fmt.Sprintf("module %s has no function %s", id.Name, fe.Name)
+						case !c.imports(id.Name):
+							why = fmt.Sprintf("module %s is loaded, but this file does not import it (missing import?)", id.Name)
+						default:
+							return c.checkCall(qual, sig, v.Args)
+						}
+					case IsBuiltinModule(id.Name):
+						why = fmt.Sprintf("std.%s has no function %s", id.Name, fe.Name)
+					default:
+						why = fmt.Sprintf("no module %s is loaded (missing import?)", id.Name)
+					}
+					for _, a := range v.Args {
+						c.kindOfExpr(a)
+					}
+					c.errors = append(c.errors, fmt.Errorf("call to unknown function %q: %s", qual, why))
+					return KindUnknown
+				}
+			}
+		}
 		// user function call
 		if id, ok := v.Callee.(*ast.IdentExpr); ok {
 			if sig, ok := c.info.Funcs[id.Name]; ok {
-				if len(sig.Params) != len(v.Args) {
-					c.errors = append(c.errors, fmt.Errorf("call to %s: want %d args, got %d", id.Name, len(sig.Params), len(v.Args)))
-				}
-				n := min(len(sig.Params), len(v.Args))
-				for i := 0; i < n; i++ {
-					ak := c.kindOfExpr(v.Args[i])
-					pk := sig.Params[i]
-					if _, ok := unifyKinds(pk, ak); !ok {
-						c.errors = append(c.errors, fmt.Errorf("call to %s: arg %d kind mismatch (want %s, got %s)", id.Name, i+1, pk, ak))
-					}
-				}
-				return sig.Ret
+				return c.checkCall(id.Name, sig, v.Args)
 			}
 			c.errors = append(c.errors, fmt.Errorf("call to unknown function %q", id.Name))
 			return KindUnknown
@@ -460,18 +1174,287 @@ func (c *checker) kindOfExpr(e ast.Expr) Kind {
 	}
 }
 
+// imports reports whether the function being checked may call module.f(...):
+// its file imports module, or module is its own. Imports are per file even
+// though the loader resolves them program-wide, so a file that stops
+// importing a module fails to check instead of working by accident.
+func (c *checker) imports(module string) bool {
+	return module == c.fn.Module || slices.Contains(c.fn.Imports, module)
+}
+
+// checkCall checks arity and argument kinds of a call to a user function.
+func (c *checker) checkCall(name string, sig FuncSig, args []ast.Expr) Kind {
+	if len(sig.Params) != len(args) {
+		c.errors = append(c.errors, fmt.Errorf("call to %s: want %d args, got %d", name, len(sig.Params), len(args)))
+	}
+	n := min(len(sig.Params), len(args))
+	passed := map[string]int{} // variable → first mut param it was passed to
+	for i := 0; i < n; i++ {
+		ak := c.kindOfExpr(args[i])
+		pk := sig.Params[i]
+		if _, ok := assignable(pk, ak); !ok {
+			c.errors = append(c.errors, fmt.Errorf("call to %s: arg %d kind mismatch (want %s, got %s)%s", name, i+1, pk, ak, noneHint(pk, ak)))
+		} else if i < len(sig.Bits) {
+			c.checkLitRange(args[i], sig.Bits[i], fmt.Sprintf("call to %s: arg %d", name, i+1))
+		}
+		if i < len(sig.Mut) && sig.Mut[i] {
+			c.checkMutArg(name, i, args[i], passed)
+		}
+		c.release(args[i]) // the callee may keep or close a file
+	}
+	return sig.Ret
+}
+
+// checkMutArg checks the argument for a `mut` parameter: it must be a mutable
+// variable, and no variable may be passed to two mut parameters of one call,
+// since writes through one would silently change the other.
+func (c *checker) checkMutArg(name string, i int, arg ast.Expr, passed map[string]int) {
+	id, ok := ast.Unparen(arg).(*ast.IdentExpr)
+	if !ok {
+		c.errors = append(c.errors, fmt.Errorf("call to %s: arg %d is passed to a mut parameter and must be a variable", name, i+1))
+		return
+	}
+	v, ok := c.scope.lookup(id.Name)
+	if !ok {
+		return // already reported as undeclared
+	}
+	if !v.mutable {
+		c.errors = append(c.errors, fmt.Errorf("call to %s: arg %d %q is passed to a mut parameter but is immutable (declare it with let mut)", name, i+1, id.Name))
+	}
+	if j, dup := passed[id.Name]; dup {
+		c.errors = append(c.errors, fmt.Errorf("call to %s: %q is passed to mut parameters %d and %d (aliasing)", name, id.Name, j+1, i+1))
+		return
+	}
+	passed[id.Name] = i
+	v.written = true
+	c.noteWrite(v)
+}
+
+/* ---------- std builtins ---------- */
+
+type builtinParam struct {
+	name string
+	kind Kind
+}
+
+type builtin struct {
+	params []builtinParam
+	ret    Kind
+}
+
+// stdBuiltins are the runtime-backed std functions with a fixed signature;
+// calls that need special checking (println, printf, read_all, exit) are
+// handled inline in kindOfExpr. Keep in sync with the codegen table.
+//
+// Stage-0 has no tuples, so proc.run's (status, output) pair is split:
+// run returns the exit status and output() the captured stdout.
+var stdBuiltins = map[string]builtin{
+	"io.flush":        {ret: KindVoid},
+	"time.now_ms":     {ret: KindInt},
+	"time.mono_ns":    {ret: KindInt},
+	"time.sleep_ms":   {params: []builtinParam{{"ms", KindInt}}, ret: KindVoid},
+	"rand.int":        {params: []builtinParam{{"n", KindInt}}, ret: KindInt},
+	"rand.seed":       {params: []builtinParam{{"s", KindInt}}, ret: KindVoid},
+	"proc.run":        {params: []builtinParam{{"cmd", KindStr}}, ret: KindInt},
+	"proc.output":     {ret: KindStr},
+	"json.valid":      {params: []builtinParam{{"doc", KindStr}}, ret: KindBool},
+	"json.get_str":    {params: []builtinParam{{"doc", KindStr}, {"key", KindStr}}, ret: KindStr},
+	"json.get_int":    {params: []builtinParam{{"doc", KindStr}, {"key", KindStr}}, ret: KindInt},
+	"json.quote":      {params: []builtinParam{{"s", KindStr}}, ret: KindStr},
+	"str.eq":          {params: []builtinParam{{"a", KindStr}, {"b", KindStr}}, ret: KindBool},
+	"str.cmp":         {params: []builtinParam{{"a", KindStr}, {"b", KindStr}}, ret: KindInt},
+	"str.len":         {params: []builtinParam{{"s", KindStr}}, ret: KindInt},
+	"hash.str":        {params: []builtinParam{{"s", KindStr}}, ret: KindInt},
+	"hash.int":        {params: []builtinParam{{"n", KindInt}}, ret: KindInt},
+	"fs.open":         {params: []builtinParam{{"path", KindStr}}, ret: KindFile.Optional()},
+	"fs.create":       {params: []builtinParam{{"path", KindStr}}, ret: KindFile.Optional()},
+	"fs.append":       {params: []builtinParam{{"path", KindStr}}, ret: KindFile.Optional()},
+	"fs.read":         {params: []builtinParam{{"f", KindFile}, {"n", KindInt}}, ret: KindStr},
+	"fs.read_line":    {params: []builtinParam{{"f", KindFile}}, ret: KindStr.Optional()},
+	"fs.write":        {params: []builtinParam{{"f", KindFile}, {"s", KindStr}}, ret: KindBool},
+	"fs.close":        {params: []builtinParam{{"f", KindFile}}, ret: KindVoid},
+	"bytes.from_file": {params: []builtinParam{{"path", KindStr}}, ret: KindBytes.Optional()},
+	"bytes.len":       {params: []builtinParam{{"b", KindBytes}}, ret: KindInt},
+	"bytes.at":        {params: []builtinParam{{"b", KindBytes}, {"i", KindInt}}, ret: KindInt},
+	"bytes.slice":     {params: []builtinParam{{"b", KindBytes}, {"lo", KindInt}, {"hi", KindInt}}, ret: KindBytes},
+	"sync.mutex":      {ret: KindMutex},
+	"sync.lock":       {params: []builtinParam{{"m", KindMutex}}, ret: KindVoid},
+	"sync.unlock":     {params: []builtinParam{{"m", KindMutex}}, ret: KindVoid},
+	"sync.atomic":     {params: []builtinParam{{"n", KindInt}}, ret: KindAtomic},
+	"sync.load":       {params: []builtinParam{{"a", KindAtomic}}, ret: KindInt},
+	"sync.store":      {params: []builtinParam{{"a", KindAtomic}, {"n", KindInt}}, ret: KindVoid},
+	"sync.add":        {params: []builtinParam{{"a", KindAtomic}, {"n", KindInt}}, ret: KindInt},
+	"sync.cas":        {params: []builtinParam{{"a", KindAtomic}, {"old", KindInt}, {"n", KindInt}}, ret: KindBool},
+}
+
+// builtinModules are the std modules whose functions are compiler
+// intrinsics; importing them needs no Desi source.
+var builtinModules = map[string]bool{
+	"io": true, "fs": true, "os": true, "fmt": true,
+	"time": true, "rand": true, "proc": true, "json": true,
+	"str": true, "hash": true, "bytes": true, "chan": true, "sync": true,
+}
+
+// IsBuiltinModule reports whether std.<name> is provided by intrinsics.
+func IsBuiltinModule(name string) bool { return builtinModules[name] }
+
+// IsBuiltin reports whether module.fn is an intrinsic. Intrinsics take
+// precedence over Desi-source std functions, which may not redefine them.
+func IsBuiltin(module, fn string) bool {
+	switch module + "." + fn {
+	case "io.println", "io.printf", "fmt.sprintf", "fs.read_all", "os.exit", "os.on_interrupt",
+		"os.at_exit", "chan.send", "chan.recv", "chan.close":
+		return true
+	}
+	_, ok := stdBuiltins[module+"."+fn]
+	return ok
+}
+
+func (c *checker) checkBuiltinCall(name string, b builtin, args []ast.Expr) Kind {
+	if len(args) != len(b.params) {
+		var ps []string
+		for _, p := range b.params {
+			ps = append(ps, p.name+": "+p.kind.String())
+		}
+		switch len(b.params) {
+		case 0:
+			c.errors = append(c.errors, fmt.Errorf("%s: want 0 args, got %d", name, len(args)))
+		case 1:
+			c.errors = append(c.errors, fmt.Errorf("%s: want 1 arg (%s), got %d", name, ps[0], len(args)))
+		default:
+			c.errors = append(c.errors, fmt.Errorf("%s: want %d args (%s), got %d", name, len(b.params), strings.Join(ps, ", "), len(args)))
+		}
+		return b.ret
+	}
+	for i, a := range args {
+		p := b.params[i]
+		if ak := c.kindOfExpr(a); ak != p.kind && ak != KindUnknown {
+			c.errors = append(c.errors, fmt.Errorf("%s: %s must be %s, got %s", name, p.name, p.kind, ak))
+		}
+	}
+	return b.ret
+}
+
+/* ---------- format strings ---------- */
+
+// checkFormatCall checks a printf-style call: the first argument must be a
+// string literal whose verbs (%d int, %s str, %b bool, %g f64, %% literal)
+// match the remaining arguments in number and kind.
+func (c *checker) checkFormatCall(name string, args []ast.Expr) {
+	if len(args) == 0 {
+		c.errors = append(c.errors, fmt.Errorf("%s: missing format string", name))
+		return
+	}
+	lit, ok := args[0].(*ast.StrLit)
+	if !ok {
+		c.kindOfExpr(args[0])
+		c.errors = append(c.errors, fmt.Errorf("%s: format must be a string literal", name))
+		return
+	}
+	verbs, err := FormatVerbs(lit.Value)
+	if err != nil {
+		c.errors = append(c.errors, fmt.Errorf("%s: %v", name, err))
+		return
+	}
+	rest := args[1:]
+	if len(verbs) != len(rest) {
+		c.errors = append(c.errors, fmt.Errorf("%s: format has %d verb(s) but %d arg(s) given", name, len(verbs), len(rest)))
+	}
+	for i, a := range rest {
+		ak := c.kindOfExpr(a)
+		if i >= len(verbs) {
+			continue
+		}
+		if ak != verbs[i] && ak != KindUnknown {
+			c.errors = append(c.errors, fmt.Errorf("%s: arg %d for %%%c must be %s, got %s", name, i+2, verbLetter(verbs[i]), verbs[i], ak))
+		}
+	}
+}
+
+// FormatVerbs returns the argument kinds a format string literal (quotes
+// included, as lexed) expects, in order.
+func FormatVerbs(lit string) ([]Kind, error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(lit, `"`), `"`)
+	var kinds []Kind
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("format ends with a lone %%")
+		}
+		switch s[i] {
+		case '%':
+		case 'd':
+			kinds = append(kinds, KindInt)
+		case 's':
+			kinds = append(kinds, KindStr)
+		case 'b':
+			kinds = append(kinds, KindBool)
+		case 'g':
+			kinds = append(kinds, KindFloat)
+		default:
+			return nil, fmt.Errorf("unknown format verb %%%c (want %%d, %%s, %%b, %%g or %%%%)", s[i])
+		}
+	}
+	return kinds, nil
+}
+
+func verbLetter(k Kind) byte {
+	switch k {
+	case KindStr:
+		return 's'
+	case KindBool:
+		return 'b'
+	case KindFloat:
+		return 'g'
+	}
+	return 'd'
+}
+
 /* ---------- helpers ---------- */
 
 func mapTextType(t string) Kind {
-	switch strings.TrimSpace(strings.ToLower(t)) {
+	t = strings.TrimSpace(strings.ToLower(t))
+	if base, ok := strings.CutSuffix(t, "?"); ok {
+		switch k := mapTextType(base); k {
+		case KindInt, KindStr, KindBool, KindFile, KindBytes:
+			return k.Optional()
+		default:
+			return KindUnknown
+		}
+	}
+	if inner, ok := strings.CutPrefix(strings.ReplaceAll(t, " ", ""), "chan["); ok {
+		// the parser spells the type text "chan [ int ]"
+		elem, ok := strings.CutSuffix(inner, "]")
+		switch k := mapTextType(elem); k {
+		case KindInt, KindStr, KindBool:
+			if ok {
+				return k.Chan()
+			}
+		}
+		return KindUnknown
+	}
+	switch t {
 	case "", "void":
 		return KindVoid
-	case "i32", "int", "u32":
+	case "i32", "int", "u32", "i64", "u64":
 		return KindInt
 	case "bool":
 		return KindBool
 	case "str", "string":
 		return KindStr
+	case "f64":
+		return KindFloat
+	case "file":
+		return KindFile
+	case "bytes":
+		return KindBytes
+	case "mutex":
+		return KindMutex
+	case "atomic":
+		return KindAtomic
 	default:
 		return KindUnknown
 	}
@@ -493,6 +1476,32 @@ func unifyKinds(a, b Kind) (Kind, bool) {
 	return KindUnknown, false
 }
 
+// assignable is unifyKinds for a value of kind from stored where to is
+// expected (variable, parameter, return): `none` and plain T values also
+// widen to `T?`, but never the other way round.
+func assignable(to, from Kind) (Kind, bool) {
+	if to.IsOptional() && !from.IsOptional() {
+		if from == KindNone {
+			return to, true
+		}
+		if _, ok := unifyKinds(to.Elem(), from); ok {
+			return to, true
+		}
+	}
+	return unifyKinds(to, from)
+}
+
+// noneHint explains a failed assignable(to, from) involving optionals.
+func noneHint(to, from Kind) string {
+	switch {
+	case from == KindNone:
+		return " (only optional types such as " + to.String() + "? can be none)"
+	case from.IsOptional() && !to.IsOptional():
+		return " (compare it with none first: if x != none: ...)"
+	}
+	return ""
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
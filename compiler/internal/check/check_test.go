@@ -0,0 +1,412 @@
+package check
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+)
+
+func TestFormatCalls(t *testing.T) {
+	cases := []struct {
+		body string
+		want string // substring of the only error; "" for none
+	}{
+		{`io.printf("%d apples and %s\n", 3, "pears")`, ""},
+		{`io.printf("%b %% done", true)`, ""},
+		{`let s = fmt.sprintf("n=%d", 1)` + "\n  io.println(s)", ""},
+		{`io.printf("%d %d", 1)`, "format has 2 verb(s) but 1 arg(s) given"},
+		{`io.printf("%d", "x")`, "arg 2 for %d must be int, got str"},
+		{`io.printf("%s", 1)`, "arg 2 for %s must be str, got int"},
+		{`io.printf("%d|%s", true, "x")`, "arg 2 for %d must be int, got bool"},
+		{`io.printf("%b", 5)`, "arg 2 for %b must be bool, got int"},
+		{`io.printf("%g %g\n", 1.5, 2.0 * 3.0)`, ""},
+		{`io.printf("%d", 1.5)`, "arg 2 for %d must be int, got f64"},
+		{`io.printf("%g", 1)`, "arg 2 for %g must be f64, got int"},
+		{`io.printf("%q", 1)`, "unknown format verb %q"},
+		{`io.printf("50%")`, "lone %"},
+		{"let f = \"%d\"\n  io.printf(f, 1)", "format must be a string literal"},
+	}
+	for _, tc := range cases {
+		src := "def main() -> void:\n  " + tc.body + "\n"
+		_, errs, _ := CheckFile(parse(t, src))
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", tc.body, errs)
+		case tc.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestInterpHoles(t *testing.T) {
+	cases := []struct {
+		body string
+		want string // substring of the only error; "" for none
+	}{
+		{`let s = f"{1} {"a"} {true} {2.5} {time.now_ms()}"` + "\n  io.println(s)", ""},
+		{`io.println(f"{undefined}")`, `undeclared identifier "undefined"`},
+		{`io.println(f"{io.println(1)}")`, "f-string hole 1 is void"},
+		{`io.println(f"{fs.read_all("p")}")`, "f-string hole 1 is str?"},
+	}
+	for _, tc := range cases {
+		src := "def main() -> void:\n  " + tc.body + "\n"
+		_, errs, _ := CheckFile(parse(t, src))
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", tc.body, errs)
+		case tc.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	ok := "def main() -> void:\n  let s = time.mono_ns()\n  time.sleep_ms(5)\n  io.println(time.now_ms() - s)\n"
+	if _, errs, _ := CheckFile(parse(t, ok)); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	bad := "def main() -> void:\n  time.sleep_ms(\"x\")\n  time.now_ms(1)\n"
+	_, errs, _ := CheckFile(parse(t, bad))
+	if len(errs) != 2 ||
+		!strings.Contains(errs[0].Error(), "time.sleep_ms: ms must be int, got str") ||
+		!strings.Contains(errs[1].Error(), "time.now_ms: want 0 args, got 1") {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestRandBuiltins(t *testing.T) {
+	src := "def main() -> void:\n  rand.seed(7)\n  let n = rand.int(10)\n  io.println(n)\n  rand.int(\"x\")\n"
+	_, errs, _ := CheckFile(parse(t, src))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "rand.int: n must be int, got str") {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestProcBuiltins(t *testing.T) {
+	src := "def main() -> void:\n  let st = proc.run(\"true\")\n  let out = proc.output()\n  io.println(st, out)\n  proc.run(1)\n"
+	_, errs, _ := CheckFile(parse(t, src))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "proc.run: cmd must be str, got int") {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	src := "def main() -> void:\n" +
+		"  let doc = \"{}\"\n" +
+		"  if json.valid(doc):\n" +
+		"    io.println(json.get_str(doc, \"k\"), json.get_int(doc, \"n\"), json.quote(\"x\"))\n" +
+		"  json.get_str(doc)\n"
+	_, errs, _ := CheckFile(parse(t, src))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "json.get_str: want 2 args (doc: str, key: str), got 1") {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestQualifiedCalls(t *testing.T) {
+	f := parse(t, ""+
+		"def main() -> i32:\n"+
+		"  let n = util.parse_int(\"42\")\n"+
+		"  util.parse_int(1)\n"+
+		"  util.nope()\n"+
+		"  strs.trim(\"\")\n"+
+		"  io.nope()\n"+
+		"  return n\n"+
+		"def parse_int(s: str) -> i32:\n"+
+		"  return 0\n"+
+		"def other() -> i32:\n"+
+		"  return util.parse_int(\"7\")\n")
+	// as the loader does for `import util` in main's file, but not other's
+	f.Decls[0].(*ast.FuncDecl).Imports = []string{"util"}
+	f.Decls[1].(*ast.FuncDecl).Module = "util"
+
+	info, errs, _ := CheckFile(f)
+	if !info.Modules["util"] {
+		t.Fatalf("util not registered as a module: %v", info.Modules)
+	}
+	want := []string{
+		"call to util.parse_int: arg 1 kind mismatch (want str, got int)",
+		`call to unknown function "util.nope": module util has no function nope`,
+		`call to unknown function "strs.trim": no module strs is loaded (missing import?)`,
+		`call to unknown function "io.nope": std.io has no function nope`,
+		`call to unknown function "util.parse_int": module util is loaded, but this file does not import it (missing import?)`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v", errs)
+	}
+	for i, w := range want {
+		if errs[i].Error() != w {
+			t.Errorf("error %d = %q, want %q", i, errs[i], w)
+		}
+	}
+}
+
+func TestDuplicateFunctionAcrossModules(t *testing.T) {
+	f := parse(t, "def width() -> i32:\n  return 1\ndef width() -> i32:\n  return 2\ndef main() -> void:\n  return\n")
+	f.Decls[1].(*ast.FuncDecl).Package = "util.text"
+	_, errs, _ := CheckFile(f)
+	want := `duplicate function "width": declared in the entry file and in module util.text (function names must be unique program-wide)`
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Fatalf("errors = %v, want %q", errs, want)
+	}
+}
+
+func TestMutualRecursion(t *testing.T) {
+	// is_even is declared in module a and calls b.is_odd; is_odd calls back
+	// into a. Neither needs to be declared first; each file imports the
+	// other.
+	f := parse(t, ""+
+		"def main() -> i32:\n"+
+		"  return a.is_even(10)\n"+
+		"def is_even(n: i32) -> i32:\n"+
+		"  if n == 0:\n"+
+		"    return 1\n"+
+		"  return b.is_odd(n - 1)\n"+
+		"def is_odd(n: i32) -> i32:\n"+
+		"  if n == 0:\n"+
+		"    return 0\n"+
+		"  return a.is_even(n - 1)\n")
+	f.Decls[0].(*ast.FuncDecl).Imports = []string{"a"}
+	f.Decls[1].(*ast.FuncDecl).Module, f.Decls[1].(*ast.FuncDecl).Imports = "a", []string{"b"}
+	f.Decls[2].(*ast.FuncDecl).Module, f.Decls[2].(*ast.FuncDecl).Imports = "b", []string{"a"}
+	if _, errs, _ := CheckFile(f); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestDuplicateParams(t *testing.T) {
+	_, errs, _ := CheckFile(parse(t, ""+
+		"def f(a: i32, a2: i32, a: str) -> i32:\n"+
+		"  return a + a2\n"+
+		"def main() -> void:\n"+
+		"  return\n"))
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	var e Error
+	if !errors.As(errs[0], &e) || e.Code != "E0001" {
+		t.Fatalf("error = %#v, want code E0001", errs[0])
+	}
+	want := `E0001: duplicate parameter "a" in f: parameter 3 repeats parameter 1 (rename it, e.g. "a3")`
+	if e.Error() != want {
+		t.Errorf("error = %q, want %q", e.Error(), want)
+	}
+}
+
+func TestAssignToParam(t *testing.T) {
+	_, errs, _ := CheckFile(parse(t, ""+
+		"def dec(n: i32) -> i32:\n"+
+		"  let n2 = 1\n"+
+		"  n := n - n2\n"+
+		"  return n\n"+
+		"def main() -> void:\n"+
+		"  return\n"))
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	want := `E0002: cannot assign to parameter "n": parameters are immutable; copy it into a local first (let mut n3 = n)`
+	if errs[0].Error() != want {
+		t.Errorf("error = %q, want %q", errs[0], want)
+	}
+}
+
+func TestMutParams(t *testing.T) {
+	head := "def bump(mut n: i32, by: i32) -> void:\n  n := n + by\n" +
+		"def pair(mut a: i32, mut b: i32) -> void:\n  a := b\n" +
+		"def main() -> void:\n  let mut x = 1\n  let y = 2\n"
+	cases := []struct {
+		body string
+		want string // substring of the only error; "" for none
+	}{
+		{"bump(x, y)\n  io.println(x)", ""},
+		{"bump(y, 1)", `arg 1 "y" is passed to a mut parameter but is immutable`},
+		{"bump(x + 1, 1)", "arg 1 is passed to a mut parameter and must be a variable"},
+		{"pair(x, x)", `"x" is passed to mut parameters 1 and 2 (aliasing)`},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n"))
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", tc.body, errs)
+		case tc.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestOptionals(t *testing.T) {
+	head := "def find(s: str) -> i32?:\n  if s == \"x\":\n    return 1\n  return none\n" +
+		"def need(n: i32) -> i32:\n  return n\n" +
+		"def main() -> void:\n  let mut n = find(\"y\")\n"
+	cases := []struct {
+		body string
+		want string // substring of the only error; "" for none
+	}{
+		{"if n != none:\n    need(n)", ""},
+		{"if (n != none):\n    need((n))", ""},
+		{"if n == none:\n    return\n  need(n)", ""},
+		{"if n == none:\n    io.println(0)\n  else:\n    need(n)", ""},
+		{"n := 3\n  n := none", ""},
+		{"need(n)", "arg 1 kind mismatch (want int, got int?) (compare it with none first"},
+		{"if n != none:\n    n := none\n    need(n)", "want int, got int?"},
+		{"let z = none", "let z = none: cannot infer an optional type"},
+		{"need(none)", "only optional types such as int? can be none"},
+		{"let m = n + 1", "operator + on int? and int"},
+		{"let s = \"a\"\n  if s == none:\n    return", "comparing str with none"},
+		{"let t = fs.read_all(\"f\")\n  io.println(t)", "unsupported kind str?"},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n"))
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", tc.body, errs)
+		case tc.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestStrBuiltins(t *testing.T) {
+	ok := "def main() -> void:\n  let same = str.eq(\"a\", \"b\")\n  let order = str.cmp(\"a\", \"b\")\n  if same:\n    io.println(order + str.len(\"a\\0b\"))\n"
+	if _, errs, _ := CheckFile(parse(t, ok)); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	bad := "def main() -> void:\n  str.cmp(\"a\", 1)\n  str.eq(\"a\")\n  str.len(1)\n"
+	_, errs, _ := CheckFile(parse(t, bad))
+	want := []string{
+		"str.cmp: b must be str, got int",
+		"str.eq: want 2 args (a: str, b: str), got 1",
+		"str.len: s must be str, got int",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v", errs)
+	}
+	for i, w := range want {
+		if errs[i].Error() != w {
+			t.Errorf("error %d = %q, want %q", i, errs[i], w)
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	ok := "def main() -> void:\n  let b = hash.str(\"main\") % 64\n  io.println(b + hash.int(42))\n"
+	if _, errs, _ := CheckFile(parse(t, ok)); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	_, errs, _ := CheckFile(parse(t, "def main() -> void:\n  hash.str(1)\n"))
+	if len(errs) != 1 || errs[0].Error() != "hash.str: s must be str, got int" {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestIntLiteralRange(t *testing.T) {
+	head := "def wide(x: i64) -> i64:\n  return x\n" +
+		"def narrow(x: i32) -> i32:\n  return 2147483647\n" +
+		"def main() -> void:\n"
+	cases := []struct {
+		body string
+		want string // substring of the only error; "" for none
+	}{
+		{"let m = -2147483648\n  io.println(m)", ""},
+		{"wide(-9223372036854775808)", ""},
+		{"wide(3000000000)", ""},
+		{"let m = 2147483648\n  io.println(m)", "let m: integer literal 2147483648 overflows i32 (-2147483648..2147483647)"},
+		{"let m = -2147483649\n  io.println(m)", "integer literal -2147483649 overflows i32"},
+		{"narrow(4294967296)", "call to narrow: arg 1: integer literal 4294967296 overflows i32"},
+		{"wide(9223372036854775808)", "integer literal 9223372036854775808 overflows i64"},
+		{"wide(-9223372036854775809)", "integer literal -9223372036854775809 overflows i64"},
+		{"let m = 5000000000i64\n  io.println(m)", ""},
+		{"let m = -128i8 + 255u8 + 0xffffu16\n  io.println(m)", ""},
+		{"wide(-9223372036854775808i64)", ""},
+		{"let m = 256u8\n  io.println(m)", "integer literal 256u8 overflows u8 (0..255)"},
+		{"let m = 128i8\n  io.println(m)", "integer literal 128i8 overflows i8 (-128..127)"},
+		{"let m = -1u32\n  io.println(m)", "integer literal -1u32 overflows u32 (0..4294967295)"},
+		{"let m = 4294967296u32\n  io.println(m)", "integer literal 4294967296u32 overflows u32"},
+		{"wide(18446744073709551615u64)", "integer literal 18446744073709551615 overflows i64"},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n"))
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected errors %v", tc.body, errs)
+		case tc.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestUnaryOperators(t *testing.T) {
+	head := "def flag() -> bool:\n  return true\n" +
+		"def main() -> void:\n  let n = 3\n  let s = \"x\"\n  io.println(n, s)\n"
+	// Each body ends by passing r to %s, so the error names r's kind.
+	cases := []struct {
+		body string
+		want string // substring of the only error
+	}{
+		{"let r = not flag()", "must be str, got bool"},
+		{"let r = !flag()", "must be str, got bool"},
+		{"let r = not n", "must be str, got bool"},
+		{"let r = -n", "must be str, got int"},
+		{"let r = - -n", "must be str, got int"},
+		{"let r = -s", "cannot negate str (unary - needs an integer or f64)"},
+		{"let r = -flag()", "cannot negate bool"},
+		{"let r = not s", "operator not on str (needs bool)"},
+		{"let r = !s", "operator ! on str (needs bool)"},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n  io.printf(\"%s\", r)\n"))
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.want) {
+			t.Errorf("%s: errors %v, want one containing %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	head := "def main() -> void:\n  let n = 3\n  let s = \"x\"\n  let b = true\n  io.println(n, s, b)\n"
+	// Each body ends by passing r to %s, so the last error names r's kind;
+	// a failed comparison is still a bool.
+	cases := []struct {
+		body string
+		want string // substring of the only error
+	}{
+		{"let r = n < 4", "must be str, got bool"},
+		{"let r = s == \"y\"", "must be str, got bool"},
+		{"let r = s >= \"a\"", "must be str, got bool"},
+		{"let r = b == false", "must be str, got bool"},
+		{"let r = n > 0 and s != \"\"", "must be str, got bool"},
+		{"let r = b or not b", "must be str, got bool"},
+		{"let r = b < true", "operator < on bool: bools have no order"},
+		{"let r = (n < 1) >= b", "operator >= on bool"},
+		{"let r = s < n", "operator < on str and int: operands must both be integers or both str"},
+		{"let r = s == n", "operator == on str and int: operands must have the same type"},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n  io.printf(\"%s\", r)\n"))
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tc.want) {
+			t.Errorf("%s: errors %v, want the first to contain %q", tc.body, errs, tc.want)
+		}
+	}
+}
+
+func TestFloats(t *testing.T) {
+	head := "def half(x: f64) -> f64:\n  return x / 2.0\n" +
+		"def main() -> void:\n  let f = 2.5e-3\n  let n = 3\n  io.println(f, n)\n"
+	// Each body ends by passing r to %s, so the last error names r's kind.
+	cases := []struct {
+		body string
+		want string // substring of the first error
+	}{
+		{"let r = half(f) * -1e9", "must be str, got f64"},
+		{"let r = f < 1.0", "must be str, got bool"},
+		{"let r = f + n", "operator + on f64 and int: operands must have the same type"},
+		{"let r = f % 2.0", "operator % is not defined on f64"},
+		{"let r = half(n)", "half: arg 1"},
+	}
+	for _, tc := range cases {
+		_, errs, _ := CheckFile(parse(t, head+"  "+tc.body+"\n  io.printf(\"%s\", r)\n"))
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), tc.want) {
+			t.Errorf("%s: errors %v, want the first to contain %q", tc.body, errs, tc.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+)
+
+// Cache remembers per-function check results so repeated checks of an
+// evolving program (watch mode, editors) only re-check functions whose
+// result could have changed. A function is reused when its own fingerprint
+// (signature + body) and the signatures of every function it mentions are
+// unchanged. A Cache is not safe for concurrent use.
+type Cache struct {
+	entries map[string]funcResult
+
+	// Stats from the most recent CheckFileCached call.
+	Reused, Checked int
+}
+
+type funcResult struct {
+	errs  []error
+	warns []Warning
+}
+
+func NewCache() *Cache { return &Cache{entries: map[string]funcResult{}} }
+
+// CheckFileCached is CheckFile with function results reused from cache
+// (which may be nil). Entries not used by this call are dropped, so the
+// cache tracks the latest version of the program only.
+func CheckFileCached(f *ast.File, cache *Cache) (*Info, []error, []Warning) {
+	info, errs := collectSigs(f)
+	var warns []Warning
+
+	var next map[string]funcResult
+	if cache != nil {
+		next = map[string]funcResult{}
+		cache.Reused, cache.Checked = 0, 0
+	}
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		var r funcResult
+		if cache == nil {
+			r.errs, r.warns = checkFunc(info, fn)
+		} else {
+			key := cacheKey(info, fn)
+			if hit, ok := cache.entries[key]; ok {
+				r = hit
+				cache.Reused++
+			} else {
+				r.errs, r.warns = checkFunc(info, fn)
+				cache.Checked++
+			}
+			next[key] = r
+		}
+		errs = append(errs, r.errs...)
+		warns = append(warns, r.warns...)
+	}
+	if cache != nil {
+		cache.entries = next
+	}
+	return info, errs, warns
+}
+
+// cacheKey captures everything checkFunc's result depends on: the function
+// itself, the lines its diagnostics quote, the modules its file imports, the
+// signature it was registered under (duplicates share the first one), and
+// the table entry — or absence — of every name it mentions.
+func cacheKey(info *Info, fn *ast.FuncDecl) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%v|%s%v|%v", ast.Fingerprint(fn), quotedLines(fn), fn.Module, fn.Imports, info.Funcs[fn.Name])
+	for _, name := range mentionedNames(fn) {
+		if sig, ok := info.Funcs[name]; ok {
+			fmt.Fprintf(&b, "|%s=%v", name, sig)
+		} else {
+			fmt.Fprintf(&b, "|%s=-", name)
+		}
+		if info.Modules[name] {
+			b.WriteString("/module")
+		}
+	}
+	return b.String()
+}
+
+// quotedLines returns the lines of fn's statements that diagnostics quote by
+// number (the defer of W0007, the with that closed a resource).
+// ast.Fingerprint ignores positions, so without
+// them a function moved down the file would get its old line numbers back.
+// Other positions stay out of the key: moving a function is no reason to
+// check it again.
+func quotedLines(fn *ast.FuncDecl) []int {
+	var lines []int
+	for _, r := range ast.StmtIDs(fn) {
+		switch st := r.Stmt.(type) {
+		case *ast.DeferStmt:
+			lines = append(lines, st.Pos.Line)
+		case *ast.WithStmt:
+			lines = append(lines, st.Pos.Line)
+		}
+	}
+	return lines
+}
+
+// mentionedNames returns the sorted identifiers (and field names) used
+// anywhere in fn's body.
+// Locals are included; that only makes the key more conservative.
+func mentionedNames(fn *ast.FuncDecl) []string {
+	seen := map[string]bool{}
+	var expr func(e ast.Expr)
+	expr = func(e ast.Expr) {
+		switch v := e.(type) {
+		case *ast.IdentExpr:
+			seen[v.Name] = true
+		case *ast.CallExpr:
+			expr(v.Callee)
+			for _, a := range v.Args {
+				expr(a)
+			}
+		case *ast.IndexExpr:
+			expr(v.Seq)
+			expr(v.Index)
+		case *ast.FieldExpr:
+			expr(v.X)
+			seen[v.Name] = true // module.f resolves f in the function table
+		case *ast.UnaryExpr:
+			expr(v.X)
+		case *ast.ParenExpr:
+			expr(v.X)
+		case *ast.InterpExpr:
+			for _, h := range v.Holes {
+				expr(h)
+			}
+		case *ast.BinaryExpr:
+			expr(v.Left)
+			expr(v.Right)
+		case *ast.RangeExpr:
+			expr(v.Lo)
+			expr(v.Hi)
+		}
+	}
+	for _, r := range ast.StmtIDs(fn) {
+		switch st := r.Stmt.(type) {
+		case *ast.LetStmt:
+			expr(st.Expr)
+		case *ast.AssignStmt:
+			expr(st.Expr)
+		case *ast.ReturnStmt:
+			if st.Expr != nil {
+				expr(st.Expr)
+			}
+		case *ast.ExprStmt:
+			expr(st.Expr)
+		case *ast.IfStmt:
+			expr(st.Cond)
+			for _, e := range st.Elifs {
+				expr(e.Cond)
+			}
+		case *ast.WhileStmt:
+			expr(st.Cond)
+		case *ast.DeferStmt:
+			expr(st.Call)
+		case *ast.SpawnStmt:
+			expr(st.Call)
+		case *ast.WithStmt:
+			expr(st.Expr)
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,117 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/parser"
+)
+
+func parse(t *testing.T, src string) *ast.File {
+	t.Helper()
+	f, err := parser.New(src).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return f
+}
+
+const incBase = "" +
+	"def add(a: i32, b: i32) -> i32:\n  return a + b\n" +
+	"def other() -> i32:\n  return 7\n" +
+	"def main() -> i32:\n  return add(1, 2)\n"
+
+func TestCacheReusesUnchangedFunctions(t *testing.T) {
+	c := NewCache()
+	CheckFileCached(parse(t, incBase), c)
+	if c.Checked != 3 || c.Reused != 0 {
+		t.Fatalf("first run: checked=%d reused=%d", c.Checked, c.Reused)
+	}
+
+	// Body-only edit to other: add and main are reused.
+	edited := "" +
+		"def add(a: i32, b: i32) -> i32:\n  return a + b\n" +
+		"def other() -> i32:\n  return 8\n" +
+		"def main() -> i32:\n  return add(1, 2)\n"
+	CheckFileCached(parse(t, edited), c)
+	if c.Checked != 1 || c.Reused != 2 {
+		t.Fatalf("body edit: checked=%d reused=%d", c.Checked, c.Reused)
+	}
+}
+
+func TestCacheRechecksCallersOnSignatureChange(t *testing.T) {
+	c := NewCache()
+	CheckFileCached(parse(t, incBase), c)
+
+	changed := "" +
+		"def add(a: i32) -> i32:\n  return a\n" +
+		"def other() -> i32:\n  return 7\n" +
+		"def main() -> i32:\n  return add(1, 2)\n"
+	_, errs, _ := CheckFileCached(parse(t, changed), c)
+	if c.Checked != 2 || c.Reused != 1 {
+		t.Fatalf("checked=%d reused=%d, want add and main re-checked", c.Checked, c.Reused)
+	}
+	_, want, _ := CheckFile(parse(t, changed))
+	if len(errs) != 1 || len(want) != 1 || errs[0].Error() != want[0].Error() {
+		t.Fatalf("cached errs %v, uncached %v", errs, want)
+	}
+}
+
+func TestCacheNoticesNewlyDefinedName(t *testing.T) {
+	c := NewCache()
+	src := "def main() -> i32:\n  return helper()\n"
+	if _, errs, _ := CheckFileCached(parse(t, src), c); len(errs) != 1 {
+		t.Fatalf("want unknown-function error, got %v", errs)
+	}
+	_, errs, _ := CheckFileCached(parse(t, src+"def helper() -> i32:\n  return 1\n"), c)
+	if len(errs) != 0 {
+		t.Fatalf("stale cached error: %v", errs)
+	}
+}
+
+// moved puts a function above the rest of src, moving everything down by
+// two lines.
+func moved(src string) string {
+	return "def first() -> void:\n  return\n" + src
+}
+
+func TestCacheKeepsQuotedLinesCurrent(t *testing.T) {
+	src := "def main() -> void:\n  let mut x = 1\n  defer io.println(x + 1)\n  x := 2\n"
+	c := NewCache()
+	CheckFileCached(parse(t, src), c)
+	_, _, warns := CheckFileCached(parse(t, moved(src)), c)
+	want := `W0007: "x" changes after the defer at line 5 reads it`
+	if len(warns) != 1 || !strings.Contains(warns[0].String(), want) {
+		t.Fatalf("warnings = %v, want %q", warns, want)
+	}
+	if c.Checked != 2 || c.Reused != 0 {
+		t.Errorf("checked=%d reused=%d, want main re-checked", c.Checked, c.Reused)
+	}
+
+	src = "def main() -> void:\n  let h = fs.open(\"a\")\n  with h as f:\n    fs.close(f)\n  io.println(h == none)\n"
+	c = NewCache()
+	CheckFileCached(parse(t, src), c)
+	_, errs, _ := CheckFileCached(parse(t, moved(src)), c)
+	want = `use of "h" after the with at line 5 closed it`
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), want) {
+		t.Fatalf("errors = %v, want %q", errs, want)
+	}
+
+	src = "def main() -> void:\n  with fs.open(\"a\") as f:\n    fs.close(f)\n  io.println(f == none)\n"
+	c = NewCache()
+	CheckFileCached(parse(t, src), c)
+	_, errs, _ = CheckFileCached(parse(t, moved(src)), c)
+	want = `use of "f" after its with block (line 4) ended`
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), want) {
+		t.Fatalf("errors = %v, want %q", errs, want)
+	}
+
+	// a function with no quoted lines is reused wherever it moves
+	c = NewCache()
+	CheckFileCached(parse(t, incBase), c)
+	CheckFileCached(parse(t, moved(incBase)), c)
+	if c.Checked != 1 || c.Reused != 3 {
+		t.Errorf("checked=%d reused=%d, want only first checked", c.Checked, c.Reused)
+	}
+}
@@ -0,0 +1,121 @@
+package check
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/lexer"
+)
+
+// IntLiteral returns the value of e as magnitude and sign if e is an integer
+// literal or a negated one (`-2147483648`), which the C emitter folds into a
+// single constant.
+func IntLiteral(e ast.Expr) (mag uint64, neg, ok bool) {
+	e = ast.Unparen(e)
+	if u, isNeg := e.(*ast.UnaryExpr); isNeg && u.Op == "-" {
+		if lit, isLit := ast.Unparen(u.X).(*ast.IntLit); isLit {
+			n, err := lexer.IntValue(lit.Value)
+			return n, true, err == nil
+		}
+		return 0, false, false
+	}
+	if lit, isLit := e.(*ast.IntLit); isLit {
+		n, err := lexer.IntValue(lit.Value)
+		return n, false, err == nil
+	}
+	return 0, false, false
+}
+
+// FitsInt reports whether the literal (mag, neg) fits a signed integer of
+// the given width.
+func FitsInt(mag uint64, neg bool, bits int) bool {
+	limit := uint64(1) << (bits - 1)
+	if neg {
+		return mag <= limit
+	}
+	return mag < limit
+}
+
+// LitBits is the width of the C integer a literal lowers to: 64 when its
+// suffix is i64 or u64, otherwise 32, so `let n = 5i64` infers an i64.
+func LitBits(e ast.Expr) int { return intBits(litSuffix(e)) }
+
+// litSuffix returns the type suffix of an integer literal, negated or not.
+func litSuffix(e ast.Expr) string {
+	e = ast.Unparen(e)
+	if u, isNeg := e.(*ast.UnaryExpr); isNeg && u.Op == "-" {
+		e = ast.Unparen(u.X)
+	}
+	if lit, isLit := e.(*ast.IntLit); isLit {
+		return lit.Suffix
+	}
+	return ""
+}
+
+// suffixRange returns the bounds of the type a literal suffix names:
+// "u8" is 0..255, "i16" is -32768..32767.
+func suffixRange(suffix string) (lo int64, hi uint64) {
+	bits, _ := strconv.Atoi(suffix[1:])
+	if suffix[0] == 'u' {
+		return 0, ^uint64(0) >> (64 - bits)
+	}
+	return -(int64(1) << (bits - 1)), uint64(1)<<(bits-1) - 1
+}
+
+// fitsSuffix reports whether the literal (mag, neg) fits its suffix's
+// type; an unsuffixed literal always does.
+func fitsSuffix(mag uint64, neg bool, suffix string) bool {
+	if suffix == "" {
+		return true
+	}
+	lo, hi := suffixRange(suffix)
+	if neg {
+		return mag == 0 || (lo < 0 && mag-1 <= hi)
+	}
+	return mag <= hi
+}
+
+// intBits is the width of the C integer a declared type lowers to: i64 and
+// u64 are int64_t, every other integer (and bool) is a C int.
+func intBits(t string) int {
+	switch strings.TrimSuffix(strings.TrimSpace(strings.ToLower(t)), "?") {
+	case "i64", "u64":
+		return 64
+	}
+	return 32
+}
+
+// checkLitRange reports a literal e that does not fit the bits-wide integer
+// it initializes. Literals beyond 64 bits were already reported by kindOfExpr.
+func (c *checker) checkLitRange(e ast.Expr, bits int, what string) {
+	mag, neg, ok := IntLiteral(e)
+	if !ok || !FitsInt(mag, neg, 64) || !fitsSuffix(mag, neg, litSuffix(e)) || FitsInt(mag, neg, bits) {
+		return
+	}
+	c.errors = append(c.errors, fmt.Errorf("%s: integer literal %s overflows i%d (%d..%d)",
+		what, litString(mag, neg), bits, -(int64(1)<<(bits-1)), int64(1)<<(bits-1)-1))
+}
+
+// checkLit64 reports literals that fit no Stage-0 integer type, or not the
+// one their suffix names.
+func (c *checker) checkLit64(e ast.Expr) {
+	mag, neg, ok := IntLiteral(e)
+	switch suffix := litSuffix(e); {
+	case !ok:
+	case !FitsInt(mag, neg, 64):
+		c.errors = append(c.errors, fmt.Errorf("integer literal %s overflows i64", litString(mag, neg)))
+	case !fitsSuffix(mag, neg, suffix):
+		lo, hi := suffixRange(suffix)
+		c.errors = append(c.errors, fmt.Errorf("integer literal %s%s overflows %s (%d..%d)",
+			litString(mag, neg), suffix, suffix, lo, hi))
+	}
+}
+
+func litString(mag uint64, neg bool) string {
+	if neg {
+		return fmt.Sprintf("-%d", mag)
+	}
+	return fmt.Sprintf("%d", mag)
+}
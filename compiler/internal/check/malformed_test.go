@@ -0,0 +1,115 @@
+package check
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/parser"
+)
+
+// malformedExprs are expressions the parser never builds: nil operands,
+// missing callees, f-strings whose parts and holes disagree.
+func malformedExprs() []ast.Expr {
+	io := func(name string) ast.Expr { return &ast.FieldExpr{X: &ast.IdentExpr{Name: "io"}, Name: name} }
+	return []ast.Expr{
+		nil,
+		&ast.IdentExpr{},
+		&ast.IntLit{Value: "x"},
+		&ast.FloatLit{Value: ""},
+		&ast.StrLit{Value: ""},
+		&ast.CharLit{},
+		&ast.UnaryExpr{Op: "-"},
+		&ast.UnaryExpr{Op: "?", X: &ast.IntLit{Value: "1"}},
+		&ast.BinaryExpr{Op: "+"},
+		&ast.BinaryExpr{Op: "==", Left: &ast.NoneLit{}},
+		&ast.BinaryExpr{Op: "@@", Left: &ast.IntLit{Value: "1"}, Right: &ast.IntLit{Value: "1"}},
+		&ast.BinaryExpr{Op: "|>", Left: &ast.IntLit{Value: "1"}},
+		&ast.CallExpr{},
+		&ast.CallExpr{Callee: &ast.FieldExpr{}},
+		&ast.CallExpr{Callee: &ast.FieldExpr{Name: "println"}},
+		&ast.CallExpr{Callee: io("println"), Args: []ast.Expr{nil}},
+		&ast.CallExpr{Callee: io("printf")},
+		&ast.CallExpr{Callee: io("printf"), Args: []ast.Expr{nil, nil}},
+		&ast.CallExpr{Callee: &ast.FieldExpr{X: &ast.IdentExpr{Name: "fs"}, Name: "read_all"}},
+		&ast.CallExpr{Callee: &ast.FieldExpr{X: &ast.IdentExpr{Name: "os"}, Name: "exit"}, Args: []ast.Expr{nil}},
+		&ast.CallExpr{Callee: &ast.IdentExpr{Name: "f"}, Args: []ast.Expr{nil, nil, nil}},
+		&ast.CallExpr{Callee: &ast.IntLit{Value: "1"}},
+		&ast.IndexExpr{},
+		&ast.FieldExpr{},
+		&ast.ParenExpr{},
+		&ast.InterpExpr{},
+		&ast.InterpExpr{Parts: []string{`""`}, Holes: []ast.Expr{nil, nil}},
+		&ast.InterpExpr{Holes: []ast.Expr{&ast.IntLit{Value: "1"}}},
+	}
+}
+
+// malformedStmts wraps x in every statement that holds an expression, and
+// adds statements with missing parts of their own.
+func malformedStmts(x ast.Expr) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.LetStmt{Name: "v", Expr: x},
+		&ast.LetStmt{Expr: x},
+		&ast.AssignStmt{Name: "v", Expr: x},
+		&ast.AssignStmt{Name: "p", Expr: x},
+		&ast.ReturnStmt{Expr: x},
+		&ast.ExprStmt{Expr: x},
+		&ast.IfStmt{Cond: x, Then: []ast.Stmt{nil}, Elifs: []ast.ElseIf{{Cond: x}}, Else: []ast.Stmt{&ast.ExprStmt{}}},
+		&ast.WhileStmt{Cond: x, Body: []ast.Stmt{&ast.ReturnStmt{Expr: x}}},
+		&ast.DeferStmt{Call: x},
+		&ast.WithStmt{Expr: x, Body: []ast.Stmt{&ast.ExprStmt{Expr: &ast.IdentExpr{}}}},
+		nil,
+	}
+}
+
+// TestMalformedASTNeverPanics feeds the checker ASTs no source parses to. It
+// may reject them however it likes, but must not crash: tools build and
+// rewrite ASTs, and a crash there surfaces as an internal compiler error.
+func TestMalformedASTNeverPanics(t *testing.T) {
+	for i, x := range malformedExprs() {
+		for j, s := range malformedStmts(x) {
+			f := &ast.File{Decls: []ast.Decl{
+				&ast.FuncDecl{Name: "f", Params: []ast.Param{{Name: "p", Type: "i32"}, {}}, Ret: "i32",
+					Body: []ast.Stmt{s}},
+				&ast.FuncDecl{Name: "main", Ret: "", Body: []ast.Stmt{s, s}},
+				&ast.FuncDecl{},
+				nil,
+			}}
+			t.Run(fmt.Sprintf("expr%d/stmt%d", i, j), func(t *testing.T) {
+				CheckFile(f)
+				CheckFileCached(f, NewCache())
+			})
+		}
+	}
+	CheckFile(&ast.File{})
+}
+
+// FuzzParseAndCheck asserts that no source makes the parser or the checker
+// panic. The examples and a few broken fragments seed it; run it longer with
+// go test -fuzz=FuzzParseAndCheck ./internal/check.
+func FuzzParseAndCheck(f *testing.F) {
+	examples, _ := filepath.Glob("../../../examples/*.desi")
+	for _, path := range examples {
+		if src, err := os.ReadFile(path); err == nil {
+			f.Add(string(src))
+		}
+	}
+	for _, src := range []string{
+		"def main() -> i32:\n  let x = \n",
+		"def main() -> i32:\n  return (((1\n",
+		"def f(a: i32, a: str) -> :\n  a := f\"{a}{\n",
+		"def main() -> void:\n  io.printf(\"%d%s\", none, 1.5)\n  defer 1\n",
+		"def main() -> i32:\n\tif 1:\n  \t  return -(-(-\\\n1))\n",
+	} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		file, err := parser.New(src).ParseFile()
+		if err != nil {
+			return
+		}
+		CheckFile(file)
+	})
+}
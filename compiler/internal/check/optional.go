@@ -0,0 +1,42 @@
+package check
+
+import "github.com/desilang/desi/compiler/internal/ast"
+
+// NoneTest recognizes the conditions that narrow an optional: `x == none`
+// and `x != none` (either operand order). It returns the variable name and
+// whether the condition is true when x is none. The C emitter uses the same
+// rules as the checker, so both agree on where x is known not to be none.
+func NoneTest(cond ast.Expr) (name string, isNone, ok bool) {
+	be, ok := ast.Unparen(cond).(*ast.BinaryExpr)
+	if !ok || (be.Op != "==" && be.Op != "!=") {
+		return "", false, false
+	}
+	x, y := ast.Unparen(be.Left), ast.Unparen(be.Right)
+	if _, ok := x.(*ast.NoneLit); ok {
+		x, y = y, x
+	}
+	id, ok := x.(*ast.IdentExpr)
+	if !ok {
+		return "", false, false
+	}
+	if _, ok := y.(*ast.NoneLit); !ok {
+		return "", false, false
+	}
+	return id.Name, be.Op == "==", true
+}
+
+// NarrowsAfter reports whether the statements after st may treat the tested
+// optional as not none: st is `if x == none:` with no elif or else, and its
+// body returns.
+func NarrowsAfter(st *ast.IfStmt) bool {
+	_, isNone, ok := NoneTest(st.Cond)
+	if !ok || !isNone || len(st.Elifs) > 0 || st.Else != nil {
+		return false
+	}
+	for _, s := range st.Then {
+		if _, ok := s.(*ast.ReturnStmt); ok {
+			return true
+		}
+	}
+	return false
+}
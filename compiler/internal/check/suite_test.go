@@ -0,0 +1,440 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDiagnostics runs every checker error and warning path once. Each case
+// lists the diagnostics it must produce, in order and nothing else: errors
+// as substrings of Error(), warnings as substrings of String() (so
+// "W0001: ..." pins the code too).
+func TestDiagnostics(t *testing.T) {
+	cases := []struct {
+		name  string
+		src   string
+		errs  []string
+		warns []string
+	}{
+		// clean programs
+		{
+			name: "clean",
+			src:  "def add(a: i32, b: i32) -> i32:\n  return a + b\ndef main() -> i32:\n  let mut x = add(1, 2)\n  x := x + 1\n  return x\n",
+		},
+		{
+			name: "underscore names are never unused",
+			src:  "def f(_a: i32) -> void:\n  let _b = 1\n  return\n",
+		},
+
+		// declarations
+		{
+			name: "duplicate function",
+			src:  "def f() -> void:\n  return\ndef f() -> void:\n  return\n",
+			errs: []string{`duplicate function "f"`},
+		},
+		{
+			name: "duplicate parameter",
+			src:  "def f(a: i32, a: i32) -> i32:\n  return a\n",
+			errs: []string{`E0001: duplicate parameter "a" in f: parameter 2 repeats parameter 1 (rename it, e.g. "a2")`},
+		},
+		{
+			name: "redeclared local",
+			src:  "def main() -> void:\n  let x = 1\n  let x = 2\n  io.println(x)\n",
+			errs: []string{`redeclaration of "x"`},
+		},
+		{
+			name: "shadowing in an inner block is allowed",
+			src:  "def main() -> void:\n  let x = 1\n  if x > 0:\n    let x = \"s\"\n    io.println(x)\n  io.println(x)\n",
+		},
+
+		// bindings and immutability
+		{
+			name: "assign to immutable local",
+			src:  "def main() -> void:\n  let x = 1\n  x := 2\n  io.println(x)\n",
+			errs: []string{`cannot assign to immutable variable "x"`},
+		},
+		{
+			name: "assign to parameter",
+			src:  "def f(n: i32) -> i32:\n  n := n + 1\n  return n\n",
+			errs: []string{`E0002: cannot assign to parameter "n": parameters are immutable; copy it into a local first (let mut n2 = n)`},
+		},
+		{
+			name: "assign to undeclared",
+			src:  "def main() -> void:\n  t := 1\n",
+			errs: []string{`assign to undeclared variable "t"`},
+		},
+		{
+			name: "assign changes kind",
+			src:  "def main() -> void:\n  let mut s = 1\n  s := \"x\"\n  io.println(s)\n",
+			errs: []string{`type mismatch: "s" is int but assigned str`},
+		},
+		{
+			name:  "let none",
+			src:   "def main() -> void:\n  let z = none\n  io.println(1)\n",
+			errs:  []string{"let z = none: cannot infer an optional type"},
+			warns: []string{`W0001: unused variable or parameter "z"`},
+		},
+		{
+			name: "undeclared identifier",
+			src:  "def main() -> void:\n  io.println(y)\n",
+			errs: []string{`use of undeclared identifier "y"`, "io.println arg 1 has unsupported kind unknown"},
+		},
+
+		// returns
+		{
+			name: "missing return value",
+			src:  "def f() -> i32:\n  return\n",
+			errs: []string{"missing return value; function returns int"},
+		},
+		{
+			name: "return value from void",
+			src:  "def f() -> void:\n  return 1\n",
+			errs: []string{"return value in function returning void"},
+		},
+		{
+			name: "return kind mismatch",
+			src:  "def f() -> i32:\n  return \"x\"\n",
+			errs: []string{"return kind mismatch: have int, got str"},
+		},
+		{
+			name:  "fall through",
+			src:   "def f(a: i32) -> i32:\n  if a > 0:\n    return 1\n",
+			warns: []string{`W0006: function "f" returns int but may fall through without an explicit return`},
+		},
+		{
+			name:  "unreachable",
+			src:   "def f() -> i32:\n  return 1\n  io.println(2)\n",
+			warns: []string{"W0004: unreachable code: statement after return"},
+		},
+
+		// unused
+		{
+			name:  "unused local and parameter",
+			src:   "def f(a: i32) -> void:\n  let b = 1\n  return\n",
+			warns: []string{`W0001: unused variable or parameter "a"`, `W0001: unused variable or parameter "b"`},
+		},
+		{
+			name:  "written but never read",
+			src:   "def main() -> void:\n  let mut x = 1\n  x := 2\n",
+			warns: []string{`W0001: unused variable or parameter "x"`},
+		},
+
+		// conditions
+		{
+			name: "if condition",
+			src:  "def main() -> void:\n  if \"s\":\n    return\n",
+			errs: []string{"if-condition must be bool/int, got str"},
+		},
+		{
+			name: "elif condition",
+			src:  "def main() -> void:\n  if true:\n    return\n  elif \"s\":\n    return\n",
+			errs: []string{"elif-condition must be bool/int, got str"},
+		},
+		{
+			name: "while condition",
+			src:  "def main() -> void:\n  while \"s\":\n    return\n",
+			errs: []string{"while-condition must be bool/int, got str"},
+		},
+
+		// defer
+		{
+			name: "nested defer",
+			src:  "def main() -> void:\n  if true:\n    defer io.println(1)\n",
+			errs: []string{"defer is only allowed at function top-level in Stage-0"},
+		},
+		{
+			name:  "defer argument assigned later",
+			src:   "def main() -> void:\n  let mut x = 1\n  defer io.println(x + 1)\n  x := 2\n  x := 3\n",
+			warns: []string{`W0007: "x" changes after the defer at line 3 reads it`},
+		},
+		{
+			name: "defer argument never assigned again, or passed by reference",
+			src: "def bump(mut n: i32) -> void:\n  n := n + 1\n" +
+				"def main() -> void:\n  let mut x = 1\n  let mut y = 1\n  defer io.println(x)\n  defer bump(y)\n  y := 2\n",
+		},
+
+		// with
+		{
+			name: "with on an optional file",
+			src:  "def main() -> void:\n  with fs.open(\"a\") as f:\n    fs.close(f)\n",
+		},
+		{
+			name:  "with needs a resource",
+			src:   "def main() -> void:\n  with 1 as f:\n    return\n",
+			errs:  []string{"with needs a resource (file or file?), got int"},
+			warns: []string{`W0001: unused variable or parameter "f"`},
+		},
+		{
+			name: "with name used after the block",
+			src:  "def main() -> void:\n  with fs.open(\"a\") as f:\n    fs.close(f)\n  fs.close(f)\n",
+			errs: []string{`use of "f" after its with block (line 2) ended`},
+		},
+		{
+			name: "with variable used after the block, then reopened",
+			src: "def main() -> void:\n  let mut h = fs.open(\"a\")\n  with h as f:\n    fs.close(f)\n" +
+				"  if h == none:\n    return\n  h := fs.open(\"b\")\n  if h != none:\n    fs.close(h)\n",
+			errs: []string{`use of "h" after the with at line 3 closed it`},
+		},
+
+		// file ownership
+		{
+			name:  "file never closed",
+			src:   "def main() -> void:\n  let f = fs.create(\"a\")\n  if f != none:\n    fs.write(f, \"x\")\n",
+			warns: []string{`W0008: file "f" is never closed`},
+		},
+		{
+			name: "file closed, deferred, returned or handed on",
+			src: "def keep(_f: file?) -> void:\n  return\n" +
+				"def open() -> file?:\n  let f = fs.open(\"a\")\n  return f\n" +
+				"def main() -> void:\n  let a = fs.open(\"a\")\n  if a == none:\n    return\n  defer fs.close(a)\n  let b = open()\n  keep(b)\n" +
+				"  let c = fs.open(\"c\")\n  let d = c\n  with d as f:\n    fs.write(f, \"x\")\n",
+		},
+		{
+			name:  "file reassigned before close",
+			src:   "def main() -> void:\n  let mut f = fs.open(\"a\")\n  f := fs.open(\"b\")\n  with f as g:\n    fs.close(g)\n",
+			warns: []string{`W0008: file "f" is reassigned before it is closed`},
+		},
+		{
+			name:  "opened file discarded",
+			src:   "def main() -> void:\n  fs.append(\"log\")\n",
+			warns: []string{"W0008: the file this call opens is discarded"},
+		},
+
+		// bytes
+		{
+			name:  "bytes has no operators",
+			src:   "def main() -> void:\n  let b = bytes.from_file(\"a\")\n  if b != none:\n    let n = bytes.len(b + b)\n",
+			errs:  []string{"operator + on bytes and bytes: bytes has no operators"},
+			warns: []string{`W0001: unused variable or parameter "n"`},
+		},
+		{
+			name: "bytes is not str",
+			src:  "def main() -> void:\n  let b = bytes.from_file(\"a\")\n  if b != none:\n    io.println(str.len(bytes.slice(b, 0, 1)))\n",
+			errs: []string{"str.len: s must be str, got bytes"},
+		},
+
+		// spawn and chan
+		{
+			name: "spawn with a chan",
+			src:  "def work(n: i32, out: chan[int]) -> void:\n  chan.send(out, n * 2)\ndef main() -> void:\n  let ch = chan[int].bounded(1)\n  spawn work(21, ch)\n  let v = chan.recv(ch)\n  if v != none:\n    io.println(v)\n",
+		},
+		{
+			name: "spawned function returns a value",
+			src:  "def f() -> i32:\n  return 1\ndef main() -> void:\n  spawn f()\n",
+			errs: []string{"spawn f: it returns int, which no one would receive; make it return void and send the result over a chan"},
+		},
+		{
+			name: "spawn with a mut parameter",
+			src:  "def f(mut n: i32) -> void:\n  n := n + 1\ndef main() -> void:\n  let mut x = 1\n  spawn f(x)\n  io.println(x)\n",
+			errs: []string{"spawn f: arg 1 is passed to a mut parameter, but a task cannot share a variable with its spawner"},
+		},
+		{
+			name: "spawn a std function",
+			src:  "def main() -> void:\n  spawn io.println(1)\n",
+			errs: []string{"spawn needs a call of a function of this program"},
+		},
+		{
+			name: "chan element kinds",
+			src:  "def main() -> void:\n  let _ch = chan[f64].bounded(1)\n",
+			errs: []string{"chan[f64]: a chan carries int, str or bool values"},
+		},
+		{
+			name: "send of the wrong kind",
+			src:  "def main() -> void:\n  let ch = chan[str].bounded(1)\n  chan.send(ch, 1)\n",
+			errs: []string{"chan.send: v must be str for a chan[str], got int"},
+		},
+		{
+			name: "recv is optional",
+			src:  "def main() -> void:\n  let ch = chan[int].bounded(1)\n  let _n = chan.recv(ch) + 1\n",
+			errs: []string{"operator + on int? and int"},
+		},
+		{
+			name: "chan has no operators",
+			src:  "def main() -> void:\n  let ch = chan[int].bounded(1)\n  chan.close(ch + 1)\n",
+			errs: []string{"operator + on chan[int] and int: a chan has no operators"},
+		},
+
+		// sync
+		{
+			name: "shared counter",
+			src:  "def bump(hits: atomic, m: mutex) -> void:\n  sync.lock(m)\n  sync.add(hits, 1)\n  sync.unlock(m)\ndef main() -> void:\n  let hits = sync.atomic(0)\n  spawn bump(hits, sync.mutex())\n  if sync.cas(hits, 1, 0):\n    io.println(sync.load(hits))\n",
+		},
+		{
+			name: "atomic is not int",
+			src:  "def main() -> void:\n  let a = sync.atomic(0)\n  let _n = a + 1\n  sync.lock(a)\n",
+			errs: []string{
+				"operator + on atomic and int: mutex and atomic have no operators (use the std.sync functions, e.g. sync.load)",
+				"sync.lock: m must be mutex, got atomic",
+			},
+		},
+
+		// os.on_interrupt
+		{
+			name: "interrupt handler",
+			src:  "def cleanup() -> void:\n  io.println(\"bye\")\ndef main() -> void:\n  os.on_interrupt(cleanup)\n",
+		},
+		{
+			name: "interrupt handler takes arguments",
+			src:  "def cleanup(code: i32) -> i32:\n  return code\ndef main() -> void:\n  os.on_interrupt(cleanup)\n",
+			errs: []string{"os.on_interrupt: cleanup must take no arguments and return void"},
+		},
+		{
+			name: "interrupt handler is not a function",
+			src:  "def cleanup() -> void:\n  return\ndef main() -> void:\n  let f = 1\n  os.on_interrupt(f)\n  os.on_interrupt(cleanup())\n",
+			errs: []string{
+				"os.on_interrupt: the argument must name a function of this program, e.g. os.on_interrupt(cleanup)",
+				"os.on_interrupt: the argument must name a function of this program",
+			},
+			warns: []string{`W0001: unused variable or parameter "f"`},
+		},
+
+		// ranges
+		{
+			name: "range outside a for loop",
+			src:  "def main() -> void:\n  let n = 3\n  let _r = 0..n\n  let _s = \"a\"..=n\n",
+			errs: []string{
+				"a range is not a value: ranges are for `for` loops, which Stage-0 does not have yet",
+				"range bounds must be integers, got str and int",
+			},
+		},
+
+		// os.at_exit
+		{
+			name: "exit hook",
+			src:  "def flush() -> void:\n  io.println(\"bye\")\ndef main() -> void:\n  os.at_exit(flush)\n  defer io.println(\"last\")\n  os.exit(1)\n",
+		},
+		{
+			name: "exit hook returns a value",
+			src:  "def flush() -> i32:\n  return 0\ndef main() -> void:\n  os.at_exit(flush)\n  os.at_exit()\n",
+			errs: []string{
+				"os.at_exit: flush must take no arguments and return void",
+				"os.at_exit: want 1 arg (a function), got 0",
+			},
+		},
+
+		// calls
+		{
+			name: "unknown function",
+			src:  "def main() -> void:\n  nope()\n",
+			errs: []string{`call to unknown function "nope"`},
+		},
+		{
+			name: "unknown module",
+			src:  "def main() -> void:\n  util.nope()\n",
+			errs: []string{`call to unknown function "util.nope": no module util is loaded (missing import?)`},
+		},
+		{
+			name: "unknown builtin",
+			src:  "def main() -> void:\n  time.nope()\n",
+			errs: []string{`call to unknown function "time.nope": std.time has no function nope`},
+		},
+		{
+			name: "arity",
+			src:  "def add(a: i32, b: i32) -> i32:\n  return a + b\ndef main() -> void:\n  io.println(add(1))\n",
+			errs: []string{"call to add: want 2 args, got 1"},
+		},
+		{
+			name: "argument kind",
+			src:  "def add(a: i32, b: i32) -> i32:\n  return a + b\ndef main() -> void:\n  io.println(add(1, \"x\"))\n",
+			errs: []string{"call to add: arg 2 kind mismatch (want int, got str)"},
+		},
+		{
+			name: "mut argument must be a variable",
+			src:  "def bump(mut n: i32) -> void:\n  n := n + 1\ndef main() -> void:\n  bump(1)\n",
+			errs: []string{"call to bump: arg 1 is passed to a mut parameter and must be a variable"},
+		},
+		{
+			name: "mut argument must be mutable",
+			src:  "def bump(mut n: i32) -> void:\n  n := n + 1\ndef main() -> void:\n  let x = 1\n  bump(x)\n",
+			errs: []string{`call to bump: arg 1 "x" is passed to a mut parameter but is immutable (declare it with let mut)`},
+		},
+		{
+			name: "mut aliasing",
+			src:  "def swap(mut a: i32, mut b: i32) -> void:\n  let t = a\n  a := b\n  b := t\ndef main() -> void:\n  let mut x = 1\n  swap(x, x)\n",
+			errs: []string{`call to swap: "x" is passed to mut parameters 1 and 2 (aliasing)`},
+		},
+
+		// builtins
+		{
+			name: "println of void",
+			src:  "def f() -> void:\n  return\ndef main() -> void:\n  io.println(f())\n",
+			errs: []string{"io.println arg 1 is void (no value)"},
+		},
+		{
+			name: "read_all arity",
+			src:  "def main() -> void:\n  fs.read_all()\n",
+			errs: []string{"fs.read_all: want 1 arg (path: str), got 0"},
+		},
+		{
+			name: "read_all path",
+			src:  "def main() -> void:\n  fs.read_all(1)\n",
+			errs: []string{"fs.read_all: path must be str, got int"},
+		},
+		{
+			name: "exit arity",
+			src:  "def main() -> void:\n  os.exit()\n",
+			errs: []string{"os.exit: want 1 arg (code: int), got 0"},
+		},
+		{
+			name: "exit code",
+			src:  "def main() -> void:\n  os.exit(\"x\")\n",
+			errs: []string{"os.exit: code must be int, got str"},
+		},
+		{
+			name: "builtin without args given one",
+			src:  "def main() -> void:\n  io.println(time.now_ms(1))\n",
+			errs: []string{"time.now_ms: want 0 args, got 1"},
+		},
+		{
+			name: "builtin param kind",
+			src:  "def main() -> void:\n  time.sleep_ms(\"x\")\n",
+			errs: []string{"time.sleep_ms: ms must be int, got str"},
+		},
+		{
+			name: "printf without format",
+			src:  "def main() -> void:\n  io.printf()\n",
+			errs: []string{"io.printf: missing format string"},
+		},
+
+		// literals and operators
+		{
+			name: "literal overflow",
+			src:  "def main() -> void:\n  let n = 2147483648\n  io.println(n)\n",
+			errs: []string{"let n: integer literal 2147483648 overflows i32"},
+		},
+		{
+			name: "optional arithmetic",
+			src:  "def main() -> void:\n  let t = fs.read_all(\"f\")\n  if t + 1:\n    return\n",
+			errs: []string{"operator + on str? and int: optionals can only be compared with none"}, // no cascade into the if
+		},
+		{
+			name: "str arithmetic",
+			src:  "def main() -> void:\n  let mut s = \"a\"\n  s += \"b\"\n  s := s * 2\n",
+			errs: []string{
+				"operator + on str and str: str has no arithmetic (build strings with an f-string",
+				"operator * on str and int: str has no arithmetic",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs, warns := CheckFile(parse(t, tc.src))
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("errors = %v, want %d: %q", errs, len(tc.errs), tc.errs)
+			}
+			for i, want := range tc.errs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+			if len(warns) != len(tc.warns) {
+				t.Fatalf("warnings = %v, want %d: %q", warns, len(tc.warns), tc.warns)
+			}
+			for i, want := range tc.warns {
+				if !strings.Contains(warns[i].String(), want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, warns[i], want)
+				}
+			}
+		})
+	}
+}
@@ -2,22 +2,37 @@ package c
 
 import (
   "bytes"
+  "slices"
   "strconv"
   "strings"
 
   "github.com/desilang/desi/compiler/internal/ast"
   "github.com/desilang/desi/compiler/internal/check"
+  "github.com/desilang/desi/compiler/internal/lexer"
   "github.com/desilang/desi/compiler/internal/term"
 )
 
 // ---- public entry ----
 
+// Options configures EmitFileWith.
+type Options struct {
+  // Source, if set, returns the text to show for line of file (a
+  // FuncDecl.File); the C of each function and statement is then preceded
+  // by it as a comment. "" shows nothing.
+  Source func(file string, line int) string
+}
+
 func EmitFile(f *ast.File, info *check.Info) string {
+  return EmitFileWith(f, info, Options{})
+}
+
+// EmitFileWith is EmitFile with options.
+func EmitFileWith(f *ast.File, info *check.Info, o Options) string {
   var b bytes.Buffer
   term.Wprintf(&b, "/* generated by desic (Stage-0) */\n")
   term.Wprintf(&b, "#include <stdint.h>\n")
   term.Wprintf(&b, "#include <stdio.h>\n")
-  term.Wprintf(&b, "#include <string.h>\n") // for strcmp on strings
+  term.Wprintf(&b, "#include <string.h>\n")
   term.Wprintf(&b, "#include \"desi_std.h\"\n\n")
 
   sigs := collectFuncSigs(f)
@@ -26,24 +41,36 @@ func EmitFile(f *ast.File, info *check.Info) string {
   for _, d := range f.Decls {
     if fn, ok := d.(*ast.FuncDecl); ok && fn.Name != "main" {
       term.Wprintf(&b, "static %s %s(%s);\n",
-        cType(sigs[fn.Name].ret), fn.Name, cParamList(fn))
+        cType(sigs[fn.Name].ret), sigs[fn.Name].cname, cParamList(fn))
     }
   }
   if len(sigs) > 0 {
     term.Wprintf(&b, "\n")
   }
 
-  // Definitions (non-main first)
+  // Definitions (non-main first), after the string literals and spawn
+  // trampolines they use
+  var body bytes.Buffer
+  lits := &strLits{names: map[string]string{}}
+  var tasks spawnTasks
   for _, d := range f.Decls {
     if fn, ok := d.(*ast.FuncDecl); ok && fn.Name != "main" {
-      emitFunc(&b, fn, sigs, info, false)
-      term.Wprintf(&b, "\n")
+      emitFunc(&body, fn, sigs, info, o, lits, &tasks, false)
+      term.Wprintf(&body, "\n")
     }
   }
   // Main last
   if m := findMain(f); m != nil {
-    emitFunc(&b, m, sigs, info, true)
+    emitFunc(&body, m, sigs, info, o, lits, &tasks, true)
   }
+  for _, def := range lits.defs {
+    term.Wprintf(&b, "%s\n", def)
+  }
+  if len(lits.defs) > 0 {
+    term.Wprintf(&b, "\n")
+  }
+  b.Write(tasks.defs.Bytes())
+  b.Write(body.Bytes())
   return b.String()
 }
 
@@ -52,18 +79,126 @@ func EmitFile(f *ast.File, info *check.Info) string {
 type sig struct {
   ret    string // "int"|"str"|"void"
   params []string
+  muts   []bool // by-reference `mut` params, passed as pointers
+  module string // qualifier for module.f(...) calls
+  reexports []string // facade qualifiers that also reach it (`pub import`)
+  cname  string // name of the C function (see cName)
+}
+
+// cName is the C name fn would like. Functions of imported modules are
+// prefixed by their package (util.text's width → util_text__width), so they
+// cannot clash with libc or the runtime. Names containing __ are otherwise
+// escaped (cReserved), so entry-file functions and locals stay out of that
+// namespace; collectFuncSigs renames what still collides, like a__b.c and
+// a.b__c.
+func cName(fn *ast.FuncDecl) string {
+  if fn.Package == "" {
+    return cIdent(fn.Name)
+  }
+  return strings.ReplaceAll(fn.Package, ".", "_") + "__" + fn.Name
+}
+
+// cKeywords are C's reserved words. Desi code can use most of them as names
+// directly (int, char) and the rest as raw identifiers (r#while).
+var cKeywords = map[string]bool{
+  "auto": true, "break": true, "case": true, "char": true, "const": true,
+  "continue": true, "default": true, "do": true, "double": true, "else": true,
+  "enum": true, "extern": true, "float": true, "for": true, "goto": true,
+  "if": true, "inline": true, "int": true, "long": true, "register": true,
+  "restrict": true, "return": true, "short": true, "signed": true,
+  "sizeof": true, "static": true, "struct": true, "switch": true,
+  "typedef": true, "union": true, "unsigned": true, "void": true,
+  "volatile": true, "while": true, "bool": true, "true": true, "false": true,
+}
+
+// cLibNames are names the generated C, its headers (stdint.h, stdio.h,
+// string.h, and stddef.h and stdarg.h through desi_std.h) or the runtime in
+// an amalgamated file (which adds pthreads and stdatomic.h) already use. A Desi name equal
+// to one would redefine or shadow it; `let printf = 1` must not break the
+// printf calls io.println lowers to.
+var cLibNames = map[string]bool{
+  "NULL": true, "EOF": true, "FILE": true, "BUFSIZ": true,
+  "size_t": true, "ptrdiff_t": true, "wchar_t": true, "offsetof": true,
+  "va_list": true, "va_start": true, "va_arg": true, "va_end": true, "va_copy": true,
+  "int8_t": true, "int16_t": true, "int32_t": true, "int64_t": true,
+  "uint8_t": true, "uint16_t": true, "uint32_t": true, "uint64_t": true,
+  "intptr_t": true, "uintptr_t": true, "intmax_t": true, "uintmax_t": true,
+  "INT8_MAX": true, "INT16_MAX": true, "INT32_MAX": true, "INT64_MAX": true,
+  "INT8_MIN": true, "INT16_MIN": true, "INT32_MIN": true, "INT64_MIN": true,
+  "UINT8_MAX": true, "UINT16_MAX": true, "UINT32_MAX": true, "UINT64_MAX": true,
+  "SIZE_MAX": true, "INT64_C": true, "UINT64_C": true,
+  "stdin": true, "stdout": true, "stderr": true,
+  "printf": true, "fprintf": true, "sprintf": true, "snprintf": true,
+  "vprintf": true, "vfprintf": true, "vsnprintf": true, "puts": true,
+  "putchar": true, "fputs": true, "fputc": true, "putc": true, "getchar": true,
+  "fgets": true, "fgetc": true, "getc": true, "fopen": true, "fclose": true,
+  "fread": true, "fwrite": true, "fflush": true, "fseek": true, "ftell": true,
+  "remove": true, "rename": true, "perror": true, "popen": true, "pclose": true,
+  "fileno": true, "isatty": true, "atexit": true,
+  "pthread_t": true, "pthread_attr_t": true, "pthread_mutex_t": true, "pthread_cond_t": true,
+  "pthread_create": true, "pthread_attr_init": true, "pthread_attr_destroy": true,
+  "pthread_attr_setdetachstate": true, "pthread_mutex_init": true, "pthread_mutex_lock": true,
+  "pthread_mutex_unlock": true, "pthread_cond_init": true, "pthread_cond_wait": true,
+  "pthread_cond_signal": true, "pthread_cond_broadcast": true,
+  "atomic_init": true, "atomic_load": true, "atomic_store": true, "atomic_fetch_add": true,
+  "atomic_compare_exchange_strong": true,
+  "strlen": true, "strcmp": true, "strncmp": true, "strcpy": true, "strncpy": true,
+  "strcat": true, "strchr": true, "strrchr": true, "strstr": true, "strdup": true,
+  "memcpy": true, "memmove": true, "memset": true, "memcmp": true, "memchr": true,
+  "malloc": true, "calloc": true, "realloc": true, "free": true, "exit": true,
+  "abort": true, "getenv": true, "system": true, "errno": true,
+}
+
+// cIdent is the C spelling of a Desi local, parameter or function name.
+// Names C or the included headers use (int, printf, int64_t), names in the
+// runtime's desi_ namespace, and names C reserves for itself (_Bool,
+// __func__) get a trailing underscore. So does any name that already ends
+// in one, which keeps the spelling one-to-one: int → int_, int_ → int__.
+func cIdent(name string) string {
+  if cKeywords[name] || cLibNames[name] || cReserved(name) || strings.HasSuffix(name, "_") {
+    return name + "_"
+  }
+  return name
+}
+
+// cReserved reports names in a namespace Desi code must not enter: the
+// runtime's (desi_, DESI_), C's own (_ then a capital, or a leading __) and
+// that of module functions (any __, see cName).
+func cReserved(name string) bool {
+  if strings.HasPrefix(name, "desi_") || strings.HasPrefix(name, "DESI_") || strings.Contains(name, "__") {
+    return true
+  }
+  return len(name) > 1 && name[0] == '_' && name[1] >= 'A' && name[1] <= 'Z'
 }
 
+// collectFuncSigs builds the emitter's function table. Every function gets
+// the C name cName asks for unless an earlier one has it; then it gets the
+// first free name with a _2, _3, ... suffix that no function asked for.
 func collectFuncSigs(f *ast.File) map[string]sig {
   m := make(map[string]sig)
+  wanted := map[string]bool{}
+  for _, d := range f.Decls {
+    if fn, ok := d.(*ast.FuncDecl); ok {
+      wanted[cName(fn)] = true
+    }
+  }
+  used := map[string]bool{}
   for _, d := range f.Decls {
     fn, ok := d.(*ast.FuncDecl)
     if !ok {
       continue
     }
-    s := sig{ret: typeToKind(fn.Ret)}
+    cname := cName(fn)
+    for n := 2; used[cname]; n++ {
+      if c := cName(fn) + "_" + strconv.Itoa(n); !wanted[c] {
+        cname = c
+      }
+    }
+    used[cname] = true
+    s := sig{ret: typeToKind(fn.Ret), module: fn.Module, reexports: fn.Reexports, cname: cname}
     for _, p := range fn.Params {
       s.params = append(s.params, typeToKind(p.Type))
+      s.muts = append(s.muts, p.Mut)
     }
     m[fn.Name] = s
   }
@@ -81,13 +216,37 @@ func findMain(f *ast.File) *ast.FuncDecl {
 
 func typeToKind(t string) string {
   t = strings.TrimSpace(strings.ToLower(t))
+  if base, ok := strings.CutSuffix(t, "?"); ok {
+    switch k := typeToKind(base); k {
+    case "str", "file", "bytes":
+      return k + "?"
+    }
+    return "int?" // int?, i64?, bool?: payload is int64_t
+  }
+  if inner, ok := strings.CutPrefix(strings.ReplaceAll(t, " ", ""), "chan["); ok {
+    // "chan [ int ]" as parsed; bool travels as an int
+    if typeToKind(strings.TrimSuffix(inner, "]")) == "str" {
+      return "chan[str]"
+    }
+    return "chan[int]"
+  }
   switch t {
   case "", "void":
     return "void"
   case "i32", "int", "u32", "bool":
     return "int"
+  case "i64", "u64":
+    return "i64"
   case "str", "string":
     return "str"
+  case "f64":
+    return "f64"
+  case "file":
+    return "file"
+  case "bytes":
+    return "bytes"
+  case "mutex", "atomic":
+    return t
   default:
     return "int"
   }
@@ -97,8 +256,24 @@ func cType(kind string) string {
   switch kind {
   case "void":
     return "void"
-  case "str":
+  case "str", "str?":
     return "const char*"
+  case "file", "file?":
+    return "desi_file*"
+  case "bytes", "bytes?":
+    return "const desi_bytes*"
+  case "chan[int]", "chan[str]":
+    return "desi_chan*"
+  case "mutex":
+    return "desi_mutex*"
+  case "atomic":
+    return "desi_atomic*"
+  case "int?":
+    return "desi_opt_int"
+  case "i64":
+    return "int64_t"
+  case "f64":
+    return "double"
   default:
     return "int"
   }
@@ -107,7 +282,11 @@ func cType(kind string) string {
 func cParamList(fn *ast.FuncDecl) string {
   var parts []string
   for _, p := range fn.Params {
-    parts = append(parts, cType(typeToKind(p.Type))+" "+p.Name)
+    if p.Mut {
+      parts = append(parts, cType(typeToKind(p.Type))+" *"+cIdent(p.Name))
+      continue
+    }
+    parts = append(parts, cType(typeToKind(p.Type))+" "+cIdent(p.Name))
   }
   return strings.Join(parts, ", ")
 }
@@ -118,28 +297,45 @@ type env struct {
   fn      *ast.FuncDecl
   sigs    map[string]sig
   vars    map[string]string // name -> kind ("int"/"str")
+  refs    map[string]bool   // mut params: pointers, dereferenced on use
+  narrow  []map[string]bool // per block: optionals known not none (false: shadowed)
   retKind string
   defers  []ast.Expr // function-scope defers (LIFO)
+  closes  []string   // C statements closing the open with resources, outermost first
+  snaps   map[*ast.IdentExpr]snap // defer arguments, evaluated at the defer
+  source  func(file string, line int) string // Options.Source
+  lits    *strLits
+  tasks   *spawnTasks
+  main    bool // main's defers are exit hooks (see emitMainDefer)
 }
 
-func emitFunc(b *bytes.Buffer, fn *ast.FuncDecl, sigs map[string]sig, info *check.Info, isMain bool) {
+func emitFunc(b *bytes.Buffer, fn *ast.FuncDecl, sigs map[string]sig, info *check.Info, o Options, lits *strLits, tasks *spawnTasks, isMain bool) {
   e := &env{
     fn:      fn,
     sigs:    sigs,
     vars:    map[string]string{},
+    refs:    map[string]bool{},
+    narrow:  []map[string]bool{{}},
     retKind: typeToKind(fn.Ret),
     defers:  nil,
+    snaps:   map[*ast.IdentExpr]snap{},
+    source:  o.Source,
+    lits:    lits,
+    tasks:   tasks,
+    main:    isMain,
   }
   for _, p := range fn.Params {
     e.vars[p.Name] = typeToKind(p.Type)
+    e.refs[p.Name] = p.Mut
   }
 
   // signature
+  e.explain(b, "", fn.Pos)
   if isMain {
     term.Wprintf(b, "int main(void) {\n")
   } else {
     term.Wprintf(b, "static %s %s(%s) {\n",
-      cType(e.retKind), fn.Name, cParamList(fn))
+      cType(e.retKind), sigs[fn.Name].cname, cParamList(fn))
   }
 
   // body
@@ -165,8 +361,20 @@ func hasTailReturn(body []ast.Stmt) bool {
   return ok
 }
 
+// explain writes the source line at pos as a comment, if Options.Source
+// is set.
+func (e *env) explain(b *bytes.Buffer, ind string, pos ast.Pos) {
+  if e.source == nil || pos.Line == 0 {
+    return
+  }
+  if text := e.source(e.fn.File, pos.Line); text != "" {
+    term.Wprintf(b, "%s/* %s */\n", ind, strings.ReplaceAll(text, "*/", "* /"))
+  }
+}
+
 func emitStmt(b *bytes.Buffer, indent int, s ast.Stmt, e *env) {
   ind := spaces(indent)
+  e.explain(b, ind, ast.StmtPos(s))
   switch st := s.(type) {
   case *ast.LetStmt:
     cExpr, kind := cExprFor(st.Expr, e)
@@ -174,84 +382,156 @@ func emitStmt(b *bytes.Buffer, indent int, s ast.Stmt, e *env) {
       kind = "int"
     }
     e.vars[st.Name] = kind
-    term.Wprintf(b, "%s%s %s = %s;\n", ind, cType(kind), st.Name, cExpr)
+    e.narrow[len(e.narrow)-1][st.Name] = false // shadows any outer narrowing
+    term.Wprintf(b, "%s%s %s = %s;\n", ind, cType(kind), cIdent(st.Name), cExpr)
 
   case *ast.AssignStmt:
-    cExpr, _ := cExprFor(st.Expr, e)
-    term.Wprintf(b, "%s%s = %s;\n", ind, st.Name, cExpr)
+    cExpr, kind := cExprFor(st.Expr, e)
+    cExpr = coerce(cExpr, kind, e.vars[st.Name])
+    if kind == "none" || isOptKind(kind) {
+      e.unnarrow(st.Name)
+    }
+    lhs := cIdent(st.Name)
+    if e.refs[st.Name] {
+      lhs = "*" + lhs
+    }
+    term.Wprintf(b, "%s%s = %s;\n", ind, lhs, cExpr)
 
   case *ast.ExprStmt:
     emitCallOrExpr(b, indent, st.Expr, e)
 
   case *ast.ReturnStmt:
-    // Before returning, run defers in LIFO
-    if len(e.defers) > 0 {
-      emitDefers(b, indent, e)
+    ret := ""
+    switch {
+    case st.Expr != nil:
+      ret = returnValue(b, ind, st.Expr, e)
+    case e.retKind != "void":
+      ret = "0"
     }
-    if st.Expr == nil {
-      if e.retKind == "void" {
-        term.Wprintf(b, "%sreturn;\n", ind)
-      } else {
-        term.Wprintf(b, "%sreturn 0;\n", ind)
-      }
-      return
-    }
-    cExpr, kind := cExprFor(st.Expr, e)
-    if e.retKind == "int" && kind != "int" {
-      term.Wprintf(b, "%s/* non-int return; force 0 */\n", ind)
-      term.Wprintf(b, "%sreturn 0;\n", ind)
+    // The result is computed before closes and defers run, since they may
+    // close or change what it reads; a constant needs no saving.
+    if e.retKind != "void" && st.Expr != nil && !isConstArg(st.Expr) && len(e.closes)+len(e.defers) > 0 {
+      term.Wprintf(b, "%s{\n", ind)
+      term.Wprintf(b, "%s  %s desi_ret = %s;\n", ind, cType(e.retKind), ret)
+      emitExits(b, indent+2, e)
+      term.Wprintf(b, "%s  return desi_ret;\n", ind)
+      term.Wprintf(b, "%s}\n", ind)
       return
     }
-    if e.retKind == "str" && kind != "str" {
-      term.Wprintf(b, "%s/* non-str return; force \"\" */\n", ind)
-      term.Wprintf(b, "%sreturn \"\";\n", ind)
-      return
+    emitExits(b, indent, e)
+    if ret == "" {
+      term.Wprintf(b, "%sreturn;\n", ind)
+    } else {
+      term.Wprintf(b, "%sreturn %s;\n", ind, ret)
     }
-    term.Wprintf(b, "%sreturn %s;\n", ind, cExpr)
 
   case *ast.IfStmt:
     cond, _ := cExprFor(st.Cond, e)
-    term.Wprintf(b, "%sif (%s) {\n", ind, stripOuterParens(cond))
-    for _, s2 := range st.Then {
-      emitStmt(b, indent+2, s2, e)
+    // Narrow optionals exactly where the checker does (check.NoneTest).
+    tested, isNone, ok := check.NoneTest(st.Cond)
+    if !ok || !isOptKind(e.vars[tested]) || e.isNarrowed(tested) {
+      tested = ""
     }
+    term.Wprintf(b, "%sif (%s) {\n", ind, stripOuterParens(cond))
+    emitBlock(b, indent+2, st.Then, e, narrowIf(tested, !isNone))
     term.Wprintf(b, "%s}", ind)
     for _, el := range st.Elifs {
       ec, _ := cExprFor(el.Cond, e)
       term.Wprintf(b, " else if (%s) {\n", stripOuterParens(ec))
-      for _, s2 := range el.Body {
-        emitStmt(b, indent+2, s2, e)
-      }
+      emitBlock(b, indent+2, el.Body, e, "")
       term.Wprintf(b, "%s}", ind)
     }
     if st.Else != nil {
       term.Wprintf(b, " else {\n")
-      for _, s2 := range st.Else {
-        emitStmt(b, indent+2, s2, e)
-      }
+      emitBlock(b, indent+2, st.Else, e, narrowIf(tested, isNone && len(st.Elifs) == 0))
       term.Wprintf(b, "%s}\n", ind)
     } else {
       term.Wprintf(b, "\n")
     }
+    if tested != "" && check.NarrowsAfter(st) {
+      e.narrow[len(e.narrow)-1][tested] = true
+    }
 
   case *ast.WhileStmt:
     cond, _ := cExprFor(st.Cond, e)
     term.Wprintf(b, "%swhile (%s) {\n", ind, stripOuterParens(cond))
-    for _, s2 := range st.Body {
-      emitStmt(b, indent+2, s2, e)
-    }
+    emitBlock(b, indent+2, st.Body, e, "")
     term.Wprintf(b, "%s}\n", ind)
 
+  case *ast.WithStmt:
+    emitWith(b, indent, st, e)
+
   case *ast.DeferStmt:
-    // Stage-0: record function-scope defers (must be call expr, per checker)
-    e.defers = append(e.defers, st.Call)
+    // Stage-0: record function-scope defers (must be call expr, per checker).
+    // The arguments are evaluated now, the call runs at exit.
+    call := st.Call
+    if ce, ok := call.(*ast.CallExpr); ok {
+      call = snapshotArgs(b, ind, ce, e)
+    }
+    e.defers = append(e.defers, call)
+    if e.main {
+      emitMainDefer(b, ind, call, e)
+      break
+    }
     term.Wprintf(b, "%s/* defer scheduled */\n", ind)
 
+  case *ast.SpawnStmt:
+    if ce, ok := st.Call.(*ast.CallExpr); ok {
+      emitSpawn(b, ind, ce, e)
+    }
+
   default:
     term.Wprintf(b, "%s/* stmt not lowered */\n", ind)
   }
 }
 
+// emitBlock emits a nested block, with narrowed (if non-empty) known not to
+// be none inside it.
+func emitBlock(b *bytes.Buffer, indent int, body []ast.Stmt, e *env, narrowed string) {
+  level := map[string]bool{}
+  if narrowed != "" {
+    level[narrowed] = true
+  }
+  e.narrow = append(e.narrow, level)
+  for _, s := range body {
+    emitStmt(b, indent, s, e)
+  }
+  e.narrow = e.narrow[:len(e.narrow)-1]
+}
+
+func narrowIf(name string, cond bool) string {
+  if cond {
+    return name
+  }
+  return ""
+}
+
+// isNarrowed reports whether the optional name is known not to be none; the
+// innermost block that mentions name decides.
+func (e *env) isNarrowed(name string) bool {
+  for i := len(e.narrow) - 1; i >= 0; i-- {
+    if v, ok := e.narrow[i][name]; ok {
+      return v
+    }
+  }
+  return false
+}
+
+// unnarrow drops the narrowing of name after it may have been assigned none,
+// stopping at the block that declared it (mirrors check's scope.unnarrow).
+func (e *env) unnarrow(name string) {
+  for i := len(e.narrow) - 1; i >= 0; i-- {
+    v, ok := e.narrow[i][name]
+    if !ok {
+      continue
+    }
+    if !v {
+      return
+    }
+    delete(e.narrow[i], name)
+  }
+}
+
 func emitCallOrExpr(b *bytes.Buffer, indent int, expr ast.Expr, e *env) {
   ind := spaces(indent)
   // io.println(...)
@@ -264,18 +544,230 @@ func emitCallOrExpr(b *bytes.Buffer, indent int, expr ast.Expr, e *env) {
   term.Wprintf(b, "%s(void)(%s);\n", ind, cx)
 }
 
-func emitDefers(b *bytes.Buffer, indent int, e *env) {
+// returnValue lowers the value of a return statement to the function's
+// result kind.
+func returnValue(b *bytes.Buffer, ind string, x ast.Expr, e *env) string {
+  cExpr, kind := cExprFor(x, e)
+  switch {
+  case isOptKind(e.retKind):
+    return coerce(cExpr, kind, e.retKind)
+  case e.retKind == "int" && !isIntKind(kind):
+    term.Wprintf(b, "%s/* non-int return; force 0 */\n", ind)
+    return "0"
+  case e.retKind == "str" && kind != "str":
+    term.Wprintf(b, "%s/* non-str return; force \"\" */\n", ind)
+    return `""`
+  }
+  return cExpr
+}
+
+// emitExits runs what a return must do before it leaves: close the open
+// with resources, innermost first, then run the defers.
+func emitExits(b *bytes.Buffer, indent int, e *env) {
   ind := spaces(indent)
-  for i := len(e.defers) - 1; i >= 0; i-- {
-    call := e.defers[i]
-    // println special-case
-    if ce, ok := call.(*ast.CallExpr); ok && isIoPrintln(ce) {
-      emitPrintln(b, indent, ce, e)
+  for i := len(e.closes) - 1; i >= 0; i-- {
+    term.Wprintf(b, "%s%s\n", ind, e.closes[i])
+  }
+  if len(e.defers) > 0 {
+    emitDefers(b, indent, e)
+  }
+}
+
+// closers are the runtime functions that release each resource kind.
+var closers = map[string]string{
+  "file": "desi_fs_close",
+}
+
+// emitWith lowers `with x as f:` to a C block holding f, closed when the
+// body ends and before every return inside it. For an optional resource the
+// body runs only if it is not none.
+func emitWith(b *bytes.Buffer, indent int, st *ast.WithStmt, e *env) {
+  ind := spaces(indent)
+  cx, kind := cExprFor(st.Expr, e)
+  elem := strings.TrimSuffix(kind, "?")
+  name := cIdent(st.Name)
+  closeStmt := closers[elem] + "(" + name + ");"
+  term.Wprintf(b, "%s{\n", ind)
+  term.Wprintf(b, "%s  %s %s = %s;\n", ind, cType(kind), name, cx)
+  inner := indent + 2
+  if isOptKind(kind) {
+    term.Wprintf(b, "%s  if (%s != NULL) {\n", ind, name)
+    inner += 2
+  }
+  prevKind, hadKind := e.vars[st.Name]
+  prevRef := e.refs[st.Name]
+  e.vars[st.Name], e.refs[st.Name] = elem, false
+  e.closes = append(e.closes, closeStmt)
+  emitBlock(b, inner, st.Body, e, "")
+  e.closes = e.closes[:len(e.closes)-1]
+  if !hasTailReturn(st.Body) {
+    term.Wprintf(b, "%s%s\n", spaces(inner), closeStmt)
+  }
+  if isOptKind(kind) {
+    term.Wprintf(b, "%s  }\n", ind)
+  }
+  term.Wprintf(b, "%s}\n", ind)
+  if hadKind {
+    e.vars[st.Name] = prevKind
+  } else {
+    delete(e.vars, st.Name)
+  }
+  e.refs[st.Name] = prevRef
+}
+
+// snap is a defer argument saved in a C local at the defer statement.
+type snap struct{ cname, kind string }
+
+// snapshotArgs saves the arguments of a deferred call in locals, like Go:
+// `defer f(x)` calls f with x as it is at the defer, whatever x holds at
+// exit. It returns the call with those arguments replaced by the locals.
+// Constants need no saving, and an argument for a mut parameter is a
+// reference, so the call sees the variable as it is at exit. In main the
+// saves are statics instead, which its exit hooks can reach, and a mut
+// argument is saved as the variable's address.
+func snapshotArgs(b *bytes.Buffer, ind string, call *ast.CallExpr, e *env) *ast.CallExpr {
+  fs, _ := e.userSig(call)
+  muts := fs.muts
+  out := &ast.CallExpr{Callee: call.Callee}
+  for i, a := range call.Args {
+    ref, isRef := ast.Unparen(a).(*ast.IdentExpr)
+    isRef = isRef && i < len(muts) && muts[i]
+    if isConstArg(a) || isRef && !e.main {
+      out.Args = append(out.Args, a)
       continue
     }
-    cx, _ := cExprFor(call, e)
-    term.Wprintf(b, "%s/* defer */ (void)(%s);\n", ind, cx)
+    s := snap{cname: "desi_defer_" + strconv.Itoa(len(e.snaps))}
+    if isRef {
+      s.kind = e.vars[ref.Name]
+      term.Wprintf(&e.tasks.defs, "static %s* %s;\n", cType(s.kind), s.cname)
+      term.Wprintf(b, "%s%s = &%s;\n", ind, s.cname, cIdent(ref.Name))
+    } else {
+      cx, kind := cExprFor(a, e)
+      if kind == "" {
+        kind = "int"
+      }
+      s.kind = kind
+      if e.main {
+        term.Wprintf(&e.tasks.defs, "static %s %s;\n", cType(kind), s.cname)
+        term.Wprintf(b, "%s%s = %s;\n", ind, s.cname, cx)
+      } else {
+        term.Wprintf(b, "%s%s %s = %s;\n", ind, cType(kind), s.cname, cx)
+      }
+    }
+    id := &ast.IdentExpr{Name: s.cname}
+    e.snaps[id] = s
+    out.Args = append(out.Args, id)
+  }
+  return out
+}
+
+// userSig returns the signature of the user function call calls, if it
+// calls one (mirrors check's userSig).
+func (e *env) userSig(call *ast.CallExpr) (sig, bool) {
+  switch f := call.Callee.(type) {
+  case *ast.IdentExpr:
+    fs, ok := e.sigs[f.Name]
+    return fs, ok
+  case *ast.FieldExpr:
+    if id, ok := f.X.(*ast.IdentExpr); ok {
+      if fs, ok := e.sigs[f.Name]; ok && (fs.module == id.Name || slices.Contains(fs.reexports, id.Name)) && e.vars[id.Name] == "" {
+        return fs, true
+      }
+    }
   }
+  return sig{}, false
+}
+
+// spawnTasks collects a file's spawn trampolines (see emitSpawn) and main's
+// exit hooks (see emitMainDefer), which are written ahead of the functions.
+type spawnTasks struct {
+  defs  bytes.Buffer
+  n     int
+  hooks int
+}
+
+// emitSpawn lowers `spawn f(args)`. Each site gets a trampoline that
+// desi_spawn runs on the new thread: it unpacks a struct of the arguments,
+// evaluated here at the spawn, and calls f with them. The runtime copies
+// the struct, so the site can build it on the stack.
+func emitSpawn(b *bytes.Buffer, ind string, call *ast.CallExpr, e *env) {
+  fs, ok := e.userSig(call)
+  if !ok {
+    return // the checker rejects it
+  }
+  name := "desi_spawn_" + strconv.Itoa(e.tasks.n)
+  e.tasks.n++
+  var fields, params, args []string
+  for i, a := range call.Args {
+    if i >= len(fs.params) {
+      break
+    }
+    ax, k := cExprFor(a, e)
+    f := "a" + strconv.Itoa(i)
+    fields = append(fields, cType(fs.params[i])+" "+f+";")
+    params = append(params, "desi_a->"+f)
+    args = append(args, coerce(ax, k, fs.params[i]))
+  }
+  if len(args) == 0 {
+    term.Wprintf(&e.tasks.defs, "static void %s(void* desi_p) {\n  (void)desi_p;\n  %s();\n}\n\n", name, fs.cname)
+    term.Wprintf(b, "%sdesi_spawn(%s, NULL, 0);\n", ind, name)
+    return
+  }
+  term.Wprintf(&e.tasks.defs, "struct %s_args { %s };\n\n", name, strings.Join(fields, " "))
+  term.Wprintf(&e.tasks.defs, "static void %s(void* desi_p) {\n", name)
+  term.Wprintf(&e.tasks.defs, "  struct %s_args* desi_a = (struct %s_args*)desi_p;\n", name, name)
+  term.Wprintf(&e.tasks.defs, "  %s(%s);\n}\n\n", fs.cname, strings.Join(params, ", "))
+  term.Wprintf(b, "%s{\n", ind)
+  term.Wprintf(b, "%s  struct %s_args desi_args = {%s};\n", ind, name, strings.Join(args, ", "))
+  term.Wprintf(b, "%s  desi_spawn(%s, &desi_args, sizeof desi_args);\n", ind, name)
+  term.Wprintf(b, "%s}\n", ind)
+}
+
+// isConstArg reports whether a is a literal, which is the same at the defer
+// and at exit.
+func isConstArg(a ast.Expr) bool {
+  switch v := ast.Unparen(a).(type) {
+  case *ast.IntLit, *ast.FloatLit, *ast.StrLit, *ast.BoolLit, *ast.CharLit, *ast.NoneLit:
+    return true
+  case *ast.UnaryExpr:
+    _, _, ok := check.IntLiteral(v)
+    return ok
+  }
+  return false
+}
+
+func emitDefers(b *bytes.Buffer, indent int, e *env) {
+  if e.main {
+    // run main's defers, and os.at_exit hooks, while its locals live
+    term.Wprintf(b, "%sdesi_exit_hooks();\n", spaces(indent))
+    return
+  }
+  for i := len(e.defers) - 1; i >= 0; i-- {
+    emitDeferCall(b, indent, e.defers[i], e)
+  }
+}
+
+func emitDeferCall(b *bytes.Buffer, indent int, call ast.Expr, e *env) {
+  // println special-case
+  if ce, ok := call.(*ast.CallExpr); ok && isIoPrintln(ce) {
+    emitPrintln(b, indent, ce, e)
+    return
+  }
+  cx, _ := cExprFor(call, e)
+  term.Wprintf(b, "%s/* defer */ (void)(%s);\n", spaces(indent), cx)
+}
+
+// emitMainDefer registers a defer in main as an exit hook, so it runs
+// however the program ends: main returning, os.exit, or an interrupt. Its
+// trampoline calls the deferred function on the arguments snapshotArgs
+// saved.
+func emitMainDefer(b *bytes.Buffer, ind string, call ast.Expr, e *env) {
+  name := "desi_main_defer_" + strconv.Itoa(e.tasks.hooks)
+  e.tasks.hooks++
+  term.Wprintf(&e.tasks.defs, "static void %s(void) {\n", name)
+  emitDeferCall(&e.tasks.defs, 2, call, e)
+  term.Wprintf(&e.tasks.defs, "}\n\n")
+  term.Wprintf(b, "%sdesi_os_at_exit(%s);\n", ind, name)
 }
 
 func isIoPrintln(c *ast.CallExpr) bool {
@@ -288,10 +780,11 @@ func isIoPrintln(c *ast.CallExpr) bool {
 }
 
 // Variadic println: io.println(a, b, c, ...)
-// strings -> %s, ints/bools/unknown -> %d
+// strings -> %s, f64 -> %g, ints/bools/unknown -> %d. It goes through the
+// runtime, not printf, so a string's embedded NULs are written too.
 func emitPrintln(b *bytes.Buffer, indent int, call *ast.CallExpr, e *env) {
   ind := spaces(indent)
-  term.Wprintf(b, "%sprintf(", ind)
+  term.Wprintf(b, "%sdesi_io_printf(", ind)
   term.Wprintf(b, "%s", buildPrintfArgs(call.Args, e))
   term.Wprintf(b, ");\n")
 }
@@ -306,10 +799,14 @@ func buildPrintfArgs(args []ast.Expr, e *env) string {
   }
   for _, a := range args {
     ce, kind := cExprFor(a, e)
-    if kind == "str" {
+    switch kind {
+    case "str":
       fmt.WriteString("%s")
-    } else {
+    case "f64":
+      fmt.WriteString("%g")
+    default:
       fmt.WriteString("%d")
+      ce = "(long long)(" + ce + ")"
     }
     argv = append(argv, ce)
   }
@@ -320,52 +817,191 @@ func buildPrintfArgs(args []ast.Expr, e *env) string {
   return fmt.String()
 }
 
+// stdBuiltins maps fixed-signature std functions to their runtime entry
+// points and result kinds (mirrors check.stdBuiltins).
+var stdBuiltins = map[string]struct{ cfunc, kind string }{
+  "io.flush":        {"desi_io_flush", "void"},
+  "time.now_ms":     {"desi_time_now_ms", "i64"},
+  "time.mono_ns":    {"desi_time_mono_ns", "i64"},
+  "time.sleep_ms":   {"desi_time_sleep_ms", "void"},
+  "rand.int":        {"desi_rand_int", "int"},
+  "rand.seed":       {"desi_rand_seed", "void"},
+  "proc.run":        {"desi_proc_run", "int"},
+  "proc.output":     {"desi_proc_output", "str"},
+  "json.valid":      {"desi_json_valid", "int"},
+  "json.get_str":    {"desi_json_get_str", "str"},
+  "json.get_int":    {"desi_json_get_int", "i64"},
+  "json.quote":      {"desi_json_quote", "str"},
+  "str.eq":          {"desi_str_eq", "int"},
+  "str.cmp":         {"desi_str_cmp", "int"},
+  "str.len":         {"desi_str_len", "i64"},
+  "hash.str":        {"desi_hash_str", "i64"},
+  "hash.int":        {"desi_hash_int", "i64"},
+  "fs.open":         {"desi_fs_open", "file?"},
+  "fs.create":       {"desi_fs_create", "file?"},
+  "fs.append":       {"desi_fs_append", "file?"},
+  "fs.read":         {"desi_fs_read", "str"},
+  "fs.read_line":    {"desi_fs_read_line", "str?"},
+  "fs.write":        {"desi_fs_write", "int"},
+  "fs.close":        {"desi_fs_close", "void"},
+  "bytes.from_file": {"desi_bytes_from_file", "bytes?"},
+  "bytes.len":       {"desi_bytes_len", "i64"},
+  "bytes.at":        {"desi_bytes_at", "int"},
+  "bytes.slice":     {"desi_bytes_slice", "bytes"},
+  "sync.mutex":      {"desi_sync_mutex", "mutex"},
+  "sync.lock":       {"desi_sync_lock", "void"},
+  "sync.unlock":     {"desi_sync_unlock", "void"},
+  "sync.atomic":     {"desi_sync_atomic", "atomic"},
+  "sync.load":       {"desi_sync_load", "i64"},
+  "sync.store":      {"desi_sync_store", "void"},
+  "sync.add":        {"desi_sync_add", "i64"},
+  "sync.cas":        {"desi_sync_cas", "int"},
+}
+
 // ---- expressions ----
 
+// intConst folds a literal (and its sign) into one C constant, an i64 when
+// it needs 64 bits or its suffix asks for them. The minimum values are
+// spelled as MIN+1 - 1 because C parses -2147483648 as the negation of a
+// constant that doesn't fit in int.
+func intConst(n uint64, neg bool, bits int) (string, string) {
+  kind := "int"
+  if bits == 64 || !check.FitsInt(n, neg, 32) {
+    kind = "i64"
+  }
+  switch {
+  case neg && n == 1<<31:
+    return "(-2147483647 - 1)", kind
+  case neg && n == 1<<63:
+    return "(-9223372036854775807LL - 1)", kind
+  case neg:
+    return "(-" + strconv.FormatUint(n, 10) + ")", kind
+  case kind == "i64":
+    return strconv.FormatUint(n, 10) + "LL", kind
+  }
+  return strconv.FormatUint(n, 10), kind
+}
+
 func cExprFor(e ast.Expr, env *env) (string, string) {
   switch v := e.(type) {
   case *ast.IntLit:
-    if hasPrefixAny(v.Value, "0b", "0B") {
-      if n, err := strconv.ParseInt(v.Value[2:], 2, 64); err == nil {
-        return strconv.FormatInt(n, 10), "int"
-      }
-      return "0", "int"
+    // Always decimal in C: no 0b (not C11), no "_", and leading zeros
+    // would make C read octal.
+    if n, err := lexer.IntValue(v.Value); err == nil {
+      return intConst(n, false, check.LitBits(v))
     }
-    return v.Value, "int"
+    return "0", "int"
+  case *ast.FloatLit:
+    return v.Value, "f64" // "2.5e-3" is a C double constant as written
   case *ast.StrLit:
-    return v.Value, "str"
+    return env.lits.ref(v.Value), "str"
+  case *ast.InterpExpr:
+    return cInterp(v, env), "str"
   case *ast.BoolLit:
     if v.Value {
       return "1", "int"
     }
     return "0", "int"
+  case *ast.CharLit:
+    return strconv.Itoa(int(v.Value)), "int"
+  case *ast.NoneLit:
+    return "0", "none" // replaced by coerce where an optional is expected
   case *ast.IdentExpr:
+    if s, ok := env.snaps[v]; ok {
+      return s.cname, s.kind
+    }
     if k, ok := env.vars[v.Name]; ok {
-      return v.Name, k
+      x := cIdent(v.Name)
+      if env.refs[v.Name] {
+        x = "(*" + x + ")"
+      }
+      if isOptKind(k) && env.isNarrowed(v.Name) {
+        if k == "str?" || k == "file?" || k == "bytes?" {
+          return x, strings.TrimSuffix(k, "?")
+        }
+        return x + ".v", "i64"
+      }
+      return x, k
     }
-    return v.Name, "int"
+    return cIdent(v.Name), "int"
   case *ast.UnaryExpr:
+    if n, neg, ok := check.IntLiteral(v); ok && neg {
+      return intConst(n, true, check.LitBits(v))
+    }
     x, k := cExprFor(v.X, env)
+    if v.Op == "not" || v.Op == "!" {
+      return "(!" + x + ")", "int" // C has no not; a bool is an int
+    }
     return "(" + v.Op + " " + x + ")", k
+  case *ast.ParenExpr:
+    x, k := cExprFor(v.X, env)
+    return "(" + x + ")", k
   case *ast.BinaryExpr:
     l, lk := cExprFor(v.Left, env)
     r, rk := cExprFor(v.Right, env)
 
-    // Special-case string equality/inequality: use strcmp
+    // x == none / x != none
+    if (v.Op == "==" || v.Op == "!=") && (lk == "none" || rk == "none") {
+      x, xk := l, lk
+      if lk == "none" {
+        x, xk = r, rk
+      }
+      isNone := "1"
+      switch xk {
+      case "str?", "file?", "bytes?":
+        isNone = "(" + x + " == NULL)"
+      case "int?":
+        isNone = "(!" + x + ".ok)"
+      }
+      if v.Op == "!=" {
+        return "(!" + isNone + ")", "int"
+      }
+      return isNone, "int"
+    }
+
+    // String equality compares contents (see desi_str_eq), never pointers.
     if (v.Op == "==" || v.Op == "!=") && (lk == "str" || rk == "str") {
-      cmp := "strcmp(" + l + ", " + r + ")"
+      eq := "desi_str_eq(" + l + ", " + r + ")"
       if v.Op == "==" {
-        return "(" + cmp + " == 0)", "int"
+        return eq, "int"
       }
-      return "(" + cmp + " != 0)", "int"
+      return "(!" + eq + ")", "int"
+    }
+    // Ordering compares bytes; comparing the pointers would be meaningless.
+    if isComparison(v.Op) && lk == "str" && rk == "str" {
+      return "(desi_str_cmp(" + l + ", " + r + ") " + v.Op + " 0)", "int"
+    }
+
+    switch v.Op {
+    case "and":
+      return "(" + l + " && " + r + ")", "int"
+    case "or":
+      return "(" + l + " || " + r + ")", "int"
+    }
+
+    // C has no power operator; desi_ipow works in 64 bits.
+    if v.Op == "**" {
+      k := "int"
+      if lk == "i64" || rk == "i64" {
+        k = "i64"
+      }
+      return "desi_ipow(" + l + ", " + r + ")", k
     }
 
     // Default: emit as plain C op; choose a best-effort kind
     k := ""
     if lk == "str" || rk == "str" {
       k = "str" // NOTE: only meaningful for '+' if we later add concat
-    } else if lk == "int" && rk == "int" {
+    } else if lk == "f64" || rk == "f64" {
+      k = "f64"
+      if isComparison(v.Op) {
+        k = "int"
+      }
+    } else if isIntKind(lk) && isIntKind(rk) {
       k = "int"
+      if (lk == "i64" || rk == "i64") && !isComparison(v.Op) {
+        k = "i64" // widen so time/size arithmetic does not truncate
+      }
     }
     return "(" + l + " " + v.Op + " " + r + ")", k
 
@@ -374,16 +1010,65 @@ func cExprFor(e ast.Expr, env *env) (string, string) {
   case *ast.IndexExpr:
     return "0", ""
   case *ast.CallExpr:
+    // chan[T].bounded(cap)
+    if fe, ok := v.Callee.(*ast.FieldExpr); ok {
+      if ix, ok := fe.X.(*ast.IndexExpr); ok {
+        if id, ok := ix.Seq.(*ast.IdentExpr); ok && id.Name == "chan" && len(v.Args) == 1 {
+          kind := "chan[int]"
+          if el, ok := ix.Index.(*ast.IdentExpr); ok && typeToKind(el.Name) == "str" {
+            kind = "chan[str]"
+          }
+          cap, _ := cExprFor(v.Args[0], env)
+          return "desi_chan_new(" + cap + ")", kind
+        }
+      }
+    }
     // std.fs.read_all / std.os.exit
     if fe, ok := v.Callee.(*ast.FieldExpr); ok {
       if id, ok := fe.X.(*ast.IdentExpr); ok {
+        if id.Name == "chan" && env.vars[id.Name] == "" && len(v.Args) > 0 {
+          if x, k, ok := chanCall(fe.Name, v.Args, env); ok {
+            return x, k
+          }
+        }
         if id.Name == "fs" && fe.Name == "read_all" {
           var args []string
           for _, a := range v.Args {
             ax, _ := cExprFor(a, env)
             args = append(args, ax)
           }
-          return "desi_fs_read_all(" + strings.Join(args, ", ") + ")", "str"
+          return "desi_fs_read_all(" + strings.Join(args, ", ") + ")", "str?"
+        }
+        if (id.Name == "io" && fe.Name == "printf") || (id.Name == "fmt" && fe.Name == "sprintf") {
+          // The runtime reads %d/%b arguments as long long and %g as double.
+          // The format is a plain C string (the checker insists on a literal).
+          var args []string
+          for i, a := range v.Args {
+            ax, k := cExprFor(a, env)
+            if lit, ok := a.(*ast.StrLit); ok && i == 0 {
+              ax = cString(lit.Value)
+            }
+            if i > 0 && k != "str" && k != "f64" {
+              ax = "(long long)(" + ax + ")"
+            }
+            args = append(args, ax)
+          }
+          if fe.Name == "sprintf" {
+            return "desi_fmt_sprintf(" + strings.Join(args, ", ") + ")", "str"
+          }
+          return "desi_io_printf(" + strings.Join(args, ", ") + ")", "void"
+        }
+        if b, ok := stdBuiltins[id.Name+"."+fe.Name]; ok {
+          var args []string
+          for _, a := range v.Args {
+            ax, _ := cExprFor(a, env)
+            args = append(args, ax)
+          }
+          return b.cfunc + "(" + strings.Join(args, ", ") + ")", b.kind
+        }
+        if id.Name == "os" && (fe.Name == "on_interrupt" || fe.Name == "at_exit") && len(v.Args) == 1 {
+          fs, _ := env.userSig(&ast.CallExpr{Callee: v.Args[0]})
+          return "desi_os_" + fe.Name + "(" + fs.cname + ")", "void"
         }
         if id.Name == "os" && fe.Name == "exit" {
           var args []string
@@ -393,17 +1078,16 @@ func cExprFor(e ast.Expr, env *env) (string, string) {
           }
           return "desi_os_exit(" + strings.Join(args, ", ") + ")", "void"
         }
+        if fs, ok := env.sigs[fe.Name]; ok && (fs.module == id.Name || slices.Contains(fs.reexports, id.Name)) && env.vars[id.Name] == "" {
+          // qualified user call: module.f(...) → pkg__f(...)
+          return fs.cname + "(" + userArgs(v.Args, fs, env) + ")", fs.ret
+        }
       }
     }
     // user function call
     if id, ok := v.Callee.(*ast.IdentExpr); ok {
       if fs, ok := env.sigs[id.Name]; ok {
-        var args []string
-        for _, a := range v.Args {
-          ax, _ := cExprFor(a, env)
-          args = append(args, ax)
-        }
-        return id.Name + "(" + strings.Join(args, ", ") + ")", fs.ret
+        return fs.cname + "(" + userArgs(v.Args, fs, env) + ")", fs.ret
       }
     }
     return "0", ""
@@ -412,6 +1096,86 @@ func cExprFor(e ast.Expr, env *env) (string, string) {
   }
 }
 
+// chanCall lowers chan.send, chan.recv and chan.close, picking the runtime
+// entry point for the channel's element kind.
+func chanCall(fn string, args []ast.Expr, env *env) (string, string, bool) {
+  ch, k := cExprFor(args[0], env)
+  elem := strings.TrimSuffix(strings.TrimPrefix(k, "chan["), "]")
+  switch {
+  case fn == "send" && len(args) == 2:
+    v, _ := cExprFor(args[1], env)
+    if elem == "str" {
+      return "desi_chan_send_str(" + ch + ", " + v + ")", "int", true
+    }
+    return "desi_chan_send_int(" + ch + ", (int64_t)(" + v + "))", "int", true
+  case fn == "recv":
+    if elem == "str" {
+      return "desi_chan_recv_str(" + ch + ")", "str?", true
+    }
+    return "desi_chan_recv_int(" + ch + ")", "int?", true
+  case fn == "close":
+    return "desi_chan_close(" + ch + ")", "void", true
+  }
+  return "", "", false
+}
+
+// userArgs lowers the arguments of a call to a user function. Arguments for
+// mut params are variables (the checker ensures it) and are passed by address;
+// a mut param forwarded to another mut param is already a pointer.
+func userArgs(args []ast.Expr, fs sig, env *env) string {
+  var out []string
+  for i, a := range args {
+    if id, ok := ast.Unparen(a).(*ast.IdentExpr); ok && i < len(fs.muts) && fs.muts[i] {
+      if s, ok := env.snaps[id]; ok {
+        out = append(out, s.cname) // main's defers save an address
+      } else if env.refs[id.Name] {
+        out = append(out, cIdent(id.Name))
+      } else {
+        out = append(out, "&"+cIdent(id.Name))
+      }
+      continue
+    }
+    ax, k := cExprFor(a, env)
+    if i < len(fs.params) {
+      ax = coerce(ax, k, fs.params[i])
+    }
+    out = append(out, ax)
+  }
+  return strings.Join(out, ", ")
+}
+
+func isOptKind(k string) bool { return strings.HasSuffix(k, "?") }
+
+// coerce converts x of kind from for storage where kind to is expected:
+// none and plain values become optionals; everything else is unchanged.
+func coerce(x, from, to string) string {
+  switch to {
+  case "str?", "file?", "bytes?":
+    if from == "none" {
+      return "NULL"
+    }
+  case "int?":
+    switch from {
+    case "none":
+      return "((desi_opt_int){0, 0})"
+    case "int?":
+    default:
+      return "((desi_opt_int){1, (int64_t)(" + x + ")})"
+    }
+  }
+  return x
+}
+
+func isIntKind(k string) bool { return k == "int" || k == "i64" }
+
+func isComparison(op string) bool {
+  switch op {
+  case "<", "<=", ">", ">=", "==", "!=":
+    return true
+  }
+  return false
+}
+
 func spaces(n int) string {
   if n <= 0 {
     return ""
@@ -419,9 +1183,100 @@ func spaces(n int) string {
   return string(bytes.Repeat([]byte(" "), n))
 }
 
-func hasPrefixAny(s string, p1, p2 string) bool {
-  return (len(s) >= len(p1) && s[:len(p1)] == p1) ||
-    (len(s) >= len(p2) && s[:len(p2)] == p2)
+// cString re-spells a Desi string literal as a C one. Most escapes read
+// the same in both, but C's \x takes every hex digit that follows and C has
+// no \u{...}, so anything beyond printable ASCII becomes octal bytes (\303\251
+// for é). A literal the lexer already rejected is copied through.
+func cString(lex string) string {
+  s, err := lexer.StringValue(lex)
+  if err != nil {
+    return lex
+  }
+  return cQuote(s)
+}
+
+// strLits collects a file's string literals. Each distinct one becomes a
+// static length-prefixed str (DESI_STR_LIT) ahead of the functions, so its
+// bytes, NULs included, reach the runtime with their length.
+type strLits struct {
+  names map[string]string // literal bytes -> C name
+  defs  []string
+}
+
+// ref returns the str for the Desi literal lex. A literal the lexer already
+// rejected is copied through.
+func (l *strLits) ref(lex string) string {
+  s, err := lexer.StringValue(lex)
+  if err != nil {
+    return lex
+  }
+  name, ok := l.names[s]
+  if !ok {
+    name = "desi_lit_" + strconv.Itoa(len(l.defs))
+    l.names[s] = name
+    l.defs = append(l.defs, "DESI_STR_LIT("+name+", "+strconv.Itoa(len(s))+", "+cQuote(s)+");")
+  }
+  return name + ".s"
+}
+
+// cQuote spells the bytes s as a C string literal.
+func cQuote(s string) string {
+  var b strings.Builder
+  b.WriteByte('"')
+  for i := 0; i < len(s); i++ {
+    switch c := s[i]; {
+    case c == '"' || c == '\\':
+      b.WriteByte('\\')
+      b.WriteByte(c)
+    case c == '\n':
+      b.WriteString(`\n`)
+    case c == '\t':
+      b.WriteString(`\t`)
+    case c == '\r':
+      b.WriteString(`\r`)
+    case c == '?' && (i+1 < len(s) && s[i+1] == '?' || i > 0 && s[i-1] == '?'):
+      b.WriteString(`\?`) // no trigraphs
+    case c < 0x20 || c >= 0x7f:
+      term.Bprintf(&b, "\\%03o", c)
+    default:
+      b.WriteByte(c)
+    }
+  }
+  b.WriteByte('"')
+  return b.String()
+}
+
+// cInterp lowers an f-string to desi_fmt_sprintf. The format holds only
+// verbs: each literal part is a str literal passed through %s, so its NULs
+// and % signs reach the output as bytes, and each hole is a %s, %g or %d.
+// The runtime reads %d as long long; bools print as 1 or 0, as in io.println.
+func cInterp(x *ast.InterpExpr, env *env) string {
+  var format strings.Builder
+  var args []string
+  for i, part := range x.Parts {
+    if s, _ := lexer.StringValue(part); s != "" {
+      format.WriteString("%s")
+      args = append(args, env.lits.ref(part))
+    }
+    if i == len(x.Holes) {
+      break
+    }
+    ax, k := cExprFor(x.Holes[i], env)
+    switch k {
+    case "str":
+      format.WriteString("%s")
+    case "f64":
+      format.WriteString("%g")
+    default:
+      format.WriteString("%d")
+      ax = "(long long)(" + ax + ")"
+    }
+    args = append(args, ax)
+  }
+  if len(args) == 0 {
+    return env.lits.ref(`""`)
+  }
+  return "desi_fmt_sprintf(" + cQuote(format.String()) + ", " + strings.Join(args, ", ") + ")"
 }
 
 // strip one balanced pair of outer parentheses if present
@@ -0,0 +1,499 @@
+package c
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/cc"
+	"github.com/desilang/desi/compiler/internal/check"
+	"github.com/desilang/desi/compiler/internal/parser"
+)
+
+// emit parses and checks src, failing on any error, and returns the C.
+func emit(t *testing.T, src string) string {
+	t.Helper()
+	f, err := parser.New(src).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	info, errs, _ := check.CheckFile(f)
+	if len(errs) > 0 {
+		t.Fatalf("check: %v", errs)
+	}
+	return EmitFile(f, info)
+}
+
+// wantFragments fails for each fragment missing from out.
+func wantFragments(t *testing.T, out string, frags ...string) {
+	t.Helper()
+	for _, f := range frags {
+		if !strings.Contains(out, f) {
+			t.Errorf("output lacks %q:\n%s", f, out)
+		}
+	}
+}
+
+func TestEmitSignatures(t *testing.T) {
+	out := emit(t, ""+
+		"def add(a: i32, b: i32) -> i32:\n  return a + b\n"+
+		"def wide(n: i64, s: str) -> i64:\n  io.println(s)\n  return n\n"+
+		"def bump(mut n: i32) -> void:\n  n := n + 1\n"+
+		"def find(s: str) -> str?:\n  return none\n"+
+		"def main() -> i32:\n  let mut x = add(1, 2)\n  bump(x)\n  io.println(wide(x, \"w\"), find(\"f\") == none)\n  return x\n")
+	wantFragments(t, out,
+		// prototypes, then definitions; main is not static
+		"static int add(int a, int b);\n",
+		"static int64_t wide(int64_t n, const char* s);\n",
+		"static void bump(int *n);\n",
+		"static const char* find(const char* s);\n",
+		"static int add(int a, int b) {\n",
+		"int main(void) {\n",
+		// mut params are pointers: written through, passed by address
+		"*n = ((*n) + 1);",
+		"bump(&x)",
+	)
+	if strings.Contains(out, "static int main") {
+		t.Errorf("main must not be static:\n%s", out)
+	}
+}
+
+func TestEmitStringLiterals(t *testing.T) {
+	// common escapes read the same in C; the rest become octal bytes
+	out := emit(t, "def main() -> void:\n  io.println(\"tab\\there \\\"q\\\"\\n\")\n")
+	wantFragments(t, out,
+		`DESI_STR_LIT(desi_lit_0, 13, "tab\there \"q\"\n");`,
+		`desi_io_printf("%s\n", desi_lit_0.s);`,
+	)
+	// literals are byte counted, so NUL and non-ASCII bytes keep their length
+	out = emit(t, "def main() -> void:\n  io.println(\"\\x41BC \\u00e9\\u{1F600} é\\0\\'\")\n")
+	wantFragments(t, out, `DESI_STR_LIT(desi_lit_0, 15, "ABC \303\251\360\237\230\200 \303\251\000'");`)
+	// equal literals share one definition
+	out = emit(t, "def main() -> void:\n  io.println(\"a\", \"b\", \"a\")\n")
+	wantFragments(t, out, `desi_io_printf("%s%s%s\n", desi_lit_0.s, desi_lit_1.s, desi_lit_0.s);`)
+	if strings.Count(out, "DESI_STR_LIT(") != 2 {
+		t.Errorf("want 2 literal definitions:\n%s", out)
+	}
+}
+
+func TestEmitInterp(t *testing.T) {
+	// holes become verbs of one sprintf, and so do the literal parts around them
+	out := emit(t, "def main() -> void:\n  let n = 3\n  let x = 1.5\n  io.println(f\"{n}% of {\"s\"} is {x}\\n\")\n")
+	wantFragments(t, out, `desi_fmt_sprintf("%d%s%s%s%g%s", (long long)(n), desi_lit_0.s, desi_lit_1.s, desi_lit_2.s, x, desi_lit_3.s)`)
+	// literal parts are strs, so the format holds only verbs and NULs survive
+	out = emit(t, "def main() -> void:\n  let n = 7\n  io.println(f\"nul=[a\\0b] pct=100% n={n}\")\n")
+	wantFragments(t, out,
+		`DESI_STR_LIT(desi_lit_0, 21, "nul=[a\000b] pct=100% n=");`,
+		`desi_fmt_sprintf("%s%d", desi_lit_0.s, (long long)(n))`,
+	)
+}
+
+func TestEmitPrintfFloat(t *testing.T) {
+	// %g reads a double, so f64 arguments are not cast like ints
+	out := emit(t, "def main() -> void:\n  let x = 1.5\n  io.printf(\"%g %d\\n\", x, 2)\n")
+	wantFragments(t, out, `desi_io_printf("%g %d\n", x, (long long)(2))`)
+}
+
+// TestRunInterpKeepsBytes compiles and runs an f-string with a NUL and a %
+// in its text; it is skipped without a C compiler.
+func TestRunInterpKeepsBytes(t *testing.T) {
+	compiler, err := cc.Find("")
+	if err != nil {
+		t.Skipf("no C compiler: %v", err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.c")
+	out := emit(t, "def main() -> void:\n  let n = 7\n  io.println(f\"nul=[a\\0b] pct=100% n={n}\")\n")
+	if err := os.WriteFile(src, []byte(out), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o := cc.Options{CC: compiler, RuntimeDir: filepath.Join("..", "..", "..", "..", "runtime", "c"), Sources: []string{src}, Out: filepath.Join(dir, "main")}
+	if err := cc.Compile(o); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	got, err := exec.Command(o.Binary()).Output()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := "nul=[a\x00b] pct=100% n=7\n"; string(got) != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestEmitExplained(t *testing.T) {
+	src := "def main() -> i32:\n  let x = 1\n  if x == 1:\n    return x\n  return 0\n"
+	f, err := parser.New(src).ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Decls[0].(*ast.FuncDecl).File = "m.desi"
+	info, _, _ := check.CheckFile(f)
+	lines := strings.Split(src, "\n")
+	out := EmitFileWith(f, info, Options{Source: func(file string, line int) string {
+		return file + ":" + strings.TrimSpace(lines[line-1])
+	}})
+	wantFragments(t, out,
+		"/* m.desi:def main() -> i32: */\nint main(void) {\n",
+		"  /* m.desi:let x = 1 */\n  int x = 1;\n",
+		"  /* m.desi:if x == 1: */\n  if (x == 1) {\n    /* m.desi:return x */\n    return x;\n",
+	)
+	if strings.Contains(EmitFile(f, info), "/* m.desi") {
+		t.Errorf("EmitFile explains without Options.Source")
+	}
+}
+
+func TestEmitEscapesReservedNames(t *testing.T) {
+	out := emit(t, ""+
+		"def printf(register: i32, int_: i32) -> i32:\n  let desi_str = register + int_\n  let _Bool = 1\n  return desi_str + _Bool\n"+
+		"def main() -> i32:\n  let strlen = printf(1, 2)\n  let int64_t = 1\n  io.println(strlen, int64_t)\n  return 0\n")
+	wantFragments(t, out,
+		"static int printf_(int register_, int int__) {",
+		"int desi_str_ = (register_ + int__);",
+		"int _Bool_ = 1;",
+		"int strlen_ = printf_(1, 2);",
+		`desi_io_printf("%d%d\n", (long long)(strlen_), (long long)(int64_t_));`,
+	)
+}
+
+// TestRuntimeSymbolsAreReserved checks that no Desi name is spelled like a
+// file-scope name of the runtime, which an amalgamated file puts in the same
+// translation unit as the program.
+func TestRuntimeSymbolsAreReserved(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "runtime", "c", "desi_std.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decl := regexp.MustCompile(`(?m)^(?:static [^(=;]*?\b(\w+)\s*[(=;\[]|#define (\w+))`)
+	matches := decl.FindAllStringSubmatch(string(src), -1)
+	if len(matches) < 10 {
+		t.Fatalf("found only %d runtime symbols; is the pattern stale?", len(matches))
+	}
+	for _, m := range matches {
+		name := m[1] + m[2]
+		if cIdent(name) == name {
+			t.Errorf("runtime symbol %s can be spelled by a Desi name", name)
+		}
+	}
+}
+
+// litName returns the C name out gives the str literal with text.
+func litName(t *testing.T, out, text string) string {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if name, rest, ok := strings.Cut(strings.TrimPrefix(line, "DESI_STR_LIT("), ", "); ok && line != name &&
+			strings.HasSuffix(rest, ", "+strconv.Quote(text)+");") {
+			return name
+		}
+	}
+	t.Fatalf("no literal %q in:\n%s", text, out)
+	return ""
+}
+
+func TestEmitDefer(t *testing.T) {
+	out := emit(t, ""+
+		"def run() -> i32:\n"+
+		"  defer io.println(\"first\")\n"+
+		"  defer io.println(\"second\")\n"+
+		"  if true:\n    return 1\n"+
+		"  return 0\n"+
+		"def main() -> void:\n"+
+		"  io.println(run())\n")
+	// Defers run before every return, last scheduled first.
+	early := strings.Index(out, "return 1;")
+	late := strings.Index(out, "return 0;")
+	if early < 0 || late < 0 {
+		t.Fatalf("returns missing:\n%s", out)
+	}
+	for _, seg := range []string{out[:early], out[early:late]} {
+		second := strings.LastIndex(seg, litName(t, out, "second")+".s")
+		first := strings.LastIndex(seg, litName(t, out, "first")+".s")
+		if second < 0 || first < 0 || second > first {
+			t.Errorf("want second then first before each return:\n%s", out)
+		}
+	}
+	wantFragments(t, out, "/* defer scheduled */")
+}
+
+func TestEmitDeferArgsEvaluatedAtDefer(t *testing.T) {
+	out := emit(t, ""+
+		"def bump(mut n: i32) -> void:\n"+
+		"  n := n + 1\n"+
+		"def run() -> i32:\n"+
+		"  let mut x = 1\n"+
+		"  defer io.println(\"x\", x * 2)\n"+
+		"  defer bump(x)\n"+
+		"  x := 5\n"+
+		"  return x\n"+
+		"def main() -> void:\n"+
+		"  io.println(run())\n")
+	// x * 2 is saved at the defer; the literal and the mut argument are not.
+	wantFragments(t, out,
+		"int desi_defer_0 = (x * 2);",
+		`desi_io_printf("%s%d\n", `+litName(t, out, "x")+`.s, (long long)(desi_defer_0));`,
+		"/* defer */ (void)(bump(&x));",
+	)
+	if strings.Contains(out, "desi_defer_1") {
+		t.Errorf("only one argument needs saving:\n%s", out)
+	}
+}
+
+func TestEmitMainDefersAreExitHooks(t *testing.T) {
+	out := emit(t, ""+
+		"def bump(mut n: i32) -> void:\n"+
+		"  n := n + 1\n"+
+		"def main() -> i32:\n"+
+		"  let mut x = 1\n"+
+		"  defer io.println(\"x\", x * 2)\n"+
+		"  defer bump(x)\n"+
+		"  x := 5\n"+
+		"  if x > 3:\n    os.exit(2)\n"+
+		"  return x\n")
+	// Each defer is a hook registered where it stands, its arguments saved
+	// in statics (a mut one by address); returns run the hooks first.
+	wantFragments(t, out,
+		"static int desi_defer_0;",
+		"static void desi_main_defer_0(void) {",
+		`desi_io_printf("%s%d\n", `+litName(t, out, "x")+`.s, (long long)(desi_defer_0));`,
+		"static int* desi_defer_1;",
+		"/* defer */ (void)(bump(desi_defer_1));",
+		"  desi_defer_0 = (x * 2);\n  desi_os_at_exit(desi_main_defer_0);\n",
+		"  desi_defer_1 = &x;\n  desi_os_at_exit(desi_main_defer_1);\n",
+		"desi_os_exit(2)",
+		"    desi_exit_hooks();\n    return desi_ret;",
+	)
+	if strings.Contains(out, "/* defer scheduled */") {
+		t.Errorf("main's defers are not run inline:\n%s", out)
+	}
+}
+
+func TestEmitAtExit(t *testing.T) {
+	out := emit(t, ""+
+		"def bye() -> void:\n"+
+		"  io.println(\"bye\")\n"+
+		"def main() -> void:\n"+
+		"  os.at_exit(bye)\n")
+	wantFragments(t, out, "desi_os_at_exit(bye)")
+	if strings.Contains(out, "desi_exit_hooks") {
+		t.Errorf("without defers, main leaves the hooks to the runtime:\n%s", out)
+	}
+}
+
+func TestEmitWith(t *testing.T) {
+	out := emit(t, ""+
+		"def first(path: str) -> i32:\n"+
+		"  defer io.println(\"done\")\n"+
+		"  with fs.open(path) as f:\n"+
+		"    if str.len(path) > 3:\n"+
+		"      return str.len(path)\n"+
+		"    io.println(\"short\")\n"+
+		"  return 0\n")
+	// The body runs only if the file opened; every way out of it closes f,
+	// and a return computes its value before the close and the defers.
+	wantFragments(t, out,
+		"desi_file* f = desi_fs_open(path);",
+		"if (f != NULL) {",
+		"int desi_ret = desi_str_len(path);",
+		"return desi_ret;",
+	)
+	ret := strings.Index(out, "int desi_ret")
+	if closed := strings.Index(out[ret:], "desi_fs_close(f);"); closed < 0 || closed > strings.Index(out[ret:], "return desi_ret;") {
+		t.Errorf("want f closed after the result is computed and before the return:\n%s", out)
+	}
+	if n := strings.Count(out, "desi_fs_close(f);"); n != 2 {
+		t.Errorf("f closed %d times in the output, want 2 (the return and the block's end):\n%s", n, out)
+	}
+}
+
+func TestEmitFileStreaming(t *testing.T) {
+	out := emit(t, ""+
+		"def copy(src: str, dst: str) -> bool:\n"+
+		"  with fs.open(src) as r:\n"+
+		"    with fs.create(dst) as w:\n"+
+		"      let line = fs.read_line(r)\n"+
+		"      if line != none:\n"+
+		"        return fs.write(w, line)\n"+
+		"  return false\n")
+	wantFragments(t, out,
+		"desi_file* r = desi_fs_open(src);",
+		"desi_file* w = desi_fs_create(dst);",
+		"const char* line = desi_fs_read_line(r);",
+		"int desi_ret = desi_fs_write(w, line);",
+		"desi_fs_close(w);\n",
+		"desi_fs_close(r);\n",
+	)
+}
+
+func TestEmitBytes(t *testing.T) {
+	out := emit(t, ""+
+		"def first(path: str) -> int:\n"+
+		"  let b = bytes.from_file(path)\n"+
+		"  if b == none:\n"+
+		"    return -1\n"+
+		"  return bytes.at(bytes.slice(b, 0, 1), 0)\n")
+	wantFragments(t, out,
+		"const desi_bytes* b = desi_bytes_from_file(path);",
+		"if (b == NULL) {",
+		"return desi_bytes_at(desi_bytes_slice(b, 0, 1), 0);",
+	)
+}
+
+func TestEmitSpawn(t *testing.T) {
+	out := emit(t, ""+
+		"def work(n: i32, who: str, out: chan[str]) -> void:\n"+
+		"  chan.send(out, who)\n"+
+		"def main() -> void:\n"+
+		"  let ch = chan[str].bounded(2)\n"+
+		"  spawn work(1, \"a\", ch)\n"+
+		"  let s = chan.recv(ch)\n"+
+		"  chan.close(ch)\n")
+	// The arguments are evaluated at the spawn and handed to a trampoline.
+	wantFragments(t, out,
+		"struct desi_spawn_0_args { int a0; const char* a1; desi_chan* a2; };",
+		"static void desi_spawn_0(void* desi_p) {",
+		"work(desi_a->a0, desi_a->a1, desi_a->a2);",
+		"static void work(int n, const char* who, desi_chan* out) {",
+		"desi_chan_send_str(out, who)",
+		"desi_chan* ch = desi_chan_new(2);",
+		"struct desi_spawn_0_args desi_args = {1, "+litName(t, out, "a")+".s, ch};",
+		"desi_spawn(desi_spawn_0, &desi_args, sizeof desi_args);",
+		"const char* s = desi_chan_recv_str(ch);",
+		"desi_chan_close(ch)",
+	)
+}
+
+func TestEmitSync(t *testing.T) {
+	out := emit(t, ""+
+		"def bump(hits: atomic, m: mutex) -> i64:\n"+
+		"  sync.lock(m)\n"+
+		"  let n = sync.add(hits, 1)\n"+
+		"  sync.unlock(m)\n"+
+		"  return n\n"+
+		"def main() -> void:\n"+
+		"  io.println(bump(sync.atomic(0), sync.mutex()))\n")
+	wantFragments(t, out,
+		"static int64_t bump(desi_atomic* hits, desi_mutex* m) {",
+		"desi_sync_lock(m)",
+		"int64_t n = desi_sync_add(hits, 1);",
+		"bump(desi_sync_atomic(0), desi_sync_mutex())",
+	)
+}
+
+func TestEmitOnInterrupt(t *testing.T) {
+	out := emit(t, ""+
+		"def int() -> void:\n"+
+		"  io.println(\"bye\")\n"+
+		"def main() -> void:\n"+
+		"  os.on_interrupt(int)\n")
+	// the handler is passed by its C name
+	wantFragments(t, out,
+		"static void int_() {",
+		"desi_os_on_interrupt(int_)",
+	)
+}
+
+func TestEmitExpressions(t *testing.T) {
+	out := emit(t, ""+
+		"def main() -> void:\n"+
+		"  let s = \"a\"\n"+
+		"  let n = -2147483648\n"+
+		"  let hex = 0xff_ff\n"+
+		"  let wide = -7i64\n"+
+		"  let c = 'A'\n"+
+		"  let p = 2 ** 10\n"+
+		"  io.println(s == \"b\", s < \"b\", not (n > 0) and c > 0 or p == 0, hex, wide)\n")
+	wantFragments(t, out,
+		"int n = (-2147483647 - 1);",
+		"int hex = 65535;",
+		"int64_t wide = (-7);",
+		"int c = 65;",
+		"desi_ipow(2, 10)",
+		`desi_str_eq(s, desi_lit_1.s)`,
+		`(desi_str_cmp(s, desi_lit_1.s) < 0)`,
+		"&&", "||", "(!(",
+	)
+}
+
+func TestEmitFloats(t *testing.T) {
+	out := emit(t, ""+
+		"def scale(x: f64) -> f64:\n"+
+		"  return x * 1_000.5\n"+
+		"def main() -> void:\n"+
+		"  let f = scale(2.5e-3)\n"+
+		"  io.println(f, f < 1e9)\n")
+	wantFragments(t, out,
+		"static double scale(double x)",
+		"(x * 1000.5)",
+		"double f = scale(2.5e-3);",
+		`desi_io_printf("%g%d\n", f, (long long)((f < 1e9)));`,
+	)
+}
+
+func TestEmitReexportedCall(t *testing.T) {
+	f, err := parser.New("def main() -> i32:\n  return lib.width()\ndef width() -> i32:\n  return 8\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as the loader leaves it for width in text.desi, re-exported by lib.desi
+	width := f.Decls[1].(*ast.FuncDecl)
+	width.Module, width.Package, width.Reexports = "text", "lib.text", []string{"lib"}
+	f.Decls[0].(*ast.FuncDecl).Imports = []string{"lib"}
+	info, errs, _ := check.CheckFile(f)
+	if len(errs) > 0 {
+		t.Fatalf("check: %v", errs)
+	}
+	// module functions are mangled by package; entry functions are not
+	wantFragments(t, EmitFile(f, info),
+		"static int lib_text__width();\n",
+		"static int lib_text__width() {\n",
+		"return lib_text__width();",
+	)
+}
+
+func TestEmitModuleNamesDoNotCollide(t *testing.T) {
+	f, err := parser.New("" +
+		"def a_b__d() -> i32:\n  let x__y = 1\n  return x__y\n" +
+		"def main() -> i32:\n  return a_b__d() + a_b.d() + a__b.c() + a.b__c()\n" +
+		"def d() -> i32:\n  return 1\n" +
+		"def c() -> i32:\n  return 2\n" +
+		"def b__c() -> i32:\n  return 3\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as the loader leaves them for `import a_b`, `import a__b` and `import a`
+	for i, pkg := range map[int]string{2: "a_b", 3: "a__b", 4: "a"} {
+		fn := f.Decls[i].(*ast.FuncDecl)
+		fn.Module, fn.Package = pkg, pkg
+	}
+	f.Decls[1].(*ast.FuncDecl).Imports = []string{"a_b", "a__b", "a"}
+	info, errs, _ := check.CheckFile(f)
+	if len(errs) > 0 {
+		t.Fatalf("check: %v", errs)
+	}
+	// an entry name with __ is escaped; a mangled name taken by an earlier
+	// module gets a suffix
+	wantFragments(t, EmitFile(f, info),
+		"static int a_b__d_() {\n",
+		"int x__y_ = 1;",
+		"static int a_b__d() {\n",
+		"static int a__b__c() {\n",
+		"static int a__b__c_2() {\n",
+		"return (((a_b__d_() + a_b__d()) + a__b__c()) + a__b__c_2());",
+	)
+}
+
+func TestEmitEscapesCKeywords(t *testing.T) {
+	out := emit(t, ""+
+		"def r#if(int: i32, mut r#while: i32) -> i32:\n  r#while := int\n  return r#while\n"+
+		"def main() -> i32:\n  let mut char = 1\n  let r#match = r#if(2, char)\n  return r#match + char\n")
+	wantFragments(t, out,
+		"static int if_(int int_, int *while_) {",
+		"*while_ = int_;",
+		"int char_ = 1;",
+		"int match = if_(2, &char_);",
+	)
+}
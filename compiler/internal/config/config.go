@@ -0,0 +1,147 @@
+// Package config loads user defaults for desic from a small TOML file.
+//
+// Precedence (lowest first): built-in defaults, the user config file,
+// then command-line flags. Only a flat subset of TOML is understood:
+// `key = value` lines with string or boolean values, `#` comments, and an
+// optional `[desic]` table header.
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds user defaults. Zero values mean "not set".
+type Config struct {
+	CC         string // cc: C compiler binary used by build/run
+	RuntimeDir string // runtime_dir: directory containing desi_std.c/.h
+	StdDir     string // std_dir: directory of Desi-source std modules
+	Color      string // color: auto | always | never
+	Werror     bool   // werror: treat warnings as errors
+
+	Path string // file the values came from; empty if none was found
+}
+
+// EnvVar overrides the config file location when set.
+const EnvVar = "DESI_CONFIG"
+
+// DefaultPath returns $DESI_CONFIG, or <user config dir>/desi/config.toml
+// (~/.config/desi/config.toml on Linux).
+func DefaultPath() string {
+	if p := os.Getenv(EnvVar); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "desi", "config.toml")
+}
+
+// Load reads the config at DefaultPath. A missing file is not an error.
+func Load() (Config, error) {
+	path := DefaultPath()
+	if path == "" {
+		return Config{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("config: %v", err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("config %s: %v", path, err)
+	}
+	c.Path = path
+	return c, nil
+}
+
+// Parse decodes config file contents.
+func Parse(data []byte) (Config, error) {
+	var c Config
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(stripComment(sc.Text()))
+		if s == "" {
+			continue
+		}
+		if strings.HasPrefix(s, "[") {
+			if s != "[desic]" {
+				return c, fmt.Errorf("%d: unknown table %s (only [desic] is supported)", line, s)
+			}
+			continue
+		}
+		key, raw, ok := strings.Cut(s, "=")
+		if !ok {
+			return c, fmt.Errorf("%d: expected key = value", line)
+		}
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+		var err error
+		switch key {
+		case "cc":
+			c.CC, err = parseString(raw)
+		case "runtime_dir":
+			c.RuntimeDir, err = parseString(raw)
+		case "std_dir":
+			c.StdDir, err = parseString(raw)
+		case "color":
+			c.Color, err = parseString(raw)
+			if err == nil && c.Color != "auto" && c.Color != "always" && c.Color != "never" {
+				err = fmt.Errorf("color must be auto, always or never, got %q", c.Color)
+			}
+		case "werror":
+			c.Werror, err = strconv.ParseBool(raw)
+			if err != nil {
+				err = fmt.Errorf("werror must be true or false, got %s", raw)
+			}
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return c, fmt.Errorf("%d: %v", line, err)
+		}
+	}
+	return c, sc.Err()
+}
+
+// stripComment drops a trailing # comment that is not inside a string.
+func stripComment(s string) string {
+	inStr := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inStr {
+				i++
+			}
+		case '"':
+			inStr = !inStr
+		case '#':
+			if !inStr {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+func parseString(raw string) (string, error) {
+	if strings.HasPrefix(raw, "'") && strings.HasSuffix(raw, "'") && len(raw) >= 2 {
+		return raw[1 : len(raw)-1], nil // TOML literal string: no escapes
+	}
+	s, err := strconv.Unquote(raw)
+	if err != nil || !strings.HasPrefix(raw, `"`) {
+		return "", fmt.Errorf("expected a quoted string, got %s", raw)
+	}
+	return s, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := "" +
+		"# desic defaults\n" +
+		"[desic]\n" +
+		"cc = \"clang\"   # trailing comment\n" +
+		"runtime_dir = 'C:\\desi\\runtime'\n" +
+		"std_dir = \"/opt/desi/std\"\n" +
+		"color = \"never\"\n" +
+		"werror = true\n"
+	c, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if c.CC != "clang" || c.RuntimeDir != `C:\desi\runtime` || c.StdDir != "/opt/desi/std" || c.Color != "never" || !c.Werror {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"nope = 1\n":         "unknown key",
+		"cc = clang\n":       "quoted string",
+		"color = \"pink\"\n": "color must be",
+		"werror = yes\n":     "werror must be",
+		"[build]\n":          "unknown table",
+		"just some words\n":  "expected key = value",
+	}
+	for src, want := range cases {
+		_, err := Parse([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) error = %v, want it to mention %q", src, err, want)
+		}
+	}
+}
+
+func TestLoadMissingFileIsNotAnError(t *testing.T) {
+	t.Setenv(EnvVar, filepath.Join(t.TempDir(), "absent.toml"))
+	c, err := Load()
+	if err != nil || c.Path != "" {
+		t.Fatalf("Load() = %+v, %v; want empty config, nil", c, err)
+	}
+}
+
+func TestLoadFromEnvPath(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(p, []byte("cc = \"gcc\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvVar, p)
+	c, err := Load()
+	if err != nil || c.CC != "gcc" || c.Path != p {
+		t.Fatalf("Load() = %+v, %v", c, err)
+	}
+}
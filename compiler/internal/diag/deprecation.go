@@ -0,0 +1,28 @@
+package diag
+
+import "fmt"
+
+// Deprecation is old syntax that is still accepted, with a warning, for one
+// release before it becomes an error. Its key is a D code in the registry.
+type Deprecation struct {
+	Since   string // first version that warns
+	Removal string // first version that rejects it
+}
+
+var deprecations = map[string]Deprecation{
+	ReassignWithEq: {Since: "0.0.1", Removal: "0.1.0"},
+}
+
+// IsDeprecation reports whether key names deprecated syntax.
+func IsDeprecation(key string) bool {
+	_, ok := deprecations[key]
+	return ok
+}
+
+// Deprecated returns the diagnostic for a use of the deprecated syntax key:
+// msg, then when the syntax stops being accepted.
+func Deprecated(key string, span Span, msg string) Diagnostic {
+	d := deprecations[key]
+	return Diagnostic{Span: span, Code: key,
+		Msg: fmt.Sprintf("%s (deprecated since %s; an error from %s)", msg, d.Since, d.Removal)}
+}
@@ -1,25 +1,85 @@
 package diag
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
 
 // Pos marks a 1-based line/column location in a file.
 type Pos struct{ Line, Col int }
 
-// Span marks a half-open range [Start, End) within a file.
+// Span marks a half-open range [Start, End) within a file. End may be on a
+// later line (a block, say); a zero End marks just the Start position.
 type Span struct {
 	Start Pos
 	End   Pos
 }
 
-// Diagnostic is a compiler message with an optional span.
+// Diagnostic is a compiler message with an optional span and registry key.
 type Diagnostic struct {
+	File string // path as shown to the user; may be empty
 	Span Span
+	Code string // registry key (see Explain); may be empty
 	Msg  string
 }
 
 func (d Diagnostic) Error() string {
-	if d.Span.Start.Line == 0 {
-		return d.Msg
+	msg := d.Msg
+	if d.Code != "" {
+		msg = d.Code + ": " + msg
 	}
-	return fmt.Sprintf("%d:%d: %s", d.Span.Start.Line, d.Span.Start.Col, d.Msg)
+	switch {
+	case d.Span.Start.Line == 0 && d.File == "":
+		return msg
+	case d.Span.Start.Line == 0:
+		return d.File + ": " + msg
+	case d.File == "":
+		return fmt.Sprintf("%d:%d: %s", d.Span.Start.Line, d.Span.Start.Col, msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Span.Start.Line, d.Span.Start.Col, msg)
+}
+
+// Tidy returns errs in a stable order for display: identical messages
+// reported more than once appear once, diagnostics that know their File and
+// Span are sorted by file, line and column, and errors without a position
+// follow in the order they were reported.
+func Tidy(errs []error) []error {
+	type entry struct {
+		err error
+		d   Diagnostic
+		at  bool // d is located
+	}
+	seen := map[string]bool{}
+	var out []entry
+	for _, e := range errs {
+		if seen[e.Error()] {
+			continue
+		}
+		seen[e.Error()] = true
+		en := entry{err: e}
+		en.at = errors.As(e, &en.d) && en.d.Span.Start.Line > 0
+		out = append(out, en)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.at != b.at {
+			return a.at
+		}
+		if !a.at {
+			return false
+		}
+		if a.d.File != b.d.File {
+			return a.d.File < b.d.File
+		}
+		if a.d.Span.Start.Line != b.d.Span.Start.Line {
+			return a.d.Span.Start.Line < b.d.Span.Start.Line
+		}
+		return a.d.Span.Start.Col < b.d.Span.Start.Col
+	})
+	tidy := make([]error, len(out))
+	for i, en := range out {
+		tidy[i] = en.err
+	}
+	return tidy
 }
@@ -0,0 +1,31 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestTidy(t *testing.T) {
+	at := func(file string, line, col int) Diagnostic {
+		return Diagnostic{File: file, Span: Span{Start: Pos{line, col}}, Msg: "m"}
+	}
+	errs := []error{
+		errors.New("no position"),
+		at("b.desi", 1, 1),
+		fmt.Errorf("wrapped: %w", at("a.desi", 3, 9)),
+		at("a.desi", 3, 2),
+		errors.New("no position"),
+		at("b.desi", 1, 1),
+		errors.New("later"),
+	}
+	var got []string
+	for _, e := range Tidy(errs) {
+		got = append(got, e.Error())
+	}
+	want := []string{"a.desi:3:2: m", "wrapped: a.desi:3:9: m", "b.desi:1:1: m", "no position", "later"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Tidy = %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package diag
+
+import "sort"
+
+// Registered diagnostic keys. A key names one kind of mistake independently
+// of the message wording, so docs, tests and tools can refer to it. Keys are
+// never reused; L is for the lexer, P for the parser, I for import
+// resolution, E for checker errors (checker warnings keep their W codes), D
+// for deprecated syntax (see Deprecation).
+const (
+	DupParam           = "E0001" // duplicate parameter name
+	AssignParam        = "E0002" // assignment to an immutable parameter
+	MalformedNumber    = "L0001" // malformed numeric literal
+	DollarIdent        = "L0002" // '$' in an identifier
+	UnterminatedString = "L0003" // a string literal with no closing quote
+	BadEscape          = "L0004" // an unknown or malformed escape in a string literal
+	UnexpectedChar     = "L0005" // a character that starts no token (@, `, a stray })
+	BadDedent          = "L0006" // a dedent to a width no enclosing block has
+	MixedIndent        = "L0007" // tabs and spaces mixed in indentation
+	LiteralTooLong     = "L0008" // a string literal over the lexer's size limit
+	TooDeep            = "P0001" // blocks or expressions nested past the parser's limit
+	ImportNotFound     = "I0001" // no search root has the imported file
+	BadImportPath      = "I0002" // an import path that cannot name a file
+	ImportCycle        = "I0003" // modules re-export each other
+	PackageMismatch    = "I0004" // a package clause disagrees with the import path
+	ReassignWithEq     = "D0001" // `x = e` used to reassign instead of `x := e`
+)
+
+var registry = map[string]string{
+	DupParam:           "a parameter name is repeated in one signature",
+	AssignParam:        "a parameter is assigned; parameters are immutable unless declared mut",
+	MalformedNumber:    "a numeric literal has no digits, a stray underscore, or a digit invalid for its base",
+	DollarIdent:        "an identifier contains '$'; Desi identifiers are letters, digits and _ (C compilers disagree on '$')",
+	UnterminatedString: `a string literal has no closing quote (""" strings may span lines but must still be closed)`,
+	BadEscape:          `a string literal has an escape Desi does not know (\q) or a malformed one (\x4, \u{110000}); see docs/spec/syntax.md`,
+	UnexpectedChar:     "a character that cannot start any token, such as @, ` or a } outside an f-string, appears outside a string or comment",
+	BadDedent:          "a line is indented less than its block but more than the block around it; dedent to a column an enclosing block starts at",
+	MixedIndent:        "indentation mixes tabs and spaces, on one line or between a block and the lines in it (a tab counts as 4 columns, so mixing hides mistakes)",
+	LiteralTooLong:     "a string literal is longer than the compiler accepts (1 MiB of source by default); read large data from a file at run time",
+	TooDeep:            "blocks or expressions are nested deeper than the compiler accepts (200 levels by default, within what C compilers take); split them with helper functions or variables",
+	ImportNotFound:     "an imported module is in none of the search roots (the entry file's directory, then $DESI_PATH)",
+	BadImportPath:      "an import path segment is empty or not an identifier",
+	ImportCycle:        "modules re-export each other in a cycle of pub imports; make one of them a plain import",
+	ReassignWithEq:     "a variable is reassigned with = (deprecated); = only initializes in let, := reassigns",
+	PackageMismatch:    "an imported file declares a package other than the path it is imported by (util/text.desi must say package util.text)",
+}
+
+// Explain returns the one-line description of a registered key.
+func Explain(key string) (string, bool) {
+	s, ok := registry[key]
+	return s, ok
+}
+
+// Keys returns every registered key in order.
+func Keys() []string {
+	keys := make([]string, 0, len(registry))
+	for k := range registry {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
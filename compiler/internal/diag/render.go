@@ -0,0 +1,105 @@
+package diag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// SnippetOptions configures Snippet.
+type SnippetOptions struct {
+	// Context is how many lines to show before and after the span.
+	Context int
+	// Line paints a source line, e.g. highlight.Line; nil leaves it plain.
+	Line func(string) string
+}
+
+// Snippet renders the lines of src around sp the way rustc does: a gutter
+// of line numbers wide enough for the last one shown, carets under the span,
+// and o.Context lines on either side. A span with no End gets a single
+// caret. A span ending on a later line is drawn rustc's way too: a rule from
+// the gutter to its start, a bar down the lines it covers, and a rule back
+// to its last character. Snippet returns "" when sp does not point into src.
+func Snippet(src string, sp Span, o SnippetOptions) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	if strings.HasSuffix(src, "\n") {
+		lines = lines[:len(lines)-1]
+	}
+	at := sp.Start.Line
+	if at < 1 || at > len(lines) {
+		return ""
+	}
+	end := at
+	if sp.End.Line > at {
+		end = min(sp.End.Line, len(lines))
+	}
+	first, last := max(at-o.Context, 1), min(end+o.Context, len(lines))
+	width := len(strconv.Itoa(last))
+	pad := strings.Repeat(" ", width)
+	paint := o.Line
+	if paint == nil {
+		paint = func(s string) string { return s }
+	}
+	red := func(s string) string { return term.Paint(term.Red, s) }
+
+	var b strings.Builder
+	gutter := func(label string) string { return term.Paint(term.Blue, label+" |") }
+	row := func(label, mark, text string) {
+		if line := strings.TrimRight(mark+text, " "); line != "" {
+			term.Bprintf(&b, "%s %s\n", gutter(label), line)
+		} else {
+			term.Bprintf(&b, "%s\n", gutter(label))
+		}
+	}
+	row(pad, "", "")
+	for n := first; n <= last; n++ {
+		text := lines[n-1]
+		if text != "" {
+			text = paint(text)
+		}
+		label := fmt.Sprintf("%*d", width, n)
+		switch {
+		case end == at:
+			row(label, "", text)
+			if n == at {
+				row(pad, "", underline(lines[n-1], sp))
+			}
+		case n == at:
+			row(label, "  ", text)
+			col := min(max(sp.Start.Col, 1), len([]rune(lines[n-1]))+1)
+			row(pad, " "+red(strings.Repeat("_", col)+"^"), "")
+		case n > at && n <= end:
+			row(label, red("|")+" ", text)
+			if n == end {
+				col := min(max(sp.End.Col-1, 1), len([]rune(lines[n-1]))+1)
+				row(pad, red("|"+strings.Repeat("_", col)+"^"), "")
+			}
+		default:
+			row(label, "  ", text)
+		}
+	}
+	return b.String()
+}
+
+// underline is the caret line for a single-line sp under text. Tabs before
+// the span are kept so the carets line up however wide the terminal draws
+// them.
+func underline(text string, sp Span) string {
+	rs := []rune(text)
+	start := min(max(sp.Start.Col, 1), len(rs)+1)
+	n := 1
+	if sp.End.Line == sp.Start.Line && sp.End.Col > start {
+		n = sp.End.Col - start
+	}
+	var b strings.Builder
+	for _, r := range rs[:start-1] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String() + term.Paint(term.Red, strings.Repeat("^", n))
+}
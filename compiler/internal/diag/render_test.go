@@ -0,0 +1,65 @@
+package diag
+
+import "testing"
+
+func TestSnippetContext(t *testing.T) {
+	src := "a\nb\nc\nd\ne\nf\ng\nh\ni\nlet x = 1\nk\nl\n"
+	sp := Span{Start: Pos{Line: 10, Col: 5}, End: Pos{Line: 10, Col: 6}}
+	got := Snippet(src, sp, SnippetOptions{Context: 1})
+	want := "" +
+		"   |\n" +
+		" 9 | i\n" +
+		"10 | let x = 1\n" +
+		"   |     ^\n" +
+		"11 | k\n"
+	if got != want {
+		t.Errorf("Snippet =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSnippetEdges(t *testing.T) {
+	src := "def f() -> i32:\n\treturn 0\n"
+	cases := []struct {
+		sp   Span
+		ctx  int
+		want string
+	}{
+		// context is clipped at both ends of the file; tabs stay tabs
+		{Span{Start: Pos{2, 2}, End: Pos{2, 8}}, 3, "" +
+			"  |\n" +
+			"1 | def f() -> i32:\n" +
+			"2 | \treturn 0\n" +
+			"  | \t^^^^^^\n"},
+		// no End: one caret
+		{Span{Start: Pos{1, 5}}, 0, "" +
+			"  |\n" +
+			"1 | def f() -> i32:\n" +
+			"  |     ^\n"},
+		{Span{Start: Pos{3, 1}}, 0, ""},
+		{Span{}, 0, ""},
+	}
+	for _, c := range cases {
+		if got := Snippet(src, c.sp, SnippetOptions{Context: c.ctx}); got != c.want {
+			t.Errorf("Snippet(%v, %d) =\n%q\nwant\n%q", c.sp, c.ctx, got, c.want)
+		}
+	}
+}
+
+func TestSnippetMultiLine(t *testing.T) {
+	src := "def f() -> i32:\n  if x:\n    g()\n    h()\n  return 0\n"
+	// from the `if` to just past h()
+	sp := Span{Start: Pos{Line: 2, Col: 3}, End: Pos{Line: 4, Col: 8}}
+	got := Snippet(src, sp, SnippetOptions{Context: 1})
+	want := "" +
+		"  |\n" +
+		"1 |   def f() -> i32:\n" +
+		"2 |     if x:\n" +
+		"  |  ___^\n" +
+		"3 | |     g()\n" +
+		"4 | |     h()\n" +
+		"  | |_______^\n" +
+		"5 |     return 0\n"
+	if got != want {
+		t.Errorf("Snippet =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+// Package highlight colors Desi source for the terminal by token class, using
+// the term color layer (so -color and NO_COLOR apply).
+package highlight
+
+import (
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/lexer"
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// Classes of tokens painted alike. Identifiers, brackets, separators and
+// layout stay plain.
+var (
+	Keyword  = term.Magenta
+	Constant = term.Cyan // numbers, characters, true/false/none
+	String   = term.Green
+	Operator = term.Yellow
+	Comment  = term.Dim
+)
+
+// color returns the color of a token kind and whether it is painted at all.
+func color(k lexer.TokKind) (term.Color, bool) {
+	switch {
+	case k == lexer.TokComment:
+		return Comment, true
+	case k == lexer.TokInt || k == lexer.TokFloat || k == lexer.TokChar,
+		k == lexer.TokTrue || k == lexer.TokFalse || k == lexer.TokNone:
+		return Constant, true
+	case k == lexer.TokStr, k >= lexer.TokFStrHead && k <= lexer.TokFStrTail:
+		return String, true
+	case k == lexer.TokAnd || k == lexer.TokOr || k == lexer.TokNot:
+		return Operator, true
+	case lexer.IsKeyword(k): // after the word constants and operators
+		return Keyword, true
+	case k >= lexer.TokLParen && k <= lexer.TokComma:
+		return "", false // brackets and separators
+	case k >= lexer.TokEq && k <= lexer.TokDotDotEq:
+		return Operator, true
+	}
+	return "", false
+}
+
+type span struct {
+	start, end int // rune columns, 1-based, half-open
+	c          term.Color
+}
+
+// Source returns src with every token, comments included, painted by class.
+// Text the lexer skips or rejects is copied through unchanged.
+func Source(src string) string {
+	lines := strings.SplitAfter(src, "\n")
+	spans := map[int][]span{} // line → spans, in column order
+	lx := lexer.NewMode(src, lexer.KeepComments)
+	for t := lx.Next(); t.Kind != lexer.TokEOF; t = lx.Next() {
+		c, ok := color(t.Kind)
+		if !ok || t.EndLine < t.Line || t.EndLine == t.Line && t.EndCol <= t.Col {
+			continue
+		}
+		// A token spanning lines (a """ string) is painted line by line.
+		for n, start := t.Line, t.Col; n <= t.EndLine && n <= len(lines); n, start = n+1, 1 {
+			end := t.EndCol
+			if n < t.EndLine {
+				end = len([]rune(strings.TrimRight(lines[n-1], "\r\n"))) + 1
+			}
+			if end > start {
+				spans[n] = append(spans[n], span{start, end, c})
+			}
+		}
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		rs := []rune(line)
+		col := 1
+		for _, sp := range spans[i+1] {
+			if sp.start < col || sp.end-1 > len(rs) {
+				continue
+			}
+			b.WriteString(string(rs[col-1 : sp.start-1]))
+			b.WriteString(term.Paint(sp.c, string(rs[sp.start-1:sp.end-1])))
+			col = sp.end
+		}
+		b.WriteString(string(rs[col-1:]))
+	}
+	return b.String()
+}
+
+// Line paints a single source line, e.g. the snippet under a diagnostic.
+// The line is lexed on its own, so constructs that span lines (""" strings)
+// are colored exactly only by Source.
+func Line(line string) string {
+	return Source(line)
+}
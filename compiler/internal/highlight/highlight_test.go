@@ -0,0 +1,78 @@
+package highlight
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+var ansi = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func withColor(t *testing.T) {
+	t.Helper()
+	if err := term.SetColorMode("always"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = term.SetColorMode("never") })
+}
+
+func TestSourcePaintsByClass(t *testing.T) {
+	withColor(t)
+	src := "def main() -> i32:  # entry\n  let r#if = \"hi\" + 'x'\n  with fs.open(r#if) as f:\n    return 0x1F\n"
+	got := Source(src)
+	for _, want := range []string{
+		term.Paint(Keyword, "def"),
+		term.Paint(Operator, "->"),
+		term.Paint(Comment, "# entry"),
+		term.Paint(Keyword, "let") + " r#if ",
+		term.Paint(Keyword, "with"),
+		term.Paint(String, `"hi"`),
+		term.Paint(Constant, "'x'"),
+		term.Paint(Constant, "0x1F"),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%q", want, got)
+		}
+	}
+	if strings.Contains(got, term.Paint(Operator, "(")) {
+		t.Errorf("brackets should stay plain:\n%q", got)
+	}
+}
+
+func TestSourcePaintsMultiLineStrings(t *testing.T) {
+	withColor(t)
+	got := Source("let s = \"\"\"a\n# not a comment\n\"\"\"  # one\n")
+	for _, want := range []string{
+		term.Paint(String, `"""a`) + "\n",
+		term.Paint(String, "# not a comment") + "\n",
+		term.Paint(String, `"""`) + "  " + term.Paint(Comment, "# one"),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%q", want, got)
+		}
+	}
+}
+
+func TestSourceKeepsText(t *testing.T) {
+	withColor(t)
+	for _, src := range []string{
+		"#!/usr/bin/env desic\ndef main() -> i32:\n\treturn 0\n",
+		"let s = \"unterminated\nlet x = 1 $ 2\r\n",
+		"  let x = 1  # indented alone, as in a snippet",
+		"",
+	} {
+		if got := ansi.ReplaceAllString(Source(src), ""); got != src {
+			t.Errorf("Source(%q) without colors = %q", src, got)
+		}
+	}
+}
+
+func TestSourceWithoutColor(t *testing.T) {
+	_ = term.SetColorMode("never")
+	src := "def main() -> i32:\n  return 0\n"
+	if got := Line(src); got != src {
+		t.Errorf("Line without color = %q, want the input", got)
+	}
+}
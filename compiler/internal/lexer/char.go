@@ -0,0 +1,99 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// CharValue decodes the raw text of a TokChar token ('a', '\n', '\x41',
+// '\u{e9}') to its code point. Supported escapes: \n \t \r \0 \\ \' \"
+// \xHH, \uHHHH and \u{H...} (up to U+10FFFF, no surrogates).
+func CharValue(lex string) (rune, error) {
+	if len(lex) < 2 || lex[0] != '\'' || lex[len(lex)-1] != '\'' || lex == "'" {
+		return 0, fmt.Errorf("unterminated character literal %s", lex)
+	}
+	body := lex[1 : len(lex)-1]
+	if body == "" {
+		return 0, fmt.Errorf("empty character literal ''")
+	}
+	var r rune
+	var rest string
+	if body[0] == '\\' {
+		var err error
+		r, rest, err = charEscape(body)
+		if err != nil {
+			return 0, fmt.Errorf("%v in character literal %s", err, lex)
+		}
+	} else {
+		var n int
+		r, n = utf8.DecodeRuneInString(body)
+		if r == utf8.RuneError && n <= 1 {
+			return 0, fmt.Errorf("invalid UTF-8 in character literal")
+		}
+		rest = body[n:]
+	}
+	if rest != "" {
+		return 0, fmt.Errorf("character literal %s has more than one character (use \"...\" for strings)", lex)
+	}
+	return r, nil
+}
+
+// charEscape decodes the escape at the start of s (which begins with '\').
+func charEscape(s string) (r rune, rest string, err error) {
+	if len(s) < 2 {
+		return 0, "", fmt.Errorf("incomplete escape")
+	}
+	switch s[1] {
+	case 'n':
+		return '\n', s[2:], nil
+	case 't':
+		return '\t', s[2:], nil
+	case 'r':
+		return '\r', s[2:], nil
+	case '0':
+		return 0, s[2:], nil
+	case '\\', '\'', '"':
+		return rune(s[1]), s[2:], nil
+	case 'x':
+		if len(s) < 4 {
+			return 0, "", fmt.Errorf(`\x needs two hex digits`)
+		}
+		n, err := strconv.ParseUint(s[2:4], 16, 8)
+		if err != nil {
+			return 0, "", fmt.Errorf(`\x needs two hex digits`)
+		}
+		return rune(n), s[4:], nil
+	case 'u':
+		if len(s) > 2 && s[2] != '{' {
+			if len(s) < 6 {
+				return 0, "", fmt.Errorf(`\u needs four hex digits or the form \u{H...}`)
+			}
+			n, err := strconv.ParseUint(s[2:6], 16, 32)
+			if err != nil {
+				return 0, "", fmt.Errorf(`\u needs four hex digits or the form \u{H...}`)
+			}
+			if !utf8.ValidRune(rune(n)) {
+				return 0, "", fmt.Errorf(`\u%s is not a valid code point`, s[2:6])
+			}
+			return rune(n), s[6:], nil
+		}
+		end := -1
+		for i := 3; i < len(s); i++ {
+			if s[i] == '}' {
+				end = i
+				break
+			}
+		}
+		if len(s) < 3 || s[2] != '{' || end < 0 {
+			return 0, "", fmt.Errorf(`\u needs four hex digits or the form \u{H...}`)
+		}
+		n, err := strconv.ParseUint(s[3:end], 16, 32)
+		if err != nil || end-3 > 6 || !utf8.ValidRune(rune(n)) {
+			return 0, "", fmt.Errorf(`\u{%s} is not a valid code point`, s[3:end])
+		}
+		return rune(n), s[end+1:], nil
+	default:
+		return 0, "", fmt.Errorf(`unknown escape \%c`, s[1])
+	}
+}
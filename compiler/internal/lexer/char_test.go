@@ -0,0 +1,53 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCharValue(t *testing.T) {
+	cases := []struct {
+		lex  string
+		want rune
+		err  string // substring of the error; "" for none
+	}{
+		{`'a'`, 'a', ""},
+		{`'é'`, 'é', ""},
+		{`'\n'`, '\n', ""},
+		{`'\''`, '\'', ""},
+		{`'\\'`, '\\', ""},
+		{`'\0'`, 0, ""},
+		{`'\x41'`, 'A', ""},
+		{`'\u{1F600}'`, 0x1F600, ""},
+		{`'\u00e9'`, 0xe9, ""},
+		{`'\u00e'`, 0, "four hex digits"},
+		{`''`, 0, "empty character literal"},
+		{`'ab'`, 0, "more than one character"},
+		{`'a`, 0, "unterminated"},
+		{`'\q'`, 0, `unknown escape \q`},
+		{`'\x4'`, 0, "two hex digits"},
+		{`'\u{D800}'`, 0, "not a valid code point"},
+	}
+	for _, tc := range cases {
+		r, err := CharValue(tc.lex)
+		switch {
+		case tc.err == "" && (err != nil || r != tc.want):
+			t.Errorf("%s = %q, %v; want %q", tc.lex, r, err, tc.want)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: err = %v, want one containing %q", tc.lex, err, tc.err)
+		}
+	}
+}
+
+func TestCharTokens(t *testing.T) {
+	l := New(`if c == '\'' or c == 'x':` + "\n")
+	var chars []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		if tok.Kind == TokChar {
+			chars = append(chars, tok.Lex)
+		}
+	}
+	if strings.Join(chars, " ") != `'\'' 'x'` {
+		t.Fatalf("char tokens = %q", chars)
+	}
+}
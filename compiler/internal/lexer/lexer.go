@@ -1,45 +1,239 @@
 package lexer
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/desilang/desi/compiler/internal/diag"
 )
 
 // Lexer scans source into tokens, producing NEWLINE/INDENT/DEDENT like Python.
 // It treats TAB as 4 spaces for indentation. Stage-0 keeps it simple.
 type Lexer struct {
-	src []rune
-	i   int
+	src   []rune        // the part of the source still needed, from rune base on
+	base  int           // index in the source of src[0]
+	rd    *bufio.Reader // more source, for NewFromReader; nil once it is all read
+	rdErr error         // why reading stopped early, if it did
+	i     int           // index in the source of the next rune
+	off   int           // byte offset of rune i
 
-	line int
-	col  int
+	line      int
+	col       int
+	lineStart []int // index into src of each line's first rune
 
 	bol        bool    // beginning-of-line: next non-space decides indentation
 	indents    []int   // stack of indent widths; starts with 0
+	indentChar []rune  // per indents entry: the ' ' or '\t' the block is indented with; 0 at top level
 	pending    []Token // queued tokens (e.g., INDENT/DEDENT/NEWLINE)
 	eofEmitted bool
+	holes      int // f-string holes open at this point; '}' closes the innermost
+
+	errs []diag.Diagnostic // malformed tokens, reported by Errors
+
+	maxLiteral int // bytes of source one string token may span (L0008)
+	mode       Mode
+}
+
+// Mode selects optional lexer behaviour; the zero Mode is what the parser
+// wants.
+type Mode uint
+
+const (
+	// KeepComments emits each comment as a COMMENT token instead of
+	// skipping it, for tools that rebuild source (formatters, doc
+	// generators). A comment does not end a line: the NEWLINE after it,
+	// if any, follows as usual, and comment-only lines still produce no
+	// NEWLINE, INDENT or DEDENT of their own.
+	KeepComments Mode = 1 << iota
+)
+
+// DefaultMaxLiteral is the longest string literal New accepts, in bytes of
+// source. C compilers take longer ones, but not gracefully.
+const DefaultMaxLiteral = 1 << 20
+
+// SetMaxLiteral changes the string literal limit (see DefaultMaxLiteral)
+// for the tokens scanned from now on; n <= 0 means no limit.
+func (lx *Lexer) SetMaxLiteral(n int) { lx.maxLiteral = n }
+
+// Errors returns the diagnostics for malformed tokens scanned so far. The
+// offending tokens are still returned by Next, so scanning continues.
+func (lx *Lexer) Errors() []diag.Diagnostic { return lx.errs }
+
+// ReadError returns the error, other than io.EOF, that stopped a
+// NewFromReader lexer reading its source; the tokens end there as if the
+// source did.
+func (lx *Lexer) ReadError() error { return lx.rdErr }
+
+func (lx *Lexer) errorf(line, col int, code, format string, args ...any) {
+	lx.errs = append(lx.errs, diag.Diagnostic{
+		Span: diag.Span{Start: diag.Pos{Line: line, Col: col}},
+		Code: code,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// tokenErrorf reports a problem with the whole of t, so it is underlined
+// rather than pointed at.
+func (lx *Lexer) tokenErrorf(t Token, code, format string, args ...any) {
+	lx.errorf(t.Line, t.Col, code, format, args...)
+	lx.errs[len(lx.errs)-1].Span.End = diag.Pos{Line: t.EndLine, Col: t.EndCol}
+}
+
+func New(src string) *Lexer { return NewMode(src, 0) }
+
+// NewMode is New with the options in mode.
+func NewMode(src string, mode Mode) *Lexer { return newLexer([]rune(src), nil, mode) }
+
+// NewFromReader is New for a source read from r as it is scanned, so it
+// need not fit in memory: the lexer keeps only the current line (all the
+// lines of a """ string) and what it has read ahead. Tokens, positions,
+// offsets and diagnostics are the same as New's for the same text.
+func NewFromReader(r io.Reader) *Lexer { return newLexer(nil, bufio.NewReader(r), 0) }
+
+func newLexer(src []rune, rd *bufio.Reader, mode Mode) *Lexer {
+	lx := &Lexer{
+		src:        src,
+		rd:         rd,
+		line:       1,
+		col:        0,
+		bol:        true,
+		indents:    []int{0},
+		indentChar: []rune{0},
+		lineStart:  []int{0},
+		maxLiteral: DefaultMaxLiteral,
+		mode:       mode,
+	}
+	lx.fill()
+	lx.skipBOM()
+	lx.skipShebang()
+	return lx
+}
+
+// skipBOM drops a leading UTF-8 byte order mark, which some editors write.
+// It takes no column, and offsets stay byte offsets into the source as given.
+func (lx *Lexer) skipBOM() {
+	if r, _ := lx.runeAt(0); r != '\uFEFF' {
+		return
+	}
+	lx.i, lx.off = 1, utf8.RuneLen('\uFEFF')
+	lx.lineStart[0] = 1
 }
 
-func New(src string) *Lexer {
-	return &Lexer{
-		src:     []rune(src),
-		line:    1,
-		col:     0,
-		bol:     true,
-		indents: []int{0},
+// skipShebang drops a leading "#!..." line so .desi files can be executable
+// scripts. The newline itself is kept so line numbers stay accurate.
+func (lx *Lexer) skipShebang() {
+	if !lx.at("#!") {
+		return
+	}
+	for {
+		ch, ok := lx.peek()
+		if !ok || ch == '\n' {
+			return
+		}
+		lx.advance()
 	}
 }
 
 func (lx *Lexer) enqueue(t Token) { lx.pending = append(lx.pending, t) }
 
+// make builds a token that started at line:col and ends at the current
+// position. NEWLINE and tokens that consume no text (INDENT, DEDENT, EOF)
+// get no end and no length.
 func (lx *Lexer) make(kind TokKind, lex string, line, col int) Token {
-	return Token{Kind: kind, Lex: lex, Line: line, Col: col}
+	t := Token{Kind: kind, Lex: lex, Line: line, Col: col, Offset: lx.off}
+	switch kind {
+	case TokIndent, TokDedent, TokEOF:
+		return t
+	case TokNewline:
+		t.Offset = lx.offsetOf(line, col)
+		return t
+	}
+	t.EndLine, t.EndCol = lx.line, lx.col+1
+	t.Offset = lx.offsetOf(line, col)
+	t.Length = lx.off - t.Offset
+	if isStringKind(kind) && lx.maxLiteral > 0 && len(lex) > lx.maxLiteral {
+		lx.tokenErrorf(t, diag.LiteralTooLong, "string literal is %d bytes long; the limit is %d", len(lex), lx.maxLiteral)
+	}
+	return t
 }
 
-func (lx *Lexer) peek() (rune, bool) {
-	if lx.i >= len(lx.src) {
-		return 0, false
+// offsetOf is the byte offset of line:col, which must not be past the
+// current position.
+func (lx *Lexer) offsetOf(line, col int) int {
+	off := lx.off
+	for _, r := range lx.text(lx.lineStart[line-1] + col - 1) {
+		off -= utf8.RuneLen(r)
+	}
+	return off
+}
+
+// lookahead is how many runes past the current one the scanners may look
+// at (an escape like \u{10FFFF} is the longest thing they look at whole).
+// A NewFromReader lexer keeps that many read ahead.
+const lookahead = 16
+
+// runeAt returns rune j of the source, which must be on the current line
+// or at most lookahead runes past the current position; ok is false past
+// the end.
+func (lx *Lexer) runeAt(j int) (r rune, ok bool) {
+	if k := j - lx.base; k < len(lx.src) {
+		return lx.src[k], true
+	}
+	return 0, false
+}
+
+// fill reads a NewFromReader source until lookahead runes past the current
+// one are in src, or the source ends.
+func (lx *Lexer) fill() {
+	for lx.rd != nil && len(lx.src)-(lx.i-lx.base) <= lookahead {
+		lx.read()
+	}
+}
+
+// read appends the next chunk of a NewFromReader source to src.
+func (lx *Lexer) read() {
+	for range 4096 {
+		r, _, err := lx.rd.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				lx.rdErr = err
+			}
+			lx.rd = nil
+			return
+		}
+		lx.src = append(lx.src, r)
+	}
+}
+
+// text returns the source from rune j up to the current position.
+func (lx *Lexer) text(j int) []rune { return lx.src[j-lx.base : lx.i-lx.base] }
+
+// ahead returns up to n (at most lookahead) runes from the current
+// position on.
+func (lx *Lexer) ahead(n int) string {
+	return string(lx.src[lx.i-lx.base : min(lx.i-lx.base+n, len(lx.src))])
+}
+
+// compact drops the runes before the current line, which no token or span
+// reaches back to, once there are enough of them to be worth copying.
+func (lx *Lexer) compact() {
+	if drop := lx.lineStart[lx.line-1] - lx.base; lx.rd != nil && drop >= 4096 {
+		lx.src = append(lx.src[:0], lx.src[drop:]...)
+		lx.base += drop
 	}
-	return lx.src[lx.i], true
+}
+
+func (lx *Lexer) peek() (rune, bool) { return lx.runeAt(lx.i) }
+
+// digitAt reports whether rune j of the source is a digit.
+func (lx *Lexer) digitAt(j int) bool {
+	r, ok := lx.runeAt(j)
+	return ok && unicode.IsDigit(r)
 }
 
 func (lx *Lexer) advance() (rune, bool) {
@@ -48,9 +242,12 @@ func (lx *Lexer) advance() (rune, bool) {
 		return 0, false
 	}
 	lx.i++
+	lx.off += utf8.RuneLen(ch)
+	lx.fill()
 	if ch == '\n' {
 		lx.line++
 		lx.col = 0
+		lx.lineStart = append(lx.lineStart, lx.i)
 	} else {
 		lx.col++
 	}
@@ -66,7 +263,10 @@ func (lx *Lexer) match(expect rune) bool {
 	return false
 }
 
-func (lx *Lexer) atEOF() bool { return lx.i >= len(lx.src) }
+func (lx *Lexer) atEOF() bool {
+	_, ok := lx.peek()
+	return !ok
+}
 
 // handle beginning-of-line: compute indentation and queue INDENT/DEDENT/skip blanks.
 func (lx *Lexer) handleBOL() {
@@ -83,6 +283,7 @@ func (lx *Lexer) handleBOL() {
 
 		// Count indentation (spaces/tabs) but don't consume newline yet
 		width := 0
+		var spaces, tabs bool
 		for {
 			ch, ok := lx.peek()
 			if !ok {
@@ -90,11 +291,13 @@ func (lx *Lexer) handleBOL() {
 			}
 			if ch == ' ' {
 				width++
+				spaces = true
 				lx.advance()
 				continue
 			}
 			if ch == '\t' {
 				width += 4 // Stage-0: TAB = 4 spaces
+				tabs = true
 				lx.advance()
 				continue
 			}
@@ -104,19 +307,17 @@ func (lx *Lexer) handleBOL() {
 		// Blank or comment-only line? Consume to newline and continue at BOL.
 		if ch, ok := lx.peek(); !ok {
 			// EOF after spaces: just unwind in next loop
-		} else if ch == '\n' {
+		} else if ch == '\n' || lx.at("\r\n") {
+			lx.match('\r')
 			lx.advance() // eat newline
 			// keep bol=true; skip emitting NEWLINE for blank lines
 			continue
 		} else if ch == '#' {
-			// consume comment to end-of-line
-			for {
-				ch, ok := lx.peek()
-				if !ok || ch == '\n' {
-					break
-				}
-				lx.advance()
+			line, col := lx.line, lx.col+1
+			if text := lx.scanComment(); lx.mode&KeepComments != 0 {
+				lx.enqueue(lx.make(TokComment, text, line, col))
 			}
+			lx.match('\r')
 			if lx.match('\n') {
 				// comment-only line: skip NEWLINE
 				continue
@@ -128,15 +329,22 @@ func (lx *Lexer) handleBOL() {
 		top := lx.indents[len(lx.indents)-1]
 		if width > top {
 			lx.indents = append(lx.indents, width)
+			lx.indentChar = append(lx.indentChar, indentChar(spaces, tabs))
 			lx.enqueue(lx.make(TokIndent, "", lx.line, lx.col))
 		} else if width < top {
+			outer := indentWidths(lx.indents[:len(lx.indents)-1])
 			for width < top && len(lx.indents) > 1 {
 				lx.indents = lx.indents[:len(lx.indents)-1]
+				lx.indentChar = lx.indentChar[:len(lx.indentChar)-1]
 				top = lx.indents[len(lx.indents)-1]
 				lx.enqueue(lx.make(TokDedent, "", lx.line, lx.col))
 			}
-			// If width != top here, it's a malformed indent; Stage-0: ignore extra for now.
+			if width != top {
+				lx.errorf(lx.line, lx.col+1, diag.BadDedent,
+					"unindent to width %d matches no enclosing block (widths %s)", width, outer)
+			}
 		}
+		lx.checkIndentChars(spaces, tabs)
 		lx.bol = false
 		// We leave lx.i at first non-space char to be lexed by Next()
 		if len(lx.pending) > 0 {
@@ -147,6 +355,54 @@ func (lx *Lexer) handleBOL() {
 	}
 }
 
+// indentChar is the character a line's indentation is made of, or 0 when
+// it mixes both (already an error) or has none.
+func indentChar(spaces, tabs bool) rune {
+	switch {
+	case spaces && !tabs:
+		return ' '
+	case tabs && !spaces:
+		return '\t'
+	}
+	return 0
+}
+
+// checkIndentChars reports indentation that mixes tabs and spaces, on the
+// line itself or against the block the line now sits in (or opens).
+func (lx *Lexer) checkIndentChars(spaces, tabs bool) {
+	if spaces && tabs {
+		lx.errorf(lx.line, 1, diag.MixedIndent, "indentation mixes tabs and spaces")
+		return
+	}
+	ch := indentChar(spaces, tabs)
+	for _, blk := range lx.indentChar {
+		if ch != 0 && blk != 0 && blk != ch {
+			lx.errorf(lx.line, 1, diag.MixedIndent, "indented with %s, but the enclosing block is indented with %s",
+				indentName(ch), indentName(blk))
+			return
+		}
+	}
+}
+
+func indentName(r rune) string {
+	if r == '\t' {
+		return "tabs"
+	}
+	return "spaces"
+}
+
+// indentWidths lists the enclosing blocks' widths for messages: "0, 2 or 4".
+func indentWidths(indents []int) string {
+	var ws []string
+	for _, w := range indents {
+		ws = append(ws, fmt.Sprint(w))
+	}
+	if len(ws) == 1 {
+		return ws[0]
+	}
+	return strings.Join(ws[:len(ws)-1], ", ") + " or " + ws[len(ws)-1]
+}
+
 // Next returns the next token. It never panics on user input.
 func (lx *Lexer) Next() Token {
 	// Emit any queued tokens first
@@ -155,6 +411,7 @@ func (lx *Lexer) Next() Token {
 		lx.pending = lx.pending[1:]
 		return t
 	}
+	lx.compact()
 
 	// Handle indentation if at beginning of a logical line
 	if lx.bol {
@@ -185,7 +442,7 @@ func (lx *Lexer) Next() Token {
 		if !ok {
 			break
 		}
-		if ch == ' ' || ch == '\t' {
+		if ch == ' ' || ch == '\t' || lx.at("\r\n") {
 			lx.advance()
 			continue
 		}
@@ -198,45 +455,128 @@ func (lx *Lexer) Next() Token {
 	if ch, ok := lx.peek(); ok && ch == '\n' {
 		lx.advance()
 		lx.bol = true
+		lx.holes = 0 // an f-string ends with its line; the parser reports the open hole
 		return lx.make(TokNewline, "", startLine, startCol)
 	}
 
+	// A backslash ending a line joins the next one to it: no NEWLINE, and the
+	// next line's indentation is just spacing.
+	if lx.at("\\\n") || lx.at("\\\r\n") {
+		lx.advance()
+		lx.match('\r')
+		lx.advance()
+		return lx.Next()
+	}
+
 	// Comment mid-line: consume to EOL, then emit NEWLINE
 	if ch, ok := lx.peek(); ok && ch == '#' {
-		for {
-			ch, ok := lx.peek()
-			if !ok || ch == '\n' {
-				break
-			}
-			lx.advance()
+		text := lx.scanComment()
+		if lx.mode&KeepComments != 0 {
+			return lx.make(TokComment, text, startLine, startCol)
 		}
+		lx.match('\r')
 		if lx.match('\n') {
 			lx.bol = true
+			lx.holes = 0
 			return lx.make(TokNewline, "", startLine, startCol)
 		}
-		// EOF after comment
-		return lx.make(TokEOF, "", lx.line, lx.col)
+		// EOF after comment: unwind the indents like any other EOF
+		return lx.Next()
 	}
 
-	// Identifiers / keywords
-	if ch, ok := lx.peek(); ok && (isIdentStart(ch)) {
+	// Identifiers / keywords. '$' is not an identifier character, but it is
+	// scanned with the name so a stray one is reported once, not skipped.
+	if ch, ok := lx.peek(); ok && (isIdentStart(ch) || ch == '$') {
 		lex := lx.scanIdent()
-		if kind, ok := keywordKind(lex); ok {
-			return lx.make(kind, lex, startLine, startCol)
+		if lex == "r" && lx.at(`"`) {
+			text, closed := lx.scanRawString()
+			if !closed {
+				return lx.unterminated(startLine, startCol)
+			}
+			return lx.make(TokStr, text, startLine, startCol)
+		}
+		if lex == "f" && lx.at(`"`) {
+			lx.advance() // consume opening "
+			text, hole, closed := lx.scanFStringText()
+			if !closed {
+				return lx.unterminated(startLine, startCol)
+			}
+			if !hole {
+				return lx.make(TokStr, text, startLine, startCol)
+			}
+			lx.holes++
+			return lx.make(TokFStrHead, text, startLine, startCol)
+		}
+		// r#name is a raw identifier: never a keyword, so names stay usable
+		// when a later version reserves them.
+		next, _ := lx.runeAt(lx.i + 1)
+		raw := lex == "r" && lx.at("#") && isIdentStart(next)
+		if raw {
+			lx.advance()
+			lex = lx.scanIdent()
 		}
-		return lx.make(TokIdent, lex, startLine, startCol)
+		kind, ok := keywordKind(lex)
+		if !ok || raw {
+			kind = TokIdent
+		}
+		t := lx.make(kind, lex, startLine, startCol)
+		if strings.ContainsRune(lex, '$') {
+			lx.tokenErrorf(t, diag.DollarIdent,
+				"identifier %q contains '$': identifiers are letters, digits and _", lex)
+		}
+		return t
 	}
 
-	// Numbers (decimal, 0x..., 0b...)
+	// Numbers (decimal, 0x..., 0b..., with _ separators; decimal floats,
+	// with a point or an exponent).
+	// Separators are dropped from the lexeme of a well-formed literal; a
+	// malformed one keeps its text for the error.
 	if ch, ok := lx.peek(); ok && unicode.IsDigit(ch) {
 		lex := lx.scanNumber()
-		return lx.make(TokInt, lex, startLine, startCol)
+		kind, err := TokInt, error(nil)
+		if isFloatLit(lex) {
+			kind = TokFloat
+			_, err = FloatValue(lex)
+		} else {
+			_, err = IntValue(lex)
+		}
+		if err == nil {
+			return lx.make(kind, strings.ReplaceAll(lex, "_", ""), startLine, startCol)
+		}
+		t := lx.make(kind, lex, startLine, startCol)
+		lx.tokenErrorf(t, diag.MalformedNumber, "%v", err)
+		return t
 	}
 
-	// Strings (simple "..." with basic escapes)
+	// Strings (simple "..." with basic escapes, or """...""" across lines)
+	if lx.at(`"""`) {
+		return lx.make(TokStr, lx.scanTripleString(startLine, startCol), startLine, startCol)
+	}
 	if ch, ok := lx.peek(); ok && ch == '"' {
-		lex := lx.scanString()
-		return lx.make(TokStr, lex, startLine, startCol)
+		if !lx.scanString() {
+			return lx.unterminated(startLine, startCol)
+		}
+		return lx.make(TokStr, string(lx.text(lx.lineStart[startLine-1]+startCol-1)), startLine, startCol)
+	}
+
+	// Character literals ('a', '\n'); validated by CharValue in the parser
+	if ch, ok := lx.peek(); ok && ch == '\'' {
+		lex := lx.scanChar()
+		return lx.make(TokChar, lex, startLine, startCol)
+	}
+
+	// The '}' that closes an f-string hole resumes the string's text.
+	if lx.holes > 0 && lx.match('}') {
+		lx.holes--
+		text, hole, closed := lx.scanFStringText()
+		if !closed {
+			return lx.unterminated(startLine, startCol)
+		}
+		if !hole {
+			return lx.make(TokFStrTail, text, startLine, startCol)
+		}
+		lx.holes++
+		return lx.make(TokFStrMid, text, startLine, startCol)
 	}
 
 	// Multi-char operators first
@@ -250,6 +590,9 @@ func (lx *Lexer) Next() Token {
 		if lx.match('>') {
 			return lx.make(TokArrow, "->", startLine, startCol)
 		}
+		if lx.match('=') {
+			return lx.make(TokMinusEq, "-=", startLine, startCol)
+		}
 		return lx.make(TokMinus, "-", startLine, startCol)
 	}
 	if lx.match('=') {
@@ -286,15 +629,30 @@ func (lx *Lexer) Next() Token {
 
 	// Single-char punctuation
 	if lx.match('+') {
+		if lx.match('=') {
+			return lx.make(TokPlusEq, "+=", startLine, startCol)
+		}
 		return lx.make(TokPlus, "+", startLine, startCol)
 	}
 	if lx.match('*') {
+		if lx.match('*') {
+			return lx.make(TokStarStar, "**", startLine, startCol)
+		}
+		if lx.match('=') {
+			return lx.make(TokStarEq, "*=", startLine, startCol)
+		}
 		return lx.make(TokStar, "*", startLine, startCol)
 	}
 	if lx.match('/') {
+		if lx.match('=') {
+			return lx.make(TokSlashEq, "/=", startLine, startCol)
+		}
 		return lx.make(TokSlash, "/", startLine, startCol)
 	}
 	if lx.match('%') {
+		if lx.match('=') {
+			return lx.make(TokPercentEq, "%=", startLine, startCol)
+		}
 		return lx.make(TokPercent, "%", startLine, startCol)
 	}
 	if lx.match('(') {
@@ -310,151 +668,319 @@ func (lx *Lexer) Next() Token {
 		return lx.make(TokRBrack, "]", startLine, startCol)
 	}
 	if lx.match('.') {
+		if lx.match('.') {
+			if lx.match('=') {
+				return lx.make(TokDotDotEq, "..=", startLine, startCol)
+			}
+			return lx.make(TokDotDot, "..", startLine, startCol)
+		}
 		return lx.make(TokDot, ".", startLine, startCol)
 	}
 	if lx.match(',') {
 		return lx.make(TokComma, ",", startLine, startCol)
 	}
+	if lx.match('?') {
+		if lx.match('.') {
+			return lx.make(TokQuestionDot, "?.", startLine, startCol)
+		}
+		if lx.match('?') {
+			return lx.make(TokCoalesce, "??", startLine, startCol)
+		}
+		return lx.make(TokQuestion, "?", startLine, startCol)
+	}
+
+	// Unknown character: report it and hand the parser an error token, so
+	// the mistake is named where it is rather than by what it breaks later.
+	ch, _ := lx.advance()
+	hint := ""
+	if ch == '\\' {
+		hint = " (a \\ continuing a line must be its last character)"
+	}
+	t := lx.make(TokErr, string(ch), startLine, startCol)
+	lx.tokenErrorf(t, diag.UnexpectedChar, "unexpected character %s%s", quoteChar(ch), hint)
+	return t
+}
 
-	// Unknown character: skip it and continue (Stage-0 lenient)
-	lx.advance()
-	return lx.Next()
+// scanComment consumes a comment from its '#' up to, not including, the
+// end of the line and returns its text.
+func (lx *Lexer) scanComment() string {
+	start := lx.i
+	for {
+		ch, ok := lx.peek()
+		if !ok || ch == '\n' || lx.at("\r\n") {
+			break
+		}
+		lx.advance()
+	}
+	return string(lx.text(start))
+}
+
+// quoteChar spells r for a message: `@`, or its code point if it would not
+// show (U+200B).
+func quoteChar(r rune) string {
+	if unicode.IsPrint(r) && r != '`' {
+		return "`" + string(r) + "`"
+	}
+	return fmt.Sprintf("U+%04X", r)
 }
 
 // ----- scanning helpers -----
 
+// isStringKind reports whether k is a string literal or a piece of an
+// f-string, the tokens whose length is limited.
+func isStringKind(k TokKind) bool {
+	return k == TokStr || k >= TokFStrHead && k <= TokFStrTail
+}
+
 func isIdentStart(r rune) bool {
-	return r == '_' || r == '$' || unicode.IsLetter(r)
+	return r == '_' || unicode.IsLetter(r)
 }
 func isIdentPart(r rune) bool {
-	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
 func (lx *Lexer) scanIdent() string {
 	start := lx.i
 	for {
 		r, ok := lx.peek()
-		if !ok || !isIdentPart(r) {
+		if !ok || (!isIdentPart(r) && r != '$') {
 			break
 		}
 		lx.advance()
 	}
-	return string(lx.src[start:lx.i])
+	return string(lx.text(start))
 }
 
+// scanNumber consumes a numeric literal, including any letters and
+// underscores glued to it ("0x", "12ab", "1__0"), so IntValue can reject the
+// whole literal instead of the lexer splitting it into odd tokens. A '.'
+// followed by a digit continues a decimal literal as a float ("0.25"); any
+// other '.' is left for the next token, as in "1.f()". So is a sign after
+// an exponent marker ("2.5e-3"), which the letters already took in.
 func (lx *Lexer) scanNumber() string {
 	start := lx.i
-	// 0x / 0b prefixes
-	if ch, ok := lx.peek(); ok && ch == '0' {
+	lx.scanAlnum()
+	lit := lx.text(start)
+	prefixed := len(lit) >= 2 && lit[0] == '0' && strings.ContainsRune("xXbB", lit[1])
+	if prefixed {
+		return string(lit)
+	}
+	if lx.at(".") && lx.digitAt(lx.i+1) {
 		lx.advance()
-		if ch2, ok2 := lx.peek(); ok2 && (ch2 == 'x' || ch2 == 'X') {
+		lx.scanAlnum()
+	}
+	if last, _ := lx.runeAt(lx.i - 1); last == 'e' || last == 'E' {
+		if (lx.at("+") || lx.at("-")) && lx.digitAt(lx.i+1) {
 			lx.advance()
-			for {
-				r, ok := lx.peek()
-				if !ok || !(unicode.IsDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')) {
-					break
-				}
-				lx.advance()
-			}
-			return string(lx.src[start:lx.i])
+			lx.scanAlnum()
 		}
-		if ch2, ok2 := lx.peek(); ok2 && (ch2 == 'b' || ch2 == 'B') {
-			lx.advance()
-			for {
-				r, ok := lx.peek()
-				if !ok || !(r == '0' || r == '1') {
-					break
-				}
-				lx.advance()
-			}
-			return string(lx.src[start:lx.i])
-		}
-		// fallthrough to decimal after single '0'
 	}
+	return string(lx.text(start))
+}
+
+// isFloatLit reports whether a scanned number is a float: a decimal
+// literal with a point or an exponent.
+func isFloatLit(lex string) bool {
+	if len(lex) >= 2 && lex[0] == '0' && strings.ContainsRune("xXbB", rune(lex[1])) {
+		return false
+	}
+	return strings.ContainsAny(lex, ".eE")
+}
+
+// scanAlnum consumes letters, digits and underscores.
+func (lx *Lexer) scanAlnum() {
 	for {
 		r, ok := lx.peek()
-		if !ok || !unicode.IsDigit(r) {
+		if !ok || !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
 			break
 		}
 		lx.advance()
 	}
-	return string(lx.src[start:lx.i])
 }
 
-func (lx *Lexer) scanString() string {
-	start := lx.i
+// scanString consumes a "..." literal and reports whether it was closed; one
+// that is not stops at the end of the line.
+func (lx *Lexer) scanString() (closed bool) {
 	lx.advance() // consume opening "
+	for !lx.atLineEnd() {
+		if r, _ := lx.peek(); r == '\\' {
+			lx.scanEscape()
+			continue
+		}
+		if r, _ := lx.advance(); r == '"' {
+			return true
+		}
+	}
+	return false
+}
+
+// atLineEnd reports whether the line ends here: at "\n", "\r\n" or the end
+// of the source.
+func (lx *Lexer) atLineEnd() bool {
+	return lx.atEOF() || lx.at("\n") || lx.at("\r\n")
+}
+
+// unterminated returns a string literal that started at line:col and has
+// no closing quote as a TokErr holding its text so far.
+func (lx *Lexer) unterminated(line, col int) Token {
+	t := lx.make(TokErr, string(lx.text(lx.lineStart[line-1]+col-1)), line, col)
+	lx.tokenErrorf(t, diag.UnterminatedString, "unterminated string literal: it needs a closing \" on the same line")
+	return t
+}
+
+// at reports whether the source continues with s.
+func (lx *Lexer) at(s string) bool {
+	i := lx.i
+	for _, r := range s {
+		if c, ok := lx.runeAt(i); !ok || c != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// scanTripleString consumes a """...""" literal, which may span lines. The
+// lexeme is the equivalent one-line literal: line breaks become \n, quotes
+// are escaped, and escapes are kept, except that a backslash at the end of
+// a line joins it to the next. A literal that never closes runs to the end
+// of the file and is reported.
+func (lx *Lexer) scanTripleString(line, col int) string {
+	for range 3 {
+		lx.advance()
+	}
+	var b strings.Builder
+	b.WriteByte('"')
 	for {
-		r, ok := lx.peek()
+		if lx.at(`"""`) {
+			for range 3 {
+				lx.advance()
+			}
+			break
+		}
+		if lx.at("\\\n") || lx.at("\\\r\n") {
+			lx.advance()
+			lx.match('\r')
+			lx.advance()
+			continue
+		}
+		if lx.at("\\") {
+			b.WriteString(lx.scanEscape())
+			continue
+		}
+		r, ok := lx.advance()
 		if !ok {
+			lx.errorf(line, col, diag.UnterminatedString, `unterminated """ string`)
 			break
 		}
+		switch {
+		case r == '\r' && lx.at("\n"):
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// scanRawString consumes the "..." of r"...". Backslashes are not escapes,
+// so the literal ends at the first quote; closed is false if the line ends
+// first. The lexeme is the equivalent plain literal, with every backslash
+// doubled, so later stages never see the difference.
+func (lx *Lexer) scanRawString() (lex string, closed bool) {
+	lx.advance() // consume opening "
+	var b strings.Builder
+	b.WriteByte('"')
+	for !lx.atLineEnd() {
+		r, _ := lx.advance()
+		if r == '"' {
+			b.WriteByte('"')
+			return b.String(), true
+		}
 		if r == '\\' {
-			lx.advance() // backslash
-			_, _ = lx.advance()
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), false
+}
+
+// scanFStringText consumes f-string text up to the '{' that opens a hole
+// (reporting hole) or the closing quote. The lexeme is a plain literal
+// holding just that text, with {{ and }} undoubled. closed is false if the
+// line ends first.
+func (lx *Lexer) scanFStringText() (lex string, hole, closed bool) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for !lx.atLineEnd() {
+		r, _ := lx.peek()
+		if r == '\\' {
+			b.WriteString(lx.scanEscape())
 			continue
 		}
+		lx.advance()
 		if r == '"' {
-			lx.advance()
+			closed = true
 			break
 		}
-		// allow newlines to terminate strings? Stage-0: stop at newline too
-		if r == '\n' {
+		if r == '{' && !lx.match('{') {
+			hole, closed = true, true
+			break
+		}
+		if r == '}' {
+			lx.match('}') // }} is one brace; a lone } is kept as is
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String(), hole, closed
+}
+
+// scanChar consumes a '...' literal up to the closing quote or end of line.
+func (lx *Lexer) scanChar() string {
+	start := lx.i
+	lx.advance() // consume opening '
+	for {
+		r, ok := lx.peek()
+		if !ok || r == '\n' {
 			break
 		}
 		lx.advance()
+		if r == '\\' {
+			if r2, ok := lx.peek(); ok && r2 != '\n' {
+				lx.advance()
+			}
+			continue
+		}
+		if r == '\'' {
+			break
+		}
 	}
-	return string(lx.src[start:lx.i])
+	return string(lx.text(start))
 }
 
 // keywordKind maps identifiers to keyword tokens.
 func keywordKind(s string) (TokKind, bool) {
-	switch s {
-	case "let":
-		return TokLet, true
-	case "mut":
-		return TokMut, true
-	case "def":
-		return TokDef, true
-	case "return":
-		return TokReturn, true
-	case "if":
-		return TokIf, true
-	case "elif":
-		return TokElif, true
-	case "else":
-		return TokElse, true
-	case "while":
-		return TokWhile, true
-	case "for":
-		return TokFor, true
-	case "in":
-		return TokIn, true
-	case "match":
-		return TokMatch, true
-	case "struct":
-		return TokStruct, true
-	case "enum":
-		return TokEnum, true
-	case "package":
-		return TokPackage, true
-	case "import":
-		return TokImport, true
-	case "as":
-		return TokAs, true
-	case "true":
-		return TokTrue, true
-	case "false":
-		return TokFalse, true
-	case "and":
-		return TokAnd, true
-	case "or":
-		return TokOr, true
-	case "not":
-		return TokNot, true
-	case "defer":
-		return TokDefer, true
-	default:
-		return 0, false
+	k, ok := keywords[s]
+	return k, ok
+}
+
+// IsKeyword reports whether k is the token of a reserved word.
+func IsKeyword(k TokKind) bool {
+	_, ok := keywordText[k]
+	return ok
+}
+
+// Keywords returns the reserved words, sorted.
+func Keywords() []string {
+	out := make([]string, 0, len(keywords))
+	for k := range keywords {
+		out = append(out, k)
 	}
+	sort.Strings(out)
+	return out
 }
@@ -1,9 +1,25 @@
 package lexer
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+)
 
 func kindsFrom(src string) []TokKind {
-	l := New(src)
+	return kindsFromLexer(New(src))
+}
+
+func kindsFromLexer(l *Lexer) []TokKind {
 	var kinds []TokKind
 	for {
 		t := l.Next()
@@ -63,3 +79,501 @@ func TestIndentDedent(t *testing.T) {
 		}
 	}
 }
+
+func TestShebangSkipped(t *testing.T) {
+	src := "#!/usr/bin/env -S desic run\nlet x = 1\n"
+	l := New(src)
+	tok := l.Next()
+	if tok.Kind != TokLet {
+		t.Fatalf("first token = %v, want let", tok.Kind)
+	}
+	if tok.Line != 2 || tok.Col != 1 {
+		t.Fatalf("let at %d:%d, want 2:1", tok.Line, tok.Col)
+	}
+}
+
+func TestBOMSkipped(t *testing.T) {
+	for _, src := range []string{"\uFEFFlet x = 1\n", "\uFEFF#!/usr/bin/env desic\nlet x = 1\n"} {
+		l := New(src)
+		tok := l.Next()
+		if tok.Kind != TokLet || tok.Col != 1 {
+			t.Fatalf("%q: first token %v at %d:%d, want let at column 1", src, tok.Kind, tok.Line, tok.Col)
+		}
+		// the offset still indexes the source as given, BOM included
+		if got := src[tok.Offset : tok.Offset+tok.Length]; got != "let" {
+			t.Errorf("%q: token text %q, want let", src, got)
+		}
+		for tok.Kind != TokEOF {
+			tok = l.Next()
+		}
+		if errs := l.Errors(); len(errs) != 0 {
+			t.Errorf("%q: errors = %v", src, errs)
+		}
+	}
+}
+
+func TestDollarInIdentifier(t *testing.T) {
+	l := New("let $a = b$c\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// each name stays one token, with one error apiece
+	if toks[1].Kind != TokIdent || toks[1].Lex != "$a" || toks[3].Kind != TokIdent || toks[3].Lex != "b$c" {
+		t.Fatalf("tokens = %v", toks)
+	}
+	errs := l.Errors()
+	if len(errs) != 2 || errs[0].Code != diag.DollarIdent {
+		t.Fatalf("errors = %v", errs)
+	}
+	if got, want := errs[1].Error(), `1:10: L0002: identifier "b$c" contains '$': identifiers are letters, digits and _`; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+}
+
+func TestRawIdentifiers(t *testing.T) {
+	l := New("let r#match = r#foo + r #x\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// r#name is an identifier even for keywords; "r #x" is r and a comment
+	want := []struct {
+		kind TokKind
+		lex  string
+	}{{TokLet, "let"}, {TokIdent, "match"}, {TokEq, "="}, {TokIdent, "foo"}, {TokPlus, "+"}, {TokIdent, "r"}, {TokNewline, ""}}
+	if len(toks) != len(want) {
+		t.Fatalf("tokens = %v", toks)
+	}
+	for i, w := range want {
+		if toks[i].Kind != w.kind || toks[i].Lex != w.lex {
+			t.Errorf("token %d = %v %q, want %v %q", i, toks[i].Kind, toks[i].Lex, w.kind, w.lex)
+		}
+	}
+	if toks[1].Col != 5 || toks[1].EndCol != 12 {
+		t.Errorf("r#match at cols %d-%d, want 5-12 (the r to past the h)", toks[1].Col, toks[1].EndCol)
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	l := New(`let p = r"C:\new\d+" + r"" + r "x"` + "\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// backslashes come out doubled; "r" then a space is an identifier
+	want := []struct {
+		kind TokKind
+		lex  string
+	}{{TokLet, "let"}, {TokIdent, "p"}, {TokEq, "="}, {TokStr, `"C:\\new\\d+"`}, {TokPlus, "+"}, {TokStr, `""`}, {TokPlus, "+"}, {TokIdent, "r"}, {TokStr, `"x"`}, {TokNewline, ""}}
+	if len(toks) != len(want) {
+		t.Fatalf("tokens = %v", toks)
+	}
+	for i, w := range want {
+		if toks[i].Kind != w.kind || toks[i].Lex != w.lex {
+			t.Errorf("token %d = %v %q, want %v %q", i, toks[i].Kind, toks[i].Lex, w.kind, w.lex)
+		}
+	}
+	if toks[3].Col != 9 || toks[3].EndCol != 21 {
+		t.Errorf("raw string at cols %d-%d, want 9-21", toks[3].Col, toks[3].EndCol)
+	}
+}
+
+func TestUnexpectedChars(t *testing.T) {
+	l := New("let a = b @ c\r\n\r\nx`}\u200b\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// a CRLF ends a line like LF; each stray character is one ERROR token
+	want := []TokKind{TokLet, TokIdent, TokEq, TokIdent, TokErr, TokIdent, TokNewline, TokIdent, TokErr, TokErr, TokErr, TokNewline}
+	var kinds []TokKind
+	for _, tok := range toks {
+		kinds = append(kinds, tok.Kind)
+	}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("kinds = %v\nwant    %v", kinds, want)
+	}
+	if toks[4].Lex != "@" || toks[4].Line != 1 || toks[4].Col != 11 {
+		t.Errorf("error token = %q at %d:%d, want \"@\" at 1:11", toks[4].Lex, toks[4].Line, toks[4].Col)
+	}
+	var msgs []string
+	for _, e := range l.Errors() {
+		if e.Code != diag.UnexpectedChar {
+			t.Errorf("code = %s, want %s", e.Code, diag.UnexpectedChar)
+		}
+		msgs = append(msgs, e.Msg)
+	}
+	wantMsgs := []string{"unexpected character `@`", "unexpected character U+0060", "unexpected character `}`", "unexpected character U+200B"}
+	if !slices.Equal(msgs, wantMsgs) {
+		t.Errorf("messages = %q, want %q", msgs, wantMsgs)
+	}
+}
+
+func TestAugmentedAssignOps(t *testing.T) {
+	kinds := kindsFrom("x += 1 -= 2 *= 3 /= 4 %= 5 ** -> - =\n")
+	want := []TokKind{
+		TokIdent, TokPlusEq, TokInt, TokMinusEq, TokInt, TokStarEq, TokInt, TokSlashEq, TokInt, TokPercentEq, TokInt,
+		TokStarStar, TokArrow, TokMinus, TokEq, TokNewline, TokEOF,
+	}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %v\nwant    %v", kinds, want)
+	}
+}
+
+func TestQuestionOps(t *testing.T) {
+	// the longest match wins: ?? and ?. are one token each, ??? is ?? then ?
+	kinds := kindsFrom("a ?? b?.c f()? str? ??? ?.5\n")
+	want := []TokKind{
+		TokIdent, TokCoalesce, TokIdent, TokQuestionDot, TokIdent, TokIdent, TokLParen, TokRParen, TokQuestion,
+		TokIdent, TokQuestion, TokCoalesce, TokQuestion, TokQuestionDot, TokInt, TokNewline, TokEOF,
+	}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %v\nwant    %v", kinds, want)
+	}
+}
+
+func TestRangeOps(t *testing.T) {
+	// 0..10 is not the float 0. then .10
+	kinds := kindsFrom("0..10 1..=n a.b 1.5..2\n")
+	want := []TokKind{
+		TokInt, TokDotDot, TokInt, TokInt, TokDotDotEq, TokIdent, TokIdent, TokDot, TokIdent,
+		TokFloat, TokDotDot, TokInt, TokNewline, TokEOF,
+	}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %v\nwant    %v", kinds, want)
+	}
+}
+
+func TestFStrings(t *testing.T) {
+	l := New(`io.println(f"a{x + 1}b\t{f"{y}"}{{c}}", f"}plain")` + "\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// an f-string without holes is a plain STR; {{ and }} are literal braces
+	want := []struct {
+		kind TokKind
+		lex  string
+	}{
+		{TokIdent, "io"}, {TokDot, "."}, {TokIdent, "println"}, {TokLParen, "("},
+		{TokFStrHead, `"a"`}, {TokIdent, "x"}, {TokPlus, "+"}, {TokInt, "1"},
+		{TokFStrMid, `"b\t"`}, {TokFStrHead, `""`}, {TokIdent, "y"}, {TokFStrTail, `""`},
+		{TokFStrTail, `"{c}"`}, {TokComma, ","}, {TokStr, `"}plain"`}, {TokRParen, ")"}, {TokNewline, ""},
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("tokens = %v", toks)
+	}
+	for i, w := range want {
+		if toks[i].Kind != w.kind || toks[i].Lex != w.lex {
+			t.Errorf("token %d = %v %q, want %v %q", i, toks[i].Kind, toks[i].Lex, w.kind, w.lex)
+		}
+	}
+	if len(l.Errors()) > 0 {
+		t.Errorf("errors = %v", l.Errors())
+	}
+}
+
+func TestTripleQuotedStrings(t *testing.T) {
+	src := "let s = \"\"\"a \"q\"\r\n  b\\\nc\\t\"\"\" + x\n"
+	l := New(src)
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	if len(toks) != 7 {
+		t.Fatalf("tokens = %v", toks)
+	}
+	s := toks[3]
+	if want := `"a \"q\"\n  bc\t"`; s.Kind != TokStr || s.Lex != want {
+		t.Errorf("string = %v %q, want %q", s.Kind, s.Lex, want)
+	}
+	if s.Line != 1 || s.Col != 9 || s.EndLine != 3 || s.EndCol != 7 {
+		t.Errorf("string at %d:%d-%d:%d, want 1:9-3:7", s.Line, s.Col, s.EndLine, s.EndCol)
+	}
+	// positions after the literal count its lines
+	if x := toks[5]; x.Lex != "x" || x.Line != 3 || x.Col != 10 {
+		t.Errorf("x at %d:%d, want 3:10", x.Line, x.Col)
+	}
+	if len(l.Errors()) != 0 {
+		t.Errorf("errors = %v", l.Errors())
+	}
+
+	l = New("let s = \"\"\"open\n\n")
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+	}
+	if errs := l.Errors(); len(errs) != 1 || errs[0].Code != diag.UnterminatedString || errs[0].Span.Start != (diag.Pos{Line: 1, Col: 9}) {
+		t.Errorf("errors = %v, want one L0003 at 1:9", errs)
+	}
+}
+
+func TestTokenEndCol(t *testing.T) {
+	l := New("let s = \"añb\"  # c\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// columns count runes; the newline ends on the next line and gets none
+	want := [][2]int{{1, 4}, {5, 6}, {7, 8}, {9, 14}, {0, 0}}
+	if len(toks) != len(want) {
+		t.Fatalf("tokens = %v", toks)
+	}
+	for i, w := range want {
+		if w[0] != 0 && (toks[i].Col != w[0] || toks[i].EndCol != w[1]) {
+			t.Errorf("token %d (%v) at cols %d-%d, want %d-%d", i, toks[i].Kind, toks[i].Col, toks[i].EndCol, w[0], w[1])
+		}
+	}
+	if toks[4].EndCol != 0 {
+		t.Errorf("NEWLINE EndCol = %d, want 0", toks[4].EndCol)
+	}
+}
+
+func TestTokenOffsets(t *testing.T) {
+	src := "let s = \"añb\"\nif é:\n    \"\"\"x\ny\"\"\"\n"
+	l := New(src)
+	var got []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		switch tok.Kind {
+		case TokNewline, TokIndent, TokDedent:
+			if tok.Length != 0 {
+				t.Errorf("%v at %d:%d has length %d, want 0", tok.Kind, tok.Line, tok.Col, tok.Length)
+			}
+		default:
+			got = append(got, src[tok.Offset:tok.Offset+tok.Length])
+		}
+	}
+	// offsets and lengths count bytes, so each slices out the token's text
+	want := []string{"let", "s", "=", `"añb"`, "if", "é", ":", "\"\"\"x\ny\"\"\""}
+	if !slices.Equal(got, want) {
+		t.Errorf("token text = %q\nwant         %q", got, want)
+	}
+}
+
+func TestKeepComments(t *testing.T) {
+	src := "# head\nif a:  # why\r\n    # inside\n    b\n# tail"
+	if got, want := kindsFrom(src), []TokKind{TokIf, TokIdent, TokColon, TokNewline, TokIndent, TokIdent, TokNewline, TokDedent, TokEOF}; !slices.Equal(got, want) {
+		t.Errorf("without KeepComments: %v\nwant %v", got, want)
+	}
+	l := NewMode(src, KeepComments)
+	var kinds []TokKind
+	var comments []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		kinds = append(kinds, tok.Kind)
+		if tok.Kind == TokComment {
+			comments = append(comments, fmt.Sprintf("%d:%d %s", tok.Line, tok.Col, tok.Lex))
+			if got := src[tok.Offset : tok.Offset+tok.Length]; got != tok.Lex {
+				t.Errorf("comment %q spans %q", tok.Lex, got)
+			}
+		}
+	}
+	// comments come in source order; the layout tokens are unchanged
+	want := []TokKind{TokComment, TokIf, TokIdent, TokColon, TokComment, TokNewline, TokComment, TokIndent, TokIdent, TokNewline, TokComment, TokDedent}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds = %v\nwant    %v", kinds, want)
+	}
+	wantComments := []string{"1:1 # head", "2:8 # why", "3:5 # inside", "5:1 # tail"}
+	if !slices.Equal(comments, wantComments) {
+		t.Errorf("comments = %q, want %q", comments, wantComments)
+	}
+}
+
+func TestKeywordsMatchLexer(t *testing.T) {
+	kws := Keywords()
+	for i, k := range kws {
+		if i > 0 && kws[i-1] >= k {
+			t.Errorf("Keywords not sorted at %q", k)
+		}
+		if tok := New(k).Next(); tok.Kind == TokIdent {
+			t.Errorf("%q is listed as a keyword but lexes as an identifier", k)
+		}
+	}
+}
+
+func TestEveryKindNamed(t *testing.T) {
+	// a keyword kind missing from the keywords table would print as TokKind(n)
+	for k := range tokCount {
+		if name := k.String(); strings.HasPrefix(name, "TokKind(") {
+			t.Errorf("kind %d has no name", int(k))
+		}
+	}
+	for _, kw := range Keywords() {
+		if tok := New(kw).Next(); tok.Kind.String() != kw {
+			t.Errorf("%q lexes as %v", kw, tok.Kind)
+		}
+	}
+}
+
+func TestBadDedent(t *testing.T) {
+	l := New("if a:\n    if b:\n        x\n  y\nz\n")
+	kindsFromLexer(l)
+	errs := l.Errors()
+	if len(errs) != 1 || errs[0].Code != diag.BadDedent {
+		t.Fatalf("errors = %v, want one %s", errs, diag.BadDedent)
+	}
+	if got, want := errs[0].Error(), "4:3: L0006: unindent to width 2 matches no enclosing block (widths 0 or 4)"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+}
+
+func TestMixedIndent(t *testing.T) {
+	cases := []struct{ src, msg string }{
+		{"if a:\n \tx\n", "indentation mixes tabs and spaces"},
+		{"if a:\n    x\n\ty\n", "indented with tabs, but the enclosing block is indented with spaces"},
+		{"if a:\n\tif b:\n\t\tx\n    y\n", "indented with spaces, but the enclosing block is indented with tabs"},
+	}
+	for _, c := range cases {
+		l := New(c.src)
+		kindsFromLexer(l)
+		errs := l.Errors()
+		if len(errs) != 1 || errs[0].Code != diag.MixedIndent || errs[0].Msg != c.msg {
+			t.Errorf("%q: errors = %v, want one %s %q", c.src, errs, diag.MixedIndent, c.msg)
+		}
+	}
+	// one kind per file is fine, whichever it is
+	for _, src := range []string{"if a:\n\tif b:\n\t\tx\n\ty\n", "if a:\n  if b:\n    x\n  y\n"} {
+		l := New(src)
+		kindsFromLexer(l)
+		if errs := l.Errors(); len(errs) != 0 {
+			t.Errorf("%q: errors = %v", src, errs)
+		}
+	}
+}
+
+func TestLineContinuation(t *testing.T) {
+	// the joined line's indentation opens no block, and CRLF works too
+	got := kindsFrom("if a:\n  let x = 1 + \\\n      2 * \\\r\n3\n  y\n")
+	want := []TokKind{TokIf, TokIdent, TokColon, TokNewline, TokIndent,
+		TokLet, TokIdent, TokEq, TokInt, TokPlus, TokInt, TokStar, TokInt, TokNewline,
+		TokIdent, TokNewline, TokDedent, TokEOF}
+	if !slices.Equal(got, want) {
+		t.Fatalf("kinds = %v\nwant    %v", got, want)
+	}
+
+	l := New("let x = 1 + \\ \n  2\n")
+	kindsFromLexer(l)
+	if errs := l.Errors(); len(errs) != 1 || errs[0].Error() != "1:13: L0005: unexpected character `\\` (a \\ continuing a line must be its last character)" {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestReaderMatchesString(t *testing.T) {
+	var big strings.Builder
+	for i := range 3000 {
+		fmt.Fprintf(&big, "def f%d(a: i32) -> str:\n    return f\"é{a}\" + r\"\\d\"  # %d\n", i, i)
+		if i%700 == 0 {
+			big.WriteString("let s = \"\"\"spans\n" + strings.Repeat("x", 5000) + "\nlines\"\"\"\n")
+		}
+	}
+	srcs := []string{
+		big.String(),
+		"\uFEFF#!/bin/desic\nif a:\r\n\tb = 0x1f + 2.5e-3\n  c\n",
+		"let s = \"abc\nlet t = 'x\nlet u = \"\\q\" $ 1__0",
+	}
+	for _, src := range srcs {
+		want := New(src)
+		for _, r := range []io.Reader{strings.NewReader(src), iotest.OneByteReader(strings.NewReader(src))} {
+			got := NewFromReader(r)
+			window := 0
+			for {
+				wt, gt := want.Next(), got.Next()
+				if gt != wt {
+					t.Fatalf("token %+v from the reader, want %+v", gt, wt)
+				}
+				window = max(window, len(got.src))
+				if wt.Kind == TokEOF {
+					break
+				}
+			}
+			if !slices.Equal(got.Errors(), want.Errors()) || got.ReadError() != nil {
+				t.Errorf("errors %v, %v from the reader, want %v", got.Errors(), got.ReadError(), want.Errors())
+			}
+			want = New(src)
+			// only the current line and a chunk read ahead are kept
+			if window > 3*4096+5100 {
+				t.Errorf("lexer held %d runes of a %d-byte source", window, len(src))
+			}
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	l := NewFromReader(io.MultiReader(strings.NewReader("let x = 1\n"), iotest.ErrReader(boom)))
+	if got, want := kindsFromLexer(l), []TokKind{TokLet, TokIdent, TokEq, TokInt, TokNewline, TokEOF}; !slices.Equal(got, want) {
+		t.Errorf("kinds = %v, want %v", got, want)
+	}
+	if l.ReadError() != boom {
+		t.Errorf("ReadError() = %v, want %v", l.ReadError(), boom)
+	}
+}
+
+// FuzzLexer asserts that the lexer never panics and, for any input,
+// terminates with EOF after balanced INDENT/DEDENT tokens, whether it reads
+// a string, keeps comments or streams from a reader (which must agree with
+// the string). The examples and a few malformed fragments seed it; run it
+// longer with go test -fuzz=FuzzLexer ./internal/lexer.
+func FuzzLexer(f *testing.F) {
+	examples, _ := filepath.Glob("../../../examples/*.desi")
+	for _, path := range examples {
+		if src, err := os.ReadFile(path); err == nil {
+			f.Add(src)
+		}
+	}
+	for _, src := range []string{
+		"",
+		"if a:\n    if b:\n        x\n  y\nz",
+		"if a:\n  x # a comment at EOF still closes the block",
+		"if a:\n \tx\n\ty\n",
+		"let s = \"abc\nlet t = f\"{a + \"{\"}\" r\"\\\n",
+		"let u = \"\"\"open\n\n  still open",
+		"\uFEFF#!/bin/desic\r\nx = 0b102 + 1e+ + 0x_ + 1__0 + 'ab' + '\\u{110000}'\r\n",
+		"a \\\n  b \\",
+		"\xff\xfe\x00\t\r\r\n  \x80 $x `y` {}}",
+	} {
+		f.Add([]byte(src))
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		want := lexAll(t, New(string(src)), len(src))
+		lexAll(t, NewMode(string(src), KeepComments), len(src))
+		got := lexAll(t, NewFromReader(bytes.NewReader(src)), len(src))
+		if !slices.Equal(got, want) {
+			t.Fatalf("the reader lexes %q as\n%v\nwant %v", src, got, want)
+		}
+	})
+}
+
+// lexAll drains l, checking the invariants FuzzLexer promises for an
+// n-byte source.
+func lexAll(t *testing.T, l *Lexer, n int) []Token {
+	t.Helper()
+	// Every token but NEWLINE, INDENT, DEDENT and EOF consumes a byte, and
+	// each line yields at most one of each of those.
+	limit := 4*n + 8
+	var toks []Token
+	depth := 0
+	for {
+		tok := l.Next()
+		toks = append(toks, tok)
+		switch tok.Kind {
+		case TokIndent:
+			depth++
+		case TokDedent:
+			if depth--; depth < 0 {
+				t.Fatalf("DEDENT without an INDENT: %v", toks)
+			}
+		}
+		if tok.Kind == TokEOF {
+			break
+		}
+		if len(toks) > limit {
+			t.Fatalf("no EOF after %d tokens of a %d-byte source", len(toks), n)
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("%d INDENT tokens are never closed: %v", depth, toks)
+	}
+	if tok := l.Next(); tok.Kind != TokEOF {
+		t.Fatalf("after EOF, Next() = %v", tok)
+	}
+	return toks
+}
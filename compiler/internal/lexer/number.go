@@ -0,0 +1,116 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// intSuffixes are the type suffixes an integer literal may end in.
+var intSuffixes = []string{"i8", "i16", "i32", "i64", "u8", "u16", "u32", "u64"}
+
+// IntSuffix splits an integer literal into its digits and its type suffix
+// ("255u8" is "255" and "u8"); the suffix is "" when there is none.
+func IntSuffix(lex string) (digits, suffix string) {
+	for _, s := range intSuffixes {
+		if d, ok := strings.CutSuffix(lex, s); ok && d != "" {
+			return d, s
+		}
+	}
+	return lex, ""
+}
+
+// IntValue decodes an integer literal as written: decimal ("1_000"), hex
+// ("0xff") or binary ("0b1010"), with an optional type suffix ("42u32",
+// "0xffu8"). Underscores may separate digits but not lead, trail, follow a
+// prefix or repeat. Decimal literals are always base 10, even with leading
+// zeros. The suffix does not bound the value here: whether 128i8 fits
+// depends on a sign the lexer never sees, so the checker holds a literal
+// to its suffix. A TokInt's Lex has its separators already removed, and
+// decodes the same.
+func IntValue(lex string) (uint64, error) {
+	num, _ := IntSuffix(lex)
+	if i := strings.LastIndexAny(num, "iu"); i > 0 && strings.Trim(num[i+1:], "0123456789") == "" {
+		return 0, fmt.Errorf("malformed number %q: unknown type suffix %q (want one of %s)",
+			lex, num[i:], strings.Join(intSuffixes, ", "))
+	}
+	digits, base, name := num, uint64(10), "decimal"
+	if len(num) >= 2 && num[0] == '0' {
+		switch num[1] {
+		case 'x', 'X':
+			digits, base, name = num[2:], 16, "hex"
+		case 'b', 'B':
+			digits, base, name = num[2:], 2, "binary"
+		}
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("malformed number %q: no digits after %s", lex, num[:2])
+	}
+	if digits[0] == '_' || digits[len(digits)-1] == '_' || strings.Contains(digits, "__") {
+		return 0, fmt.Errorf("malformed number %q: '_' must sit between two digits", lex)
+	}
+	var n uint64
+	for _, r := range digits {
+		if r == '_' {
+			continue
+		}
+		d := digitVal(r)
+		if d >= base {
+			return 0, fmt.Errorf("malformed number %q: invalid %s digit %q", lex, name, r)
+		}
+		if n > (^uint64(0)-d)/base {
+			return 0, fmt.Errorf("malformed number %q: too large for 64 bits", lex)
+		}
+		n = n*base + d
+	}
+	return n, nil
+}
+
+// digitVal returns the value of a hex digit, or 99 for anything else.
+func digitVal(r rune) uint64 {
+	switch {
+	case r >= '0' && r <= '9':
+		return uint64(r - '0')
+	case r >= 'a' && r <= 'f':
+		return uint64(r-'a') + 10
+	case r >= 'A' && r <= 'F':
+		return uint64(r-'A') + 10
+	}
+	return 99
+}
+
+// FloatValue decodes a float literal as written: decimal digits with a
+// fraction ("0.25"), an exponent ("1e9", "2.5e-3") or both. Underscores
+// follow the IntValue rules within each run of digits.
+func FloatValue(lex string) (float64, error) {
+	mant, exp, hasExp := lex, "", false
+	if i := strings.IndexAny(lex, "eE"); i >= 0 {
+		mant, exp, hasExp = lex[:i], lex[i+1:], true
+	}
+	whole, frac, hasFrac := strings.Cut(mant, ".")
+	parts := []string{whole}
+	if hasFrac {
+		parts = append(parts, frac)
+	}
+	if hasExp {
+		if exp == "" || exp == "+" || exp == "-" {
+			return 0, fmt.Errorf("malformed number %q: no digits in the exponent", lex)
+		}
+		parts = append(parts, strings.TrimLeft(exp[:1], "+-")+exp[1:])
+	}
+	for _, part := range parts {
+		if part == "" || part[0] == '_' || part[len(part)-1] == '_' || strings.Contains(part, "__") {
+			return 0, fmt.Errorf("malformed number %q: '_' must sit between two digits", lex)
+		}
+		for _, r := range part {
+			if r != '_' && (r < '0' || r > '9') {
+				return 0, fmt.Errorf("malformed number %q: invalid decimal digit %q", lex, r)
+			}
+		}
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(lex, "_", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed number %q: out of range for a 64-bit float", lex)
+	}
+	return f, nil
+}
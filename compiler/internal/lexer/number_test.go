@@ -0,0 +1,156 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+)
+
+func TestIntValue(t *testing.T) {
+	cases := []struct {
+		lex  string
+		want uint64
+		err  string // substring of the error; "" for none
+	}{
+		{"0", 0, ""},
+		{"1_000_000", 1000000, ""},
+		{"007", 7, ""},
+		{"0xff_ff", 0xffff, ""},
+		{"0B1010", 10, ""},
+		{"18446744073709551615", 1<<64 - 1, ""},
+		{"0x", 0, "no digits after 0x"},
+		{"0b", 0, "no digits after 0b"},
+		{"1_", 0, "'_' must sit between two digits"},
+		{"1__0", 0, "'_' must sit between two digits"},
+		{"0x_1", 0, "'_' must sit between two digits"},
+		{"0b102", 0, "invalid binary digit '2'"},
+		{"12ab", 0, "invalid decimal digit 'a'"},
+		{"0xfg", 0, "invalid hex digit 'g'"},
+		{"18446744073709551616", 0, "too large for 64 bits"},
+		{"255u8", 255, ""},
+		{"0xffu16", 255, ""},
+		{"0b1i64", 1, ""},
+		{"1000u8", 1000, ""}, // the checker bounds a suffixed value
+		{"42u7", 0, `unknown type suffix "u7"`},
+		{"7i", 0, `unknown type suffix "i"`},
+		{"1_u8", 0, "'_' must sit between two digits"},
+	}
+	for _, tc := range cases {
+		n, err := IntValue(tc.lex)
+		switch {
+		case tc.err == "" && (err != nil || n != tc.want):
+			t.Errorf("%s = %d, %v; want %d", tc.lex, n, err, tc.want)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: err = %v, want one containing %q", tc.lex, err, tc.err)
+		}
+	}
+}
+
+func TestIntSuffix(t *testing.T) {
+	l := New("42u32 7i64 0xff_u8 1_000i16 12 u8\n")
+	var got []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		if tok.Kind == TokInt {
+			digits, suffix := IntSuffix(tok.Lex)
+			got = append(got, digits+"/"+suffix)
+		}
+	}
+	want := []string{"42/u32", "7/i64", "0xff_/u8", "1000/i16", "12/"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("suffixes = %q, want %q", got, want)
+	}
+	// "0xff_u8" is the one malformed literal: a separator before the suffix
+	if errs := l.Errors(); len(errs) != 1 || errs[0].Span.Start.Col != 12 {
+		t.Errorf("errors = %v, want one at 1:12", errs)
+	}
+}
+
+func TestMalformedNumberIsOneTokenAndAnError(t *testing.T) {
+	l := New("let x = 0x + 1\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	if toks[3].Kind != TokInt || toks[3].Lex != "0x" {
+		t.Fatalf("token 3 = %v %q, want INT \"0x\"", toks[3].Kind, toks[3].Lex)
+	}
+	errs := l.Errors()
+	if len(errs) != 1 || errs[0].Code != diag.MalformedNumber {
+		t.Fatalf("errors = %v", errs)
+	}
+	if got, want := errs[0].Error(), `1:9: L0001: malformed number "0x": no digits after 0x`; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+	// the whole literal is underlined, not just its first column
+	if end := errs[0].Span.End; end != (diag.Pos{Line: 1, Col: 11}) {
+		t.Errorf("error ends at %d:%d, want 1:11", end.Line, end.Col)
+	}
+}
+
+func TestFloatValue(t *testing.T) {
+	cases := []struct {
+		lex  string
+		want float64
+		err  string
+	}{
+		{"1.5", 1.5, ""},
+		{"0.25", 0.25, ""},
+		{"1_000.000_5", 1000.0005, ""},
+		{"007.5", 7.5, ""},
+		{"1_.5", 0, "'_' must sit between two digits"},
+		{"1._5", 0, "'_' must sit between two digits"},
+		{"1.5x", 0, "invalid decimal digit 'x'"},
+		{"1e9", 1e9, ""},
+		{"2.5e-3", 2.5e-3, ""},
+		{"1_0E+1_0", 1e11, ""},
+		{"1e", 0, "no digits in the exponent"},
+		{"1e+", 0, "no digits in the exponent"},
+		{"1e_5", 0, "'_' must sit between two digits"},
+		{"1e5Q", 0, "invalid decimal digit 'Q'"},
+		{"1e400", 0, "out of range"},
+		{"1" + strings.Repeat("0", 400) + ".0", 0, "out of range"},
+	}
+	for _, tc := range cases {
+		f, err := FloatValue(tc.lex)
+		switch {
+		case tc.err == "" && (err != nil || f != tc.want):
+			t.Errorf("%s = %v, %v; want %v", tc.lex, f, err, tc.want)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: err = %v, want one containing %q", tc.lex, err, tc.err)
+		}
+	}
+}
+
+func TestFloatTokens(t *testing.T) {
+	l := New("1.5 0.25 1.f 0x1.5 1.2.3 1e9 2.5e-3 0xe-1 x-1e2\n")
+	var got []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		got = append(got, tok.Kind.String()+" "+tok.Lex)
+	}
+	// a '.' not followed by a digit, or after a hex literal, is a DOT
+	want := []string{"FLOAT 1.5", "FLOAT 0.25", "INT 1", ". .", "IDENT f", "INT 0x1", ". .", "INT 5", "FLOAT 1.2", ". .", "INT 3",
+		"FLOAT 1e9", "FLOAT 2.5e-3", "INT 0xe", "- -", "INT 1", "IDENT x", "- -", "FLOAT 1e2", "NEWLINE "}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("tokens = %q\nwant %q", got, want)
+	}
+	if errs := l.Errors(); len(errs) != 0 {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestSeparatorsStripped(t *testing.T) {
+	l := New("1_000_000 0xFF_FF 1_000.000_5 1__0\n")
+	var got []string
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		got = append(got, tok.Lex)
+	}
+	// the malformed literal keeps its text, and its error points at it
+	want := []string{"1000000", "0xFFFF", "1000.0005", "1__0", ""}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lexemes = %q, want %q", got, want)
+	}
+	if errs := l.Errors(); len(errs) != 1 || errs[0].Span.Start.Col != 31 {
+		t.Errorf("errors = %v, want one at 1:31", errs)
+	}
+}
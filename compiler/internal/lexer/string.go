@@ -0,0 +1,60 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+)
+
+// StringValue decodes the text of a TokStr token ("a\tb", "\u{e9}") to the
+// bytes it stands for. Escapes are those of CharValue; \xHH is one byte, so
+// "\xff" is not UTF-8, while \u escapes are encoded as UTF-8.
+func StringValue(lex string) (string, error) {
+	if len(lex) < 2 || lex[0] != '"' || lex[len(lex)-1] != '"' {
+		return "", fmt.Errorf("unterminated string literal %s", lex)
+	}
+	body := lex[1 : len(lex)-1]
+	var b strings.Builder
+	for body != "" {
+		if body[0] != '\\' {
+			b.WriteByte(body[0])
+			body = body[1:]
+			continue
+		}
+		hex := strings.HasPrefix(body, `\x`)
+		r, rest, err := charEscape(body)
+		if err != nil {
+			return "", err
+		}
+		if hex {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+		body = rest
+	}
+	return b.String(), nil
+}
+
+// scanEscape consumes the escape at a backslash inside a string literal and
+// returns its text, reporting one that is malformed or unknown (L0004). A
+// bad escape consumes the backslash and the character after it. A backslash
+// at the end of a line is left to the caller.
+func (lx *Lexer) scanEscape() string {
+	line, col, start := lx.line, lx.col+1, lx.i
+	s := lx.ahead(12) // \u{10FFFF} is the longest
+	n := 2
+	if len(s) < 2 || s[1] == '\n' || s[1] == '\r' {
+		n = 1
+	} else if _, rest, err := charEscape(s); err != nil {
+		lx.errorf(line, col, diag.BadEscape, "%v in string literal", err)
+	} else {
+		n = utf8.RuneCountInString(s[:len(s)-len(rest)])
+	}
+	for range n {
+		lx.advance()
+	}
+	return string(lx.text(start))
+}
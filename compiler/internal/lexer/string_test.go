@@ -0,0 +1,85 @@
+package lexer
+
+import (
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/diag"
+)
+
+func TestStringValue(t *testing.T) {
+	cases := []struct{ lex, want string }{
+		{`"a\tb"`, "a\tb"},
+		{`"\"q\" \\ \'"`, `"q" \ '`},
+		{`"\x41\xff"`, "A\xff"},
+		{`"é\u{1F600}"`, "é😀"},
+		{`"é"`, "é"},
+	}
+	for _, tc := range cases {
+		if got, err := StringValue(tc.lex); err != nil || got != tc.want {
+			t.Errorf("StringValue(%s) = %q, %v; want %q", tc.lex, got, err, tc.want)
+		}
+	}
+	for _, lex := range []string{`"\q"`, `"\u{110000}"`, `"open`} {
+		if _, err := StringValue(lex); err == nil {
+			t.Errorf("StringValue(%s) succeeded", lex)
+		}
+	}
+}
+
+func TestBadEscapes(t *testing.T) {
+	l := New(`let s = "ok \n \x41 é" + "\q \x4g \uD800 \u{110000}" + """\w"""` + "\n")
+	var toks []Token
+	for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+		toks = append(toks, tok)
+	}
+	// the bad literals are still strings, so parsing goes on
+	if len(toks) != 9 || toks[5].Kind != TokStr || toks[5].Lex != `"\q \x4g \uD800 \u{110000}"` {
+		t.Fatalf("tokens = %v", toks)
+	}
+	want := []string{
+		`1:27: L0004: unknown escape \q in string literal`,
+		`1:30: L0004: \x needs two hex digits in string literal`,
+		`1:35: L0004: \uD800 is not a valid code point in string literal`,
+		`1:42: L0004: \u{110000} is not a valid code point in string literal`,
+		`1:59: L0004: unknown escape \w in string literal`,
+	}
+	errs := l.Errors()
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %v", errs)
+	}
+	for i, e := range errs {
+		if e.Code != diag.BadEscape || e.Error() != want[i] {
+			t.Errorf("error %d = %q, want %q", i, e.Error(), want[i])
+		}
+	}
+}
+
+func TestUnterminatedStrings(t *testing.T) {
+	cases := []struct{ src, lex string }{
+		{"let s = \"abc\nx\n", `"abc`},
+		{"let s = \"abc\r\nx\n", `"abc`},
+		{"let s = \"a\\\"", `"a\"`},
+		{"let s = r\"a\\\nx\n", `r"a\`},
+		{"let s = f\"a{1}b\nx\n", "}b"},
+	}
+	for _, tc := range cases {
+		l := New(tc.src)
+		var errTok Token
+		for tok := l.Next(); tok.Kind != TokEOF; tok = l.Next() {
+			if tok.Kind == TokErr {
+				errTok = tok
+			}
+		}
+		if errTok.Lex != tc.lex {
+			t.Errorf("%q: error token %q, want %q", tc.src, errTok.Lex, tc.lex)
+			continue
+		}
+		// the whole unclosed literal is underlined, up to the end of its line
+		errs := l.Errors()
+		if len(errs) != 1 || errs[0].Code != diag.UnterminatedString ||
+			errs[0].Span.Start != (diag.Pos{Line: errTok.Line, Col: errTok.Col}) ||
+			errs[0].Span.End != (diag.Pos{Line: 1, Col: errTok.Col + len(tc.lex)}) {
+			t.Errorf("%q: errors = %v", tc.src, errs)
+		}
+	}
+}
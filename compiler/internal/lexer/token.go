@@ -12,12 +12,20 @@ const (
   TokNewline         // logical newline
   TokIndent          // indent block
   TokDedent          // dedent block
+  TokErr             // a character no token starts with, or a string literal with no closing quote; Lex is its text
+  TokComment         // # to end of line, with KeepComments only; Lex is the text from the #
 
   // Literals/identifiers
   TokIdent
   TokInt
   TokFloat
   TokStr
+  TokChar // 'a'; Lex is the raw literal, decoded by CharValue
+  // f"a{x}b{y}c" lexes as FSTR_HEAD "a", x, FSTR_MID "b", y, FSTR_TAIL "c".
+  // Each Lex is a plain literal holding that piece of text.
+  TokFStrHead
+  TokFStrMid
+  TokFStrTail
 
   // Keywords (Stage-0)
   TokLet
@@ -35,7 +43,9 @@ const (
   TokEnum
   TokPackage
   TokImport
+  TokPub
   TokAs
+  TokWith
 
   // Operators/punctuation
   TokEq      // =
@@ -43,6 +53,7 @@ const (
   TokPlus    // +
   TokMinus   // -
   TokStar    // *
+  TokStarStar // **
   TokSlash   // /
   TokPercent // %
   TokLParen  // (
@@ -62,6 +73,19 @@ const (
   TokGe   // >=
   TokEqEq // ==
   TokNe   // !=
+  // Augmented assignment: x += e is x := x + e
+  TokPlusEq    // +=
+  TokMinusEq   // -=
+  TokStarEq    // *=
+  TokSlashEq   // /=
+  TokPercentEq // %=
+  // ? is the optional type suffix; as operators, ? (error propagation),
+  // ?. (optional chaining) and ?? (none-coalescing) are reserved
+  TokQuestion    // ?
+  TokQuestionDot // ?.
+  TokCoalesce    // ??
+  TokDotDot      // .. (half-open range)
+  TokDotDotEq    // ..= (inclusive range)
 
   // Boolean & logical words
   TokTrue
@@ -69,7 +93,11 @@ const (
   TokAnd
   TokOr
   TokNot
-  TokDefer // NEW
+  TokDefer
+  TokSpawn
+  TokNone
+
+  tokCount // number of kinds; keep last
 )
 
 // Token is a single lexeme with source position.
@@ -78,6 +106,10 @@ type Token struct {
   Lex  string
   Line int
   Col  int
+  EndLine int // line EndCol is on: Line, except for """ strings spanning lines
+  EndCol int // column just past the token's source text; 0 for layout tokens
+  Offset int // byte offset of the token's source text in the file
+  Length int // bytes of source text; 0 for layout tokens
 }
 
 func (k TokKind) String() string {
@@ -90,6 +122,10 @@ func (k TokKind) String() string {
     return "INDENT"
   case TokDedent:
     return "DEDENT"
+  case TokErr:
+    return "ERROR"
+  case TokComment:
+    return "COMMENT"
   case TokIdent:
     return "IDENT"
   case TokInt:
@@ -98,38 +134,14 @@ func (k TokKind) String() string {
     return "FLOAT"
   case TokStr:
     return "STR"
-  case TokLet:
-    return "let"
-  case TokMut:
-    return "mut"
-  case TokDef:
-    return "def"
-  case TokReturn:
-    return "return"
-  case TokIf:
-    return "if"
-  case TokElif:
-    return "elif"
-  case TokElse:
-    return "else"
-  case TokWhile:
-    return "while"
-  case TokFor:
-    return "for"
-  case TokIn:
-    return "in"
-  case TokMatch:
-    return "match"
-  case TokStruct:
-    return "struct"
-  case TokEnum:
-    return "enum"
-  case TokPackage:
-    return "package"
-  case TokImport:
-    return "import"
-  case TokAs:
-    return "as"
+  case TokChar:
+    return "CHAR"
+  case TokFStrHead:
+    return "FSTR_HEAD"
+  case TokFStrMid:
+    return "FSTR_MID"
+  case TokFStrTail:
+    return "FSTR_TAIL"
   case TokEq:
     return "="
   case TokAssign:
@@ -140,6 +152,8 @@ func (k TokKind) String() string {
     return "-"
   case TokStar:
     return "*"
+  case TokStarStar:
+    return "**"
   case TokSlash:
     return "/"
   case TokPercent:
@@ -176,19 +190,70 @@ func (k TokKind) String() string {
     return "=="
   case TokNe:
     return "!="
-  case TokTrue:
-    return "true"
-  case TokFalse:
-    return "false"
-  case TokAnd:
-    return "and"
-  case TokOr:
-    return "or"
-  case TokNot:
-    return "not"
-  case TokDefer:
-    return "defer"
-  default:
-    return "TokKind(" + strconv.Itoa(int(k)) + ")"
+  case TokPlusEq:
+    return "+="
+  case TokMinusEq:
+    return "-="
+  case TokStarEq:
+    return "*="
+  case TokSlashEq:
+    return "/="
+  case TokPercentEq:
+    return "%="
+  case TokQuestion:
+    return "?"
+  case TokQuestionDot:
+    return "?."
+  case TokCoalesce:
+    return "??"
+  case TokDotDot:
+    return ".."
+  case TokDotDotEq:
+    return "..="
+  }
+  if s, ok := keywordText[k]; ok {
+    return s
   }
+  return "TokKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// keywords maps reserved words to their tokens. It is the only list of
+// them: the lexer, TokKind.String and Keywords (which editor tooling reads,
+// so highlighting follows the lexer) all derive from it.
+var keywords = map[string]TokKind{
+  "let":     TokLet,
+  "mut":     TokMut,
+  "def":     TokDef,
+  "return":  TokReturn,
+  "if":      TokIf,
+  "elif":    TokElif,
+  "else":    TokElse,
+  "while":   TokWhile,
+  "for":     TokFor,
+  "in":      TokIn,
+  "match":   TokMatch,
+  "struct":  TokStruct,
+  "enum":    TokEnum,
+  "package": TokPackage,
+  "import":  TokImport,
+  "pub":     TokPub,
+  "as":      TokAs,
+  "with":    TokWith,
+  "true":    TokTrue,
+  "false":   TokFalse,
+  "and":     TokAnd,
+  "or":      TokOr,
+  "not":     TokNot,
+  "defer":   TokDefer,
+  "spawn":   TokSpawn,
+  "none":    TokNone,
 }
+
+// keywordText is keywords inverted, for TokKind.String.
+var keywordText = func() map[TokKind]string {
+  m := make(map[TokKind]string, len(keywords))
+  for s, k := range keywords {
+    m[k] = s
+  }
+  return m
+}()
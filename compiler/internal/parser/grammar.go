@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule is one production of the grammar the parser accepts.
+type Rule struct {
+	Name string
+	Body string // EBNF right-hand side, e.g. `"while" expr ":" block`
+}
+
+// Terminals are the token classes the grammar uses but does not define;
+// the lexer produces them (INDENT and DEDENT from indentation).
+var Terminals = []string{"IDENT", "INT", "FLOAT", "STR", "FSTR_HEAD", "FSTR_MID", "FSTR_TAIL", "CHAR", "NEWLINE", "INDENT", "DEDENT", "EOF"}
+
+// stmtRules mirror parseFile, parseFuncDecl and parseStmt. They are kept by
+// hand; TestGrammarRules checks that they hang together.
+var stmtRules = []Rule{
+	{"file", `NEWLINE* package_decl? import_decl* func_decl* EOF`},
+	{"package_decl", `"package" dotted_ident NEWLINE`},
+	{"import_decl", `"pub"? "import" dotted_ident NEWLINE`},
+	{"dotted_ident", `IDENT ( "." IDENT )*`},
+	{"func_decl", `"def" IDENT "(" params? ")" "->" type ":" block`},
+	{"params", `param ( "," param )* ","?`},
+	{"param", `"mut"? IDENT ":" type`},
+	{"type", `IDENT "?"?`},
+	{"block", `NEWLINE INDENT stmt+ DEDENT`},
+	{"stmt", `let_stmt | assign_stmt | return_stmt | if_stmt | while_stmt | defer_stmt | spawn_stmt | with_stmt | expr_stmt`},
+	{"let_stmt", `"let" "mut"? IDENT "=" expr NEWLINE`},
+	{"assign_stmt", `IDENT ( ":=" | "+=" | "-=" | "*=" | "/=" | "%=" ) expr NEWLINE`},
+	{"return_stmt", `"return" expr? NEWLINE`},
+	{"if_stmt", `"if" expr ":" block ( "elif" expr ":" block )* ( "else" ":" block )?`},
+	{"while_stmt", `"while" expr ":" block`},
+	{"defer_stmt", `"defer" expr NEWLINE`},
+	{"spawn_stmt", `"spawn" expr NEWLINE`},
+	{"with_stmt", `"with" expr "as" IDENT ":" block`},
+	{"expr_stmt", `expr NEWLINE`},
+}
+
+// postfixRules mirror parsePostfix and parsePrimary.
+var postfixRules = []Rule{
+	{"postfix", `primary ( "(" args? ")" | "[" expr "]" | "." IDENT )*`},
+	{"args", `expr ( "," expr )* ","?`},
+	{"primary", `IDENT | INT | FLOAT | STR | fstring | CHAR | "true" | "false" | "none" | "(" expr ")"`},
+	{"fstring", `FSTR_HEAD expr ( FSTR_MID expr )* FSTR_TAIL`},
+}
+
+// Grammar returns the productions of the accepted syntax, starting with
+// file. The expression rules are generated from the operator tables the
+// parser runs on, one rule per precedence level.
+func Grammar() []Rule {
+	rules := append([]Rule{}, stmtRules...)
+	levels := opLevels()
+	name := func(i int) string {
+		if i == len(levels) {
+			return "unary"
+		}
+		return fmt.Sprintf("prec%d", levels[i][0].Prec)
+	}
+	var ranges []string
+	for _, r := range rangeOps {
+		ranges = append(ranges, quote(r.String()))
+	}
+	rules = append(rules, Rule{"expr", "range"},
+		Rule{"range", fmt.Sprintf("%s ( ( %s ) %s )?", name(0), strings.Join(ranges, " | "), name(0))})
+	for i, ops := range levels {
+		next := name(i + 1)
+		var alts []string
+		for _, op := range ops {
+			alts = append(alts, quote(op.Op))
+		}
+		alt := strings.Join(alts, " | ")
+		if len(alts) > 1 {
+			alt = "( " + alt + " )"
+		}
+		body := fmt.Sprintf("%s ( %s %s )*", next, alt, next)
+		if ops[0].Right {
+			body = fmt.Sprintf("%s ( %s %s )?", next, alt, name(i))
+		}
+		rules = append(rules, Rule{name(i), body})
+	}
+	var alts []string
+	for _, u := range unaryOps {
+		alts = append(alts, quote(u.String()))
+	}
+	rules = append(rules, Rule{"unary", fmt.Sprintf("( %s ) unary | postfix", strings.Join(alts, " | "))})
+	return append(rules, postfixRules...)
+}
+
+// opLevels groups binOps by precedence, loosest first.
+func opLevels() [][]Operator {
+	var levels [][]Operator
+	for _, op := range binOps {
+		if _, ok := reservedOps[op.kind]; ok {
+			continue // not accepted yet
+		}
+		o := Operator{Op: op.kind.String(), Prec: op.prec, Right: op.right}
+		if n := len(levels); n > 0 && levels[n-1][0].Prec == op.prec {
+			levels[n-1] = append(levels[n-1], o)
+			continue
+		}
+		levels = append(levels, []Operator{o})
+	}
+	return levels
+}
+
+func quote(s string) string { return `"` + s + `"` }
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/lexer"
+)
+
+// ruleRef matches the quoted literals and names in a rule body.
+var ruleRef = regexp.MustCompile(`"[^"]*"|[A-Za-z_][A-Za-z_0-9]*`)
+
+// TestGrammarRules checks that the exported grammar is closed: every name
+// used is a rule or a terminal, every rule is reachable from file, and every
+// quoted literal is exactly one token of the lexer.
+func TestGrammarRules(t *testing.T) {
+	rules := Grammar()
+	defined := map[string]string{}
+	for _, r := range rules {
+		if _, dup := defined[r.Name]; dup {
+			t.Errorf("rule %s defined twice", r.Name)
+		}
+		defined[r.Name] = r.Body
+	}
+	if rules[0].Name != "file" {
+		t.Fatalf("first rule = %s, want file", rules[0].Name)
+	}
+
+	reached := map[string]bool{"file": true}
+	queue := []string{"file"}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, ref := range ruleRef.FindAllString(defined[name], -1) {
+			if ref[0] == '"' {
+				lit := ref[1 : len(ref)-1]
+				lx := lexer.New(lit)
+				tok := lx.Next()
+				if tok.Kind.String() != lit || tok.Kind == lexer.TokIdent || lx.Next().Kind != lexer.TokEOF {
+					t.Errorf("%s: literal %s is not one token (got %v)", name, ref, tok.Kind)
+				}
+				continue
+			}
+			if slices.Contains(Terminals, ref) {
+				continue
+			}
+			if _, ok := defined[ref]; !ok {
+				t.Errorf("%s refers to undefined %s", name, ref)
+				continue
+			}
+			if !reached[ref] {
+				reached[ref] = true
+				queue = append(queue, ref)
+			}
+		}
+	}
+	for _, r := range rules {
+		if !reached[r.Name] {
+			t.Errorf("rule %s is unreachable from file", r.Name)
+		}
+	}
+}
+
+// TestGrammarFollowsOperatorTable pins the generated expression rules to
+// the table the parser runs on.
+func TestGrammarFollowsOperatorTable(t *testing.T) {
+	body := map[string]string{}
+	for _, r := range Grammar() {
+		body[r.Name] = r.Body
+	}
+	want := map[string]string{
+		"expr":  `range`,
+		"range": `prec1 ( ( ".." | "..=" ) prec1 )?`,
+		"prec4": `prec5 ( ( "==" | "!=" ) prec5 )*`,
+		"prec5": `prec7 ( ( "<" | "<=" | ">" | ">=" ) prec7 )*`, // ?? (prec6) is reserved
+		"prec9": `unary ( "**" prec9 )?`,
+		"unary": `( "-" | "!" | "not" ) unary | postfix`,
+	}
+	for name, w := range want {
+		if body[name] != w {
+			t.Errorf("%s := %s, want %s", name, body[name], w)
+		}
+	}
+}
@@ -2,24 +2,67 @@ package parser
 
 import (
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/diag"
 	"github.com/desilang/desi/compiler/internal/lexer"
 )
 
 type Parser struct {
 	lx  *lexer.Lexer
 	tok lexer.Token
+
+	deprecated []diag.Diagnostic // accepted old syntax, reported by Deprecations
+
+	maxDepth int // see Limits
+	depth    int // blocks and expressions open at p.tok
+
+	trace func(tok lexer.Token, prods []string) // see SetTrace
+	prods []string                              // productions open at p.tok, when tracing
 }
 
+// Limits bound what the parser accepts, so pathological input (a 10 MB
+// string literal, parentheses 10,000 deep) is a diagnostic rather than an
+// exhausted stack or C the C compiler chokes on. Zero fields take the
+// DefaultLimits value; negative ones disable the limit.
+type Limits struct {
+	MaxDepth   int // nested blocks and expressions (P0001)
+	MaxLiteral int // bytes of source in one string literal (L0008)
+}
+
+// DefaultLimits keep generated C within what C compilers accept: clang
+// stops at 256 nested brackets, and the emitter adds some of its own.
+var DefaultLimits = Limits{MaxDepth: 200, MaxLiteral: lexer.DefaultMaxLiteral}
+
 func New(src string) *Parser {
-	p := &Parser{lx: lexer.New(src)}
+	return NewWith(src, Limits{})
+}
+
+// NewWith is New with explicit limits.
+func NewWith(src string, lim Limits) *Parser {
+	if lim.MaxDepth == 0 {
+		lim.MaxDepth = DefaultLimits.MaxDepth
+	}
+	if lim.MaxLiteral == 0 {
+		lim.MaxLiteral = DefaultLimits.MaxLiteral
+	}
+	p := &Parser{lx: lexer.New(src), maxDepth: lim.MaxDepth}
+	p.lx.SetMaxLiteral(lim.MaxLiteral)
 	p.next()
 	return p
 }
 
-func (p *Parser) next()                   { p.tok = p.lx.Next() }
+func (p *Parser) next() {
+	if p.trace != nil {
+		p.trace(p.tok, p.prods)
+	}
+	p.tok = p.lx.Next()
+}
+
 func (p *Parser) at(k lexer.TokKind) bool { return p.tok.Kind == k }
 func (p *Parser) accept(k lexer.TokKind) bool {
 	if p.at(k) {
@@ -41,12 +84,70 @@ func (p *Parser) skipNewlines() {
 	}
 }
 
+// enter opens one level of nesting at the current token, failing past the
+// depth limit; leave closes it.
+func (p *Parser) enter() error {
+	p.depth++
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return diag.Diagnostic{
+			Span: diag.Span{Start: diag.Pos{Line: p.tok.Line, Col: p.tok.Col}},
+			Code: diag.TooDeep,
+			Msg:  fmt.Sprintf("nested more than %d levels deep; split this up with helper functions or variables", p.maxDepth),
+		}
+	}
+	return nil
+}
+
+func (p *Parser) leave() { p.depth-- }
+
+// SetTrace makes the parser call f with each token as it consumes it, and
+// the grammar productions (rule names of Grammar) open at that point,
+// outermost first. prods is only valid during the call.
+func (p *Parser) SetTrace(f func(tok lexer.Token, prods []string)) { p.trace = f }
+
+// production opens the named grammar production for the trace; the
+// returned function closes it. Without a trace it does nothing.
+func (p *Parser) production(name string) func() {
+	if p.trace == nil {
+		return func() {}
+	}
+	p.prods = append(p.prods, name)
+	n := len(p.prods)
+	return func() { p.prods = p.prods[:n-1] }
+}
+
+// retitle renames the innermost production once the parser knows which
+// alternative it is in, as for a statement starting with a name.
+func (p *Parser) retitle(name string) {
+	if p.trace != nil && len(p.prods) > 0 {
+		p.prods[len(p.prods)-1] = name
+	}
+}
+
+// Deprecations returns the uses of deprecated syntax parsed so far. The
+// syntax is accepted; callers decide whether it is a warning or an error.
+func (p *Parser) Deprecations() []diag.Diagnostic { return p.deprecated }
+
+// ParseFile parses a whole file. Malformed tokens reported by the lexer take
+// precedence over syntax errors, which they usually cause.
 func (p *Parser) ParseFile() (*ast.File, error) {
+	f, err := p.parseFile()
+	if errs := p.lx.Errors(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return f, err
+}
+
+func (p *Parser) parseFile() (*ast.File, error) {
+	defer p.production("file")()
 	f := &ast.File{}
 	p.skipNewlines()
 
 	// package (optional)
-	if p.accept(lexer.TokPackage) {
+	if p.at(lexer.TokPackage) {
+		end := p.production("package_decl")
+		kw := p.tok
+		p.next()
 		name, err := p.parseDottedIdent()
 		if err != nil {
 			return nil, err
@@ -54,12 +155,20 @@ func (p *Parser) ParseFile() (*ast.File, error) {
 		if _, err := p.expect(lexer.TokNewline); err != nil {
 			return nil, err
 		}
-		f.Pkg = &ast.PackageDecl{Name: name}
+		f.Pkg = &ast.PackageDecl{Name: name, Pos: ast.Pos{Line: kw.Line, Col: kw.Col}}
+		end()
 		p.skipNewlines()
 	}
 
 	// imports
-	for p.accept(lexer.TokImport) {
+	for p.at(lexer.TokImport) || p.at(lexer.TokPub) {
+		end := p.production("import_decl")
+		kw := p.tok
+		pub := p.accept(lexer.TokPub)
+		if pub && !p.at(lexer.TokImport) {
+			return nil, fmt.Errorf("pub is only allowed on imports in Stage-0, got %v at %d:%d", p.tok.Kind, p.tok.Line, p.tok.Col)
+		}
+		p.next()
 		path, err := p.parseDottedIdent()
 		if err != nil {
 			return nil, err
@@ -67,19 +176,30 @@ func (p *Parser) ParseFile() (*ast.File, error) {
 		if _, err := p.expect(lexer.TokNewline); err != nil {
 			return nil, err
 		}
-		f.Imports = append(f.Imports, ast.ImportDecl{Path: path})
+		f.Imports = append(f.Imports, ast.ImportDecl{Path: path, Pos: ast.Pos{Line: kw.Line, Col: kw.Col}, Pub: pub})
+		end()
 		p.skipNewlines()
 	}
 
 	// decls
 	for !p.at(lexer.TokEOF) {
 		switch {
-		case p.accept(lexer.TokDef):
+		case p.at(lexer.TokDef):
+			end := p.production("func_decl")
+			kw := p.tok
+			p.next()
 			fn, err := p.parseFuncDecl()
 			if err != nil {
 				return nil, err
 			}
+			end()
+			fn.Pos = ast.Pos{Line: kw.Line, Col: kw.Col}
 			f.Decls = append(f.Decls, fn)
+		case p.at(lexer.TokStruct), p.at(lexer.TokEnum):
+			// Reject rather than skip: silently dropping a type would surface
+			// later as confusing "undeclared" errors. See docs/spec/types.md
+			// for the semantics they will have.
+			return nil, fmt.Errorf("%v declarations are not supported in Stage-0 yet at %d:%d", p.tok.Kind, p.tok.Line, p.tok.Col)
 		default:
 			for !p.at(lexer.TokNewline) && !p.at(lexer.TokEOF) {
 				p.next()
@@ -91,6 +211,7 @@ func (p *Parser) ParseFile() (*ast.File, error) {
 }
 
 func (p *Parser) parseDottedIdent() (string, error) {
+	defer p.production("dotted_ident")()
 	var parts []string
 	t, err := p.expect(lexer.TokIdent)
 	if err != nil {
@@ -108,6 +229,7 @@ func (p *Parser) parseDottedIdent() (string, error) {
 }
 
 func (p *Parser) parseTypeUntil(stoppers ...lexer.TokKind) (string, error) {
+	defer p.production("type")()
 	stop := make(map[lexer.TokKind]bool)
 	for _, k := range stoppers {
 		stop[k] = true
@@ -135,7 +257,7 @@ func (p *Parser) parseTypeUntil(stoppers ...lexer.TokKind) (string, error) {
 			}
 		}
 		if p.tok.Lex != "" {
-			if b.Len() > 0 {
+			if b.Len() > 0 && p.tok.Kind != lexer.TokQuestion { // "str?", not "str ?"
 				b.WriteByte(' ')
 			}
 			b.WriteString(p.tok.Lex)
@@ -150,8 +272,30 @@ func (p *Parser) parseTypeUntil(stoppers ...lexer.TokKind) (string, error) {
 	return strings.TrimSpace(b.String()), nil
 }
 
+// parseCommaList parses `item ("," item)* ","? close` or just `close`, the
+// opening token already consumed. Every comma-separated production uses it,
+// so the trailing-comma rule is the same everywhere: one trailing comma is
+// allowed after at least one item; a lone or doubled comma is an error.
+func (p *Parser) parseCommaList(close lexer.TokKind, item func() error) error {
+	for !p.accept(close) {
+		if p.at(lexer.TokComma) {
+			return fmt.Errorf("unexpected %v at %d:%d (expected an item or %v)", p.tok.Kind, p.tok.Line, p.tok.Col, close)
+		}
+		if err := item(); err != nil {
+			return err
+		}
+		if p.accept(lexer.TokComma) {
+			continue
+		}
+		_, err := p.expect(close)
+		return err
+	}
+	return nil
+}
+
 func (p *Parser) parseFuncDecl() (*ast.FuncDecl, error) {
 	// def <name> "(" params? ")" "->" type ":" NEWLINE INDENT stmts DEDENT
+	// param := "mut"? <ident> ":" type
 	nameTok, err := p.expect(lexer.TokIdent)
 	if err != nil {
 		return nil, err
@@ -161,30 +305,31 @@ func (p *Parser) parseFuncDecl() (*ast.FuncDecl, error) {
 	}
 
 	var params []ast.Param
-	if !p.accept(lexer.TokRParen) {
-		for {
-			id, err := p.expect(lexer.TokIdent)
-			if err != nil {
-				return nil, err
-			}
-			if _, err := p.expect(lexer.TokColon); err != nil {
-				return nil, err
-			}
-			ty, err := p.parseTypeUntil(lexer.TokComma, lexer.TokRParen)
-			if err != nil {
-				return nil, err
-			}
-			params = append(params, ast.Param{Name: id.Lex, Type: ty})
-			if p.accept(lexer.TokComma) {
-				continue
-			}
-			_, err = p.expect(lexer.TokRParen)
-			if err != nil {
-				return nil, err
-			}
-			break
+	end := p.production("params")
+	err = p.parseCommaList(lexer.TokRParen, func() error {
+		defer p.production("param")()
+		mut := p.accept(lexer.TokMut)
+		id, err := p.expect(lexer.TokIdent)
+		if err != nil {
+			return err
+		}
+		if _, err := p.expect(lexer.TokColon); err != nil {
+			return err
+		}
+		ty, err := p.parseTypeUntil(lexer.TokComma, lexer.TokRParen)
+		if err != nil {
+			return err
+		}
+		if ty == "" {
+			return fmt.Errorf("missing type for parameter %q at %d:%d", id.Lex, p.tok.Line, p.tok.Col)
 		}
+		params = append(params, ast.Param{Name: id.Lex, Type: ty, Mut: mut})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	end()
 
 	if _, err := p.expect(lexer.TokArrow); err != nil {
 		return nil, err
@@ -211,22 +356,29 @@ func (p *Parser) parseFuncDecl() (*ast.FuncDecl, error) {
 }
 
 func (p *Parser) parseBlock() ([]ast.Stmt, error) {
+	defer p.production("block")()
 	if _, err := p.expect(lexer.TokNewline); err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(lexer.TokIndent); err != nil {
 		return nil, err
 	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
 	var body []ast.Stmt
 	for !p.at(lexer.TokDedent) && !p.at(lexer.TokEOF) {
 		p.skipNewlines()
 		if p.at(lexer.TokDedent) || p.at(lexer.TokEOF) {
 			break
 		}
+		pos := ast.Pos{Line: p.tok.Line, Col: p.tok.Col}
 		s, err := p.parseStmt()
 		if err != nil {
 			return nil, err
 		}
+		setStmtPos(s, pos)
 		body = append(body, s)
 	}
 	if _, err := p.expect(lexer.TokDedent); err != nil {
@@ -235,7 +387,49 @@ func (p *Parser) parseBlock() ([]ast.Stmt, error) {
 	return body, nil
 }
 
+// setStmtPos records where s starts.
+func setStmtPos(s ast.Stmt, pos ast.Pos) {
+	switch st := s.(type) {
+	case *ast.LetStmt:
+		st.Pos = pos
+	case *ast.AssignStmt:
+		st.Pos = pos
+	case *ast.ReturnStmt:
+		st.Pos = pos
+	case *ast.ExprStmt:
+		st.Pos = pos
+	case *ast.IfStmt:
+		st.Pos = pos
+	case *ast.WhileStmt:
+		st.Pos = pos
+	case *ast.DeferStmt:
+		st.Pos = pos
+	case *ast.SpawnStmt:
+		st.Pos = pos
+	case *ast.WithStmt:
+		st.Pos = pos
+	}
+}
+
+// stmtProductions name the statement a token starts, for the trace; a
+// name starts an assign_stmt or an expr_stmt, decided by what follows it.
+var stmtProductions = map[lexer.TokKind]string{
+	lexer.TokLet:    "let_stmt",
+	lexer.TokIdent:  "stmt",
+	lexer.TokReturn: "return_stmt",
+	lexer.TokIf:     "if_stmt",
+	lexer.TokWhile:  "while_stmt",
+	lexer.TokDefer:  "defer_stmt",
+	lexer.TokSpawn:  "spawn_stmt",
+	lexer.TokWith:   "with_stmt",
+}
+
 func (p *Parser) parseStmt() (ast.Stmt, error) {
+	name, ok := stmtProductions[p.tok.Kind]
+	if !ok {
+		name = "expr_stmt"
+	}
+	defer p.production(name)()
 	switch {
 	case p.accept(lexer.TokLet):
 		mut := p.accept(lexer.TokMut)
@@ -258,7 +452,13 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 	case p.at(lexer.TokIdent):
 		save := p.tok
 		p.next()
-		if p.at(lexer.TokAssign) {
+		if p.at(lexer.TokEq) {
+			p.deprecated = append(p.deprecated, diag.Deprecated(diag.ReassignWithEq,
+				diag.Span{Start: diag.Pos{Line: p.tok.Line, Col: p.tok.Col}},
+				fmt.Sprintf("%s = ... reassigns with =; write %s := ...", save.Lex, save.Lex)))
+		}
+		if p.at(lexer.TokAssign) || p.at(lexer.TokEq) {
+			p.retitle("assign_stmt")
 			p.next()
 			expr, err := p.parseExpr()
 			if err != nil {
@@ -269,6 +469,22 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 			}
 			return &ast.AssignStmt{Name: save.Lex, Expr: expr}, nil
 		}
+		if op, ok := augmentedOps[p.tok.Kind]; ok {
+			p.retitle("assign_stmt")
+			p.next()
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(lexer.TokNewline); err != nil {
+				return nil, err
+			}
+			// x += e is sugar for x := x + e; later stages never see it.
+			return &ast.AssignStmt{Name: save.Lex, Expr: &ast.BinaryExpr{
+				Op: op, Left: &ast.IdentExpr{Name: save.Lex}, Right: expr,
+			}}, nil
+		}
+		p.retitle("expr_stmt")
 		lhs := &ast.IdentExpr{Name: save.Lex}
 		expr, err := p.parseExprWithLHS(lhs)
 		if err != nil {
@@ -318,6 +534,24 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		}
 		return &ast.DeferStmt{Call: expr}, nil
 
+	case p.accept(lexer.TokSpawn):
+		// Stage-0: spawn <call-expr> NEWLINE
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(lexer.TokNewline); err != nil {
+			return nil, err
+		}
+		return &ast.SpawnStmt{Call: expr}, nil
+
+	case p.accept(lexer.TokWith):
+		ws, err := p.parseWithStmt()
+		if err != nil {
+			return nil, err
+		}
+		return ws, nil
+
 	default:
 		expr, err := p.parseExpr()
 		if err != nil {
@@ -389,50 +623,110 @@ func (p *Parser) parseWhileStmt() (*ast.WhileStmt, error) {
 	return &ast.WhileStmt{Cond: cond, Body: body}, nil
 }
 
+// parseWithStmt parses `with expr as name:` and its block; `with` is
+// already consumed.
+func (p *Parser) parseWithStmt() (*ast.WithStmt, error) {
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(lexer.TokAs); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(lexer.TokIdent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(lexer.TokColon); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.WithStmt{Expr: expr, Name: name.Lex, Body: body}, nil
+}
+
 /*** Expressions (Pratt parser) ***/
 
 func (p *Parser) parseExpr() (ast.Expr, error) {
+	defer p.production("expr")()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
 	left, err := p.parseUnary()
 	if err != nil {
 		return nil, err
 	}
-	return p.parseBinaryRHS(1, left)
+	if left, err = p.parseBinaryRHS(1, left); err != nil {
+		return nil, err
+	}
+	return p.parseRange(left)
 }
 
 func (p *Parser) parseExprWithLHS(lhs ast.Expr) (ast.Expr, error) {
+	defer p.production("expr")()
 	post, err := p.parsePostfix(lhs)
 	if err != nil {
 		return nil, err
 	}
-	return p.parseBinaryRHS(1, post)
+	if post, err = p.parseBinaryRHS(1, post); err != nil {
+		return nil, err
+	}
+	return p.parseRange(post)
+}
+
+// rangeOps build a RangeExpr from two operands. They bind looser than any
+// binary operator, so 0..n + 1 is 0..(n + 1), and do not chain.
+var rangeOps = []lexer.TokKind{lexer.TokDotDot, lexer.TokDotDotEq}
+
+// parseRange finishes `lo..hi` or `lo..=hi` if a range operator follows lo.
+func (p *Parser) parseRange(lo ast.Expr) (ast.Expr, error) {
+	if !slices.Contains(rangeOps, p.tok.Kind) {
+		return lo, nil
+	}
+	defer p.production("range")()
+	inclusive := p.at(lexer.TokDotDotEq)
+	p.next()
+	hi, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if hi, err = p.parseBinaryRHS(1, hi); err != nil {
+		return nil, err
+	}
+	if slices.Contains(rangeOps, p.tok.Kind) {
+		return nil, fmt.Errorf("ranges do not chain (parenthesize one), got %v at %d:%d", p.tok.Kind, p.tok.Line, p.tok.Col)
+	}
+	return &ast.RangeExpr{Lo: lo, Hi: hi, Inclusive: inclusive}, nil
 }
 
+// unaryOps are the prefix operators, which bind tighter than any binary
+// one. Like binOps, the parser and the grammar export share the table.
+var unaryOps = []lexer.TokKind{lexer.TokMinus, lexer.TokBang, lexer.TokNot}
+
 func (p *Parser) parseUnary() (ast.Expr, error) {
-	switch {
-	case p.accept(lexer.TokMinus):
-		x, err := p.parseUnary()
-		if err != nil {
-			return nil, err
-		}
-		return &ast.UnaryExpr{Op: "-", X: x}, nil
-	case p.accept(lexer.TokBang):
-		x, err := p.parseUnary()
-		if err != nil {
-			return nil, err
-		}
-		return &ast.UnaryExpr{Op: "!", X: x}, nil
-	case p.accept(lexer.TokNot):
-		x, err := p.parseUnary()
-		if err != nil {
-			return nil, err
+	for _, k := range unaryOps {
+		if p.at(k) {
+			defer p.production("unary")()
+			p.next()
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			x, err := p.parseUnary()
+			p.leave()
+			if err != nil {
+				return nil, err
+			}
+			return &ast.UnaryExpr{Op: k.String(), X: x}, nil
 		}
-		return &ast.UnaryExpr{Op: "not", X: x}, nil
-	default:
-		return p.parsePrimary()
 	}
+	return p.parsePrimary()
 }
 
 func (p *Parser) parsePrimary() (ast.Expr, error) {
+	defer p.production("primary")()
 	if p.at(lexer.TokIdent) {
 		t := p.tok
 		p.next()
@@ -441,59 +735,107 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 	if p.at(lexer.TokInt) {
 		t := p.tok
 		p.next()
-		return p.parsePostfix(&ast.IntLit{Value: t.Lex})
+		value, suffix := lexer.IntSuffix(t.Lex)
+		return p.parsePostfix(&ast.IntLit{Value: value, Suffix: suffix})
+	}
+	if p.at(lexer.TokFloat) {
+		t := p.tok
+		p.next()
+		return p.parsePostfix(&ast.FloatLit{Value: t.Lex})
 	}
 	if p.at(lexer.TokStr) {
 		t := p.tok
 		p.next()
 		return p.parsePostfix(&ast.StrLit{Value: t.Lex})
 	}
+	if p.at(lexer.TokFStrHead) {
+		return p.parseInterp()
+	}
 	if p.accept(lexer.TokTrue) {
 		return p.parsePostfix(&ast.BoolLit{Value: true})
 	}
 	if p.accept(lexer.TokFalse) {
 		return p.parsePostfix(&ast.BoolLit{Value: false})
 	}
-	if p.accept(lexer.TokLParen) {
+	if p.at(lexer.TokChar) {
+		t := p.tok
+		r, err := lexer.CharValue(t.Lex)
+		if err != nil {
+			return nil, fmt.Errorf("%v at %d:%d", err, t.Line, t.Col)
+		}
+		p.next()
+		return p.parsePostfix(&ast.CharLit{Value: r, Raw: t.Lex})
+	}
+	if p.accept(lexer.TokNone) {
+		return &ast.NoneLit{}, nil
+	}
+	if p.at(lexer.TokLParen) {
+		lp := p.tok
+		p.next()
 		e, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
-		if _, err := p.expect(lexer.TokRParen); err != nil {
-			return nil, err
+		rp, err := p.expect(lexer.TokRParen)
+		if err != nil {
+			return nil, fmt.Errorf("%v (unclosed ( at %d:%d)", err, lp.Line, lp.Col)
 		}
-		return p.parsePostfix(e)
+		return p.parsePostfix(&ast.ParenExpr{
+			X:      e,
+			Lparen: ast.Pos{Line: lp.Line, Col: lp.Col},
+			Rparen: ast.Pos{Line: rp.Line, Col: rp.Col},
+		})
 	}
 	return nil, fmt.Errorf("unexpected token in expression: %v at %d:%d", p.tok.Kind, p.tok.Line, p.tok.Col)
 }
 
+// parseInterp parses an f-string: the lexer splits f"a{x}b" into a head, a
+// mid per extra hole, and a tail, with each hole's tokens in between.
+func (p *Parser) parseInterp() (ast.Expr, error) {
+	defer p.production("fstring")()
+	x := &ast.InterpExpr{Parts: []string{p.tok.Lex}}
+	p.next()
+	for {
+		hole, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		x.Holes = append(x.Holes, hole)
+		t := p.tok
+		switch t.Kind {
+		case lexer.TokFStrMid:
+			p.next()
+			x.Parts = append(x.Parts, t.Lex)
+		case lexer.TokFStrTail:
+			p.next()
+			x.Parts = append(x.Parts, t.Lex)
+			return p.parsePostfix(x)
+		default:
+			return nil, fmt.Errorf("expected } to close f-string hole, got %v at %d:%d", t.Kind, t.Line, t.Col)
+		}
+	}
+}
+
 func (p *Parser) parsePostfix(base ast.Expr) (ast.Expr, error) {
+	defer p.production("postfix")()
 	e := base
 	for {
 		switch {
 		case p.accept(lexer.TokLParen):
-			// arguments with optional trailing comma
 			var args []ast.Expr
-			if !p.accept(lexer.TokRParen) {
-				for {
-					if p.at(lexer.TokRParen) {
-						p.next()
-						break
-					}
-					a, err := p.parseExpr()
-					if err != nil {
-						return nil, err
-					}
-					args = append(args, a)
-					if p.accept(lexer.TokComma) {
-						continue
-					}
-					if _, err := p.expect(lexer.TokRParen); err != nil {
-						return nil, err
-					}
-					break
+			end := p.production("args")
+			err := p.parseCommaList(lexer.TokRParen, func() error {
+				a, err := p.parseExpr()
+				if err != nil {
+					return err
 				}
+				args = append(args, a)
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
+			end()
 			e = &ast.CallExpr{Callee: e, Args: args}
 		case p.accept(lexer.TokLBrack):
 			idx, err := p.parseExpr()
@@ -510,6 +852,8 @@ func (p *Parser) parsePostfix(base ast.Expr) (ast.Expr, error) {
 				return nil, err
 			}
 			e = &ast.FieldExpr{X: e, Name: id.Lex}
+		case p.at(lexer.TokQuestion) || p.at(lexer.TokQuestionDot):
+			return nil, p.reserved()
 		default:
 			return e, nil
 		}
@@ -517,12 +861,24 @@ func (p *Parser) parsePostfix(base ast.Expr) (ast.Expr, error) {
 }
 
 func (p *Parser) parseBinaryRHS(minPrec int, left ast.Expr) (ast.Expr, error) {
+	// Each operator folded into left nests it one level deeper (a chain
+	// a + b + c + ... is as deep as it is long once emitted as C).
+	folds := 0
+	defer func() { p.depth -= folds }()
 	for {
-		prec, ok := binPrec(p.tok.Kind)
+		prec, _, ok := binPrec(p.tok.Kind)
 		if !ok || prec < minPrec {
 			return left, nil
 		}
 		opTok := p.tok
+		if err := p.reserved(); err != nil {
+			return nil, err
+		}
+		folds++
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		end := p.level(prec)
 		p.next()
 
 		right, err := p.parseUnary()
@@ -530,17 +886,24 @@ func (p *Parser) parseBinaryRHS(minPrec int, left ast.Expr) (ast.Expr, error) {
 			return nil, err
 		}
 
+		// Let tighter operators (and, for a right-associative level, the
+		// same operator) take `right` as their left operand first.
 		for {
-			nextPrec, ok := binPrec(p.tok.Kind)
-			if !ok || nextPrec <= prec {
+			nextPrec, nextRight, ok := binPrec(p.tok.Kind)
+			if !ok || nextPrec < prec || (nextPrec == prec && !nextRight) {
 				break
 			}
-			right, err = p.parseBinaryRHS(prec+1, right)
+			sub := prec + 1
+			if nextPrec == prec {
+				sub = prec
+			}
+			right, err = p.parseBinaryRHS(sub, right)
 			if err != nil {
 				return nil, err
 			}
 		}
 
+		end()
 		left = &ast.BinaryExpr{
 			Op:    opTok.Kind.String(),
 			Left:  left,
@@ -549,23 +912,86 @@ func (p *Parser) parseBinaryRHS(minPrec int, left ast.Expr) (ast.Expr, error) {
 	}
 }
 
-func binPrec(k lexer.TokKind) (int, bool) {
-	switch k {
-	case lexer.TokPipe:
-		return 1, true // |>
-	case lexer.TokOr:
-		return 2, true
-	case lexer.TokAnd:
-		return 3, true
-	case lexer.TokEqEq, lexer.TokNe:
-		return 4, true
-	case lexer.TokLt, lexer.TokLe, lexer.TokGt, lexer.TokGe:
-		return 5, true
-	case lexer.TokPlus, lexer.TokMinus:
-		return 6, true
-	case lexer.TokStar, lexer.TokSlash, lexer.TokPercent:
-		return 7, true
-	default:
-		return 0, false
+// binOps is the binary operator table, loosest first. The parser and
+// `desic explain-precedence` both read it, so the printed table is always
+// the one in use. Operators sharing a level must share associativity.
+var binOps = []struct {
+	kind  lexer.TokKind
+	prec  int
+	right bool // right-associative: a ** b ** c is a ** (b ** c)
+}{
+	{lexer.TokPipe, 1, false}, // |>
+	{lexer.TokOr, 2, false},
+	{lexer.TokAnd, 3, false},
+	{lexer.TokEqEq, 4, false}, {lexer.TokNe, 4, false},
+	{lexer.TokLt, 5, false}, {lexer.TokLe, 5, false}, {lexer.TokGt, 5, false}, {lexer.TokGe, 5, false},
+	{lexer.TokCoalesce, 6, true}, // ?? (reserved)
+	{lexer.TokPlus, 7, false}, {lexer.TokMinus, 7, false},
+	{lexer.TokStar, 8, false}, {lexer.TokSlash, 8, false}, {lexer.TokPercent, 8, false},
+	{lexer.TokStarStar, 9, true},
+}
+
+// reservedOps are operators with a place in the syntax but no meaning yet:
+// ?? has its binOps level and ? and ?. bind as postfix operators, so
+// programs using them get a plain error now and keep parsing the same way
+// once they land.
+var reservedOps = map[lexer.TokKind]string{
+	lexer.TokQuestion:    "error propagation",
+	lexer.TokQuestionDot: "optional chaining",
+	lexer.TokCoalesce:    "none-coalescing",
+}
+
+// level opens the production of a binary operator level (see Grammar).
+func (p *Parser) level(prec int) func() {
+	if p.trace == nil {
+		return func() {}
+	}
+	return p.production("prec" + strconv.Itoa(prec))
+}
+
+// reserved reports the current token if it is a reserved operator.
+func (p *Parser) reserved() error {
+	if what, ok := reservedOps[p.tok.Kind]; ok {
+		return fmt.Errorf("%v (%s) is reserved and not supported in Stage-0 yet at %d:%d", p.tok.Kind, what, p.tok.Line, p.tok.Col)
+	}
+	return nil
+}
+
+// augmentedOps maps each augmented assignment to the binary operator it
+// applies: x -= e is x := x - e.
+var augmentedOps = map[lexer.TokKind]string{
+	lexer.TokPlusEq:    "+",
+	lexer.TokMinusEq:   "-",
+	lexer.TokStarEq:    "*",
+	lexer.TokSlashEq:   "/",
+	lexer.TokPercentEq: "%",
+}
+
+// binPrec returns the precedence and associativity of a binary operator.
+func binPrec(k lexer.TokKind) (prec int, right, ok bool) {
+	for _, op := range binOps {
+		if op.kind == k {
+			return op.prec, op.right, true
+		}
+	}
+	return 0, false, false
+}
+
+// Operator describes one binary operator.
+type Operator struct {
+	Op       string // source spelling, e.g. "<=" or "and"
+	Prec     int    // higher binds tighter
+	Right    bool   // right-associative; otherwise a - b - c is (a - b) - c
+	Reserved bool   // placed in the table but rejected by the parser for now
+}
+
+// Operators returns the binary operator table, tightest-binding first.
+func Operators() []Operator {
+	out := make([]Operator, 0, len(binOps))
+	for _, op := range binOps {
+		_, reserved := reservedOps[op.kind]
+		out = append(out, Operator{Op: op.kind.String(), Prec: op.prec, Right: op.right, Reserved: reserved})
 	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Prec > out[j].Prec })
+	return out
 }
@@ -1,9 +1,13 @@
 package parser
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/diag"
+	"github.com/desilang/desi/compiler/internal/lexer"
 )
 
 func TestParseExprsInFunction(t *testing.T) {
@@ -53,3 +57,282 @@ func TestParseExprsInFunction(t *testing.T) {
 		t.Fatalf("assign expr not Binary '*'")
 	}
 }
+
+func TestTrailingCommaPolicy(t *testing.T) {
+	ok := []string{
+		"def f(a: i32, b: i32,) -> i32:\n  return g(a, b,)\n",
+		"def f(a: i32,) -> i32:\n  return g(a,)\n",
+		"def f() -> i32:\n  return g()\n",
+	}
+	for _, src := range ok {
+		if _, err := New(src).ParseFile(); err != nil {
+			t.Errorf("%q: unexpected error: %v", src, err)
+		}
+	}
+
+	bad := []string{
+		"def f(,) -> i32:\n  return 0\n",
+		"def f(a: i32,,) -> i32:\n  return 0\n",
+		"def f() -> i32:\n  return g(,)\n",
+		"def f() -> i32:\n  return g(1,,)\n",
+		"def f(a:, b: i32) -> i32:\n  return 0\n", // empty annotation
+	}
+	for _, src := range bad {
+		if _, err := New(src).ParseFile(); err == nil {
+			t.Errorf("%q: expected error", src)
+		}
+	}
+}
+
+func TestTrailingCommaDoesNotAddItems(t *testing.T) {
+	f, err := New("def f(a: i32,) -> i32:\n  return g(a,)\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := f.Decls[0].(*ast.FuncDecl)
+	if len(fn.Params) != 1 || fn.Params[0].Type != "i32" {
+		t.Fatalf("params = %+v", fn.Params)
+	}
+	call := fn.Body[0].(*ast.ReturnStmt).Expr.(*ast.CallExpr)
+	if len(call.Args) != 1 {
+		t.Fatalf("args = %d, want 1", len(call.Args))
+	}
+}
+
+func TestMutParams(t *testing.T) {
+	f, err := New("def f(mut a: i32, b: str) -> void:\n  return\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := f.Decls[0].(*ast.FuncDecl).Params
+	if len(ps) != 2 || !ps[0].Mut || ps[0].Name != "a" || ps[1].Mut {
+		t.Fatalf("params = %+v", ps)
+	}
+}
+
+func TestPubImports(t *testing.T) {
+	f, err := New("import a\npub import b.c\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ims := f.Imports
+	if len(ims) != 2 || ims[0].Pub || !ims[1].Pub || ims[1].Path != "b.c" || ims[1].Pos != (ast.Pos{Line: 2, Col: 1}) {
+		t.Fatalf("imports = %+v", ims)
+	}
+	_, err = New("pub def f() -> void:\n  return\n").ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "pub is only allowed on imports in Stage-0, got def at 1:5") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDeprecatedReassignWithEq(t *testing.T) {
+	p := New("def f() -> void:\n  let mut x = 1\n  x = 2\n  x := 3\n")
+	f, err := p.ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// accepted as a reassignment, with one deprecation at the =
+	if _, ok := f.Decls[0].(*ast.FuncDecl).Body[1].(*ast.AssignStmt); !ok {
+		t.Fatalf("x = 2 parsed as %T, want an assignment", f.Decls[0].(*ast.FuncDecl).Body[1])
+	}
+	deps := p.Deprecations()
+	if len(deps) != 1 || deps[0].Code != diag.ReassignWithEq {
+		t.Fatalf("deprecations = %v", deps)
+	}
+	if got, want := deps[0].Error(), "3:5: D0001: x = ... reassigns with =; write x := ..."; !strings.HasPrefix(got, want) {
+		t.Errorf("deprecation = %q, want it to start with %q", got, want)
+	}
+}
+
+func TestAggregateDeclsRejected(t *testing.T) {
+	for _, src := range []string{"struct Span:\n  start: u32\n", "enum Tok:\n  Plus\n"} {
+		_, err := New(src).ParseFile()
+		if err == nil || !strings.Contains(err.Error(), "not supported in Stage-0 yet at 1:1") {
+			t.Errorf("%q: err = %v", src, err)
+		}
+	}
+}
+
+func TestOptionalTypesAndNone(t *testing.T) {
+	f, err := New("def f(s: str?) -> i32?:\n  return none\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := f.Decls[0].(*ast.FuncDecl)
+	if fn.Params[0].Type != "str?" || fn.Ret != "i32?" {
+		t.Fatalf("types = %q, %q; want str?, i32?", fn.Params[0].Type, fn.Ret)
+	}
+	if _, ok := fn.Body[0].(*ast.ReturnStmt).Expr.(*ast.NoneLit); !ok {
+		t.Fatalf("return expr = %#v, want NoneLit", fn.Body[0].(*ast.ReturnStmt).Expr)
+	}
+}
+
+func TestAugmentedAssign(t *testing.T) {
+	f, err := New("def f(mut n: i32) -> void:\n  n += 1\n  n *= n - 2\n  n %= 3\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each desugars to n := n <op> rhs, the whole rhs as one operand
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	for i, op := range []string{"+", "*", "%"} {
+		st, ok := body[i].(*ast.AssignStmt)
+		if !ok {
+			t.Fatalf("stmt %d = %#v, want AssignStmt", i, body[i])
+		}
+		be, ok := st.Expr.(*ast.BinaryExpr)
+		if !ok || st.Name != "n" || be.Op != op || be.Left.(*ast.IdentExpr).Name != "n" {
+			t.Errorf("stmt %d = %s := %#v, want n := n %s ...", i, st.Name, st.Expr, op)
+		}
+	}
+	if _, ok := body[1].(*ast.AssignStmt).Expr.(*ast.BinaryExpr).Right.(*ast.BinaryExpr); !ok {
+		t.Errorf("n *= n - 2: rhs is not n - 2")
+	}
+}
+
+func TestInterpExpr(t *testing.T) {
+	f, err := New("def f(x: i32) -> str:\n  return f\"x={x * 2}!\"\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, ok := f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.ReturnStmt).Expr.(*ast.InterpExpr)
+	if !ok {
+		t.Fatalf("return expr = %#v, want InterpExpr", f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.ReturnStmt).Expr)
+	}
+	if len(x.Parts) != 2 || x.Parts[0] != `"x="` || x.Parts[1] != `"!"` || len(x.Holes) != 1 {
+		t.Fatalf("parts = %q, holes = %d", x.Parts, len(x.Holes))
+	}
+	if _, err := New("def f() -> str:\n  return f\"{1\n").ParseFile(); err == nil || !strings.Contains(err.Error(), "expected } to close f-string hole") {
+		t.Errorf("unclosed hole: err = %v", err)
+	}
+}
+
+func TestLexerErrorsWin(t *testing.T) {
+	_, err := New("def f() -> i32:\n  return 1__0\n").ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "2:10: L0001:") {
+		t.Fatalf("err = %v, want the L0001 lexer error", err)
+	}
+}
+
+func TestUnexpectedCharIsReported(t *testing.T) {
+	_, err := New("def f() -> i32:\n  return 1 @ 2\n").ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "2:12: L0005: unexpected character `@`") {
+		t.Fatalf("err = %v, want the L0005 lexer error", err)
+	}
+}
+
+func TestBadIndentIsReported(t *testing.T) {
+	_, err := New("def f() -> i32:\n    if 1 == 1:\n        return 1\n  return 2\n").ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "4:3: L0006: unindent to width 2") {
+		t.Fatalf("err = %v, want the L0006 lexer error", err)
+	}
+}
+
+func TestParenExprKeepsSpan(t *testing.T) {
+	f, err := New("def f(a: i32) -> i32:\n  return (a + 1) * 2\n").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mul := f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.ReturnStmt).Expr.(*ast.BinaryExpr)
+	pe, ok := mul.Left.(*ast.ParenExpr)
+	if !ok {
+		t.Fatalf("left = %#v, want ParenExpr", mul.Left)
+	}
+	if pe.Lparen != (ast.Pos{Line: 2, Col: 10}) || pe.Rparen != (ast.Pos{Line: 2, Col: 16}) {
+		t.Fatalf("parens at %v..%v, want {2 10}..{2 16}", pe.Lparen, pe.Rparen)
+	}
+	if _, ok := pe.X.(*ast.BinaryExpr); !ok {
+		t.Fatalf("inner = %#v, want BinaryExpr", pe.X)
+	}
+
+	_, err = New("def f(a: i32) -> i32:\n  return (a + 1\n").ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "unclosed ( at 2:10") {
+		t.Fatalf("err = %v, want it to point at the open paren", err)
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	deep := strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000)
+	block := ""
+	for i := 1; i <= 250; i++ {
+		block += strings.Repeat(" ", i) + "if true:\n"
+	}
+	block += strings.Repeat(" ", 251) + "return 1\n"
+	for name, body := range map[string]string{
+		"parens": "  return " + deep + "\n",
+		"unary":  "  return " + strings.Repeat("-", 10000) + "1\n",
+		"chain":  "  return 1" + strings.Repeat(" + 1", 300) + "\n",
+		"power":  "  return 2" + strings.Repeat(" ** 2", 300) + "\n",
+		"blocks": block,
+	} {
+		_, err := New("def f() -> i32:\n" + body).ParseFile()
+		var d diag.Diagnostic
+		if !errors.As(err, &d) || d.Code != diag.TooDeep {
+			t.Errorf("%s: err = %v, want %s", name, err, diag.TooDeep)
+		}
+	}
+
+	// the limit is configurable, and ordinary code is far from the default
+	src := "def f() -> i32:\n  return ((1 + 2) * 3)" + strings.Repeat(" + 1", 100) + "\n"
+	if _, err := New(src).ParseFile(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewWith(src, Limits{MaxDepth: 3}).ParseFile()
+	// the body, the return value and the outer ( are three levels
+	if err == nil || !strings.Contains(err.Error(), "2:12: P0001: nested more than 3 levels deep") {
+		t.Fatalf("err = %v, want P0001 inside the inner (", err)
+	}
+	if _, err := NewWith(src, Limits{MaxDepth: -1}).ParseFile(); err != nil {
+		t.Fatalf("no limit: %v", err)
+	}
+}
+
+func TestLiteralLimit(t *testing.T) {
+	src := "def f() -> str:\n  return \"" + strings.Repeat("x", 100) + "\"\n"
+	if _, err := NewWith(src, Limits{MaxLiteral: 102}).ParseFile(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewWith(src, Limits{MaxLiteral: 101}).ParseFile()
+	if err == nil || err.Error() != "2:10: L0008: string literal is 102 bytes long; the limit is 101" {
+		t.Fatalf("err = %v", err)
+	}
+	big := "def f() -> str:\n  return \"" + strings.Repeat("x", 10<<20) + "\"\n"
+	if _, err := New(big).ParseFile(); err == nil || !strings.Contains(err.Error(), "L0008") {
+		t.Fatalf("10 MB literal: err = %v", err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	p := New("def f() -> void:\n  x := -a\n  g(1)\n")
+	var got []string
+	p.SetTrace(func(tok lexer.Token, prods []string) {
+		got = append(got, tok.Kind.String()+" "+strings.Join(prods, ">"))
+	})
+	if _, err := p.ParseFile(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"def file>func_decl",
+		"IDENT file>func_decl",
+		"( file>func_decl",
+		") file>func_decl>params",
+		"-> file>func_decl",
+		"IDENT file>func_decl>type",
+		": file>func_decl",
+		"NEWLINE file>func_decl>block",
+		"INDENT file>func_decl>block",
+		"IDENT file>func_decl>block>stmt",
+		":= file>func_decl>block>assign_stmt",
+		"- file>func_decl>block>assign_stmt>expr>unary",
+		"IDENT file>func_decl>block>assign_stmt>expr>unary>primary",
+		"NEWLINE file>func_decl>block>assign_stmt",
+		"IDENT file>func_decl>block>stmt",
+		"( file>func_decl>block>expr_stmt>expr>postfix",
+		"INT file>func_decl>block>expr_stmt>expr>postfix>args>expr>primary",
+		") file>func_decl>block>expr_stmt>expr>postfix>args",
+		"NEWLINE file>func_decl>block>expr_stmt",
+		"DEDENT file>func_decl>block",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("trace:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
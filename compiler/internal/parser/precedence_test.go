@@ -0,0 +1,164 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+)
+
+// wantPrec is the documented precedence table (docs/spec/syntax.md). It is
+// spelled out here, not read from binOps, so that reordering the parser's
+// table fails this test instead of silently changing how programs parse.
+var wantPrec = map[string]int{
+	"**": 9,
+	"*":  8, "/": 8, "%": 8,
+	"+": 7, "-": 7,
+	"??": 6,
+	"<":  5, "<=": 5, ">": 5, ">=": 5,
+	"==": 4, "!=": 4,
+	"and": 3,
+	"or":  2,
+	"|>":  1,
+}
+
+// wantRight lists the right-associative operators.
+var wantRight = map[string]bool{"**": true, "??": true}
+
+// wantReserved lists the operators with a level but no meaning yet.
+var wantReserved = map[string]bool{"??": true}
+
+func TestOperatorTable(t *testing.T) {
+	ops := Operators()
+	if len(ops) != len(wantPrec) {
+		t.Fatalf("Operators() has %d entries, want %d: %v", len(ops), len(wantPrec), ops)
+	}
+	for i, op := range ops {
+		if want, ok := wantPrec[op.Op]; !ok || op.Prec != want {
+			t.Errorf("%s: precedence %d, want %d", op.Op, op.Prec, want)
+		}
+		if op.Right != wantRight[op.Op] {
+			t.Errorf("%s: right-associative = %t, want %t", op.Op, op.Right, wantRight[op.Op])
+		}
+		if op.Reserved != wantReserved[op.Op] {
+			t.Errorf("%s: reserved = %t, want %t", op.Op, op.Reserved, wantReserved[op.Op])
+		}
+		if i > 0 && ops[i-1].Prec < op.Prec {
+			t.Errorf("Operators() not tightest-first at %s", op.Op)
+		}
+		if i > 0 && ops[i-1].Prec == op.Prec && ops[i-1].Right != op.Right {
+			t.Errorf("%s and %s share a level but not associativity", ops[i-1].Op, op.Op)
+		}
+	}
+}
+
+// TestOperatorPairs parses `a OP1 b OP2 c` for every pair of binary
+// operators and checks the grouping: the tighter operator groups first, and
+// equal precedence groups by the level's associativity.
+func TestOperatorPairs(t *testing.T) {
+	for op1, p1 := range wantPrec {
+		for op2, p2 := range wantPrec {
+			if wantReserved[op1] || wantReserved[op2] {
+				continue
+			}
+			src := fmt.Sprintf("def f() -> void:\n  x := a %s b %s c\n", op1, op2)
+			f, err := New(src).ParseFile()
+			if err != nil {
+				t.Errorf("%s %s: %v", op1, op2, err)
+				continue
+			}
+			got := shape(f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.AssignStmt).Expr)
+			want := fmt.Sprintf("((a %s b) %s c)", op1, op2)
+			if p2 > p1 || (p2 == p1 && wantRight[op1]) {
+				want = fmt.Sprintf("(a %s (b %s c))", op1, op2)
+			}
+			if got != want {
+				t.Errorf("a %s b %s c parsed as %s, want %s", op1, op2, got, want)
+			}
+		}
+	}
+}
+
+func TestUnaryBindsTighter(t *testing.T) {
+	for op := range wantPrec {
+		if wantReserved[op] {
+			continue
+		}
+		for _, un := range []string{"-", "!", "not "} {
+			src := fmt.Sprintf("def f() -> void:\n  x := %sa %s %sb\n", un, op, un)
+			f, err := New(src).ParseFile()
+			if err != nil {
+				t.Fatalf("%q: %v", src, err)
+			}
+			e := f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.AssignStmt).Expr
+			if be, ok := e.(*ast.BinaryExpr); !ok || be.Op != op {
+				t.Errorf("%q parsed as %s, want a top-level %s", src, shape(e), op)
+			}
+		}
+	}
+}
+
+// shape prints e fully parenthesized.
+func shape(e ast.Expr) string {
+	switch v := e.(type) {
+	case *ast.IdentExpr:
+		return v.Name
+	case *ast.IntLit:
+		return v.Value
+	case *ast.ParenExpr:
+		return shape(v.X)
+	case *ast.UnaryExpr:
+		return v.Op + shape(v.X)
+	case *ast.BinaryExpr:
+		return "(" + shape(v.Left) + " " + v.Op + " " + shape(v.Right) + ")"
+	case *ast.RangeExpr:
+		op := ".."
+		if v.Inclusive {
+			op = "..="
+		}
+		return "[" + shape(v.Lo) + op + shape(v.Hi) + "]"
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	cases := map[string]string{
+		"0..n":           "[0..n]",
+		"1..=n":          "[1..=n]",
+		"a + 1..b * 2":   "[(a + 1)..(b * 2)]",
+		"lo..hi or done": "[lo..(hi or done)]",
+		"-a..-b |> f":    "[-a..(-b |> f)]",
+		"(a..b) == c":    "([a..b] == c)",
+	}
+	for src, want := range cases {
+		f, err := New("def f() -> void:\n  x := " + src + "\n").ParseFile()
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := shape(f.Decls[0].(*ast.FuncDecl).Body[0].(*ast.AssignStmt).Expr); got != want {
+			t.Errorf("%s parsed as %s, want %s", src, got, want)
+		}
+	}
+	_, err := New("def f() -> void:\n  x := a..b..c\n").ParseFile()
+	if err == nil || err.Error() != "ranges do not chain (parenthesize one), got .. at 2:12" {
+		t.Errorf("a..b..c: err = %v", err)
+	}
+}
+
+func TestReservedOperators(t *testing.T) {
+	cases := map[string]string{
+		"x := a ?? b\n":     "?? (none-coalescing) is reserved and not supported in Stage-0 yet at 2:10",
+		"x := a?.b\n":       "?. (optional chaining) is reserved and not supported in Stage-0 yet at 2:9",
+		"x := f(a)?\n":      "? (error propagation) is reserved and not supported in Stage-0 yet at 2:12",
+		"x := a + b ?? c\n": "?? (none-coalescing) is reserved",
+	}
+	for body, want := range cases {
+		_, err := New("def f() -> void:\n  " + body).ParseFile()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: err = %v, want one containing %q", body, err, want)
+		}
+	}
+}
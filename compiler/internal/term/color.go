@@ -0,0 +1,56 @@
+package term
+
+import (
+	"fmt"
+	"os"
+)
+
+// Color is an ANSI SGR parameter string.
+type Color string
+
+const (
+	Bold    Color = "1"
+	Dim     Color = "2"
+	Red     Color = "31"
+	Green   Color = "32"
+	Yellow  Color = "33"
+	Blue    Color = "34"
+	Magenta Color = "35"
+	Cyan    Color = "36"
+)
+
+// colorOn is decided once by SetColorMode; stderr-oriented because that is
+// where diagnostics go.
+var colorOn = false
+
+// SetColorMode selects "auto" (color when stderr is a terminal and NO_COLOR
+// is unset), "always", or "never". An empty mode means "auto".
+func SetColorMode(mode string) error {
+	switch mode {
+	case "", "auto":
+		colorOn = os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr)
+	case "always":
+		colorOn = true
+	case "never":
+		colorOn = false
+	default:
+		return fmt.Errorf("color must be auto, always or never, got %q", mode)
+	}
+	return nil
+}
+
+// ColorEnabled reports whether Paint currently emits escape sequences.
+func ColorEnabled() bool { return colorOn }
+
+// Paint wraps s in the escape sequence for c when color is enabled.
+func Paint(c Color, s string) string {
+	if !colorOn || s == "" {
+		return s
+	}
+	return "\x1b[" + string(c) + "m" + s + "\x1b[0m"
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,280 @@
+// Package toolchain installs a pinned, portable C toolchain for hosts that
+// have none. It uses Zig's release archives: one self-contained binary that
+// carries clang and lld, driven as `zig cc`. Install puts a small `clang`
+// wrapper next to it so the rest of desic sees an ordinary gcc-style driver.
+package toolchain
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// ZigVersion is the pinned release Install downloads.
+const ZigVersion = "0.13.0"
+
+// DownloadURL is where release archives are fetched from, as
+// <DownloadURL>/<ZigVersion>/<archive>.
+const DownloadURL = "https://ziglang.org/download"
+
+// archive is the pinned release archive for one host.
+type archive struct {
+	name   string // file name under DownloadURL/ZigVersion
+	sha256 string
+}
+
+// pinned lists the ZigVersion archive of each host with its SHA-256, as
+// published in Zig's release index (ziglang.org/download/index.json) when
+// the version was pinned. Install accepts nothing else, so a changed or
+// compromised index or mirror cannot change what gets installed. Bump
+// ZigVersion and these together.
+var pinned = map[string]archive{
+	"x86_64-linux":   {"zig-linux-x86_64-0.13.0.tar.xz", "d45312e61ebcc48032b77bc4cf7fd6915c11fa16e4aad116b66c9468211230ea"},
+	"aarch64-linux":  {"zig-linux-aarch64-0.13.0.tar.xz", "041ac42323837eb5624068acd8b00cd5777dac4cf91179e8dad7a7e90dd0c556"},
+	"x86_64-macos":   {"zig-macos-x86_64-0.13.0.tar.xz", "8b06ed1091b2269b700b3b07f8e3be3b833000841bae5aa6a09b1a8b4773effd"},
+	"aarch64-macos":  {"zig-macos-aarch64-0.13.0.tar.xz", "46fae219656545dfaf4dce12fb4e8685cec5b51d721beee9389ab4194d43394c"},
+	"x86_64-windows": {"zig-windows-x86_64-0.13.0.zip", "d859994725ef9402381e557c60bb57497215682e355204d754ee3df75ee3c158"},
+}
+
+// EnvVar overrides the install directory.
+const EnvVar = "DESI_TOOLCHAIN_DIR"
+
+// Dir returns $DESI_TOOLCHAIN_DIR, or <user cache dir>/desi/toolchain
+// (~/.cache/desi/toolchain on Linux). It returns "" if neither is known.
+func Dir() string {
+	if d := os.Getenv(EnvVar); d != "" {
+		return d
+	}
+	d, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(d, "desi", "toolchain")
+}
+
+// wrapperName is the clang wrapper's file name on goos.
+func wrapperName(goos string) string {
+	if goos == "windows" {
+		return "clang.cmd"
+	}
+	return "clang"
+}
+
+// Compiler returns the installed clang wrapper, or "" if Install has not
+// run.
+func Compiler() string {
+	dir := Dir()
+	if dir == "" {
+		return ""
+	}
+	p := filepath.Join(dir, "bin", wrapperName(runtime.GOOS))
+	if st, err := os.Stat(p); err != nil || st.IsDir() {
+		return ""
+	}
+	return p
+}
+
+// Host is the release index key for goos/goarch, e.g. "x86_64-linux".
+func Host(goos, goarch string) (string, error) {
+	arch, ok := map[string]string{"amd64": "x86_64", "arm64": "aarch64", "386": "x86", "riscv64": "riscv64"}[goarch]
+	if !ok {
+		return "", fmt.Errorf("no prebuilt toolchain for %s/%s", goos, goarch)
+	}
+	osName, ok := map[string]string{"linux": "linux", "darwin": "macos", "windows": "windows", "freebsd": "freebsd"}[goos]
+	if !ok {
+		return "", fmt.Errorf("no prebuilt toolchain for %s/%s", goos, goarch)
+	}
+	return arch + "-" + osName, nil
+}
+
+// Options configures Install. Zero values mean the defaults.
+type Options struct {
+	Dir    string       // install directory; Dir() if ""
+	Mirror string       // where archives are fetched from; DownloadURL if ""
+	Client *http.Client // http.DefaultClient if nil
+	GOOS   string       // host OS; runtime.GOOS if ""
+	GOARCH string       // host architecture; runtime.GOARCH if ""
+	Log    io.Writer    // progress lines; discarded if nil
+}
+
+// Install downloads the pinned toolchain for the host into o.Dir, checks
+// it against the pinned SHA-256, unpacks it and writes the clang wrapper.
+// It returns the wrapper's path. Installing over an existing install
+// replaces the wrapper and leaves the archive contents in place.
+func Install(o Options) (string, error) {
+	goos, goarch := o.GOOS, o.GOARCH
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	dir := o.Dir
+	if dir == "" {
+		dir = Dir()
+	}
+	if dir == "" {
+		return "", errors.New("no cache directory; set $" + EnvVar)
+	}
+	mirror := o.Mirror
+	if mirror == "" {
+		mirror = DownloadURL
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	log := o.Log
+	if log == nil {
+		log = io.Discard
+	}
+
+	host, err := Host(goos, goarch)
+	if err != nil {
+		return "", err
+	}
+	pin, ok := pinned[host]
+	if !ok {
+		return "", fmt.Errorf("no pinned zig %s archive for %s", ZigVersion, host)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	base := pin.name
+	url := strings.TrimSuffix(mirror, "/") + "/" + ZigVersion + "/" + base
+	file := filepath.Join(dir, base)
+	term.Wprintf(log, "downloading %s\n", url)
+	if err := download(client, url, file, pin.sha256); err != nil {
+		return "", err
+	}
+	defer os.Remove(file)
+	term.Wprintf(log, "unpacking into %s\n", dir)
+	if err := unpack(file, dir); err != nil {
+		return "", fmt.Errorf("unpack %s: %v", base, err)
+	}
+	top := strings.TrimSuffix(strings.TrimSuffix(base, ".zip"), ".tar.xz")
+	zig := filepath.Join(dir, top, "zig")
+	if goos == "windows" {
+		zig += ".exe"
+	}
+	if _, err := os.Stat(zig); err != nil {
+		return "", fmt.Errorf("%s has no zig binary at %s", base, filepath.Join(top, filepath.Base(zig)))
+	}
+	return writeWrapper(dir, zig, goos)
+}
+
+// download saves url to file and fails unless its SHA-256 is sum.
+func download(client *http.Client, url, file, sum string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return fmt.Errorf("download %s: %v", url, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, sum) {
+		os.Remove(file)
+		return fmt.Errorf("download %s: checksum mismatch (got %s, want %s)", url, got, sum)
+	}
+	return nil
+}
+
+// unpack extracts a .zip itself and hands .tar.xz to the system tar, which
+// every supported host ships (Windows 10 and later included).
+func unpack(archive, dir string) error {
+	if strings.HasSuffix(archive, ".zip") {
+		return unzip(archive, dir)
+	}
+	out, err := exec.Command("tar", "-xJf", archive, "-C", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tar: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func unzip(archive, dir string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		dst := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("%s: path escapes the archive", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := extract(f, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extract(f *zip.File, dst string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode()|0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rc)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeWrapper writes bin/clang (bin/clang.cmd on Windows), which runs
+// `zig cc` with its arguments.
+func writeWrapper(dir, zig, goos string) (string, error) {
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0o755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(bin, wrapperName(goos))
+	script := "#!/bin/sh\nexec '" + strings.ReplaceAll(zig, "'", `'\''`) + "' cc \"$@\"\n"
+	if goos == "windows" {
+		script = "@\"" + zig + "\" cc %*\r\n"
+	}
+	if err := os.WriteFile(p, []byte(script), 0o755); err != nil {
+		return "", err
+	}
+	return p, nil
+}
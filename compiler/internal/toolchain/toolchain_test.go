@@ -0,0 +1,110 @@
+package toolchain
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// serve hosts one x86_64-windows zip as a mirror and returns its URL. The
+// zip is pinned with sum for the test.
+func serve(t *testing.T, data []byte, sum string) string {
+	t.Helper()
+	old := pinned["x86_64-windows"]
+	t.Cleanup(func() { pinned["x86_64-windows"] = old })
+	pinned["x86_64-windows"] = archive{old.name, sum}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/"+ZigVersion+"/zig-windows-x86_64-"+ZigVersion+".zip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	return srv.URL
+}
+
+func zigZip(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	f, err := zw.Create("zig-windows-x86_64-" + ZigVersion + "/zig.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("MZ"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestInstall(t *testing.T) {
+	archive := zigZip(t)
+	h := sha256.Sum256(archive)
+	dir := t.TempDir()
+	o := Options{Dir: dir, Mirror: serve(t, archive, hex.EncodeToString(h[:])), GOOS: "windows", GOARCH: "amd64"}
+	got, err := Install(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "bin", "clang.cmd"); got != want {
+		t.Errorf("Install = %q, want %q", got, want)
+	}
+	data, _ := os.ReadFile(got)
+	if !strings.Contains(string(data), "zig.exe\" cc %*") {
+		t.Errorf("wrapper = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "zig-windows-x86_64-"+ZigVersion+".zip")); !os.IsNotExist(err) {
+		t.Errorf("archive left behind: %v", err)
+	}
+}
+
+func TestInstallRejectsBadChecksum(t *testing.T) {
+	o := Options{Dir: t.TempDir(), Mirror: serve(t, zigZip(t), strings.Repeat("0", 64)), GOOS: "windows", GOARCH: "amd64"}
+	if _, err := Install(o); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("err = %v, want a checksum mismatch", err)
+	}
+	o.GOARCH = "mips"
+	if _, err := Install(o); err == nil {
+		t.Errorf("Install for mips succeeded")
+	}
+	o.GOOS, o.GOARCH = "freebsd", "riscv64"
+	if _, err := Install(o); err == nil || !strings.Contains(err.Error(), "no pinned zig") {
+		t.Errorf("err = %v, want no pinned archive", err)
+	}
+}
+
+func TestPinnedArchives(t *testing.T) {
+	for host, a := range pinned {
+		arch, osName, _ := strings.Cut(host, "-")
+		if want := "zig-" + osName + "-" + arch + "-" + ZigVersion; !strings.HasPrefix(a.name, want+".") {
+			t.Errorf("%s: archive %s, want %s.*", host, a.name, want)
+		}
+		if b, err := hex.DecodeString(a.sha256); err != nil || len(b) != sha256.Size {
+			t.Errorf("%s: bad SHA-256 %q", host, a.sha256)
+		}
+	}
+}
+
+func TestCompiler(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvVar, dir)
+	if p := Compiler(); p != "" {
+		t.Errorf("Compiler() = %q before install", p)
+	}
+	want, err := writeWrapper(dir, filepath.Join(dir, "zig"), runtime.GOOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := Compiler(); p != want {
+		t.Errorf("Compiler() = %q after install, want %q", p, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Package vfs abstracts where compiler inputs come from, so unsaved editor
+// buffers (or stdin) can be layered over the real file system.
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileProvider maps a path to its contents and modification time.
+// Paths are passed as the caller has them; implementations should not
+// assume they are absolute.
+type FileProvider interface {
+	ReadFile(path string) ([]byte, error)
+	ModTime(path string) (time.Time, error)
+}
+
+// Entry is one name in a directory listing.
+type Entry struct {
+	Name string
+	Dir  bool
+}
+
+// DirLister is implemented by providers that can list a directory. It is
+// optional: the loader only uses it to suggest near misses for imports that
+// are not found.
+type DirLister interface {
+	ListDir(path string) ([]Entry, error)
+}
+
+// OS reads straight from the host file system.
+type OS struct{}
+
+func (OS) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+
+func (OS) ModTime(path string) (time.Time, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.ModTime(), nil
+}
+
+func (OS) ListDir(path string) ([]Entry, error) {
+	des, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(des))
+	for _, de := range des {
+		out = append(out, Entry{Name: de.Name(), Dir: de.IsDir()})
+	}
+	return out, nil
+}
+
+// Overlay serves in-memory contents for some paths and defers to Base for
+// everything else. It is safe for concurrent use.
+type Overlay struct {
+	Base FileProvider
+
+	mu    sync.RWMutex
+	files map[string]overlayFile // key: cleaned absolute path
+}
+
+type overlayFile struct {
+	data []byte
+	mod  time.Time
+}
+
+// NewOverlay returns an empty overlay on top of base (OS{} if nil).
+func NewOverlay(base FileProvider) *Overlay {
+	if base == nil {
+		base = OS{}
+	}
+	return &Overlay{Base: base, files: map[string]overlayFile{}}
+}
+
+// Set replaces the contents of path; its mtime becomes the current time.
+func (o *Overlay) Set(path string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[key(path)] = overlayFile{data: data, mod: time.Now()}
+}
+
+// Remove drops the overlay for path so reads fall through to Base again.
+func (o *Overlay) Remove(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.files, key(path))
+}
+
+func (o *Overlay) lookup(path string) (overlayFile, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	f, ok := o.files[key(path)]
+	return f, ok
+}
+
+func (o *Overlay) ReadFile(path string) ([]byte, error) {
+	if f, ok := o.lookup(path); ok {
+		return f.data, nil
+	}
+	return o.Base.ReadFile(path)
+}
+
+func (o *Overlay) ModTime(path string) (time.Time, error) {
+	if f, ok := o.lookup(path); ok {
+		return f.mod, nil
+	}
+	return o.Base.ModTime(path)
+}
+
+// ListDir merges the overlay's files (and the directories they imply)
+// under path with Base's listing, if Base can list directories.
+func (o *Overlay) ListDir(path string) ([]Entry, error) {
+	dir := key(path) + string(filepath.Separator)
+	seen := map[string]bool{}
+	var out []Entry
+	o.mu.RLock()
+	for k := range o.files {
+		rest, ok := strings.CutPrefix(k, dir)
+		if !ok {
+			continue
+		}
+		name, _, sub := strings.Cut(rest, string(filepath.Separator))
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, Entry{Name: name, Dir: sub})
+		}
+	}
+	o.mu.RUnlock()
+	var baseErr error
+	if bl, ok := o.Base.(DirLister); ok {
+		var base []Entry
+		base, baseErr = bl.ListDir(path)
+		for _, e := range base {
+			if !seen[e.Name] {
+				seen[e.Name] = true
+				out = append(out, e)
+			}
+		}
+	}
+	if len(out) == 0 && baseErr != nil {
+		return nil, baseErr
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func key(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,66 @@
+package desi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runtimeInclude is how generated C and desi_std.c include the runtime
+// header.
+const runtimeInclude = `#include "desi_std.h"`
+
+// Amalgamate returns program (C from EmitC) and the runtime in runtimeDir as
+// one self-contained translation unit. desi_std.h is inlined once, where
+// desi_std.c includes it, so the runtime's feature-test macros still come
+// before any system header; the program follows, then the rest of the
+// runtime, whose private macros can no longer reach the program's code.
+func Amalgamate(program, runtimeDir string) (string, error) {
+	hdr, err := os.ReadFile(filepath.Join(runtimeDir, "desi_std.h"))
+	if err != nil {
+		return "", err
+	}
+	src, err := os.ReadFile(filepath.Join(runtimeDir, "desi_std.c"))
+	if err != nil {
+		return "", err
+	}
+	pre, post, ok := cutInclude(string(src))
+	if !ok {
+		return "", fmt.Errorf("%s does not include desi_std.h", filepath.Join(runtimeDir, "desi_std.c"))
+	}
+	progPre, progPost, ok := cutInclude(program)
+	if !ok {
+		return "", fmt.Errorf("generated C does not include desi_std.h")
+	}
+	var b strings.Builder
+	b.WriteString("/* Generated by desic: a Desi program and its runtime in one file.\n")
+	b.WriteString("   Compile it alone, e.g. cc prog.amalgam.c -o prog. */\n\n")
+	b.WriteString(pre)
+	b.WriteString("/* ---- desi_std.h ---- */\n")
+	b.Write(hdr)
+	b.WriteString("\n/* ---- program ---- */\n")
+	b.WriteString(progPre)
+	b.WriteString(progPost)
+	b.WriteString("\n/* ---- desi_std.c ---- */\n")
+	b.WriteString(post)
+	return b.String(), nil
+}
+
+// cutInclude splits C source around its runtimeInclude line, dropping the
+// line itself.
+func cutInclude(src string) (before, after string, ok bool) {
+	for off := 0; off < len(src); {
+		end := strings.IndexByte(src[off:], '\n')
+		if end < 0 {
+			end = len(src) - off
+		} else {
+			end++
+		}
+		if strings.TrimSpace(src[off:off+end]) == runtimeInclude {
+			return src[:off], src[off+end:], true
+		}
+		off += end
+	}
+	return "", "", false
+}
@@ -0,0 +1,37 @@
+package desi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAmalgamate(t *testing.T) {
+	rt := t.TempDir()
+	os.WriteFile(filepath.Join(rt, "desi_std.h"), []byte("#ifndef H\n#define H\nint rt(void);\n#endif\n"), 0o644)
+	os.WriteFile(filepath.Join(rt, "desi_std.c"), []byte("#define FEATURE 1\n#include \"desi_std.h\"\n#include <stdio.h>\nint rt(void) { return 1; }\n"), 0o644)
+
+	out, err := Amalgamate("#include <stdint.h>\n#include \"desi_std.h\"\n\nint main(void) { return rt(); }\n", rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// feature macros, header, program, then the runtime's own code
+	order := []string{"#define FEATURE 1", "int rt(void);", "#include <stdint.h>", "int main(void)", "#include <stdio.h>", "int rt(void) {"}
+	last := -1
+	for _, s := range order {
+		i := strings.Index(out, s)
+		if i <= last {
+			t.Fatalf("%q out of order in:\n%s", s, out)
+		}
+		last = i
+	}
+	if strings.Contains(out, `#include "desi_std.h"`) {
+		t.Errorf("runtime header still included:\n%s", out)
+	}
+
+	os.WriteFile(filepath.Join(rt, "desi_std.c"), []byte("int rt(void) { return 1; }\n"), 0o644)
+	if _, err := Amalgamate("#include \"desi_std.h\"\n", rt); err == nil || !strings.Contains(err.Error(), "does not include desi_std.h") {
+		t.Errorf("err = %v, want a missing include", err)
+	}
+}
@@ -0,0 +1,44 @@
+package desi
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/desilang/desi/compiler/internal/term"
+)
+
+// WriteDepfile writes a Make/Ninja depfile saying target depends on deps:
+// one rule, `target: dep...`, with spaces, '#' and '$' escaped the way both
+// tools read them.
+func WriteDepfile(w io.Writer, target string, deps []string) {
+	term.Wprintf(w, "%s:", depEscape(target))
+	for _, d := range deps {
+		term.Wprintf(w, " \\\n  %s", depEscape(d))
+	}
+	term.Wprintf(w, "\n")
+}
+
+// Deps returns the .desi files a build read, as paths relative to the
+// working directory where possible. Files that exist only in an overlay
+// (stdin, unsaved buffers) and import candidates that were never found are
+// left out: an external build tool could not stat them.
+func Deps(files []string) []string {
+	wd, _ := os.Getwd()
+	var out []string
+	for _, f := range files {
+		if st, err := os.Stat(f); err != nil || st.IsDir() {
+			continue
+		}
+		if r, err := filepath.Rel(wd, f); err == nil && !strings.HasPrefix(r, "..") {
+			f = r
+		}
+		out = append(out, filepath.ToSlash(f))
+	}
+	return out
+}
+
+func depEscape(p string) string {
+	return strings.NewReplacer(" ", `\ `, "#", `\#`, "$", "$$").Replace(p)
+}
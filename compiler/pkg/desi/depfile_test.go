@@ -0,0 +1,29 @@
+package desi
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDepfile(t *testing.T) {
+	var b strings.Builder
+	WriteDepfile(&b, "gen/out/m", []string{"m.desi", "my dir/#x$.desi"})
+	want := "gen/out/m: \\\n  m.desi \\\n  my\\ dir/\\#x$$.desi\n"
+	if b.String() != want {
+		t.Errorf("WriteDepfile =\n%q\nwant\n%q", b.String(), want)
+	}
+}
+
+func TestDepsSkipsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "m.desi")
+	if err := os.WriteFile(real, []byte("def main() -> i32:\n  return 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := Deps([]string{real, filepath.Join(dir, "overlay-only.desi"), dir})
+	if len(got) != 1 || !strings.HasSuffix(got[0], "/m.desi") {
+		t.Errorf("Deps = %q, want just m.desi", got)
+	}
+}
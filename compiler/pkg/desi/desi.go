@@ -0,0 +1,294 @@
+// Package desi is the public Go API of the Stage-0 Desi compiler, for tools
+// that want to embed it instead of shelling out to desic.
+//
+// The pipeline is Parse (or Load for a file plus its imports) → Check →
+// EmitC, with Build running all of it and optionally invoking a C compiler.
+// desic itself is a thin wrapper over this package.
+package desi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/desilang/desi/compiler/internal/ast"
+	"github.com/desilang/desi/compiler/internal/build"
+	"github.com/desilang/desi/compiler/internal/cc"
+	"github.com/desilang/desi/compiler/internal/check"
+	cgen "github.com/desilang/desi/compiler/internal/codegen/c"
+	"github.com/desilang/desi/compiler/internal/parser"
+	"github.com/desilang/desi/compiler/internal/vfs"
+)
+
+// Re-exported compiler types. They are aliases, so values flow freely
+// between this package and the compiler internals.
+type (
+	File         = ast.File
+	Info         = check.Info
+	Warning      = check.Warning
+	CheckError   = check.Error // coded checker error; match with errors.As
+	CheckCache   = check.Cache
+	LoadResult   = build.Result
+	FileProvider = vfs.FileProvider
+	Overlay      = vfs.Overlay
+)
+
+// NewOverlay returns an in-memory file overlay on top of base (the host
+// file system if nil), for compiling unsaved buffers.
+func NewOverlay(base FileProvider) *Overlay { return vfs.NewOverlay(base) }
+
+var (
+	// ErrDiagnostics reports that the input has errors (or warnings under
+	// Werror); the details are in BuildResult.
+	ErrDiagnostics = errors.New("input has errors")
+	// ErrCompile reports that the C compiler failed.
+	ErrCompile = errors.New("cc failed")
+)
+
+// Parse parses a single source file. name is used in error messages only.
+func Parse(name string, src []byte) (*File, error) {
+	f, err := parser.New(string(src)).ParseFile()
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// LoadOptions configures Load. The zero value reads from the host file
+// system with the default std directory.
+type LoadOptions struct {
+	FS     FileProvider // source files; host file system if nil
+	StdDir string       // Desi-source std modules; default "std"
+
+	DenyDeprecated bool // report deprecated syntax as errors instead of warnings
+}
+
+// Load parses entry and, recursively, the modules it imports, merging them
+// into one File.
+func Load(entry string, opts LoadOptions) (*LoadResult, []error) {
+	fs := opts.FS
+	if fs == nil {
+		fs = vfs.OS{}
+	}
+	return build.LoadWith(fs, entry, build.Options{StdDir: opts.StdDir, DenyDeprecated: opts.DenyDeprecated})
+}
+
+// Check typechecks f and returns the information codegen needs along
+// with any errors and warnings.
+func Check(f *File) (*Info, []error, []Warning) {
+	return check.CheckFile(f)
+}
+
+// NewCheckCache returns an empty cache for BuildOptions.CheckCache.
+func NewCheckCache() *CheckCache { return check.NewCache() }
+
+// EmitC lowers a checked file to C source.
+func EmitC(f *File, info *Info) string {
+	return cgen.EmitFile(f, info)
+}
+
+// EmitCExplained is EmitC with each function and statement preceded by a
+// comment holding the Desi line it came from ("util/text.desi:4: ..."),
+// read through fs (the host file system if nil).
+func EmitCExplained(f *File, info *Info, fs FileProvider) string {
+	if fs == nil {
+		fs = vfs.OS{}
+	}
+	wd, _ := os.Getwd()
+	lines := map[string][]string{}
+	source := func(file string, line int) string {
+		src, ok := lines[file]
+		if !ok {
+			data, _ := fs.ReadFile(file)
+			src = strings.Split(string(data), "\n")
+			lines[file] = src
+		}
+		if line < 1 || line > len(src) {
+			return ""
+		}
+		name := file
+		if r, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(r, "..") {
+			name = r
+		}
+		return fmt.Sprintf("%s:%d: %s", filepath.ToSlash(name), line, strings.TrimSpace(src[line-1]))
+	}
+	return cgen.EmitFileWith(f, info, cgen.Options{Source: source})
+}
+
+// BuildOptions configures Build. Only Entry is required.
+type BuildOptions struct {
+	Entry string       // entry .desi file
+	FS    FileProvider // source files; host file system if nil
+	Name  string       // base name for outputs; defaults to the entry's basename
+
+	StdDir         string // Desi-source std modules; default "std"
+	OutDir         string // where <Name>.c (and the binary) go; default DefaultOutDir
+	CacheDir       string // compiled runtime objects, one per target; default DefaultCacheDir
+	Werror         bool   // fail on warnings
+	DenyDeprecated bool   // fail on deprecated syntax, otherwise accepted with a warning
+	CC             string // C compiler; if empty, only C is emitted
+	RuntimeDir     string // C runtime directory; default runtime/c
+	Out            string // binary file name inside OutDir; default Name
+	Target         string // target triple to cross-compile for (zig or clang CC); host if empty
+	DepFile        bool   // also write <Name>.d in OutDir, a Make/Ninja depfile (see WriteDepfile)
+	Amalgam        bool   // also write <Name>.amalgam.c in OutDir, the C and runtime in one file (see Amalgamate)
+
+	Passes []Pass // run after the globally registered passes (see RegisterPass)
+
+	// CheckCache, if set, lets repeated builds skip re-checking functions
+	// whose bodies and referenced signatures are unchanged.
+	CheckCache *CheckCache
+}
+
+// DefaultOutDir is where Build writes outputs unless BuildOptions.OutDir
+// says otherwise.
+var DefaultOutDir = filepath.Join("gen", "out")
+
+// DefaultCacheDir is where Build keeps the runtime compiled for each target
+// unless BuildOptions.CacheDir says otherwise.
+var DefaultCacheDir = filepath.Join("gen", "cache")
+
+// BuildResult describes what Build did. It is non-nil even on failure.
+type BuildResult struct {
+	Files    []string  // every source file looked at, for invalidation/watching
+	Errors   []error   // load/parse/check errors
+	Warnings []Warning // deprecation, pass and check warnings
+	CFile    string    // path of the emitted C, if any
+	Binary   string    // path of the compiled binary, if CC was set
+	DepFile  string    // path of the depfile, if BuildOptions.DepFile was set
+	Amalgam  string    // path of the amalgamated C, if BuildOptions.Amalgam was set
+	Timings  []Timing  // the stages that ran, in order
+}
+
+// Timing is the wall time one stage of Build took. Passes count toward the
+// stage they follow.
+type Timing struct {
+	Stage    string // "load", "check", "emit" or "cc"
+	Duration time.Duration
+}
+
+// Build loads, checks and emits C for opts.Entry, then compiles it when
+// opts.CC is set. Registered passes run at their stages along the way. It
+// returns ErrDiagnostics when the input has errors, an error wrapping
+// ErrCompile when the C compiler fails, and any other error for I/O
+// problems writing outputs.
+func Build(opts BuildOptions) (*BuildResult, error) {
+	res := &BuildResult{}
+	start := time.Now()
+	lap := func(stage string) {
+		now := time.Now()
+		res.Timings = append(res.Timings, Timing{Stage: stage, Duration: now.Sub(start)})
+		start = now
+	}
+	lr, errs := Load(opts.Entry, LoadOptions{FS: opts.FS, StdDir: opts.StdDir, DenyDeprecated: opts.DenyDeprecated})
+	res.Files, res.Warnings = lr.Paths, lr.Warnings
+	if len(errs) > 0 {
+		lap("load")
+		res.Errors = errs
+		return res, ErrDiagnostics
+	}
+
+	pw, err := RunPasses(AfterParse, lr.File, nil, opts.Passes)
+	res.Warnings = append(res.Warnings, pw...)
+	lap("load")
+	if err != nil {
+		res.Errors = []error{err}
+		return res, ErrDiagnostics
+	}
+
+	info, errs, warns := check.CheckFileCached(lr.File, opts.CheckCache)
+	res.Errors, res.Warnings = errs, append(res.Warnings, warns...)
+	if len(errs) > 0 {
+		lap("check")
+		return res, ErrDiagnostics
+	}
+	pw, err = RunPasses(AfterCheck, lr.File, info, opts.Passes)
+	res.Warnings = append(res.Warnings, pw...)
+	lap("check")
+	if err != nil {
+		res.Errors = []error{err}
+		return res, ErrDiagnostics
+	}
+	if opts.Werror && len(res.Warnings) > 0 {
+		return res, ErrDiagnostics
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(opts.Entry), filepath.Ext(opts.Entry))
+	}
+	outDir := opts.OutDir
+	if outDir == "" {
+		outDir = DefaultOutDir
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return res, fmt.Errorf("mkdir %s: %v", outDir, err)
+	}
+	runtimeDir := opts.RuntimeDir
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join("runtime", "c")
+	}
+	cpath := filepath.Join(outDir, name+".c")
+	csrc := EmitC(lr.File, info)
+	if err := os.WriteFile(cpath, []byte(csrc), 0o644); err != nil {
+		return res, fmt.Errorf("write %s: %v", cpath, err)
+	}
+	res.CFile = cpath
+	if opts.Amalgam {
+		apath := filepath.Join(outDir, name+".amalgam.c")
+		src, err := Amalgamate(csrc, runtimeDir)
+		if err != nil {
+			return res, fmt.Errorf("amalgamate: %v", err)
+		}
+		if err := os.WriteFile(apath, []byte(src), 0o644); err != nil {
+			return res, fmt.Errorf("write %s: %v", apath, err)
+		}
+		res.Amalgam = apath
+	}
+	lap("emit")
+
+	if opts.CC == "" {
+		return res, writeDepfile(res, opts.DepFile, filepath.Join(outDir, name+".d"), cpath)
+	}
+	cacheDir := opts.CacheDir
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir
+	}
+	out := opts.Out
+	if out == "" {
+		out = name
+	}
+	co := cc.Options{
+		CC:         opts.CC,
+		RuntimeDir: runtimeDir,
+		Sources:    []string{cpath},
+		Out:        filepath.Join(outDir, out),
+		Target:     opts.Target,
+		CacheDir:   filepath.Join(cacheDir, "runtime"),
+	}
+	err = cc.Compile(co)
+	lap("cc")
+	if err != nil {
+		return res, fmt.Errorf("%w: %v", ErrCompile, err)
+	}
+	res.Binary = co.Binary()
+	return res, writeDepfile(res, opts.DepFile, filepath.Join(outDir, name+".d"), res.Binary)
+}
+
+// writeDepfile writes res's source dependencies to path, naming target (the
+// last output of the build) as the file they produce, when enabled.
+func writeDepfile(res *BuildResult, enabled bool, path, target string) error {
+	if !enabled {
+		return nil
+	}
+	var b strings.Builder
+	WriteDepfile(&b, filepath.ToSlash(target), Deps(res.Files))
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("write %s: %v", path, err)
+	}
+	res.DepFile = path
+	return nil
+}
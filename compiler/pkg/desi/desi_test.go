@@ -0,0 +1,96 @@
+package desi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const addSrc = "" +
+	"def add(a: i32, b: i32) -> i32:\n" +
+	"  return a + b\n" +
+	"def main() -> i32:\n" +
+	"  return add(1, 2)\n"
+
+func TestParseCheckEmit(t *testing.T) {
+	f, err := Parse("add.desi", []byte(addSrc))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	info, errs, warns := Check(f)
+	if len(errs) > 0 || len(warns) > 0 {
+		t.Fatalf("check: errs=%v warns=%v", errs, warns)
+	}
+	c := EmitC(f, info)
+	if !strings.Contains(c, "static int add(int a, int b)") {
+		t.Fatalf("emitted C lacks add():\n%s", c)
+	}
+}
+
+func TestParseErrorNamesFile(t *testing.T) {
+	_, err := Parse("bad.desi", []byte("def (\n"))
+	if err == nil || !strings.Contains(err.Error(), "bad.desi") {
+		t.Fatalf("err = %v, want mention of bad.desi", err)
+	}
+}
+
+func TestBuildFromOverlay(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewOverlay(nil)
+	entry := filepath.Join(dir, "prog.desi")
+	fs.Set(entry, []byte(addSrc))
+
+	res, err := Build(BuildOptions{Entry: entry, FS: fs, OutDir: filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatalf("build: %v (errors: %v)", err, res.Errors)
+	}
+	if res.CFile != filepath.Join(dir, "out", "prog.c") {
+		t.Fatalf("CFile = %q", res.CFile)
+	}
+	if _, err := os.Stat(res.CFile); err != nil {
+		t.Fatalf("C file not written: %v", err)
+	}
+	var stages []string
+	for _, tm := range res.Timings {
+		stages = append(stages, tm.Stage)
+	}
+	if got := strings.Join(stages, ","); got != "load,check,emit" {
+		t.Errorf("timed stages = %s, want load,check,emit (no cc without CC)", got)
+	}
+}
+
+func TestEmitCExplained(t *testing.T) {
+	fs := NewOverlay(nil)
+	fs.Set("<stdin>", []byte(addSrc))
+	lr, errs := Load("<stdin>", LoadOptions{FS: fs})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	info, errs, _ := Check(lr.File)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	c := EmitCExplained(lr.File, info, fs)
+	for _, want := range []string{"/* <stdin>:1: def add(a: i32, b: i32) -> i32: */\n", "  /* <stdin>:4: return add(1, 2) */\n  return add(1, 2);"} {
+		if !strings.Contains(c, want) {
+			t.Errorf("C lacks %q:\n%s", want, c)
+		}
+	}
+}
+
+func TestBuildReportsDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewOverlay(nil)
+	entry := filepath.Join(dir, "bad.desi")
+	fs.Set(entry, []byte("def main() -> i32:\n  return nope\n"))
+
+	res, err := Build(BuildOptions{Entry: entry, FS: fs, OutDir: dir})
+	if !errors.Is(err, ErrDiagnostics) {
+		t.Fatalf("err = %v, want ErrDiagnostics", err)
+	}
+	if len(res.Errors) != 1 || res.CFile != "" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,47 @@
+package desi
+
+import "github.com/desilang/desi/compiler/internal/ast"
+
+// AST node types, re-exported so passes outside this module can inspect
+// and rewrite the tree (see Pass).
+type (
+	Node        = ast.Node
+	Decl        = ast.Decl
+	Stmt        = ast.Stmt
+	Expr        = ast.Expr
+	PackageDecl = ast.PackageDecl
+	ImportDecl  = ast.ImportDecl
+	FuncDecl    = ast.FuncDecl
+	Param       = ast.Param
+
+	IdentExpr  = ast.IdentExpr
+	IntLit     = ast.IntLit
+	FloatLit   = ast.FloatLit
+	StrLit     = ast.StrLit
+	InterpExpr = ast.InterpExpr
+	BoolLit    = ast.BoolLit
+	CharLit    = ast.CharLit
+	NoneLit    = ast.NoneLit
+	CallExpr   = ast.CallExpr
+	IndexExpr  = ast.IndexExpr
+	FieldExpr  = ast.FieldExpr
+	UnaryExpr  = ast.UnaryExpr
+	BinaryExpr = ast.BinaryExpr
+	RangeExpr  = ast.RangeExpr
+	ParenExpr  = ast.ParenExpr
+
+	LetStmt    = ast.LetStmt
+	AssignStmt = ast.AssignStmt
+	ReturnStmt = ast.ReturnStmt
+	ExprStmt   = ast.ExprStmt
+	IfStmt     = ast.IfStmt
+	ElseIf     = ast.ElseIf
+	WhileStmt  = ast.WhileStmt
+	DeferStmt  = ast.DeferStmt
+	SpawnStmt  = ast.SpawnStmt
+	WithStmt   = ast.WithStmt
+)
+
+// Unparen returns e with any enclosing parentheses removed, so a pass can
+// match (a + b) as the *BinaryExpr inside.
+func Unparen(e Expr) Expr { return ast.Unparen(e) }
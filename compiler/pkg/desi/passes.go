@@ -0,0 +1,99 @@
+package desi
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Stage is a point in the pipeline where external passes run.
+type Stage int
+
+const (
+	// AfterParse runs once the entry file and its imports are loaded, before
+	// typechecking. Passes here may rewrite the AST; Info is nil.
+	AfterParse Stage = iota
+	// AfterCheck runs after a clean typecheck, before C is emitted. The
+	// AST must not change shape here, since Info describes it.
+	AfterCheck
+)
+
+func (s Stage) String() string {
+	switch s {
+	case AfterParse:
+		return "after-parse"
+	case AfterCheck:
+		return "after-check"
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// Pass is an external lint rule or transform.
+type Pass struct {
+	Name  string
+	Stage Stage
+	// Order sorts passes within a stage, lowest first; equal orders run in
+	// registration order (global passes before BuildOptions.Passes).
+	Order int
+	// Run inspects or rewrites ctx.File. A non-nil error fails the build
+	// and stops the remaining passes of the stage, since later passes may
+	// rely on earlier transforms.
+	Run func(ctx *PassContext) error
+}
+
+// PassContext is what a pass sees.
+type PassContext struct {
+	File *File
+	Info *Info // nil at AfterParse
+
+	warnings []Warning
+}
+
+// Warn reports a non-fatal diagnostic; it is subject to Werror like
+// checker warnings.
+func (c *PassContext) Warn(code, format string, a ...any) {
+	c.warnings = append(c.warnings, Warning{Code: code, Msg: fmt.Sprintf(format, a...)})
+}
+
+var (
+	passMu sync.Mutex
+	passes []Pass
+)
+
+// RegisterPass adds p to every subsequent Build, typically from an init
+// function of the package providing it, like database/sql drivers.
+func RegisterPass(p Pass) {
+	if p.Run == nil {
+		panic("desi: RegisterPass with nil Run: " + p.Name)
+	}
+	passMu.Lock()
+	defer passMu.Unlock()
+	passes = append(passes, p)
+}
+
+// passesFor returns the passes of stage s in run order.
+func passesFor(s Stage, extra []Pass) []Pass {
+	passMu.Lock()
+	all := append(append([]Pass(nil), passes...), extra...)
+	passMu.Unlock()
+	var out []Pass
+	for _, p := range all {
+		if p.Stage == s {
+			out = append(out, p)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Order < out[j].Order })
+	return out
+}
+
+// RunPasses runs the passes of stage s over f, returning the warnings they
+// reported and the first error, prefixed with the failing pass's name.
+func RunPasses(s Stage, f *File, info *Info, extra []Pass) ([]Warning, error) {
+	ctx := &PassContext{File: f, Info: info}
+	for _, p := range passesFor(s, extra) {
+		if err := p.Run(ctx); err != nil {
+			return ctx.warnings, fmt.Errorf("pass %s (%s): %w", p.Name, s, err)
+		}
+	}
+	return ctx.warnings, nil
+}
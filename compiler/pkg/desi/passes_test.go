@@ -0,0 +1,145 @@
+package desi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPassOrderingAndStages(t *testing.T) {
+	var trace []string
+	rec := func(name string) func(*PassContext) error {
+		return func(ctx *PassContext) error {
+			trace = append(trace, name)
+			return nil
+		}
+	}
+	extra := []Pass{
+		{Name: "late", Stage: AfterParse, Order: 10, Run: rec("late")},
+		{Name: "check", Stage: AfterCheck, Run: func(ctx *PassContext) error {
+			if ctx.Info == nil {
+				t.Error("AfterCheck pass got nil Info")
+			}
+			trace = append(trace, "check")
+			return nil
+		}},
+		{Name: "early-a", Stage: AfterParse, Order: -1, Run: rec("early-a")},
+		{Name: "early-b", Stage: AfterParse, Order: -1, Run: rec("early-b")},
+	}
+	res, err := buildOverlay(t, addSrc, extra)
+	if err != nil {
+		t.Fatalf("build: %v (%v)", err, res.Errors)
+	}
+	if got := strings.Join(trace, ","); got != "early-a,early-b,late,check" {
+		t.Fatalf("trace = %s", got)
+	}
+}
+
+func TestPassTransformReachesCodegen(t *testing.T) {
+	rename := Pass{Name: "rename", Stage: AfterParse, Run: func(ctx *PassContext) error {
+		for _, d := range ctx.File.Decls {
+			if fn, ok := d.(*FuncDecl); ok && fn.Name == "add" {
+				fn.Name = "plus"
+				for _, s := range ctx.File.Decls {
+					renameCalls(s.(*FuncDecl).Body)
+				}
+			}
+		}
+		return nil
+	}}
+	res, err := buildOverlay(t, addSrc, []Pass{rename})
+	if err != nil {
+		t.Fatalf("build: %v (%v)", err, res.Errors)
+	}
+	c, err := os.ReadFile(res.CFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(c), "plus(") || strings.Contains(string(c), "add(") {
+		t.Fatalf("rename did not reach codegen:\n%s", c)
+	}
+}
+
+func renameCalls(body []Stmt) {
+	for _, s := range body {
+		if r, ok := s.(*ReturnStmt); ok {
+			if c, ok := r.Expr.(*CallExpr); ok {
+				c.Callee = &IdentExpr{Name: "plus"}
+			}
+		}
+	}
+}
+
+func TestPassRewritesInterpHole(t *testing.T) {
+	src := "def main() -> void:\n  let n = 1\n  let m = 2\n  io.println(f\"v={n}\")\n"
+	swap := Pass{Name: "swap", Stage: AfterParse, Run: func(ctx *PassContext) error {
+		for _, s := range ctx.File.Decls[0].(*FuncDecl).Body {
+			if es, ok := s.(*ExprStmt); ok {
+				x := es.Expr.(*CallExpr).Args[0].(*InterpExpr)
+				if id, ok := x.Holes[0].(*IdentExpr); !ok || id.Name != "n" {
+					t.Errorf("hole = %#v, want n", x.Holes[0])
+				}
+				x.Holes[0] = &IdentExpr{Name: "m"}
+			}
+		}
+		return nil
+	}}
+	res, err := buildOverlay(t, src, []Pass{swap})
+	if err != nil {
+		t.Fatalf("build: %v (%v)", err, res.Errors)
+	}
+	c, err := os.ReadFile(res.CFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(c), "(long long)(m)") || strings.Contains(string(c), "(long long)(n)") {
+		t.Fatalf("rewritten hole did not reach codegen:\n%s", c)
+	}
+}
+
+func TestPassErrorStopsBuild(t *testing.T) {
+	ran := false
+	extra := []Pass{
+		{Name: "lint", Stage: AfterParse, Run: func(*PassContext) error { return errors.New("no adds allowed") }},
+		{Name: "after", Stage: AfterParse, Order: 1, Run: func(*PassContext) error { ran = true; return nil }},
+	}
+	res, err := buildOverlay(t, addSrc, extra)
+	if !errors.Is(err, ErrDiagnostics) {
+		t.Fatalf("err = %v, want ErrDiagnostics", err)
+	}
+	if ran {
+		t.Fatal("pass after the failing one still ran")
+	}
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Error(), "pass lint (after-parse): no adds allowed") {
+		t.Fatalf("errors = %v", res.Errors)
+	}
+}
+
+func TestPassWarningsHonorWerror(t *testing.T) {
+	warn := Pass{Name: "style", Stage: AfterCheck, Run: func(ctx *PassContext) error {
+		ctx.Warn("L0001", "function %s is short", "add")
+		return nil
+	}}
+	dir := t.TempDir()
+	fs := NewOverlay(nil)
+	entry := filepath.Join(dir, "prog.desi")
+	fs.Set(entry, []byte(addSrc))
+	res, err := Build(BuildOptions{Entry: entry, FS: fs, OutDir: dir, Werror: true, Passes: []Pass{warn}})
+	if !errors.Is(err, ErrDiagnostics) {
+		t.Fatalf("err = %v, want ErrDiagnostics", err)
+	}
+	if len(res.Warnings) != 1 || res.Warnings[0].String() != "L0001: function add is short" {
+		t.Fatalf("warnings = %v", res.Warnings)
+	}
+}
+
+func buildOverlay(t *testing.T, src string, extra []Pass) (*BuildResult, error) {
+	t.Helper()
+	dir := t.TempDir()
+	fs := NewOverlay(nil)
+	entry := filepath.Join(dir, "prog.desi")
+	fs.Set(entry, []byte(src))
+	return Build(BuildOptions{Entry: entry, FS: fs, OutDir: dir, Passes: extra})
+}
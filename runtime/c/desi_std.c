@@ -1,34 +1,1044 @@
+#if !defined(_WIN32) && !defined(_POSIX_C_SOURCE)
+#define _POSIX_C_SOURCE 200809L /* clock_gettime, nanosleep */
+#endif
+
 #include "desi_std.h"
 
+#ifdef _WIN32
+#include <io.h>
+#include <windows.h>
+#define popen _popen
+#define pclose _pclose
+#define isatty _isatty
+#define fileno _fileno
+#else
+#include <pthread.h>
+#include <signal.h>
+#include <sys/wait.h>
+#include <time.h>
+#include <unistd.h>
+#endif
+
+#if defined(_MSC_VER) && !defined(__clang__)
+#define DESI_INTERLOCKED 1 /* MSVC's C11 atomics are still experimental */
+#else
+#include <stdatomic.h>
+#endif
+#include <stdarg.h>
 #include <stdio.h>
 #include <stdlib.h>
+#include <string.h>
+
+/* ---- locks ---- */
+
+/* desi_lock and desi_cond are a mutex and a condition variable: pthreads,
+   or the SRW locks Windows has had since Vista. A static lock is initialised
+   with DESI_LOCK_INIT, others with desi_lock_init / desi_cond_init. */
+#ifdef _WIN32
+typedef SRWLOCK desi_lock;
+typedef CONDITION_VARIABLE desi_cond;
+#define DESI_LOCK_INIT SRWLOCK_INIT
+static void desi_lock_init(desi_lock* l) { InitializeSRWLock(l); }
+static void desi_cond_init(desi_cond* c) { InitializeConditionVariable(c); }
+static void desi_lock_acquire(desi_lock* l) { AcquireSRWLockExclusive(l); }
+static void desi_lock_release(desi_lock* l) { ReleaseSRWLockExclusive(l); }
+static void desi_cond_wait(desi_cond* c, desi_lock* l) { SleepConditionVariableSRW(c, l, INFINITE, 0); }
+static void desi_cond_signal(desi_cond* c) { WakeConditionVariable(c); }
+static void desi_cond_broadcast(desi_cond* c) { WakeAllConditionVariable(c); }
+#else
+typedef pthread_mutex_t desi_lock;
+typedef pthread_cond_t desi_cond;
+#define DESI_LOCK_INIT PTHREAD_MUTEX_INITIALIZER
+static void desi_lock_init(desi_lock* l) { pthread_mutex_init(l, NULL); }
+static void desi_cond_init(desi_cond* c) { pthread_cond_init(c, NULL); }
+static void desi_lock_acquire(desi_lock* l) { pthread_mutex_lock(l); }
+static void desi_lock_release(desi_lock* l) { pthread_mutex_unlock(l); }
+static void desi_cond_wait(desi_cond* c, desi_lock* l) { pthread_cond_wait(c, l); }
+static void desi_cond_signal(desi_cond* c) { pthread_cond_signal(c); }
+static void desi_cond_broadcast(desi_cond* c) { pthread_cond_broadcast(c); }
+#endif
+
+/* ---- strings ---- */
+
+DESI_STR_LIT(desi_empty, 0, "");
+
+int64_t desi_str_len(const char* s) {
+  if (!s) return 0;
+  int64_t n;
+  memcpy(&n, s - sizeof n, sizeof n);
+  return n;
+}
+
+/* desi_str_alloc returns room for an n-byte str, its length and closing NUL
+   already in place, or NULL if out of memory. */
+static char* desi_str_alloc(size_t n) {
+  int64_t len = (int64_t)n;
+  char* p = (char*)malloc(sizeof len + n + 1);
+  if (!p) return NULL;
+  memcpy(p, &len, sizeof len);
+  p += sizeof len;
+  p[n] = '\0';
+  return p;
+}
+
+static void desi_str_free(const char* s) {
+  if (s && s != desi_empty.s) free((char*)s - sizeof(int64_t));
+}
 
-char* desi_fs_read_all(const char* path) {
+const char* desi_fs_read_all(const char* path) {
   FILE* f = fopen(path, "rb");
   if (!f) return NULL;
   if (fseek(f, 0, SEEK_END) != 0) { fclose(f); return NULL; }
   long n = ftell(f);
   if (n < 0) { fclose(f); return NULL; }
   if (fseek(f, 0, SEEK_SET) != 0) { fclose(f); return NULL; }
-  char* buf = (char*)malloc((size_t)n + 1);
+  char* buf = desi_str_alloc((size_t)n);
   if (!buf) { fclose(f); return NULL; }
   size_t rd = fread(buf, 1, (size_t)n, f);
   fclose(f);
-  if (rd != (size_t)n) { free(buf); return NULL; }
-  buf[n] = '\0';
+  if (rd != (size_t)n) { desi_str_free(buf); return NULL; }
   return buf;
 }
 
 int desi_fs_write_all(const char* path, const char* data) {
   FILE* f = fopen(path, "wb");
   if (!f) return -1;
-  size_t len = 0;
-  if (data) for (; data[len]; ++len) {}
-  size_t wr = fwrite(data, 1, len, f);
+  size_t len = (size_t)desi_str_len(data);
+  size_t wr = len ? fwrite(data, 1, len, f) : 0;
   fclose(f);
   return wr == len ? 0 : -1;
 }
 
+/* ---- formatting ---- */
+
+typedef struct {
+  char* buf;
+  size_t len, cap;
+  int oom;
+} desi_sb;
+
+static void desi_sb_put(desi_sb* sb, const char* s, size_t n) {
+  if (sb->oom) return;
+  if (sb->len + n + 1 > sb->cap) {
+    size_t cap = sb->cap ? sb->cap : 64;
+    while (cap < sb->len + n + 1) cap *= 2;
+    char* nb = (char*)realloc(sb->buf, cap);
+    if (!nb) { sb->oom = 1; return; }
+    sb->buf = nb;
+    sb->cap = cap;
+  }
+  memcpy(sb->buf + sb->len, s, n);
+  sb->len += n;
+  sb->buf[sb->len] = '\0';
+}
+
+/* desi_sb_str frees sb's buffer and returns its contents as a str, or
+   fallback if out of memory. */
+static const char* desi_sb_str(desi_sb* sb, const char* fallback) {
+  char* s = sb->oom ? NULL : desi_str_alloc(sb->len);
+  if (s && sb->len) memcpy(s, sb->buf, sb->len);
+  free(sb->buf);
+  return s ? s : fallback;
+}
+
+/* desi_sb_int appends n in decimal; it is the hot path of io.println. */
+static void desi_sb_int(desi_sb* sb, long long n) {
+  char num[24];
+  char* e = num + sizeof num;
+  char* p = e;
+  unsigned long long u = n < 0 ? 0ULL - (unsigned long long)n : (unsigned long long)n;
+  do {
+    *--p = (char)('0' + u % 10);
+    u /= 10;
+  } while (u);
+  if (n < 0) *--p = '-';
+  desi_sb_put(sb, p, (size_t)(e - p));
+}
+
+static void desi_vformat(desi_sb* sb, const char* fmt, va_list ap) {
+  char num[32];
+  for (const char* p = fmt; *p; ++p) {
+    if (*p != '%' || !p[1]) {
+      const char* q = p + 1;
+      while (*q && *q != '%') ++q;
+      desi_sb_put(sb, p, (size_t)(q - p));
+      p = q - 1;
+      continue;
+    }
+    switch (*++p) {
+      case 'd':
+        desi_sb_int(sb, va_arg(ap, long long));
+        break;
+      case 's': {
+        const char* s = va_arg(ap, const char*);
+        if (s) desi_sb_put(sb, s, (size_t)desi_str_len(s));
+        break;
+      }
+      case 'b': {
+        const char* s = va_arg(ap, long long) ? "true" : "false";
+        desi_sb_put(sb, s, strlen(s));
+        break;
+      }
+      case 'g': { /* f64 holes of f-strings */
+        int n = snprintf(num, sizeof num, "%g", va_arg(ap, double));
+        desi_sb_put(sb, num, (size_t)n);
+        break;
+      }
+      default: /* %% and anything the compiler let through */
+        desi_sb_put(sb, p, 1);
+        break;
+    }
+  }
+}
+
+/* Program output collects in desi_out and is written out when it reaches
+   DESI_OUT_MAX bytes, on io.flush, before proc.run and at exit. On a
+   terminal every call is written at once, so prompts and progress show.
+   desi_out_lock keeps spawned tasks' calls whole and in one piece. */
+#define DESI_OUT_MAX 65536
+
+static desi_sb desi_out;
+static int desi_out_mode; /* 0 until the first write, then 1 buffered, 2 terminal */
+static desi_lock desi_out_lock = DESI_LOCK_INIT;
+
+/* desi_out_flush writes desi_out; the caller holds desi_out_lock. */
+static void desi_out_flush(void) {
+  if (desi_out.len) fwrite(desi_out.buf, 1, desi_out.len, stdout);
+  desi_out.len = 0;
+  desi_out.oom = 0;
+  fflush(stdout);
+}
+
+void desi_io_flush(void) {
+  desi_lock_acquire(&desi_out_lock);
+  desi_out_flush();
+  desi_lock_release(&desi_out_lock);
+}
+
+void desi_io_printf(const char* fmt, ...) {
+  desi_lock_acquire(&desi_out_lock);
+  if (!desi_out_mode) {
+    desi_out_mode = isatty(fileno(stdout)) ? 2 : 1;
+    atexit(desi_io_flush);
+  }
+  va_list ap;
+  va_start(ap, fmt);
+  desi_vformat(&desi_out, fmt, ap);
+  va_end(ap);
+  if (desi_out.oom || desi_out.len >= DESI_OUT_MAX || desi_out_mode == 2) desi_out_flush();
+  desi_lock_release(&desi_out_lock);
+}
+
+const char* desi_fmt_sprintf(const char* fmt, ...) {
+  desi_sb sb = {0};
+  va_list ap;
+  va_start(ap, fmt);
+  desi_vformat(&sb, fmt, ap);
+  va_end(ap);
+  return desi_sb_str(&sb, desi_empty.s);
+}
+
+/* ---- file handles ---- */
+
+struct desi_file {
+  FILE* fp; /* NULL once closed */
+};
+
+static desi_file* desi_file_open(const char* path, const char* mode) {
+  FILE* fp = fopen(path, mode);
+  if (!fp) return NULL;
+  desi_file* f = (desi_file*)malloc(sizeof *f);
+  if (!f) { fclose(fp); return NULL; }
+  f->fp = fp;
+  return f;
+}
+
+desi_file* desi_fs_open(const char* path) { return desi_file_open(path, "rb"); }
+desi_file* desi_fs_create(const char* path) { return desi_file_open(path, "wb"); }
+desi_file* desi_fs_append(const char* path) { return desi_file_open(path, "ab"); }
+
+const char* desi_fs_read(desi_file* f, int64_t n) {
+  if (!f || !f->fp || n <= 0) return desi_empty.s;
+  char* buf = desi_str_alloc((size_t)n);
+  if (!buf) return desi_empty.s;
+  size_t rd = fread(buf, 1, (size_t)n, f->fp);
+  if (rd == 0) { desi_str_free(buf); return desi_empty.s; }
+  /* shrink the length prefix to what was read; the block stays n long */
+  int64_t len = (int64_t)rd;
+  memcpy(buf - sizeof len, &len, sizeof len);
+  buf[rd] = '\0';
+  return buf;
+}
+
+const char* desi_fs_read_line(desi_file* f) {
+  if (!f || !f->fp) return NULL;
+  desi_sb sb = {0};
+  int c, any = 0;
+  while ((c = getc(f->fp)) != EOF) {
+    any = 1;
+    if (c == '\n') break;
+    char ch = (char)c;
+    desi_sb_put(&sb, &ch, 1);
+  }
+  if (!any) { free(sb.buf); return NULL; }
+  if (sb.len > 0 && sb.buf[sb.len - 1] == '\r') sb.len--;
+  return desi_sb_str(&sb, desi_empty.s);
+}
+
+int desi_fs_write(desi_file* f, const char* s) {
+  if (!f || !f->fp) return 0;
+  size_t len = (size_t)desi_str_len(s);
+  return (len == 0 || fwrite(s, 1, len, f->fp) == len) && fflush(f->fp) == 0;
+}
+
+void desi_fs_close(desi_file* f) {
+  if (f && f->fp) {
+    fclose(f->fp);
+    f->fp = NULL;
+  }
+}
+
+/* ---- bytes ---- */
+
+struct desi_bytes {
+  int64_t len;
+  unsigned char data[];
+};
+
+static const desi_bytes desi_bytes_empty = {0};
+
+static const desi_bytes* desi_bytes_make(const void* p, size_t n) {
+  if (n == 0) return &desi_bytes_empty;
+  desi_bytes* b = (desi_bytes*)malloc(sizeof *b + n);
+  if (!b) return NULL;
+  b->len = (int64_t)n;
+  memcpy(b->data, p, n);
+  return b;
+}
+
+const desi_bytes* desi_bytes_from_file(const char* path) {
+  FILE* f = fopen(path, "rb");
+  if (!f) return NULL;
+  desi_sb sb = {0};
+  char chunk[4096];
+  size_t n;
+  while ((n = fread(chunk, 1, sizeof chunk, f)) > 0) desi_sb_put(&sb, chunk, n);
+  int bad = ferror(f) || sb.oom;
+  fclose(f);
+  const desi_bytes* b = bad ? NULL : desi_bytes_make(sb.buf, sb.len);
+  free(sb.buf);
+  return b;
+}
+
+int64_t desi_bytes_len(const desi_bytes* b) { return b ? b->len : 0; }
+
+int desi_bytes_at(const desi_bytes* b, int64_t i) {
+  if (!b || i < 0 || i >= b->len) return -1;
+  return b->data[i];
+}
+
+const desi_bytes* desi_bytes_slice(const desi_bytes* b, int64_t lo, int64_t hi) {
+  int64_t n = desi_bytes_len(b);
+  if (lo < 0) lo = 0;
+  if (hi > n) hi = n;
+  if (lo >= hi) return &desi_bytes_empty;
+  const desi_bytes* s = desi_bytes_make(b->data + lo, (size_t)(hi - lo));
+  return s ? s : &desi_bytes_empty;
+}
+
+/* ---- time ---- */
+
+#ifdef _WIN32
+
+int64_t desi_time_now_ms(void) {
+  FILETIME ft;
+  GetSystemTimeAsFileTime(&ft);
+  ULARGE_INTEGER t;
+  t.LowPart = ft.dwLowDateTime;
+  t.HighPart = ft.dwHighDateTime;
+  /* 100ns ticks since 1601-01-01 -> ms since 1970-01-01 */
+  return (int64_t)((t.QuadPart - 116444736000000000ULL) / 10000ULL);
+}
+
+int64_t desi_time_mono_ns(void) {
+  static LARGE_INTEGER freq;
+  LARGE_INTEGER now;
+  if (freq.QuadPart == 0) QueryPerformanceFrequency(&freq);
+  QueryPerformanceCounter(&now);
+  return (int64_t)((double)now.QuadPart * 1e9 / (double)freq.QuadPart);
+}
+
+void desi_time_sleep_ms(int64_t ms) {
+  if (ms > 0) Sleep((DWORD)ms);
+}
+
+#else
+
+int64_t desi_time_now_ms(void) {
+  struct timespec ts;
+  clock_gettime(CLOCK_REALTIME, &ts);
+  return (int64_t)ts.tv_sec * 1000 + ts.tv_nsec / 1000000;
+}
+
+int64_t desi_time_mono_ns(void) {
+  struct timespec ts;
+  clock_gettime(CLOCK_MONOTONIC, &ts);
+  return (int64_t)ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+void desi_time_sleep_ms(int64_t ms) {
+  if (ms <= 0) return;
+  struct timespec ts = { (time_t)(ms / 1000), (long)(ms % 1000) * 1000000L };
+  while (nanosleep(&ts, &ts) != 0) {} /* resume after signals */
+}
+
+#endif
+
+/* ---- rand (xorshift64*) ---- */
+
+static uint64_t desi_rand_state;
+
+static uint64_t desi_splitmix64(uint64_t x) {
+  /* scramble the seed so small/similar seeds give unrelated streams */
+  x += 0x9E3779B97F4A7C15ULL;
+  x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9ULL;
+  x = (x ^ (x >> 27)) * 0x94D049BB133111EBULL;
+  return x ^ (x >> 31);
+}
+
+void desi_rand_seed(int64_t seed) {
+  desi_rand_state = desi_splitmix64((uint64_t)seed);
+  if (desi_rand_state == 0) desi_rand_state = 1; /* xorshift state must be non-zero */
+}
+
+static uint64_t desi_rand_next(void) {
+  if (desi_rand_state == 0) desi_rand_seed(desi_time_mono_ns() ^ desi_time_now_ms());
+  uint64_t x = desi_rand_state;
+  x ^= x >> 12;
+  x ^= x << 25;
+  x ^= x >> 27;
+  desi_rand_state = x;
+  return x * 0x2545F4914F6CDD1DULL;
+}
+
+int desi_rand_int(int n) {
+  if (n <= 0) return 0;
+  /* rejection sampling avoids modulo bias */
+  uint64_t bound = (uint64_t)n;
+  uint64_t limit = UINT64_MAX - UINT64_MAX % bound;
+  uint64_t r;
+  do {
+    r = desi_rand_next();
+  } while (r >= limit);
+  return (int)(r % bound);
+}
+
+/* ---- proc ---- */
+
+static const char* desi_proc_last;
+
+int desi_proc_run(const char* cmd) {
+  desi_str_free(desi_proc_last);
+  desi_proc_last = NULL;
+  desi_io_flush(); /* keep our output ordered before the child's */
+  FILE* p = popen(cmd, "r");
+  if (!p) return -1;
+  desi_sb sb = {0};
+  char chunk[4096];
+  size_t n;
+  while ((n = fread(chunk, 1, sizeof chunk, p)) > 0) desi_sb_put(&sb, chunk, n);
+  int st = pclose(p);
+  desi_proc_last = desi_sb_str(&sb, NULL);
+  if (st == -1) return -1;
+#ifdef _WIN32
+  return st;
+#else
+  if (WIFEXITED(st)) return WEXITSTATUS(st);
+  if (WIFSIGNALED(st)) return 128 + WTERMSIG(st); /* shell convention */
+  return -1;
+#endif
+}
+
+const char* desi_proc_output(void) {
+  return desi_proc_last ? desi_proc_last : desi_empty.s;
+}
+
+/* ---- tasks and channels ---- */
+
+/* A task runs on its own detached thread, with its own copy of the
+   argument block the spawn site built; it frees the copy when done. */
+typedef struct {
+  desi_task fn;
+  void* args;
+} desi_task_start;
+
+#ifdef _WIN32
+static DWORD WINAPI desi_task_main(LPVOID p) {
+#else
+static void* desi_task_main(void* p) {
+#endif
+  desi_task_start st = *(desi_task_start*)p;
+  free(p);
+  st.fn(st.args);
+  free(st.args);
+  return 0;
+}
+
+void desi_spawn(desi_task fn, const void* args, size_t n) {
+  desi_task_start* st = (desi_task_start*)malloc(sizeof *st);
+  void* copy = malloc(n ? n : 1);
+  int ok = st != NULL && copy != NULL;
+  if (ok) {
+    if (n) memcpy(copy, args, n);
+    st->fn = fn;
+    st->args = copy;
+#ifdef _WIN32
+    HANDLE h = CreateThread(NULL, 0, desi_task_main, st, 0, NULL);
+    ok = h != NULL;
+    if (ok) CloseHandle(h);
+#else
+    pthread_t t;
+    pthread_attr_t attr;
+    ok = pthread_attr_init(&attr) == 0;
+    if (ok) {
+      pthread_attr_setdetachstate(&attr, PTHREAD_CREATE_DETACHED);
+      ok = pthread_create(&t, &attr, desi_task_main, st) == 0;
+      pthread_attr_destroy(&attr);
+    }
+#endif
+  }
+  if (!ok) {
+    /* Running the task here instead could deadlock on its channels. */
+    free(st);
+    free(copy);
+    fputs("desi: spawn: cannot start a thread\n", stderr);
+    exit(1);
+  }
+}
+
+/* A channel is a ring of cap items guarded by mu. Senders wait on room and
+   receivers on items; close wakes both. */
+typedef union {
+  int64_t i;
+  const char* s;
+} desi_chan_item;
+
+struct desi_chan {
+  desi_lock mu;
+  desi_cond items, room;
+  int64_t cap, head, len;
+  int closed;
+  desi_chan_item buf[];
+};
+
+desi_chan* desi_chan_new(int64_t cap) {
+  if (cap < 1) cap = 1;
+  desi_chan* ch = (desi_chan*)malloc(sizeof *ch + (size_t)cap * sizeof(desi_chan_item));
+  if (!ch) {
+    fputs("desi: chan: out of memory\n", stderr);
+    exit(1);
+  }
+  desi_lock_init(&ch->mu);
+  desi_cond_init(&ch->items);
+  desi_cond_init(&ch->room);
+  ch->cap = cap;
+  ch->head = ch->len = 0;
+  ch->closed = 0;
+  return ch;
+}
+
+static int desi_chan_send(desi_chan* ch, desi_chan_item v) {
+  desi_lock_acquire(&ch->mu);
+  while (!ch->closed && ch->len == ch->cap) desi_cond_wait(&ch->room, &ch->mu);
+  int ok = !ch->closed;
+  if (ok) {
+    ch->buf[(ch->head + ch->len) % ch->cap] = v;
+    ch->len++;
+    desi_cond_signal(&ch->items);
+  }
+  desi_lock_release(&ch->mu);
+  return ok;
+}
+
+/* desi_chan_recv waits for an item and reports whether there was one: a
+   closed channel still gives up what was sent before close. */
+static int desi_chan_recv(desi_chan* ch, desi_chan_item* v) {
+  desi_lock_acquire(&ch->mu);
+  while (!ch->closed && ch->len == 0) desi_cond_wait(&ch->items, &ch->mu);
+  int ok = ch->len > 0;
+  if (ok) {
+    *v = ch->buf[ch->head];
+    ch->head = (ch->head + 1) % ch->cap;
+    ch->len--;
+    desi_cond_signal(&ch->room);
+  }
+  desi_lock_release(&ch->mu);
+  return ok;
+}
+
+int desi_chan_send_int(desi_chan* ch, int64_t v) {
+  desi_chan_item it;
+  it.i = v;
+  return desi_chan_send(ch, it);
+}
+
+int desi_chan_send_str(desi_chan* ch, const char* v) {
+  desi_chan_item it;
+  it.s = v;
+  return desi_chan_send(ch, it);
+}
+
+desi_opt_int desi_chan_recv_int(desi_chan* ch) {
+  desi_opt_int r = {0, 0};
+  desi_chan_item it;
+  if (desi_chan_recv(ch, &it)) {
+    r.ok = 1;
+    r.v = it.i;
+  }
+  return r;
+}
+
+const char* desi_chan_recv_str(desi_chan* ch) {
+  desi_chan_item it;
+  return desi_chan_recv(ch, &it) ? it.s : NULL;
+}
+
+void desi_chan_close(desi_chan* ch) {
+  desi_lock_acquire(&ch->mu);
+  ch->closed = 1;
+  desi_cond_broadcast(&ch->items);
+  desi_cond_broadcast(&ch->room);
+  desi_lock_release(&ch->mu);
+}
+
+/* ---- sync ---- */
+
+struct desi_mutex {
+  desi_lock l;
+};
+
+desi_mutex* desi_sync_mutex(void) {
+  desi_mutex* m = (desi_mutex*)malloc(sizeof *m);
+  if (!m) {
+    fputs("desi: sync: out of memory\n", stderr);
+    exit(1);
+  }
+  desi_lock_init(&m->l);
+  return m;
+}
+
+void desi_sync_lock(desi_mutex* m) { desi_lock_acquire(&m->l); }
+void desi_sync_unlock(desi_mutex* m) { desi_lock_release(&m->l); }
+
+struct desi_atomic {
+#ifdef DESI_INTERLOCKED
+  volatile LONG64 v;
+#else
+  _Atomic int64_t v;
+#endif
+};
+
+desi_atomic* desi_sync_atomic(int64_t v) {
+  desi_atomic* a = (desi_atomic*)malloc(sizeof *a);
+  if (!a) {
+    fputs("desi: sync: out of memory\n", stderr);
+    exit(1);
+  }
+#ifdef DESI_INTERLOCKED
+  a->v = v;
+#else
+  atomic_init(&a->v, v);
+#endif
+  return a;
+}
+
+#ifdef DESI_INTERLOCKED
+int64_t desi_sync_load(desi_atomic* a) { return InterlockedCompareExchange64(&a->v, 0, 0); }
+void desi_sync_store(desi_atomic* a, int64_t v) { InterlockedExchange64(&a->v, v); }
+int64_t desi_sync_add(desi_atomic* a, int64_t d) {
+  return (int64_t)((uint64_t)InterlockedExchangeAdd64(&a->v, d) + (uint64_t)d);
+}
+int desi_sync_cas(desi_atomic* a, int64_t old, int64_t v) {
+  return InterlockedCompareExchange64(&a->v, v, old) == old;
+}
+#else
+int64_t desi_sync_load(desi_atomic* a) { return atomic_load(&a->v); }
+void desi_sync_store(desi_atomic* a, int64_t v) { atomic_store(&a->v, v); }
+int64_t desi_sync_add(desi_atomic* a, int64_t d) {
+  return (int64_t)((uint64_t)atomic_fetch_add(&a->v, d) + (uint64_t)d); /* wraps, never overflows */
+}
+int desi_sync_cas(desi_atomic* a, int64_t old, int64_t v) {
+  return atomic_compare_exchange_strong(&a->v, &old, v);
+}
+#endif
+
+/* ---- json ---- */
+
+static const char* desi_json_ws(const char* p) {
+  while (*p == ' ' || *p == '\t' || *p == '\n' || *p == '\r') p++;
+  return p;
+}
+
+static int desi_hexval(char c) {
+  if (c >= '0' && c <= '9') return c - '0';
+  if (c >= 'a' && c <= 'f') return c - 'a' + 10;
+  if (c >= 'A' && c <= 'F') return c - 'A' + 10;
+  return -1;
+}
+
+static int desi_json_hex4(const char* p, unsigned* out) {
+  unsigned v = 0;
+  for (int i = 0; i < 4; i++) {
+    int h = desi_hexval(p[i]);
+    if (h < 0) return 0;
+    v = v * 16 + (unsigned)h;
+  }
+  *out = v;
+  return 1;
+}
+
+static void desi_sb_utf8(desi_sb* sb, unsigned cp) {
+  char b[4];
+  size_t n;
+  if (cp < 0x80) { b[0] = (char)cp; n = 1; }
+  else if (cp < 0x800) { b[0] = (char)(0xC0 | (cp >> 6)); b[1] = (char)(0x80 | (cp & 0x3F)); n = 2; }
+  else if (cp < 0x10000) {
+    b[0] = (char)(0xE0 | (cp >> 12)); b[1] = (char)(0x80 | ((cp >> 6) & 0x3F));
+    b[2] = (char)(0x80 | (cp & 0x3F)); n = 3;
+  } else {
+    b[0] = (char)(0xF0 | (cp >> 18)); b[1] = (char)(0x80 | ((cp >> 12) & 0x3F));
+    b[2] = (char)(0x80 | ((cp >> 6) & 0x3F)); b[3] = (char)(0x80 | (cp & 0x3F)); n = 4;
+  }
+  desi_sb_put(sb, b, n);
+}
+
+/* desi_json_string parses a string starting at the opening quote, appending the
+   decoded text to sb when non-NULL. Returns the position after the closing
+   quote, or NULL if malformed. */
+static const char* desi_json_string(const char* p, desi_sb* sb) {
+  if (*p++ != '"') return NULL;
+  for (;;) {
+    unsigned char c = (unsigned char)*p;
+    if (c == '"') return p + 1;
+    if (c < 0x20) return NULL; /* includes NUL: unterminated */
+    if (c != '\\') {
+      if (sb) desi_sb_put(sb, p, 1);
+      p++;
+      continue;
+    }
+    char e = p[1];
+    const char* rep = NULL;
+    switch (e) {
+      case '"': rep = "\""; break;
+      case '\\': rep = "\\"; break;
+      case '/': rep = "/"; break;
+      case 'b': rep = "\b"; break;
+      case 'f': rep = "\f"; break;
+      case 'n': rep = "\n"; break;
+      case 'r': rep = "\r"; break;
+      case 't': rep = "\t"; break;
+      case 'u': {
+        unsigned cp, lo;
+        if (!desi_json_hex4(p + 2, &cp)) return NULL;
+        p += 6;
+        if (cp >= 0xD800 && cp <= 0xDBFF && p[0] == '\\' && p[1] == 'u' &&
+            desi_json_hex4(p + 2, &lo) && lo >= 0xDC00 && lo <= 0xDFFF) {
+          cp = 0x10000 + ((cp - 0xD800) << 10) + (lo - 0xDC00);
+          p += 6;
+        }
+        if (sb) desi_sb_utf8(sb, cp);
+        continue;
+      }
+      default: return NULL;
+    }
+    if (sb) desi_sb_put(sb, rep, 1);
+    p += 2;
+  }
+}
+
+static const char* desi_json_value(const char* p, int depth);
+
+static const char* desi_json_number(const char* p) {
+  const char* start = p;
+  if (*p == '-') p++;
+  if (*p == '0') p++;
+  else if (*p >= '1' && *p <= '9') while (*p >= '0' && *p <= '9') p++;
+  else return NULL;
+  if (*p == '.') {
+    p++;
+    if (!(*p >= '0' && *p <= '9')) return NULL;
+    while (*p >= '0' && *p <= '9') p++;
+  }
+  if (*p == 'e' || *p == 'E') {
+    p++;
+    if (*p == '+' || *p == '-') p++;
+    if (!(*p >= '0' && *p <= '9')) return NULL;
+    while (*p >= '0' && *p <= '9') p++;
+  }
+  return p > start ? p : NULL;
+}
+
+/* desi_json_value skips one value starting at p (no leading whitespace) and
+   returns the position after it, or NULL if malformed. */
+static const char* desi_json_value(const char* p, int depth) {
+  if (depth > 512) return NULL;
+  switch (*p) {
+    case '"': return desi_json_string(p, NULL);
+    case '{':
+    case '[': {
+      char close = *p == '{' ? '}' : ']';
+      int obj = *p == '{';
+      p = desi_json_ws(p + 1);
+      if (*p == close) return p + 1;
+      for (;;) {
+        if (obj) {
+          if (!(p = desi_json_string(p, NULL))) return NULL;
+          p = desi_json_ws(p);
+          if (*p++ != ':') return NULL;
+          p = desi_json_ws(p);
+        }
+        if (!(p = desi_json_value(p, depth + 1))) return NULL;
+        p = desi_json_ws(p);
+        if (*p == close) return p + 1;
+        if (*p++ != ',') return NULL;
+        p = desi_json_ws(p);
+      }
+    }
+    case 't': return strncmp(p, "true", 4) == 0 ? p + 4 : NULL;
+    case 'f': return strncmp(p, "false", 5) == 0 ? p + 5 : NULL;
+    case 'n': return strncmp(p, "null", 4) == 0 ? p + 4 : NULL;
+    default: return desi_json_number(p);
+  }
+}
+
+int desi_json_valid(const char* doc) {
+  if (!doc) return 0;
+  const char* p = desi_json_value(desi_json_ws(doc), 0);
+  /* a NUL before the end is not whitespace, so it fails here */
+  return p && desi_json_ws(p) == doc + desi_str_len(doc);
+}
+
+/* desi_json_lookup returns the start of key's value in the top-level object
+   doc, or NULL. Later duplicates win, as in most JSON libraries. */
+static const char* desi_json_lookup(const char* doc, const char* key) {
+  if (!desi_json_valid(doc)) return NULL;
+  const char* p = desi_json_ws(doc);
+  if (*p != '{') return NULL;
+  const char* found = NULL;
+  p = desi_json_ws(p + 1);
+  while (*p == '"') {
+    desi_sb k = {0};
+    p = desi_json_string(p, &k);
+    int match = (int64_t)k.len == desi_str_len(key) && (!k.len || memcmp(k.buf, key, k.len) == 0);
+    free(k.buf);
+    p = desi_json_ws(desi_json_ws(p) + 1); /* skip ':' */
+    if (match) found = p;
+    p = desi_json_ws(desi_json_value(p, 0));
+    if (*p == ',') p = desi_json_ws(p + 1);
+  }
+  return found;
+}
+
+const char* desi_json_get_str(const char* doc, const char* key) {
+  const char* v = desi_json_lookup(doc, key);
+  if (!v || *v != '"') return desi_empty.s;
+  desi_sb sb = {0};
+  desi_json_string(v, &sb);
+  return desi_sb_str(&sb, desi_empty.s);
+}
+
+int64_t desi_json_get_int(const char* doc, const char* key) {
+  const char* v = desi_json_lookup(doc, key);
+  if (!v || !(*v == '-' || (*v >= '0' && *v <= '9'))) return 0;
+  return (int64_t)strtoll(v, NULL, 10);
+}
+
+const char* desi_json_quote(const char* s) {
+  static const char hex[] = "0123456789abcdef";
+  desi_sb sb = {0};
+  desi_sb_put(&sb, "\"", 1);
+  const char* end = s ? s + desi_str_len(s) : s;
+  for (const char* p = s; p != end; p++) {
+    unsigned char c = (unsigned char)*p;
+    switch (c) {
+      case '"': desi_sb_put(&sb, "\\\"", 2); break;
+      case '\\': desi_sb_put(&sb, "\\\\", 2); break;
+      case '\n': desi_sb_put(&sb, "\\n", 2); break;
+      case '\r': desi_sb_put(&sb, "\\r", 2); break;
+      case '\t': desi_sb_put(&sb, "\\t", 2); break;
+      case '\b': desi_sb_put(&sb, "\\b", 2); break;
+      case '\f': desi_sb_put(&sb, "\\f", 2); break;
+      default:
+        if (c < 0x20) {
+          char u[6] = {'\\', 'u', '0', '0', hex[c >> 4], hex[c & 15]};
+          desi_sb_put(&sb, u, 6);
+        } else {
+          desi_sb_put(&sb, p, 1);
+        }
+    }
+  }
+  desi_sb_put(&sb, "\"", 1);
+  DESI_STR_LIT(quoted_empty, 2, "\"\"");
+  return desi_sb_str(&sb, quoted_empty.s);
+}
+
+int desi_str_eq(const char* a, const char* b) {
+  if (!a || !b) return a == b;
+  int64_t n = desi_str_len(a);
+  if (desi_str_len(b) != n) return 0;
+  unsigned char diff = 0;
+  for (int64_t i = 0; i < n; i++) diff |= (unsigned char)(a[i] ^ b[i]);
+  return diff == 0;
+}
+
+int desi_str_cmp(const char* a, const char* b) {
+  if (!a || !b) return (a != NULL) - (b != NULL);
+  int64_t na = desi_str_len(a), nb = desi_str_len(b);
+  int c = memcmp(a, b, (size_t)(na < nb ? na : nb));
+  if (c == 0) return (na > nb) - (na < nb);
+  return (c > 0) - (c < 0);
+}
+
+#define DESI_FNV_OFFSET 14695981039346656037ULL
+#define DESI_FNV_PRIME 1099511628211ULL
+
+int64_t desi_hash_str(const char* s) {
+  uint64_t h = DESI_FNV_OFFSET;
+  for (const char* end = s ? s + desi_str_len(s) : s; s != end; s++) {
+    h ^= (unsigned char)*s;
+    h *= DESI_FNV_PRIME;
+  }
+  return (int64_t)(h >> 1);
+}
+
+int64_t desi_hash_int(int64_t n) {
+  uint64_t h = DESI_FNV_OFFSET, u = (uint64_t)n;
+  for (int i = 0; i < 8; i++) {
+    h ^= (u >> (8 * i)) & 0xff;
+    h *= DESI_FNV_PRIME;
+  }
+  return (int64_t)(h >> 1);
+}
+
+int64_t desi_ipow(int64_t base, int64_t exp) {
+  if (exp < 0) {
+    if (base == 1) return 1;
+    if (base == -1) return (exp & 1) ? -1 : 1;
+    return 0;
+  }
+  uint64_t r = 1, b = (uint64_t)base;
+  while (exp > 0) {
+    if (exp & 1) r *= b;
+    b *= b;
+    exp >>= 1;
+  }
+  return (int64_t)r;
+}
+
+/* Exit hooks are os.at_exit handlers and main's defers, run last
+   registered first. Each is popped before it runs, so one that registers
+   another, or calls os.exit, never runs twice. desi_exiting marks that
+   they have started: os.exit from a hook must not call exit() again,
+   which C leaves undefined inside an atexit handler. */
+typedef void (*desi_hook)(void);
+static desi_hook* desi_hooks;
+static size_t desi_hooks_len, desi_hooks_cap;
+static int desi_exiting;
+static desi_lock desi_hooks_lock = DESI_LOCK_INIT;
+
+void desi_exit_hooks(void) {
+  for (;;) {
+    desi_hook fn = NULL;
+    desi_lock_acquire(&desi_hooks_lock);
+    desi_exiting = 1;
+    if (desi_hooks_len) fn = desi_hooks[--desi_hooks_len];
+    desi_lock_release(&desi_hooks_lock);
+    if (!fn) break;
+    fn();
+  }
+  desi_io_flush(); /* hooks may print after the atexit flush has run */
+}
+
+void desi_os_at_exit(void (*fn)(void)) {
+  int ok = 1;
+  desi_lock_acquire(&desi_hooks_lock);
+  if (desi_hooks_len == desi_hooks_cap) {
+    size_t cap = desi_hooks_cap ? desi_hooks_cap * 2 : 8;
+    desi_hook* hooks = (desi_hook*)realloc(desi_hooks, cap * sizeof *hooks);
+    ok = hooks && (desi_hooks_cap || atexit(desi_exit_hooks) == 0);
+    if (hooks) desi_hooks = hooks;
+    if (ok) desi_hooks_cap = cap;
+  }
+  if (ok) desi_hooks[desi_hooks_len++] = fn;
+  desi_lock_release(&desi_hooks_lock);
+  if (!ok) { /* unlocked: exit runs the hooks */
+    fputs("desi: os.at_exit: out of memory\n", stderr);
+    exit(1);
+  }
+}
+
 void desi_os_exit(int code) {
+  desi_lock_acquire(&desi_hooks_lock);
+  int again = desi_exiting;
+  desi_lock_release(&desi_hooks_lock);
+  desi_exit_hooks();
+  if (again) {
+    fflush(NULL);
+    _Exit(code);
+  }
   exit(code);
 }
+
+/* The interrupt handler is Desi code, which may allocate, lock and print,
+   none of which is safe in a signal handler. So the signal only wakes a
+   watcher thread (through a pipe on POSIX; Windows already runs console
+   handlers on a thread of their own), which runs it and exits. */
+static void (*volatile desi_interrupt_fn)(void);
+
+#ifdef _WIN32
+static BOOL WINAPI desi_on_ctrl(DWORD ev) {
+  if (ev != CTRL_C_EVENT && ev != CTRL_BREAK_EVENT) return FALSE;
+  desi_interrupt_fn();
+  exit(130);
+}
+
+void desi_os_on_interrupt(void (*fn)(void)) {
+  if (!desi_interrupt_fn) SetConsoleCtrlHandler(desi_on_ctrl, TRUE);
+  desi_interrupt_fn = fn;
+}
+#else
+static int desi_interrupt_pipe[2];
+
+static void desi_on_sigint(int sig) {
+  char b = (char)sig;
+  ssize_t n = write(desi_interrupt_pipe[1], &b, 1);
+  (void)n; /* nothing to do about a failure here */
+}
+
+static void* desi_interrupt_main(void* p) {
+  char b;
+  (void)p;
+  while (read(desi_interrupt_pipe[0], &b, 1) != 1) {
+  }
+  desi_interrupt_fn();
+  exit(130); /* 128 + SIGINT, as shells report it */
+}
+
+void desi_os_on_interrupt(void (*fn)(void)) {
+  if (!desi_interrupt_fn) {
+    pthread_t t;
+    struct sigaction sa;
+    if (pipe(desi_interrupt_pipe) != 0 || pthread_create(&t, NULL, desi_interrupt_main, NULL) != 0) {
+      fputs("desi: os.on_interrupt: cannot watch for interrupts\n", stderr);
+      exit(1);
+    }
+    pthread_detach(t);
+    memset(&sa, 0, sizeof sa);
+    sa.sa_handler = desi_on_sigint;
+    sa.sa_flags = SA_RESETHAND; /* a second ^C kills at once */
+    sigemptyset(&sa.sa_mask);
+    sigaction(SIGINT, &sa, NULL);
+  }
+  desi_interrupt_fn = fn;
+}
+#endif
@@ -2,21 +2,230 @@
 #define DESI_STD_H
 
 #include <stddef.h>
+#include <stdint.h>
 
 #ifdef __cplusplus
 extern "C" {
 #endif
 
-// Read entire file into an allocated buffer (NUL-terminated).
-// Returns NULL on error. Caller may free() the result.
-char* desi_fs_read_all(const char* path);
+// A `str` is a const char* to its bytes, which may be anything: NUL,
+// bytes >= 0x80, invalid UTF-8. Every str the compiler emits or the runtime
+// returns is length-prefixed: its byte count is stored as an int64_t just
+// before the bytes, and a NUL follows them, so a str is also a C string up
+// to its first embedded NUL. Strings from elsewhere must not be passed to
+// functions that take a str.
+//
+// DESI_STR_LIT(name, n, "...") defines a static str of n bytes; use it as
+// name.s. n must be the literal's length without the terminating NUL.
+#define DESI_STR_LIT(name, n, lit) \
+  static const struct { int64_t len; char s[(n) + 1]; } name = { (n), lit }
 
-// Write whole string to a file, overwrite. Returns 0 on success, -1 on error.
+// Byte length of s, embedded NULs included; 0 for NULL (a `none` str?).
+int64_t desi_str_len(const char* s);
+
+// An `int?`/`bool?` value: ok is 0 for none. `str?` is a plain pointer that
+// is NULL for none.
+typedef struct {
+  int ok;
+  int64_t v;
+} desi_opt_int;
+
+// Read an entire file into a newly allocated str, byte for byte.
+// Returns NULL on error.
+const char* desi_fs_read_all(const char* path);
+
+// Write all bytes of str data to a file, overwrite. Returns 0 on success,
+// -1 on error.
 int desi_fs_write_all(const char* path, const char* data);
 
-// Exit process with the given code.
+// A `file` is an open file handle. `file?` is a plain pointer that is NULL
+// for none.
+typedef struct desi_file desi_file;
+
+// Open a file for reading, for writing (created or truncated), or for
+// appending (created if missing). Each returns NULL if it cannot.
+desi_file* desi_fs_open(const char* path);
+desi_file* desi_fs_create(const char* path);
+desi_file* desi_fs_append(const char* path);
+
+// Read up to n bytes as a new str; the empty str at end of file, on error,
+// or from a closed handle.
+const char* desi_fs_read(desi_file* f, int64_t n);
+
+// Read the next line as a new str, without its "\n" (or "\r\n"). Returns
+// NULL at end of file; a last line with no newline is still returned.
+const char* desi_fs_read_line(desi_file* f);
+
+// Write all bytes of str s and flush. Returns 1 on success, 0 on error or
+// if f is closed or not open for writing.
+int desi_fs_write(desi_file* f, const char* s);
+
+// Close f. Closing a closed handle, or NULL, does nothing: the handle stays
+// allocated so a `with` block may close what the body already closed.
+void desi_fs_close(desi_file* f);
+
+// A `bytes` is binary data: a length and that many bytes, none of them
+// special, so it is never used as a C string. `bytes?` is a plain pointer
+// that is NULL for none.
+typedef struct desi_bytes desi_bytes;
+
+// Read an entire file as bytes. Returns NULL on error.
+const desi_bytes* desi_bytes_from_file(const char* path);
+
+// Number of bytes in b.
+int64_t desi_bytes_len(const desi_bytes* b);
+
+// The byte at index i (0-255), or -1 if i is out of range.
+int desi_bytes_at(const desi_bytes* b, int64_t i);
+
+// A copy of the bytes from lo up to, not including, hi. Both are clamped
+// to 0..len, and the result is empty if lo >= hi. Never NULL.
+const desi_bytes* desi_bytes_slice(const desi_bytes* b, int64_t lo, int64_t hi);
+
+// A task is a function run on its own thread by desi_spawn; the compiler
+// generates one per spawn site, unpacking the call's arguments from args.
+typedef void (*desi_task)(void* args);
+
+// Run fn on a new detached thread, passing it a copy of the n bytes at args.
+// Exits the program if no thread can be started. Nothing waits for tasks:
+// when main returns the program ends.
+void desi_spawn(desi_task fn, const void* args, size_t n);
+
+// A channel carries int or str values between tasks through a buffer of a
+// fixed capacity. Items come out in the order they went in.
+typedef struct desi_chan desi_chan;
+
+// A new open channel holding up to cap items (at least 1).
+desi_chan* desi_chan_new(int64_t cap);
+
+// Send v, waiting while the channel is full. Returns 1 once v is queued, or
+// 0 if the channel is (or becomes) closed.
+int desi_chan_send_int(desi_chan* ch, int64_t v);
+int desi_chan_send_str(desi_chan* ch, const char* v);
+
+// Receive the oldest item, waiting while the channel is empty and open.
+// Items sent before close are still received; after that the result is
+// none (ok 0 / NULL).
+desi_opt_int desi_chan_recv_int(desi_chan* ch);
+const char* desi_chan_recv_str(desi_chan* ch);
+
+// Close ch: waiting senders fail and receivers see none once it is drained.
+// Closing twice does nothing.
+void desi_chan_close(desi_chan* ch);
+
+// A mutex guards work that several tasks must not do at once. Lock and
+// unlock it from the same task; locking it twice without an unlock waits
+// forever.
+typedef struct desi_mutex desi_mutex;
+
+// A new unlocked mutex. Exits the program if out of memory.
+desi_mutex* desi_sync_mutex(void);
+void desi_sync_lock(desi_mutex* m);
+void desi_sync_unlock(desi_mutex* m);
+
+// An atomic is an integer any task may read and change without a lock
+// (C11 atomics, or Interlocked functions under MSVC), sequentially
+// consistent.
+typedef struct desi_atomic desi_atomic;
+
+// A new atomic holding v. Exits the program if out of memory.
+desi_atomic* desi_sync_atomic(int64_t v);
+int64_t desi_sync_load(desi_atomic* a);
+void desi_sync_store(desi_atomic* a, int64_t v);
+// Add d (wrapping like +) and return the new value.
+int64_t desi_sync_add(desi_atomic* a, int64_t d);
+// If a holds old, store v and return 1; otherwise return 0.
+int desi_sync_cas(desi_atomic* a, int64_t old, int64_t v);
+
+// printf-style formatting with Desi verbs: %d (int, passed as long long),
+// %s (str, all of its bytes), %b (bool, printed as true/false), %g (f64)
+// and %% (a literal percent sign). The format is a plain C string; format
+// strings are checked against their arguments by the compiler.
+// Output is buffered: it is written when the buffer fills, at exit (a
+// normal return from main or os.exit), before desi_proc_run and on
+// desi_io_flush. On a terminal each call is written at once. Calls from
+// concurrent tasks do not interleave.
+void desi_io_printf(const char* fmt, ...);
+
+// Write out buffered output and flush stdout.
+void desi_io_flush(void);
+
+// Like desi_io_printf but returns a newly allocated str (never NULL;
+// "" if out of memory).
+const char* desi_fmt_sprintf(const char* fmt, ...);
+
+// Wall-clock time in milliseconds since the Unix epoch.
+int64_t desi_time_now_ms(void);
+
+// Monotonic clock in nanoseconds from an arbitrary origin; use differences
+// for timing.
+int64_t desi_time_mono_ns(void);
+
+// Sleep for at least ms milliseconds (no-op if ms <= 0).
+void desi_time_sleep_ms(int64_t ms);
+
+// Seed the PRNG. The same seed always yields the same sequence. Without a
+// call the generator is seeded from the clock on first use.
+void desi_rand_seed(int64_t seed);
+
+// Uniform random integer in [0, n); 0 if n <= 0.
+int desi_rand_int(int n);
+
+// Run cmd through the system shell (sh -c / cmd.exe /c), capturing its
+// stdout. Returns the exit status, or -1 if the process could not be
+// started. stderr is not captured.
+int desi_proc_run(const char* cmd);
+
+// Captured stdout of the most recent desi_proc_run ("" before any run).
+const char* desi_proc_output(void);
+
+// JSON helpers. Stage-0 has no map/list types, so documents stay strings:
+// values are read by key from a top-level object, and strings are quoted
+// for output. Lookups on invalid JSON, a missing key, or a value of the
+// wrong type yield "" / 0.
+int desi_json_valid(const char* doc);
+const char* desi_json_get_str(const char* doc, const char* key);
+int64_t desi_json_get_int(const char* doc, const char* key);
+// Quote and escape s as a JSON string literal, e.g. a"b -> "a\"b".
+const char* desi_json_quote(const char* s);
+
+// String comparison, over all bytes (an embedded NUL is an ordinary byte).
+// NULL (a `none` str?) equals only NULL and orders before every string.
+// desi_str_eq takes time depending only on the lengths, not on where the
+// strings differ, so it is safe for comparing secrets of public length.
+int desi_str_eq(const char* a, const char* b);
+// -1, 0 or 1 by unsigned byte order; a proper prefix orders first.
+int desi_str_cmp(const char* a, const char* b);
+
+// Integer power for `**`: exact modulo 2^64 (wraps like * does); a
+// negative exponent gives 0 unless base is 1 or -1.
+int64_t desi_ipow(int64_t base, int64_t exp);
+
+// Hashes for user-built symbol tables: 64-bit FNV-1a (desi_hash_int hashes
+// the 8 little-endian bytes of n) with the top bit cleared, so results are
+// non-negative and `h % buckets` is a valid index. Not for cryptography.
+int64_t desi_hash_str(const char* s);
+int64_t desi_hash_int(int64_t n);
+
+// Exit process with the given code, after running the exit hooks.
 void desi_os_exit(int code);
 
+// Register fn to run when the program ends: main returning, os.exit, or
+// the exit after an os.on_interrupt handler. Hooks run once each, the last
+// registered first; main's defers are registered the same way.
+void desi_os_at_exit(void (*fn)(void));
+
+// Run and drop the registered exit hooks, then flush stdout. Main calls it
+// before returning, while the locals its defers use still exist; the
+// runtime also calls it from atexit.
+void desi_exit_hooks(void);
+
+// On SIGINT (Ctrl-C or Ctrl-Break on Windows) run fn, on a thread of its
+// own while the program carries on, then exit with status 130. A later call
+// replaces fn. A second interrupt while fn runs ends the program at once
+// (POSIX).
+void desi_os_on_interrupt(void (*fn)(void));
+
 #ifdef __cplusplus
 }
 #endif